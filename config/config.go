@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -15,11 +16,29 @@ type (
 		Database
 		Redis
 		Jwt
+		Purge
+		Verification
+		Login
+		Invite
+		Notify
+		Audit
+		Outbox
+		Webhook
+		Watch
+		Gateway
+		Scim
+		Session
+		Group
+		UserCache
+		Relation
+		Metrics
 	}
 
 	Http struct {
 		Host string `env-required:"true" env:"HTTP_HOST"`
 		Port string `env-required:"true" env:"HTTP_PORT"`
+		// comma separated CIDRs of the reverse proxies whose X-Forwarded-For is believed
+		TrustedProxies string `env:"TRUSTED_PROXIES"`
 	}
 
 	Database struct {
@@ -33,9 +52,116 @@ type (
 	}
 
 	Jwt struct {
-		AccessTokenExpiry  int    `env-required:"true" env:"ACCESS_TOKEN_EXPIRY"`  // minute
-		RefreshTokenExpiry int    `env-required:"true" env:"REFRESH_TOKEN_EXPIRY"` // minute
-		Secret             string `env-required:"true" env:"TOKEN_SECRET"`
+		AccessTokenExpiry   int      `env-required:"true" env:"ACCESS_TOKEN_EXPIRY"`  // minute
+		RefreshTokenExpiry  int      `env-required:"true" env:"REFRESH_TOKEN_EXPIRY"` // minute
+		Secret              string   `env-required:"true" env:"TOKEN_SECRET"`
+		Claims              []string `env:"ACCESS_TOKEN_CLAIMS"`                          // allowlist of profile fields and attributes
+		ImpersonationExpiry int      `env:"IMPERSONATION_TOKEN_EXPIRY" env-default:"15"`  // minute
+		ExchangeExpiry      int      `env:"EXCHANGED_TOKEN_EXPIRY" env-default:"5"`       // minute
+		RefreshWindow       int      `env:"ACCESS_TOKEN_REFRESH_WINDOW" env-default:"60"` // second
+		Audience            string   `env:"SERVICE_AUDIENCE" env-default:"authenticator"` // tokens bound to another audience can not call the api's
+	}
+
+	Purge struct {
+		DeletedUserRetention int `env:"DELETED_USER_RETENTION" env-default:"43200"` // minute
+		Interval             int `env:"PURGE_INTERVAL" env-default:"60"`            // minute
+	}
+
+	Verification struct {
+		CodeLength  int `env:"VERIFICATION_CODE_LENGTH" env-default:"6"`
+		CodeExpiry  int `env:"VERIFICATION_CODE_EXPIRY" env-default:"10"` // minute
+		MaxAttempts int `env:"VERIFICATION_MAX_ATTEMPTS" env-default:"5"`
+	}
+
+	Login struct {
+		MaxFailures  int    `env:"LOGIN_MAX_FAILURES" env-default:"5"`
+		Lockout      int    `env:"LOGIN_LOCKOUT" env-default:"15"`     // minute
+		CodeExpiry   int    `env:"LOGIN_CODE_EXPIRY" env-default:"10"` // minute
+		MagicLinkURL string `env:"LOGIN_MAGIC_LINK_URL"`               // codes are sent as links when set
+	}
+
+	Invite struct {
+		Expiry    int    `env:"INVITE_EXPIRY" env-default:"10080"`     // minute
+		MaxExpiry int    `env:"INVITE_MAX_EXPIRY" env-default:"43200"` // minute, the longest expires_in accepted
+		URL       string `env:"INVITE_URL"`                            // invite tokens are sent as links when set
+	}
+
+	Notify struct {
+		File string `env:"NOTIFY_FILE"` // messages are only logged when empty
+	}
+
+	Audit struct {
+		CheckpointSecret   string `env:"AUDIT_CHECKPOINT_SECRET"`                    // TOKEN_SECRET is used when empty
+		CheckpointInterval int    `env:"AUDIT_CHECKPOINT_INTERVAL" env-default:"60"` // minute
+		ChainInterval      int    `env:"AUDIT_CHAIN_INTERVAL" env-default:"1"`       // second
+	}
+
+	Outbox struct {
+		Publisher     string `env:"OUTBOX_PUBLISHER" env-default:"log"` // log or redis
+		Stream        string `env:"OUTBOX_STREAM" env-default:"authenticator:user-events"`
+		StreamMaxLen  int64  `env:"OUTBOX_STREAM_MAXLEN" env-default:"0"`  // approximate, unbounded when 0
+		RelayInterval int    `env:"OUTBOX_RELAY_INTERVAL" env-default:"5"` // second
+		BatchSize     int    `env:"OUTBOX_BATCH_SIZE" env-default:"100"`
+		ClaimTimeout  int    `env:"OUTBOX_CLAIM_TIMEOUT" env-default:"60"` // second, a crashed relay's events are retried after it
+		MaxAttempts   int    `env:"OUTBOX_MAX_ATTEMPTS" env-default:"10"`  // failed attempts before an event is dead-lettered
+		Retention     int    `env:"OUTBOX_RETENTION" env-default:"10080"`  // minute
+	}
+
+	Webhook struct {
+		MaxAttempts      int  `env:"WEBHOOK_MAX_ATTEMPTS" env-default:"10"`
+		RetryBase        int  `env:"WEBHOOK_RETRY_BASE" env-default:"30"`       // second
+		RetryMax         int  `env:"WEBHOOK_RETRY_MAX" env-default:"21600"`     // second
+		Timeout          int  `env:"WEBHOOK_TIMEOUT" env-default:"10"`          // second
+		AllowPrivate     bool `env:"WEBHOOK_ALLOW_PRIVATE" env-default:"false"` // deliver to loopback and private addresses, for development
+		DeliveryInterval int  `env:"WEBHOOK_DELIVERY_INTERVAL" env-default:"5"` // second
+		BatchSize        int  `env:"WEBHOOK_BATCH_SIZE" env-default:"20"`
+		Retention        int  `env:"WEBHOOK_RETENTION" env-default:"10080"` // minute
+	}
+
+	Watch struct {
+		PollInterval int `env:"WATCH_POLL_INTERVAL" env-default:"1000"` // millisecond
+		BatchSize    int `env:"WATCH_BATCH_SIZE" env-default:"100"`
+		MaxStreams   int `env:"WATCH_MAX_STREAMS" env-default:"1000"`
+	}
+
+	Gateway struct {
+		Port string `env:"GATEWAY_PORT" env-default:"8080"`
+	}
+
+	Scim struct {
+		Token string   `env:"SCIM_TOKEN"`
+		Roles []string `env:"SCIM_ROLES"` // built-in roles SCIM groups may grant
+	}
+
+	Session struct {
+		AccessCookie  string `env:"SESSION_ACCESS_COOKIE" env-default:"access_token"`
+		RefreshCookie string `env:"SESSION_REFRESH_COOKIE" env-default:"refresh_token"`
+		CsrfCookie    string `env:"SESSION_CSRF_COOKIE" env-default:"csrf_token"`
+		CookieDomain  string `env:"SESSION_COOKIE_DOMAIN"`
+		CookieSecure  bool   `env:"SESSION_COOKIE_SECURE" env-default:"true"`
+		SameSite      string `env:"SESSION_SAME_SITE" env-default:"strict"` // strict, lax or none
+	}
+
+	Group struct {
+		CacheTTL int `env:"GROUP_CACHE_TTL" env-default:"300"` // second
+	}
+
+	UserCache struct {
+		Size    int    `env:"USER_CACHE_SIZE" env-default:"10000"`  // users kept in process, the cache is off when 0
+		TTL     int    `env:"USER_CACHE_TTL" env-default:"30"`      // second, the most a cached user can be stale
+		Redis   bool   `env:"USER_CACHE_REDIS" env-default:"false"` // share cached users between replicas through Redis
+		Channel string `env:"USER_CACHE_CHANNEL" env-default:"authenticator:user-cache"`
+	}
+
+	Relation struct {
+		Namespaces string `env:"RELATION_NAMESPACES"` // json file, the built-in namespaces are used when empty
+		MaxDepth   int    `env:"RELATION_MAX_DEPTH" env-default:"16"`
+	}
+
+	Metrics struct {
+		// served apart from GATEWAY_PORT, so the metrics are only reachable where this port is
+		Port     string `env:"METRICS_PORT" env-default:"9090"`
+		Interval int    `env:"METRICS_INTERVAL" env-default:"30"` // second, how often the session and outbox gauges are read
 	}
 
 	Redis struct {
@@ -60,7 +186,40 @@ func NewConfig() (*Config, error) {
 		return nil, err
 	}
 
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
 	Conf = cfg
 
 	return cfg, nil
 }
+
+// validate rejects settings the service can not run with, such as the intervals of the
+// background jobs, which must be positive.
+func (c *Config) validate() error {
+
+	intervals := []struct {
+		name  string
+		value int
+	}{
+		{"PURGE_INTERVAL", c.Purge.Interval},
+		{"AUDIT_CHECKPOINT_INTERVAL", c.Audit.CheckpointInterval},
+		{"AUDIT_CHAIN_INTERVAL", c.Audit.ChainInterval},
+		{"OUTBOX_RELAY_INTERVAL", c.Outbox.RelayInterval},
+		{"OUTBOX_CLAIM_TIMEOUT", c.Outbox.ClaimTimeout},
+		{"OUTBOX_MAX_ATTEMPTS", c.Outbox.MaxAttempts},
+		{"WEBHOOK_DELIVERY_INTERVAL", c.Webhook.DeliveryInterval},
+		{"WATCH_POLL_INTERVAL", c.Watch.PollInterval},
+		{"METRICS_INTERVAL", c.Metrics.Interval},
+	}
+
+	for _, i := range intervals {
+		if i.value <= 0 {
+			return fmt.Errorf("config: %s must be positive, got %d", i.name, i.value)
+		}
+	}
+
+	return nil
+}
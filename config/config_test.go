@@ -0,0 +1,58 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() *Config {
+	c := &Config{}
+	c.Purge.Interval = 60
+	c.Audit.CheckpointInterval = 60
+	c.Audit.ChainInterval = 1
+	c.Outbox.RelayInterval = 5
+	c.Outbox.ClaimTimeout = 60
+	c.Outbox.MaxAttempts = 10
+	c.Webhook.DeliveryInterval = 5
+	c.Watch.PollInterval = 1000
+	c.Metrics.Interval = 30
+	return c
+}
+
+func TestValidateIntervals(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"purge zero", func(c *Config) { c.Purge.Interval = 0 }, "PURGE_INTERVAL"},
+		{"audit negative", func(c *Config) { c.Audit.CheckpointInterval = -1 }, "AUDIT_CHECKPOINT_INTERVAL"},
+		{"audit chain zero", func(c *Config) { c.Audit.ChainInterval = 0 }, "AUDIT_CHAIN_INTERVAL"},
+		{"outbox zero", func(c *Config) { c.Outbox.RelayInterval = 0 }, "OUTBOX_RELAY_INTERVAL"},
+		{"outbox claim zero", func(c *Config) { c.Outbox.ClaimTimeout = 0 }, "OUTBOX_CLAIM_TIMEOUT"},
+		{"outbox attempts zero", func(c *Config) { c.Outbox.MaxAttempts = 0 }, "OUTBOX_MAX_ATTEMPTS"},
+		{"webhook zero", func(c *Config) { c.Webhook.DeliveryInterval = 0 }, "WEBHOOK_DELIVERY_INTERVAL"},
+		{"watch zero", func(c *Config) { c.Watch.PollInterval = 0 }, "WATCH_POLL_INTERVAL"},
+		{"metrics zero", func(c *Config) { c.Metrics.Interval = 0 }, "METRICS_INTERVAL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+
+			err := c.validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("validate() = %v, want an error naming %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -4,23 +4,36 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 
 	"authenticator/config"
 	"authenticator/internal/controller"
+	"authenticator/internal/controller/clientinfo"
+	"authenticator/internal/controller/extauthz"
+	"authenticator/internal/controller/forwardauth"
+	"authenticator/internal/controller/gateway"
+	"authenticator/internal/controller/scim"
+	"authenticator/internal/controller/session"
 	"authenticator/internal/usecase"
 	"authenticator/pkg/cache"
+	"authenticator/pkg/metrics"
 	"authenticator/pkg/postgres"
 )
 
 func Run(cfg *config.Config) {
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	c, err := cache.NewRedisService(ctx, cfg.Redis.URL, 1, 8, 256)
 	if err != nil {
 		log.Fatal().Err(err).Msg("app - Run - cache.NewRedisService")
@@ -32,11 +45,30 @@ func Run(cfg *config.Config) {
 		return
 	}
 
-	useCases := usecase.LoadUseCases(pg, c)
+	useCases, err := usecase.LoadUseCases(pg, c)
+	if err != nil {
+		log.Fatal().Err(err).Msg("app - Run - usecase.LoadUseCases")
+	}
+
+	collectors := []prometheus.Collector{metrics.NewPgxPoolCollector(pg.Pool), metrics.NewRedisPoolCollector(c)}
+	if useCases.UserCache != nil {
+		collectors = append(collectors, metrics.NewUserCacheCollector(useCases.UserCache.Stats))
+	}
+	metrics.Register(collectors...)
+
+	proxies, err := clientinfo.ParseProxies(cfg.Http.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("App - clientinfo.ParseProxies")
+		return
+	}
 
-	userRouter := controller.NewUserRouter(useCases.UserUseCase)
-	s := grpc.NewServer()
+	userRouter := controller.NewUserRouter(useCases.UserUseCase, useCases.WebhookUseCase, useCases.OutboxUseCase, useCases.UserUseCase, useCases.UserUseCase, useCases.RelationUseCase)
+	auth := controller.NewAuthInterceptor(useCases.UserUseCase, cfg.Jwt.Audience)
+	unary := []grpc.UnaryServerInterceptor{metrics.UnaryServerInterceptor, controller.NewClientInfoInterceptor(proxies), auth.Unary}
+	stream := []grpc.StreamServerInterceptor{metrics.StreamServerInterceptor, auth.Stream}
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
 	controller.RegisterAuthServiceServer(s, userRouter)
+	authv3.RegisterAuthorizationServer(s, extauthz.NewServer(useCases.UserUseCase))
 
 	lis, err := net.Listen("tcp", ":"+cfg.Http.Port)
 	if err != nil {
@@ -44,7 +76,54 @@ func Run(cfg *config.Config) {
 		return
 	}
 
+	api, err := gateway.NewHandler(&controller.AuthService_ServiceDesc, userRouter, unary, stream)
+	if err != nil {
+		log.Fatal().Err(err).Msg("App - gateway.NewHandler")
+		return
+	}
+
+	sameSite, err := session.ParseSameSite(cfg.Session.SameSite)
+	if err != nil {
+		log.Fatal().Err(err).Msg("App - session.ParseSameSite")
+		return
+	}
+	cookies := session.Cookies{
+		Access:   cfg.Session.AccessCookie,
+		Refresh:  cfg.Session.RefreshCookie,
+		Csrf:     cfg.Session.CsrfCookie,
+		Domain:   cfg.Session.CookieDomain,
+		Secure:   cfg.Session.CookieSecure,
+		SameSite: sameSite,
+		MaxAge:   time.Duration(cfg.Jwt.RefreshTokenExpiry) * time.Minute,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(gateway.Prefix, api)
+	mux.Handle(forwardauth.Path, forwardauth.NewHandler(useCases.UserUseCase, cookies))
+	mux.Handle(session.Prefix+"/", session.NewHandler(useCases.UserUseCase, cookies, proxies))
+	if cfg.Scim.Token != "" {
+		mux.Handle(scim.Prefix+"/", scim.NewHandler(useCases.UserUseCase, cfg.Scim.Token, cfg.Scim.Roles, proxies))
+	}
+	httpServer := &http.Server{
+		Addr:              ":" + cfg.Gateway.Port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	metricsServer := newMetricsServer(cfg.Metrics.Port)
+
 	go setupSerer(s, lis)
+	go setupGateway(httpServer)
+	go setupMetrics(metricsServer)
+	go runPurgeJob(ctx, useCases.UserUseCase, cfg.Purge)
+	go runAuditChainJob(ctx, useCases.UserUseCase, cfg.Audit)
+	go runAuditCheckpointJob(ctx, useCases.UserUseCase, cfg.Audit)
+	go runOutboxRelay(ctx, useCases.OutboxUseCase, cfg.Outbox)
+	go runWebhookDelivery(ctx, useCases.WebhookUseCase, cfg.Webhook)
+	go useCases.OutboxUseCase.WatchHead(ctx, time.Duration(cfg.Watch.PollInterval)*time.Millisecond)
+	go runMetricsJob(ctx, useCases.UserUseCase, useCases.OutboxUseCase, cfg.Metrics)
+	if useCases.UserCache != nil {
+		go useCases.UserCache.Subscribe(ctx)
+	}
 
 	signalChan := make(chan os.Signal, 1)
 	quitChan := make(chan interface{})
@@ -55,8 +134,19 @@ func Run(cfg *config.Config) {
 		select {
 		case <-quitChan:
 			log.Warn().Msg("quit channel closed, closing listener")
+			cancel()
 			s.Stop()
 
+			err = httpServer.Shutdown(context.Background())
+			if err != nil {
+				log.Err(err).Msg("App - httpServer.Shutdown()")
+			}
+
+			err = metricsServer.Shutdown(context.Background())
+			if err != nil {
+				log.Err(err).Msg("App - metricsServer.Shutdown()")
+			}
+
 			err = lis.Close()
 			if err != nil {
 				log.Err(err).Msg("App - lis.Close()")
@@ -80,3 +170,28 @@ func setupSerer(srv *grpc.Server, lis net.Listener) {
 		return
 	}
 }
+
+func setupGateway(srv *http.Server) {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal().Err(err).Msg("App - setupGateway - srv.ListenAndServe()")
+		return
+	}
+}
+
+// newMetricsServer serves the metrics alone on their port, apart from the public gateway.
+func newMetricsServer(port string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(metrics.Path, metrics.Handler())
+	return &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func setupMetrics(srv *http.Server) {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal().Err(err).Msg("App - setupMetrics - srv.ListenAndServe()")
+		return
+	}
+}
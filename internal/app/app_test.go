@@ -0,0 +1,35 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"authenticator/pkg/metrics"
+)
+
+func TestMetricsServer(t *testing.T) {
+	srv := newMetricsServer("9090")
+	if srv.Addr != ":9090" {
+		t.Fatalf("Addr = %q, want :9090", srv.Addr)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{metrics.Path, http.StatusOK},
+		{"/v1/users", http.StatusNotFound},
+		{"/forward-auth", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"authenticator/config"
+	"authenticator/internal/usecase"
+	"authenticator/pkg/metrics"
+	"authenticator/pkg/util"
+)
+
+// runPurgeJob periodically removes users that stayed deleted longer than the retention period
+// and invited users whose invite expired.
+func runPurgeJob(ctx context.Context, uc *usecase.UserUseCase, cfg config.Purge) {
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Minute)
+	defer ticker.Stop()
+
+	retention := time.Duration(cfg.DeletedUserRetention) * time.Minute
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := uc.PurgeDeleted(ctx, util.NowUTC().Add(-retention))
+			if err != nil {
+				log.Err(err).Msg("App - runPurgeJob - uc.PurgeDeleted")
+			} else if n > 0 {
+				log.Info().Int("count", n).Msg("App - runPurgeJob - deleted users purged")
+			}
+
+			n, err = uc.PurgeExpiredInvites(ctx, util.NowUTC())
+			if err != nil {
+				log.Err(err).Msg("App - runPurgeJob - uc.PurgeExpiredInvites")
+			} else if n > 0 {
+				log.Info().Int("count", n).Msg("App - runPurgeJob - expired invites purged")
+			}
+		}
+	}
+}
+
+// runAuditChainJob links the pending audit events into the chain, a full batch is followed at once
+// by the next one.
+func runAuditChainJob(ctx context.Context, uc *usecase.UserUseCase, cfg config.Audit) {
+	ticker := time.NewTicker(time.Duration(cfg.ChainInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				n, err := uc.ChainAuditEvents(ctx)
+				if err != nil {
+					log.Err(err).Msg("App - runAuditChainJob - uc.ChainAuditEvents")
+				}
+				if err != nil || n < usecase.AuditChainBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// runAuditCheckpointJob periodically signs the head of the audit chain.
+func runAuditCheckpointJob(ctx context.Context, uc *usecase.UserUseCase, cfg config.Audit) {
+	ticker := time.NewTicker(time.Duration(cfg.CheckpointInterval) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cp, err := uc.CreateAuditCheckpoint(ctx)
+			if err != nil {
+				log.Err(err).Msg("App - runAuditCheckpointJob - uc.CreateAuditCheckpoint")
+			} else if cp != nil {
+				log.Info().Int64("seq", cp.Seq).Msg("App - runAuditCheckpointJob - audit checkpoint created")
+			}
+		}
+	}
+}
+
+// runOutboxRelay publishes pending outbox events and removes the ones published longer than the retention period.
+func runOutboxRelay(ctx context.Context, uc *usecase.OutboxUseCase, cfg config.Outbox) {
+	ticker := time.NewTicker(time.Duration(cfg.RelayInterval) * time.Second)
+	defer ticker.Stop()
+
+	retention := time.Duration(cfg.Retention) * time.Minute
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// drain the backlog before waiting for the next tick
+			for {
+				n, err := uc.Relay(ctx, cfg.BatchSize)
+				if err != nil {
+					log.Err(err).Msg("App - runOutboxRelay - uc.Relay")
+				}
+				if err != nil || n < cfg.BatchSize || ctx.Err() != nil {
+					break
+				}
+			}
+
+			if _, err := uc.PurgePublished(ctx, util.NowUTC().Add(-retention)); err != nil {
+				log.Err(err).Msg("App - runOutboxRelay - uc.PurgePublished")
+			}
+		}
+	}
+}
+
+// runWebhookDelivery sends due webhook deliveries and removes the ones delivered longer than the retention period.
+func runWebhookDelivery(ctx context.Context, uc *usecase.WebhookUseCase, cfg config.Webhook) {
+	ticker := time.NewTicker(time.Duration(cfg.DeliveryInterval) * time.Second)
+	defer ticker.Stop()
+
+	retention := time.Duration(cfg.Retention) * time.Minute
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				n, err := uc.DeliverDue(ctx, cfg.BatchSize)
+				if err != nil {
+					log.Err(err).Msg("App - runWebhookDelivery - uc.DeliverDue")
+				}
+				if err != nil || n < cfg.BatchSize || ctx.Err() != nil {
+					break
+				}
+			}
+
+			if _, err := uc.PurgeDelivered(ctx, util.NowUTC().Add(-retention)); err != nil {
+				log.Err(err).Msg("App - runWebhookDelivery - uc.PurgeDelivered")
+			}
+		}
+	}
+}
+
+// runMetricsJob reads the gauges that need a query, the active sessions and the outbox backlog.
+func runMetricsJob(ctx context.Context, users *usecase.UserUseCase, outbox *usecase.OutboxUseCase, cfg config.Metrics) {
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if n, err := users.ActiveSessions(ctx); err != nil {
+			log.Err(err).Msg("App - runMetricsJob - users.ActiveSessions")
+		} else {
+			metrics.ActiveSessions.Set(float64(n))
+		}
+
+		if n, err := outbox.Backlog(ctx); err != nil {
+			log.Err(err).Msg("App - runMetricsJob - outbox.Backlog")
+		} else {
+			metrics.OutboxBacklog.Set(float64(n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// envoyCheckFullMethodName is the ext_authz Check served on the same server, Envoy calls it without a token.
+const envoyCheckFullMethodName = "/envoy.service.auth.v3.Authorization/Check"
+
+// publicMethods are called without a token. They log the caller in, or carry the token
+// they act on in the request and check it themselves.
+var publicMethods = map[string]bool{
+	AuthService_Auth_FullMethodName:                true,
+	AuthService_UpdateToken_FullMethodName:         true,
+	AuthService_RequestLoginCode_FullMethodName:    true,
+	AuthService_CompleteLoginCode_FullMethodName:   true,
+	AuthService_AcceptInvite_FullMethodName:        true,
+	AuthService_ExchangeToken_FullMethodName:       true,
+	AuthService_Impersonate_FullMethodName:         true,
+	AuthService_StartVerification_FullMethodName:   true,
+	AuthService_ConfirmVerification_FullMethodName: true,
+	AuthService_CheckAccess_FullMethodName:         true,
+	envoyCheckFullMethodName:                       true,
+}
+
+// principalMethods are public methods that take the token of the caller too when there is one. It only adds to
+// the answer, like the trace of CheckAccess for a policy manager, so an invalid token is ignored.
+var principalMethods = map[string]bool{
+	AuthService_CheckAccess_FullMethodName: true,
+}
+
+// methodPermissions is the permission the token of the caller needs for every other method.
+// A method in neither map is denied, so a new RPC must be declared here.
+var methodPermissions = map[string]model.Permission{
+	AuthService_Create_FullMethodName:                  model.PermissionUserWrite,
+	AuthService_Delete_FullMethodName:                  model.PermissionUserWrite,
+	AuthService_UpdateUser_FullMethodName:              model.PermissionUserWrite,
+	AuthService_InviteUser_FullMethodName:              model.PermissionUserWrite,
+	AuthService_RestoreUser_FullMethodName:             model.PermissionUserWrite,
+	AuthService_PurgeUser_FullMethodName:               model.PermissionUserWrite,
+	AuthService_GetUser_FullMethodName:                 model.PermissionUserRead,
+	AuthService_GetEffectivePermissions_FullMethodName: model.PermissionUserRead,
+	AuthService_WatchUserEvents_FullMethodName:         model.PermissionUserRead,
+	AuthService_ListAuditEvents_FullMethodName:         model.PermissionAuditRead,
+	AuthService_VerifyAuditLog_FullMethodName:          model.PermissionAuditRead,
+	AuthService_CreateWebhook_FullMethodName:           model.PermissionWebhook,
+	AuthService_ListWebhooks_FullMethodName:            model.PermissionWebhook,
+	AuthService_DeleteWebhook_FullMethodName:           model.PermissionWebhook,
+	AuthService_ListWebhookDeliveries_FullMethodName:   model.PermissionWebhook,
+	AuthService_ReplayWebhookDeliveries_FullMethodName: model.PermissionWebhook,
+	AuthService_CreateGroup_FullMethodName:             model.PermissionGroup,
+	AuthService_GetGroup_FullMethodName:                model.PermissionGroup,
+	AuthService_ListGroups_FullMethodName:              model.PermissionGroup,
+	AuthService_UpdateGroup_FullMethodName:             model.PermissionGroup,
+	AuthService_DeleteGroup_FullMethodName:             model.PermissionGroup,
+	AuthService_AddGroupMember_FullMethodName:          model.PermissionGroup,
+	AuthService_RemoveGroupMember_FullMethodName:       model.PermissionGroup,
+	AuthService_ListGroupMembers_FullMethodName:        model.PermissionGroup,
+	AuthService_PutPolicy_FullMethodName:               model.PermissionPolicy,
+	AuthService_ListPolicies_FullMethodName:            model.PermissionPolicy,
+	AuthService_DeletePolicy_FullMethodName:            model.PermissionPolicy,
+	AuthService_WriteTuples_FullMethodName:             model.PermissionRelationWrite,
+	AuthService_Check_FullMethodName:                   model.PermissionRelationRead,
+	AuthService_Expand_FullMethodName:                  model.PermissionRelationRead,
+	AuthService_ListObjects_FullMethodName:             model.PermissionRelationRead,
+	AuthService_ValidateToken_FullMethodName:           model.PermissionTokenValidate,
+	AuthService_ValidateTokens_FullMethodName:          model.PermissionTokenValidate,
+	AuthService_StreamValidateTokens_FullMethodName:    model.PermissionTokenValidate,
+}
+
+// actorDenied are the permissions an impersonation or delegated token, one with an act claim, can not use.
+// They change users, access rules or where events are sent, which must be done by the actor itself.
+var actorDenied = map[model.Permission]bool{
+	model.PermissionUserWrite:     true,
+	model.PermissionImpersonate:   true,
+	model.PermissionPolicy:        true,
+	model.PermissionRelationWrite: true,
+	model.PermissionWebhook:       true,
+}
+
+// AuthInterceptor validates the bearer token in the authorization metadata of a call for the audience of
+// the service, checks it has the permission of the method and stores it in the context as the principal of the call.
+type AuthInterceptor struct {
+	u        usecase.User
+	audience string
+}
+
+func NewAuthInterceptor(u usecase.User, audience string) *AuthInterceptor {
+	return &AuthInterceptor{
+		u:        u,
+		audience: audience,
+	}
+}
+
+func (a *AuthInterceptor) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *AuthInterceptor) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &principalStream{ServerStream: ss, ctx: ctx})
+}
+
+func (a *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.AuthInterceptor").
+		Str("method", method).Logger()
+
+	if publicMethods[method] {
+		token := bearerToken(ctx)
+		if token == "" || !principalMethods[method] {
+			return ctx, nil
+		}
+		principal, err := a.u.Validate(ctx, &dto.Validate{AccessToken: token, Audience: a.audience})
+		if err != nil {
+			zLog.Err(err).Msg("Error - Controller - AuthInterceptor - Validate of an optional token")
+			return ctx, nil
+		}
+		return dto.WithPrincipal(ctx, principal), nil
+	}
+
+	permission, ok := methodPermissions[method]
+	if !ok {
+		zLog.Error().Msg("Error - Controller - AuthInterceptor - method has no permission")
+		return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
+	}
+
+	token := bearerToken(ctx)
+	if token == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Unauthorized")
+	}
+
+	// tokens bound to another audience are refused, as are the ones of users not enabled
+	principal, err := a.u.Validate(ctx, &dto.Validate{AccessToken: token, Audience: a.audience})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - AuthInterceptor - Validate")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	if principal.Actor != nil && actorDenied[permission] {
+		zLog.Error().Msgf("Error - Controller - AuthInterceptor - <%s> acting for <%s> can not use <%s>",
+			principal.Actor.Username, principal.Username, permission)
+		return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
+	}
+
+	// the permissions are narrowed by the scopes already, a scoped token is checked against its scopes too
+	if len(principal.Scopes) > 0 && !slices.Contains(principal.Scopes, string(permission)) {
+		zLog.Error().Msgf("Error - Controller - AuthInterceptor - token of <%s> is not scoped for <%s>", principal.Username, permission)
+		return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
+	}
+
+	if !slices.Contains(principal.Permissions, permission) {
+		zLog.Error().Msgf("Error - Controller - AuthInterceptor - <%s> lacks <%s>", principal.Username, permission)
+		return nil, status.Errorf(codes.PermissionDenied, "Permission denied")
+	}
+
+	return dto.WithPrincipal(ctx, principal), nil
+}
+
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get("authorization") {
+		if scheme, token, ok := strings.Cut(v, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+// principalStream is the stream of a call with the principal in its context.
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
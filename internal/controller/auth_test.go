@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+const testAudience = "authenticator"
+
+// fakeValidator returns the principal of the token, tokens it does not know are unauthorized.
+type fakeValidator struct {
+	usecase.User
+	principals map[string]*dto.TokenInfo
+	audience   string
+}
+
+func (f *fakeValidator) Validate(_ context.Context, in *dto.Validate) (*dto.TokenInfo, error) {
+	f.audience = in.Audience
+	p, ok := f.principals[in.AccessToken]
+	if !ok {
+		return nil, model.ErrUnauthorized
+	}
+	return p, nil
+}
+
+func TestAuthorize(t *testing.T) {
+	admin := model.PermissionList([]string{model.RoleAdmin})
+	v := &fakeValidator{principals: map[string]*dto.TokenInfo{
+		"admin": {Username: "admin", Permissions: admin},
+		"scoped": {
+			Username:    "admin",
+			Permissions: []model.Permission{model.PermissionUserRead},
+			Scopes:      []string{string(model.PermissionUserRead)},
+		},
+		"service": {Username: "gateway", Permissions: model.PermissionList([]string{model.RoleService})},
+		"user":    {Username: "alice"},
+		"actor": {
+			Username:    "alice",
+			Permissions: admin,
+			Actor:       &dto.ActorClaim{Username: "admin"},
+		},
+	}}
+	a := NewAuthInterceptor(v, testAudience)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		want   codes.Code
+	}{
+		{"public method", "", AuthService_Auth_FullMethodName, codes.OK},
+		{"no token", "", AuthService_GetUser_FullMethodName, codes.Unauthenticated},
+		{"unknown token", "other", AuthService_GetUser_FullMethodName, codes.Unauthenticated},
+		{"permission granted", "admin", AuthService_Create_FullMethodName, codes.OK},
+		{"scoped permission", "scoped", AuthService_GetUser_FullMethodName, codes.OK},
+		{"permission outside the scopes", "scoped", AuthService_Create_FullMethodName, codes.PermissionDenied},
+		{"actor reads", "actor", AuthService_GetUser_FullMethodName, codes.OK},
+		{"actor writes users", "actor", AuthService_Create_FullMethodName, codes.PermissionDenied},
+		{"actor puts policies", "actor", AuthService_PutPolicy_FullMethodName, codes.PermissionDenied},
+		{"actor writes relations", "actor", AuthService_WriteTuples_FullMethodName, codes.PermissionDenied},
+		{"actor manages webhooks", "actor", AuthService_CreateWebhook_FullMethodName, codes.PermissionDenied},
+		{"validation without a token", "", AuthService_ValidateToken_FullMethodName, codes.Unauthenticated},
+		{"validation by a user", "user", AuthService_ValidateToken_FullMethodName, codes.PermissionDenied},
+		{"validation by a service", "service", AuthService_ValidateToken_FullMethodName, codes.OK},
+		{"batch validation without a token", "", AuthService_ValidateTokens_FullMethodName, codes.Unauthenticated},
+		{"batch validation by a user", "user", AuthService_ValidateTokens_FullMethodName, codes.PermissionDenied},
+		{"batch validation by a service", "service", AuthService_ValidateTokens_FullMethodName, codes.OK},
+		{"stream validation by a user", "user", AuthService_StreamValidateTokens_FullMethodName, codes.PermissionDenied},
+		{"stream validation by a service", "service", AuthService_StreamValidateTokens_FullMethodName, codes.OK},
+		{"service reads users", "service", AuthService_GetUser_FullMethodName, codes.PermissionDenied},
+		{"undeclared method", "admin", "/authenticator.AuthService/Unknown", codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+			}
+
+			_, err := a.authorize(ctx, tt.method)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("authorize = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if v.audience != testAudience {
+		t.Fatalf("validated for audience %q, want %q", v.audience, testAudience)
+	}
+}
+
+func TestAuthorizeOptionalPrincipal(t *testing.T) {
+	v := &fakeValidator{principals: map[string]*dto.TokenInfo{
+		"manager": {Username: "root", Permissions: []model.Permission{model.PermissionPolicy}},
+	}}
+	a := NewAuthInterceptor(v, testAudience)
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		want   string
+	}{
+		{"check access without a token", "", AuthService_CheckAccess_FullMethodName, ""},
+		{"check access with a token", "manager", AuthService_CheckAccess_FullMethodName, "root"},
+		{"check access with an unknown token", "other", AuthService_CheckAccess_FullMethodName, ""},
+		{"other public method with a token", "manager", AuthService_Auth_FullMethodName, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+			}
+
+			ctx, err := a.authorize(ctx, tt.method)
+			if err != nil {
+				t.Fatalf("authorize = %v, want the public method allowed", err)
+			}
+			var got string
+			if p := dto.PrincipalFrom(ctx); p != nil {
+				got = p.Username
+			}
+			if got != tt.want {
+				t.Fatalf("principal = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if v.audience != testAudience {
+		t.Fatalf("validated for audience %q, want %q", v.audience, testAudience)
+	}
+}
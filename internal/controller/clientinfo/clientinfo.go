@@ -0,0 +1,97 @@
+// Package clientinfo tells the address and the user agent of a caller for the audit log. The
+// address is the peer of the connection, X-Forwarded-For is only believed when the peer is one of
+// the trusted proxies.
+package clientinfo
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"authenticator/internal/dto"
+)
+
+// Proxies are the networks of the trusted reverse proxies.
+type Proxies []netip.Prefix
+
+// ParseProxies parses a comma separated list of CIDRs or addresses, an empty list trusts no proxy.
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy <%s>: %w", v, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy <%s>: %w", v, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p Proxies) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIp returns the address of the client behind the peer. When the peer is a trusted proxy,
+// the X-Forwarded-For values are read from the right and the first address that is not a trusted
+// proxy is the client, since only the proxies appended to the header are known to be honest.
+func (p Proxies) ClientIp(remoteAddr string, forwardedFor []string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+
+	if !p.trusted(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range forwardedFor {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			return ip
+		}
+		ip = hop
+		if !p.trusted(hop) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+// FromRequest returns the client of the HTTP request.
+func (p Proxies) FromRequest(r *http.Request) dto.ClientInfo {
+	return dto.ClientInfo{
+		Ip:        p.ClientIp(r.RemoteAddr, r.Header.Values("X-Forwarded-For")),
+		UserAgent: r.UserAgent(),
+	}
+}
@@ -0,0 +1,60 @@
+package clientinfo
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("ParseProxies: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"no header", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted peer", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hop", "10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.1.2.3:5000", []string{"198.51.100.1, 192.168.1.1"}, "198.51.100.1"},
+		{"several headers", "10.1.2.3:5000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage hop", "10.1.2.3:5000", []string{"not-an-ip"}, "10.1.2.3"},
+		{"only proxies", "10.1.2.3:5000", []string{"10.9.9.9"}, "10.9.9.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxies.ClientIp(tt.remoteAddr, tt.forwardedFor); got != tt.want {
+				t.Fatalf("ClientIp = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoTrustedProxies(t *testing.T) {
+	proxies, err := ParseProxies("")
+	if err != nil {
+		t.Fatalf("ParseProxies: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("User-Agent", "test")
+
+	info := proxies.FromRequest(r)
+	if info.Ip != "127.0.0.1" || info.UserAgent != "test" {
+		t.Fatalf("FromRequest = %+v, want the peer address", info)
+	}
+}
+
+func TestParseProxiesRejectsGarbage(t *testing.T) {
+	if _, err := ParseProxies("10.0.0.0/8,nope"); err == nil {
+		t.Fatal("ParseProxies accepted an invalid CIDR")
+	}
+}
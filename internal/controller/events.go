@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func (r *UserRouter) WatchUserEvents(in *WatchUserEventsRequest, stream AuthService_WatchUserEventsServer) error {
+
+	ctx := stream.Context()
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "WatchUserEvents").Logger()
+
+	watchRequest := &dto.WatchUserEvents{
+		Cursor:     in.Cursor,
+		FromOldest: in.FromOldest,
+		Types:      in.Types,
+	}
+
+	if in.UserId != "" {
+		id, err := uuid.Parse(in.UserId)
+		if err != nil {
+			zLog.Err(err).Msg("Error - Controller - User - WatchUserEvents - uuid.Parse")
+			return dto.NewGrpcError(model.ErrBadRequest)
+		}
+		watchRequest.UserId = id
+	}
+
+	err := r.e.WatchUserEvents(ctx, watchRequest, func(ev *model.OutboxEvent) error {
+		return stream.Send(&UserEvent{
+			Cursor:   ev.Pos,
+			Id:       ev.Id.String(),
+			Type:     ev.Type,
+			UserId:   ev.AggregateId.String(),
+			Payload:  ev.Payload,
+			CreateTs: ev.CreateTs.Unix(),
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		zLog.Err(err).Msg("Error - Controller - User - WatchUserEvents")
+		return dto.NewGrpcError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+// Package extauthz implements the Envoy external authorization service (envoy.service.auth.v3.Authorization),
+// so Envoy can check the bearer token of a request with the authenticator before routing it upstream.
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/rs/zerolog"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// Headers set on the request forwarded upstream. They always replace what the client sent.
+const (
+	HeaderUserId   = "x-user-id"
+	HeaderUsername = "x-username"
+	HeaderRoles    = "x-user-roles"
+)
+
+// Server allows a request when its bearer token is valid, and adds the user to the upstream request headers.
+type Server struct {
+	u usecase.User
+}
+
+func NewServer(u usecase.User) *Server {
+	return &Server{
+		u: u,
+	}
+}
+
+func (s *Server) Check(ctx context.Context, in *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.extauthz").
+		Str("method", "Check").Logger()
+
+	token, ok := bearerToken(in.GetAttributes().GetRequest().GetHttp().GetHeaders())
+	if !ok {
+		return denied(model.ErrUnauthorized), nil
+	}
+
+	data, err := s.u.Validate(ctx, &dto.Validate{AccessToken: token})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - extauthz - Check")
+		return denied(err), nil
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{
+					header(HeaderUserId, data.UserId.String()),
+					header(HeaderUsername, data.Username),
+					header(HeaderRoles, strings.Join(data.Roles, ",")),
+				},
+			},
+		},
+	}, nil
+}
+
+// bearerToken reads the token of the Authorization header. Envoy passes the header names in lower case.
+func bearerToken(headers map[string]string) (string, bool) {
+	scheme, token, ok := strings.Cut(headers["authorization"], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// denied answers 401 for a missing or invalid token. Errors of the authenticator itself deny with 503,
+// so they can be told apart from bad tokens.
+func denied(err error) *authv3.CheckResponse {
+	code, httpCode, message := codes.Unavailable, typev3.StatusCode_ServiceUnavailable, "Service unavailable"
+	switch {
+	case errors.Is(err, model.ErrUnauthorized), errors.Is(err, model.ErrNotFound):
+		code, httpCode, message = codes.Unauthenticated, typev3.StatusCode_Unauthorized, "Unauthorized"
+	}
+
+	var headers []*corev3.HeaderValueOption
+	if httpCode == typev3.StatusCode_Unauthorized {
+		headers = append(headers, header("www-authenticate", "Bearer"))
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: httpCode},
+				Headers: headers,
+				Body:    message,
+			},
+		},
+	}
+}
+
+func header(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
@@ -0,0 +1,120 @@
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+var aliceId = uuid.New()
+
+// fakeUsers accepts the token "valid" of alice and fails the token "down" like an unreachable database.
+type fakeUsers struct {
+	usecase.User
+}
+
+func (fakeUsers) Validate(_ context.Context, in *dto.Validate) (*dto.TokenInfo, error) {
+	switch in.AccessToken {
+	case "valid":
+		return &dto.TokenInfo{UserId: aliceId, Username: "alice", Roles: []string{"admin", "support"}}, nil
+	case "down":
+		return nil, errors.New("connection refused")
+	}
+	return nil, model.ErrUnauthorized
+}
+
+func checkRequest(authorization string) *authv3.CheckRequest {
+	headers := map[string]string{}
+	if authorization != "" {
+		headers["authorization"] = authorization
+	}
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: headers},
+			},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          codes.Code
+		httpCode      typev3.StatusCode
+	}{
+		{"no token", "", codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"basic auth", "Basic YWxpY2U6c2VjcmV0", codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"empty bearer token", "Bearer  ", codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"invalid token", "Bearer other", codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"unavailable", "Bearer down", codes.Unavailable, typev3.StatusCode_ServiceUnavailable},
+		{"valid token", "Bearer valid", codes.OK, 0},
+		{"scheme in any case", "bearer valid", codes.OK, 0},
+	}
+
+	s := NewServer(fakeUsers{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Check(context.Background(), checkRequest(tt.authorization))
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if got := codes.Code(resp.GetStatus().GetCode()); got != tt.code {
+				t.Fatalf("Check = %v, want %v", got, tt.code)
+			}
+			if tt.code == codes.OK {
+				return
+			}
+
+			denied := resp.GetDeniedResponse()
+			if got := denied.GetStatus().GetCode(); got != tt.httpCode {
+				t.Fatalf("denied with %v, want %v", got, tt.httpCode)
+			}
+			challenged := len(denied.GetHeaders()) == 1 && denied.GetHeaders()[0].GetHeader().GetKey() == "www-authenticate"
+			if challenged != (tt.httpCode == typev3.StatusCode_Unauthorized) {
+				t.Fatalf("denied headers = %v, want www-authenticate only with 401", denied.GetHeaders())
+			}
+		})
+	}
+}
+
+func TestCheckReplacesUserHeaders(t *testing.T) {
+	s := NewServer(fakeUsers{})
+
+	resp, err := s.Check(context.Background(), checkRequest("Bearer valid"))
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	want := map[string]string{
+		HeaderUserId:   aliceId.String(),
+		HeaderUsername: "alice",
+		HeaderRoles:    "admin,support",
+	}
+	headers := resp.GetOkResponse().GetHeaders()
+	if len(headers) != len(want) {
+		t.Fatalf("headers = %v, want %v", headers, want)
+	}
+	for _, h := range headers {
+		if want[h.GetHeader().GetKey()] != h.GetHeader().GetValue() {
+			t.Fatalf("header %s = %q, want %q", h.GetHeader().GetKey(), h.GetHeader().GetValue(), want[h.GetHeader().GetKey()])
+		}
+		// a client can not pass its own user headers upstream
+		if h.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+			t.Fatalf("header %s appended with %v, want it overwritten", h.GetHeader().GetKey(), h.GetAppendAction())
+		}
+	}
+}
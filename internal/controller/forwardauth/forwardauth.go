@@ -0,0 +1,144 @@
+// Package forwardauth serves the endpoint reverse proxies call to authenticate a request before
+// passing it upstream, such as nginx auth_request and Traefik forwardAuth.
+package forwardauth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/controller/session"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// Path is the path the endpoint is served on.
+const Path = "/forward-auth"
+
+// Response headers with the authenticated user, for the proxy to copy to the upstream request.
+const (
+	HeaderUserId   = "X-User-Id"
+	HeaderUsername = "X-Username"
+	HeaderRoles    = "X-User-Roles"
+)
+
+// HeaderPermissions lists the permissions the request needs, separated by commas.
+// The proxy must set it for the protected path, replacing any value the client sent.
+const HeaderPermissions = "X-Required-Permissions"
+
+// Handler answers 200 when the request carries a valid access token with every required permission,
+// 401 when the token is missing or invalid and 403 when a permission is missing.
+// The token is read from the Authorization bearer header, or else from the session cookie. A request authenticated
+// by the cookie must carry its original method, and a state-changing one must also pass the CSRF check of the session,
+// else it is answered 403.
+type Handler struct {
+	u       usecase.User
+	cookies session.Cookies
+}
+
+func NewHandler(u usecase.User, cookies session.Cookies) *Handler {
+	return &Handler{
+		u:       u,
+		cookies: cookies,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.forwardauth").
+		Str("method", "ServeHTTP").Logger()
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, fromCookie := h.accessToken(r)
+	if token == "" {
+		unauthorized(w)
+		return
+	}
+
+	if fromCookie {
+		// without the original method a state-changing request could pass as a safe one
+		method, ok := originalMethod(r)
+		if !ok {
+			http.Error(w, "Missing original method", http.StatusForbidden)
+			return
+		}
+		if !h.cookies.ValidCsrf(r, method) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+	}
+
+	data, err := h.u.Validate(r.Context(), &dto.Validate{AccessToken: token})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - forwardauth - Validate")
+		if errors.Is(err, model.ErrUnauthorized) || errors.Is(err, model.ErrNotFound) {
+			unauthorized(w)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	granted := make(map[model.Permission]bool, len(data.Permissions))
+	for _, p := range data.Permissions {
+		granted[p] = true
+	}
+	for _, p := range requiredPermissions(r) {
+		if !granted[p] {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+	}
+
+	w.Header().Set(HeaderUserId, data.UserId.String())
+	w.Header().Set(HeaderUsername, data.Username)
+	w.Header().Set(HeaderRoles, strings.Join(data.Roles, ","))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) accessToken(r *http.Request) (token string, fromCookie bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token), false
+	}
+	if c, err := r.Cookie(h.cookies.Access); err == nil {
+		return c.Value, true
+	}
+	return "", false
+}
+
+// originalMethod is the method of the request the proxy checks. Traefik sends it in X-Forwarded-Method,
+// nginx can be set up to send it in X-Original-Method. It reports false when neither header is set.
+func originalMethod(r *http.Request) (string, bool) {
+	for _, key := range []string{"X-Forwarded-Method", "X-Original-Method"} {
+		if m := r.Header.Get(key); m != "" {
+			return strings.ToUpper(m), true
+		}
+	}
+	return "", false
+}
+
+// requiredPermissions reads the permissions of the HeaderPermissions header and of the permissions
+// query parameter, which suits proxies that can only configure the address of the endpoint.
+func requiredPermissions(r *http.Request) []model.Permission {
+	values := append(r.Header.Values(HeaderPermissions), r.URL.Query()["permissions"]...)
+
+	var perms []model.Permission
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				perms = append(perms, model.Permission(p))
+			}
+		}
+	}
+	return perms
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,81 @@
+package forwardauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/controller/session"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// fakeUsers accepts the token "valid" of a user with the user:read permission.
+type fakeUsers struct {
+	usecase.User
+}
+
+func (fakeUsers) Validate(_ context.Context, in *dto.Validate) (*dto.TokenInfo, error) {
+	if in.AccessToken != "valid" {
+		return nil, model.ErrUnauthorized
+	}
+	return &dto.TokenInfo{
+		UserId:      uuid.New(),
+		Username:    "alice",
+		Permissions: []model.Permission{model.PermissionUserRead},
+	}, nil
+}
+
+var cookies = session.Cookies{Access: "access_token", Refresh: "refresh_token", Csrf: "csrf_token"}
+
+func TestForwardAuth(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		cookie bool
+		csrf   string
+		want   int
+	}{
+		{name: "no token", want: http.StatusUnauthorized},
+		{name: "bearer token", header: map[string]string{"Authorization": "Bearer valid"}, want: http.StatusOK},
+		{name: "invalid token", header: map[string]string{"Authorization": "Bearer other"}, want: http.StatusUnauthorized},
+		{name: "missing permission", header: map[string]string{"Authorization": "Bearer valid", HeaderPermissions: "user:write"}, want: http.StatusForbidden},
+		{name: "bearer token without original method", header: map[string]string{"Authorization": "Bearer valid"}, want: http.StatusOK},
+		{name: "cookie without original method", cookie: true, want: http.StatusForbidden},
+		{name: "cookie safe method", cookie: true, header: map[string]string{"X-Forwarded-Method": "GET"}, want: http.StatusOK},
+		{name: "cookie unsafe method without csrf", cookie: true, header: map[string]string{"X-Original-Method": "post"}, want: http.StatusForbidden},
+		{name: "cookie unsafe method with csrf", cookie: true, csrf: "c", header: map[string]string{"X-Forwarded-Method": "POST", session.HeaderCsrf: "c"}, want: http.StatusOK},
+	}
+
+	h := NewHandler(fakeUsers{}, cookies)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, Path, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: cookies.Access, Value: "valid"})
+			}
+			if tt.csrf != "" {
+				r.AddCookie(&http.Cookie{Name: cookies.Csrf, Value: tt.csrf})
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusOK && w.Header().Get(HeaderUsername) != "alice" {
+				t.Fatalf("%s = %q, want alice", HeaderUsername, w.Header().Get(HeaderUsername))
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpStatus maps the gRPC codes to HTTP statuses the way the gRPC HTTP mapping does.
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// errorResponse is the body of every failed call:
+//
+//	{"error": {"code": 404, "status": "NOT_FOUND", "grpc_code": 5, "message": "Not found"}}
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code     int    `json:"code"`
+	Status   string `json:"status"`
+	GrpcCode int    `json:"grpc_code"`
+	Message  string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code, ok := httpStatus[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	writeErrorStatus(w, code, st)
+}
+
+func writeErrorStatus(w http.ResponseWriter, code int, st *status.Status) {
+	b, _ := json.Marshal(newErrorResponse(code, st))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// errorBody is the error body of err, for errors sent inside a stream.
+func errorBody(err error) ([]byte, error) {
+	st := status.Convert(err)
+	code, ok := httpStatus[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	return json.Marshal(newErrorResponse(code, st))
+}
+
+func newErrorResponse(code int, st *status.Status) *errorResponse {
+	return &errorResponse{Error: errorDetail{
+		Code:     code,
+		Status:   statusName(st.Code()),
+		GrpcCode: int(st.Code()),
+		Message:  st.Message(),
+	}}
+}
+
+// statusName is the canonical name of the code, such as NOT_FOUND.
+func statusName(c codes.Code) string {
+	name := c.String()
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' && name[i-1] >= 'a' && name[i-1] <= 'z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
@@ -0,0 +1,222 @@
+// Package gateway serves the AuthService RPCs as JSON over HTTP for clients that can not speak gRPC.
+//
+// Every unary RPC is POST Prefix + method name with the request message as the JSON body and the
+// response message as the JSON reply, both in the protobuf JSON mapping with the field names of
+// auth.proto. Server streaming RPCs are also served on GET with the request in the query string and
+// reply with server-sent events. The calls go through the gRPC handlers and interceptors, so they
+// behave exactly like the gRPC API. The OpenAPI spec, built from the proto descriptors, is served at
+// Prefix + "openapi.json".
+package gateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Prefix is the path the gateway is served under.
+const Prefix = "/v1/"
+
+const maxBodyBytes = 1 << 20
+
+// request headers passed on to the gRPC handlers as metadata
+var forwardedHeaders = []string{
+	"authorization",
+	"user-agent",
+	"x-forwarded-for",
+	"x-request-id",
+}
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	unmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+type method struct {
+	unary  *grpc.MethodDesc
+	stream *grpc.StreamDesc
+	input  protoreflect.MessageDescriptor
+}
+
+// Handler routes the gateway requests to the service implementation srv.
+type Handler struct {
+	srv               interface{}
+	service           string
+	methods           map[string]method
+	interceptor       grpc.UnaryServerInterceptor
+	streamInterceptor grpc.StreamServerInterceptor
+	spec              []byte
+}
+
+// NewHandler serves the service described by desc, implemented by srv. The interceptors run around
+// every call in the order given, as with grpc.ChainUnaryInterceptor and grpc.ChainStreamInterceptor.
+func NewHandler(desc *grpc.ServiceDesc, srv interface{}, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor) (*Handler, error) {
+
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(desc.ServiceName))
+	if err != nil {
+		return nil, err
+	}
+	service := d.(protoreflect.ServiceDescriptor)
+
+	h := &Handler{
+		srv:               srv,
+		service:           desc.ServiceName,
+		methods:           make(map[string]method),
+		interceptor:       chain(unary),
+		streamInterceptor: chainStream(stream),
+	}
+	for i := range desc.Methods {
+		m := &desc.Methods[i]
+		h.methods[m.MethodName] = method{
+			unary: m,
+			input: service.Methods().ByName(protoreflect.Name(m.MethodName)).Input(),
+		}
+	}
+	for i := range desc.Streams {
+		s := &desc.Streams[i]
+		if s.ClientStreams {
+			// the gateway reads one request per call, client streams are left to gRPC
+			continue
+		}
+		h.methods[s.StreamName] = method{
+			stream: s,
+			input:  service.Methods().ByName(protoreflect.Name(s.StreamName)).Input(),
+		}
+	}
+
+	h.spec, err = openAPI(service, h.methods)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+
+	if name == "openapi.json" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(h.spec)
+		return
+	}
+
+	m, ok := h.methods[name]
+	if !ok {
+		writeError(w, status.Error(codes.Unimplemented, "Unknown method"))
+		return
+	}
+
+	if r.Method != http.MethodPost && (m.stream == nil || r.Method != http.MethodGet) {
+		w.Header().Set("Allow", http.MethodPost)
+		if m.stream != nil {
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		}
+		writeErrorStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "Method not allowed"))
+		return
+	}
+
+	ctx := incomingContext(r)
+
+	in := m.input
+	decode := func(v interface{}) error {
+		msg, ok := v.(proto.Message)
+		if !ok || msg.ProtoReflect().Descriptor() != in {
+			return status.Error(codes.Internal, "Internal server error")
+		}
+		if r.Method == http.MethodGet {
+			return decodeQuery(r, msg)
+		}
+		return decodeBody(w, r, msg)
+	}
+
+	if m.stream != nil {
+		h.serveStream(ctx, w, m.stream, decode)
+		return
+	}
+
+	out, err := m.unary.Handler(h.srv, ctx, decode, h.interceptor)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	b, err := marshaler.Marshal(out.(proto.Message))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// incomingContext carries the forwarded headers as metadata and the client address as the peer,
+// where the gRPC server would have them.
+func incomingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, key := range forwardedHeaders {
+		if v := r.Header.Values(key); len(v) > 0 {
+			md.Set(key, v...)
+		}
+	}
+
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	return ctx
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, msg proto.Message) error {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "Bad request")
+	}
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return nil
+	}
+	if err = unmarshaler.Unmarshal(b, msg); err != nil {
+		return status.Error(codes.InvalidArgument, "Bad request")
+	}
+	return nil
+}
+
+// chain runs the interceptors in order around the handler.
+func chain(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// chainStream runs the stream interceptors in order around the handler.
+func chainStream(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, inner)
+			}
+		}
+		return next(srv, ss)
+	}
+}
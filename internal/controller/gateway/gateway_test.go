@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"authenticator/internal/controller"
+)
+
+// fakeServer knows the user alice and streams events after the cursor, up to 3.
+type fakeServer struct {
+	controller.UnimplementedAuthServiceServer
+}
+
+func (fakeServer) GetUser(_ context.Context, in *controller.GetUserRequest) (*controller.GetUserResponse, error) {
+	if in.Username != "alice" {
+		return nil, status.Error(codes.NotFound, "Not found")
+	}
+	return &controller.GetUserResponse{Username: "alice", Email: "alice@example.com"}, nil
+}
+
+func (fakeServer) WatchUserEvents(in *controller.WatchUserEventsRequest, s controller.AuthService_WatchUserEventsServer) error {
+	for cursor := in.Cursor + 1; cursor <= 3; cursor++ {
+		if err := s.Send(&controller.UserEvent{Cursor: cursor, Type: strings.Join(in.Types, ",")}); err != nil {
+			return err
+		}
+	}
+	if in.FromOldest {
+		return status.Error(codes.FailedPrecondition, "Cursor expired")
+	}
+	return nil
+}
+
+// requireToken rejects calls without authorization metadata, like the auth interceptor.
+func requireToken(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("authorization")) == 0 {
+		return status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return nil
+}
+
+func newTestHandler(t *testing.T, calls *[]string) *Handler {
+	t.Helper()
+
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			*calls = append(*calls, name+" "+info.FullMethod)
+			return handler(ctx, req)
+		}
+	}
+	auth := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := requireToken(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	authStream := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := requireToken(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+
+	h, err := NewHandler(&controller.AuthService_ServiceDesc, fakeServer{},
+		[]grpc.UnaryServerInterceptor{record("first"), record("second"), auth},
+		[]grpc.StreamServerInterceptor{authStream})
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	return h
+}
+
+func serve(h *Handler, method, path, body string, token bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token {
+		r.Header.Set("Authorization", "Bearer t")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestUnaryCall(t *testing.T) {
+	var calls []string
+	h := newTestHandler(t, &calls)
+
+	rec := serve(h, http.MethodPost, Prefix+"GetUser", `{"username":"alice","unknown":1}`, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GetUser = %d %s, want 200", rec.Code, rec.Body)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GetUser body: %v", err)
+	}
+	if body["username"] != "alice" || body["email"] != "alice@example.com" {
+		t.Fatalf("GetUser body = %v, want alice", body)
+	}
+	// proto field names, with the unset ones too
+	if _, ok := body["email_verified"]; !ok {
+		t.Fatalf("GetUser body = %v, want email_verified", body)
+	}
+
+	method := "/" + controller.AuthService_ServiceDesc.ServiceName + "/GetUser"
+	if want := []string{"first " + method, "second " + method}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("interceptors ran %v, want %v", calls, want)
+	}
+}
+
+func TestUnaryErrors(t *testing.T) {
+	var calls []string
+	h := newTestHandler(t, &calls)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    bool
+		want     int
+		grpcCode codes.Code
+	}{
+		{"no token", http.MethodPost, Prefix + "GetUser", `{"username":"alice"}`, false, http.StatusUnauthorized, codes.Unauthenticated},
+		{"not found", http.MethodPost, Prefix + "GetUser", `{"username":"bob"}`, true, http.StatusNotFound, codes.NotFound},
+		{"bad json", http.MethodPost, Prefix + "GetUser", `{"username":`, true, http.StatusBadRequest, codes.InvalidArgument},
+		{"get of a unary call", http.MethodGet, Prefix + "GetUser", "", true, http.StatusMethodNotAllowed, codes.Unimplemented},
+		{"unknown method", http.MethodPost, Prefix + "Unknown", "", true, http.StatusNotImplemented, codes.Unimplemented},
+		{"client stream", http.MethodPost, Prefix + "StreamValidateTokens", "", true, http.StatusNotImplemented, codes.Unimplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.path, tt.body, tt.token)
+			if rec.Code != tt.want {
+				t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			}
+
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("error body %s: %v", rec.Body, err)
+			}
+			if body.Error.Code != tt.want || body.Error.GrpcCode != int(tt.grpcCode) || body.Error.Status != statusName(tt.grpcCode) {
+				t.Fatalf("error = %+v, want %d %v", body.Error, tt.want, tt.grpcCode)
+			}
+		})
+	}
+
+	if got := serve(h, http.MethodGet, Prefix+"GetUser", "", true).Header().Get("Allow"); got != http.MethodPost {
+		t.Fatalf("Allow = %q, want POST", got)
+	}
+}
+
+func TestStreamCall(t *testing.T) {
+	var calls []string
+	h := newTestHandler(t, &calls)
+
+	rec := serve(h, http.MethodGet, Prefix+"WatchUserEvents?cursor=1&types=a&types=b", "", true)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("WatchUserEvents = %d %q, want 200 text/event-stream", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+	if len(events) != 2 {
+		t.Fatalf("events = %q, want the events after cursor 1", events)
+	}
+	for i, ev := range events {
+		name, data, _ := strings.Cut(ev, "\n")
+		if name != "event: message" {
+			t.Fatalf("event %d = %q, want a message", i, ev)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &msg); err != nil {
+			t.Fatalf("event %d data: %v", i, err)
+		}
+		// int64 fields are strings in the protobuf JSON mapping
+		if msg["cursor"] != []string{"2", "3"}[i] || msg["type"] != "a,b" {
+			t.Fatalf("event %d = %v, want cursor %d of types a,b", i, msg, i+2)
+		}
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	var calls []string
+	h := newTestHandler(t, &calls)
+
+	// an error before the first message is a plain error
+	rec := serve(h, http.MethodGet, Prefix+"WatchUserEvents", "", false)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("WatchUserEvents without token = %d %q, want a 401 JSON error", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = serve(h, http.MethodGet, Prefix+"WatchUserEvents?cursor=x", "", true)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("WatchUserEvents with a bad cursor = %d, want 400", rec.Code)
+	}
+
+	// after it, an error event ends the stream
+	rec = serve(h, http.MethodGet, Prefix+"WatchUserEvents?cursor=2&from_oldest=true", "", true)
+	events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+	if rec.Code != http.StatusOK || len(events) != 2 || !strings.HasPrefix(events[1], "event: error\n") {
+		t.Fatalf("WatchUserEvents = %d %q, want a message and an error event", rec.Code, events)
+	}
+
+	var body errorResponse
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.SplitN(events[1], "\n", 2)[1], "data: ")), &body); err != nil {
+		t.Fatalf("error event: %v", err)
+	}
+	if body.Error.GrpcCode != int(codes.FailedPrecondition) {
+		t.Fatalf("error event = %+v, want FAILED_PRECONDITION", body.Error)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	var calls []string
+	h := newTestHandler(t, &calls)
+
+	rec := serve(h, http.MethodGet, Prefix+"openapi.json", "", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("openapi.json = %d, want 200", rec.Code)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	if _, ok := spec.Paths[Prefix+"GetUser"]["post"]; !ok {
+		t.Fatalf("openapi.json has no POST %sGetUser", Prefix)
+	}
+	if _, ok := spec.Paths[Prefix+"WatchUserEvents"]["get"]; !ok {
+		t.Fatalf("openapi.json has no GET %sWatchUserEvents", Prefix)
+	}
+	if _, ok := spec.Paths[Prefix+"StreamValidateTokens"]; ok {
+		t.Fatalf("openapi.json has the client stream StreamValidateTokens")
+	}
+}
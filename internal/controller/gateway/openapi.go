@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"encoding/json"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type object = map[string]interface{}
+
+// openAPI builds the OpenAPI 3 spec of the served methods from the service descriptor,
+// so it always matches auth.proto.
+func openAPI(service protoreflect.ServiceDescriptor, methods map[string]method) ([]byte, error) {
+
+	schemas := object{
+		"Error": object{
+			"type": "object",
+			"properties": object{
+				"error": object{
+					"type": "object",
+					"properties": object{
+						"code":      object{"type": "integer", "description": "HTTP status"},
+						"status":    object{"type": "string", "description": "gRPC status name, such as NOT_FOUND"},
+						"grpc_code": object{"type": "integer"},
+						"message":   object{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := object{}
+	for _, name := range names {
+		md := service.Methods().ByName(protoreflect.Name(name))
+		addSchema(schemas, md.Input())
+		addSchema(schemas, md.Output())
+
+		errorResponse := object{
+			"description": "error",
+			"content":     object{"application/json": object{"schema": ref("Error")}},
+		}
+		op := object{
+			"operationId": name,
+			"requestBody": object{
+				"content": object{"application/json": object{"schema": ref(string(md.Input().Name()))}},
+			},
+			"responses": object{
+				"200": object{
+					"description": "OK",
+					"content":     object{"application/json": object{"schema": ref(string(md.Output().Name()))}},
+				},
+				"default": errorResponse,
+			},
+		}
+
+		if methods[name].stream == nil {
+			paths[Prefix+name] = object{"post": op}
+			continue
+		}
+
+		events := object{
+			"description": "server-sent events, each message event holds a " + string(md.Output().Name()),
+			"content":     object{"text/event-stream": object{"schema": object{"type": "string"}}},
+		}
+		op["responses"] = object{"200": events, "default": errorResponse}
+		get := object{
+			"operationId": name + "Events",
+			"parameters":  queryParameters(md.Input()),
+			"responses":   object{"200": events, "default": errorResponse},
+		}
+		paths[Prefix+name] = object{"post": op, "get": get}
+	}
+
+	return json.MarshalIndent(object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   string(service.Name()),
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}, "", "  ")
+}
+
+func addSchema(schemas object, md protoreflect.MessageDescriptor) {
+	name := string(md.Name())
+	if _, ok := schemas[name]; ok {
+		return
+	}
+
+	properties := object{}
+	schemas[name] = object{"type": "object", "properties": properties}
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		switch {
+		case fd.IsMap():
+			properties[string(fd.Name())] = object{"type": "object", "additionalProperties": fieldSchema(schemas, fd.MapValue())}
+		case fd.IsList():
+			properties[string(fd.Name())] = object{"type": "array", "items": fieldSchema(schemas, fd)}
+		default:
+			properties[string(fd.Name())] = fieldSchema(schemas, fd)
+		}
+	}
+}
+
+// fieldSchema is the schema of a single value of the field in the protobuf JSON mapping,
+// where 64 bit integers are strings.
+func fieldSchema(schemas object, fd protoreflect.FieldDescriptor) object {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return object{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return object{"type": "integer", "format": "int32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return object{"type": "string", "format": "int64"}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return object{"type": "number"}
+	case protoreflect.BytesKind:
+		return object{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return object{"type": "string", "enum": names}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		addSchema(schemas, fd.Message())
+		return ref(string(fd.Message().Name()))
+	}
+	return object{"type": "string"}
+}
+
+// queryParameters are the fields decodeQuery accepts.
+func queryParameters(md protoreflect.MessageDescriptor) []object {
+	var params []object
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			continue
+		}
+		schema := fieldSchema(nil, fd)
+		if fd.IsList() {
+			schema = object{"type": "array", "items": schema}
+		}
+		params = append(params, object{
+			"name":   string(fd.Name()),
+			"in":     "query",
+			"schema": schema,
+		})
+	}
+	return params
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// serveStream replies with server-sent events: a message event per response message and an error
+// event when the call fails after the stream started. Errors before the first message are plain
+// JSON errors.
+func (h *Handler) serveStream(ctx context.Context, w http.ResponseWriter, desc *grpc.StreamDesc, decode func(interface{}) error) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, status.Error(codes.Unimplemented, "Streaming not supported"))
+		return
+	}
+
+	s := &eventStream{ctx: ctx, w: w, flusher: flusher, decode: decode}
+	info := &grpc.StreamServerInfo{
+		FullMethod:     "/" + h.service + "/" + desc.StreamName,
+		IsServerStream: desc.ServerStreams,
+	}
+	err := h.streamInterceptor(h.srv, s, info, desc.Handler)
+	if err == nil {
+		return
+	}
+
+	if !s.started {
+		writeError(w, err)
+		return
+	}
+
+	b, _ := errorBody(err)
+	_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+	flusher.Flush()
+}
+
+// eventStream is the grpc.ServerStream of a streaming call served over HTTP.
+type eventStream struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	flusher http.Flusher
+	decode  func(interface{}) error
+	started bool
+}
+
+func (s *eventStream) SetHeader(metadata.MD) error  { return nil }
+func (s *eventStream) SendHeader(metadata.MD) error { return nil }
+func (s *eventStream) SetTrailer(metadata.MD)       {}
+
+func (s *eventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *eventStream) RecvMsg(m interface{}) error {
+	return s.decode(m)
+}
+
+func (s *eventStream) SendMsg(m interface{}) error {
+	b, err := marshaler.Marshal(m.(proto.Message))
+	if err != nil {
+		return err
+	}
+
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+	}
+
+	if _, err = fmt.Fprintf(s.w, "event: message\ndata: %s\n\n", b); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+
+	return nil
+}
+
+// decodeQuery sets the scalar and repeated scalar fields of the request from the query string,
+// keyed by their proto or JSON names, so a stream can be opened with a plain GET, as EventSource does.
+func decodeQuery(r *http.Request, msg proto.Message) error {
+	m := msg.ProtoReflect()
+	fields := m.Descriptor().Fields()
+
+	for key, values := range r.URL.Query() {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			fd = fields.ByJSONName(key)
+		}
+		if fd == nil || fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			continue
+		}
+
+		for _, v := range values {
+			value, err := scalar(fd, v)
+			if err != nil {
+				return status.Error(codes.InvalidArgument, "Bad request")
+			}
+			if fd.IsList() {
+				m.Mutable(fd).List().Append(value)
+			} else {
+				m.Set(fd, value)
+			}
+		}
+	}
+
+	return nil
+}
+
+func scalar(fd protoreflect.FieldDescriptor, v string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(v)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(v, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(v, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(v, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(v)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported value %q for %s", v, fd.Name())
+}
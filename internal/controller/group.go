@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func (r *UserRouter) CreateGroup(ctx context.Context, in *CreateGroupRequest) (*CreateGroupResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "CreateGroup").Logger()
+
+	createRequest := &dto.CreateGroup{
+		Name:        in.Name,
+		Description: in.Description,
+		Roles:       in.Roles,
+	}
+
+	data, err := r.g.CreateGroup(ctx, createRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - CreateGroup")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &CreateGroupResponse{Group: newGroup(data)}, nil
+}
+
+func (r *UserRouter) GetGroup(ctx context.Context, in *GetGroupRequest) (*GetGroupResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "GetGroup").Logger()
+
+	id, err := uuid.Parse(in.Id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - GetGroup - uuid.Parse")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	data, err := r.g.GetGroup(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - GetGroup")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &GetGroupResponse{Group: newGroup(data)}, nil
+}
+
+func (r *UserRouter) ListGroups(ctx context.Context, in *ListGroupsRequest) (*ListGroupsResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListGroups").Logger()
+
+	data, err := r.g.ListGroups(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListGroups")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ListGroupsResponse{
+		Groups: make([]*Group, 0, len(data)),
+	}
+	for i := range data {
+		res.Groups = append(res.Groups, newGroup(&data[i]))
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) UpdateGroup(ctx context.Context, in *UpdateGroupRequest) (*UpdateGroupResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "UpdateGroup").Logger()
+
+	id, err := uuid.Parse(in.Id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - UpdateGroup - uuid.Parse")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	updateRequest := &dto.UpdateGroup{
+		Id:          id,
+		Description: in.Description,
+		Roles:       in.Roles,
+	}
+
+	data, err := r.g.UpdateGroup(ctx, updateRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - UpdateGroup")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &UpdateGroupResponse{Group: newGroup(data)}, nil
+}
+
+func (r *UserRouter) DeleteGroup(ctx context.Context, in *DeleteGroupRequest) (*DeleteGroupResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "DeleteGroup").Logger()
+
+	id, err := uuid.Parse(in.Id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - DeleteGroup - uuid.Parse")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	err = r.g.DeleteGroup(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - DeleteGroup")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &DeleteGroupResponse{}, nil
+}
+
+func (r *UserRouter) AddGroupMember(ctx context.Context, in *GroupMemberRequest) (*GroupMemberResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "AddGroupMember").Logger()
+
+	memberRequest, err := newGroupMember(in)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - AddGroupMember - newGroupMember")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	err = r.g.AddGroupMember(ctx, memberRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - AddGroupMember")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &GroupMemberResponse{}, nil
+}
+
+func (r *UserRouter) RemoveGroupMember(ctx context.Context, in *GroupMemberRequest) (*GroupMemberResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "RemoveGroupMember").Logger()
+
+	memberRequest, err := newGroupMember(in)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - RemoveGroupMember - newGroupMember")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	err = r.g.RemoveGroupMember(ctx, memberRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - RemoveGroupMember")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &GroupMemberResponse{}, nil
+}
+
+func (r *UserRouter) ListGroupMembers(ctx context.Context, in *ListGroupMembersRequest) (*ListGroupMembersResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListGroupMembers").Logger()
+
+	id, err := uuid.Parse(in.GroupId)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListGroupMembers - uuid.Parse")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	data, err := r.g.ListGroupMembers(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListGroupMembers")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ListGroupMembersResponse{
+		Users:  make([]*GroupMemberUser, 0, len(data.Users)),
+		Groups: make([]*Group, 0, len(data.Groups)),
+	}
+	for _, u := range data.Users {
+		res.Users = append(res.Users, &GroupMemberUser{
+			UserId:   u.Id.String(),
+			Username: u.Username,
+		})
+	}
+	for i := range data.Groups {
+		res.Groups = append(res.Groups, newGroup(&data.Groups[i]))
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) GetEffectivePermissions(ctx context.Context, in *GetEffectivePermissionsRequest) (*GetEffectivePermissionsResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "GetEffectivePermissions").Logger()
+
+	data, err := r.g.GetEffectiveAccess(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - GetEffectivePermissions")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &GetEffectivePermissionsResponse{
+		Groups:      data.Groups,
+		Roles:       data.Roles,
+		Permissions: permissionStrings(data.Permissions),
+	}, nil
+}
+
+func newGroup(g *model.Group) *Group {
+	return &Group{
+		Id:          g.Id.String(),
+		Name:        g.Name,
+		Description: g.Description,
+		Roles:       g.Roles,
+		CreateTs:    g.CreateTs.Unix(),
+		UpdateTs:    g.UpdateTs.Unix(),
+	}
+}
+
+func newGroupMember(in *GroupMemberRequest) (*dto.GroupMember, error) {
+	groupId, err := uuid.Parse(in.GroupId)
+	if err != nil {
+		return nil, model.ErrBadRequest
+	}
+
+	member := &dto.GroupMember{
+		GroupId:  groupId,
+		Username: in.Username,
+	}
+
+	if in.MemberGroupId != "" {
+		if member.MemberGroupId, err = uuid.Parse(in.MemberGroupId); err != nil {
+			return nil, model.ErrBadRequest
+		}
+	}
+
+	return member, nil
+}
+
+func permissionStrings(perms []model.Permission) []string {
+	out := make([]string, 0, len(perms))
+	for _, p := range perms {
+		out = append(out, string(p))
+	}
+	return out
+}
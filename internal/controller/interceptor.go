@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"authenticator/internal/controller/clientinfo"
+	"authenticator/internal/dto"
+)
+
+// NewClientInfoInterceptor stores the address and the user agent of the caller in the context.
+// x-forwarded-for is only read from the trusted proxies.
+func NewClientInfoInterceptor(proxies clientinfo.Proxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(dto.WithClientInfo(ctx, clientInfo(ctx, proxies)), req)
+	}
+}
+
+func clientInfo(ctx context.Context, proxies clientinfo.Proxies) dto.ClientInfo {
+	var info dto.ClientInfo
+
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	info.Ip = proxies.ClientIp(remoteAddr, md.Get("x-forwarded-for"))
+	if v := md.Get("user-agent"); len(v) > 0 {
+		info.UserAgent = v[0]
+	}
+
+	return info
+}
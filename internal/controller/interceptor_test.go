@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"authenticator/internal/controller/clientinfo"
+)
+
+func TestClientInfoReadsForwardedForFromTrustedProxies(t *testing.T) {
+	proxies, err := clientinfo.ParseProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("ParseProxies: %v", err)
+	}
+
+	md := metadata.Pairs("x-forwarded-for", "198.51.100.1", "user-agent", "test")
+	for _, tt := range []struct {
+		peer string
+		want string
+	}{
+		{"10.0.0.5", "198.51.100.1"},
+		{"203.0.113.7", "203.0.113.7"},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 5000}})
+
+		info := clientInfo(ctx, proxies)
+		if info.Ip != tt.want || info.UserAgent != "test" {
+			t.Fatalf("clientInfo from %s = %+v, want ip %s", tt.peer, info, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func (r *UserRouter) PutPolicy(ctx context.Context, in *PutPolicyRequest) (*PutPolicyResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "PutPolicy").Logger()
+
+	putRequest := &dto.PutPolicy{
+		Realm:       in.Realm,
+		Name:        in.Name,
+		Description: in.Description,
+		Effect:      in.Effect,
+		Actions:     in.Actions,
+		Condition:   in.Condition,
+	}
+
+	data, err := r.p.PutPolicy(ctx, putRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - PutPolicy")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &PutPolicyResponse{Policy: newPolicy(data)}, nil
+}
+
+func (r *UserRouter) ListPolicies(ctx context.Context, in *ListPoliciesRequest) (*ListPoliciesResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListPolicies").Logger()
+
+	data, err := r.p.ListPolicies(ctx, in.Realm)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListPolicies")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ListPoliciesResponse{
+		Policies: make([]*Policy, 0, len(data)),
+	}
+	for i := range data {
+		res.Policies = append(res.Policies, newPolicy(&data[i]))
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) DeletePolicy(ctx context.Context, in *DeletePolicyRequest) (*DeletePolicyResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "DeletePolicy").Logger()
+
+	err := r.p.DeletePolicy(ctx, in.Realm, in.Name)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - DeletePolicy")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &DeletePolicyResponse{}, nil
+}
+
+func (r *UserRouter) CheckAccess(ctx context.Context, in *CheckAccessRequest) (*CheckAccessResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "CheckAccess").Logger()
+
+	checkRequest := &dto.CheckAccess{
+		SubjectToken: in.SubjectToken,
+		Realm:        in.Realm,
+		Action:       in.Action,
+		Context:      in.Context,
+		Explain:      in.Explain,
+	}
+	if in.Resource != nil {
+		checkRequest.ResourceType = in.Resource.Type
+		checkRequest.ResourceId = in.Resource.Id
+		checkRequest.ResourceAttributes = in.Resource.Attributes
+	}
+
+	data, err := r.p.CheckAccess(ctx, checkRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - CheckAccess")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &CheckAccessResponse{
+		Allowed: data.Allowed,
+		Reason:  data.Reason,
+		Policy:  data.Policy,
+		Trace:   make([]*PolicyTrace, 0, len(data.Trace)),
+	}
+	for _, t := range data.Trace {
+		res.Trace = append(res.Trace, &PolicyTrace{
+			Policy:  t.Policy,
+			Effect:  string(t.Effect),
+			Matched: t.Matched,
+			Error:   t.Error,
+		})
+	}
+
+	return res, nil
+}
+
+func newPolicy(p *model.Policy) *Policy {
+	return &Policy{
+		Id:          p.Id.String(),
+		Realm:       p.Realm,
+		Name:        p.Name,
+		Description: p.Description,
+		Effect:      string(p.Effect),
+		Actions:     p.Actions,
+		Condition:   p.Condition,
+		CreateTs:    p.CreateTs.Unix(),
+		UpdateTs:    p.UpdateTs.Unix(),
+	}
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func (r *UserRouter) WriteTuples(ctx context.Context, in *WriteTuplesRequest) (*WriteTuplesResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "WriteTuples").Logger()
+
+	writes, err := newRelationTuples(in.Writes)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - WriteTuples - newRelationTuples")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	deletes, err := newRelationTuples(in.Deletes)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - WriteTuples - newRelationTuples")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	token, err := r.t.WriteTuples(ctx, &dto.WriteTuples{Writes: writes, Deletes: deletes})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - WriteTuples")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &WriteTuplesResponse{ConsistencyToken: token}, nil
+}
+
+func (r *UserRouter) Check(ctx context.Context, in *CheckRequest) (*CheckResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "Check").Logger()
+
+	tuple, err := newRelationTuple(in.Tuple)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - Check - newRelationTuple")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	allowed, token, err := r.t.Check(ctx, &dto.CheckRelation{Tuple: tuple, Consistency: newConsistency(in.Consistency)})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - Check")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &CheckResponse{Allowed: allowed, ConsistencyToken: token}, nil
+}
+
+func (r *UserRouter) Expand(ctx context.Context, in *ExpandRequest) (*ExpandResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "Expand").Logger()
+
+	object, err := model.ParseSubject(in.Object)
+	if err != nil || object.Relation != "" {
+		zLog.Err(err).Msg("Error - Controller - User - Expand - model.ParseSubject")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+	object.Relation = in.Relation
+
+	tree, token, err := r.t.Expand(ctx, &dto.ExpandRelation{Object: object, Consistency: newConsistency(in.Consistency)})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - Expand")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &ExpandResponse{Tree: newExpandNode(tree), ConsistencyToken: token}, nil
+}
+
+func (r *UserRouter) ListObjects(ctx context.Context, in *ListObjectsRequest) (*ListObjectsResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListObjects").Logger()
+
+	subject, err := model.ParseSubject(in.Subject)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListObjects - model.ParseSubject")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	listRequest := &dto.ListObjects{
+		Namespace:   in.Namespace,
+		Relation:    in.Relation,
+		Subject:     subject,
+		Consistency: newConsistency(in.Consistency),
+	}
+
+	ids, token, err := r.t.ListObjects(ctx, listRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListObjects")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &ListObjectsResponse{ObjectIds: ids, ConsistencyToken: token}, nil
+}
+
+func newRelationTuple(in *RelationTuple) (model.RelationTuple, error) {
+	if in == nil {
+		return model.RelationTuple{}, model.ErrBadRequest
+	}
+	return model.ParseRelationTuple(in.Object + "#" + in.Relation + "@" + in.Subject)
+}
+
+func newRelationTuples(in []*RelationTuple) ([]model.RelationTuple, error) {
+	tuples := make([]model.RelationTuple, 0, len(in))
+	for _, t := range in {
+		tuple, err := newRelationTuple(t)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, nil
+}
+
+func newConsistency(in *Consistency) dto.Consistency {
+	if in == nil {
+		return dto.Consistency{}
+	}
+	return dto.Consistency{Token: in.Token, Exact: in.Exact}
+}
+
+func newExpandNode(n *model.ExpandNode) *ExpandNode {
+	object := n.Object
+	object.Relation = ""
+	node := &ExpandNode{
+		Object:   object.String(),
+		Relation: n.Object.Relation,
+		Subjects: make([]string, 0, len(n.Subjects)),
+		Children: make([]*ExpandNode, 0, len(n.Children)),
+	}
+	for _, s := range n.Subjects {
+		node.Subjects = append(node.Subjects, s.String())
+	}
+	for i := range n.Children {
+		node.Children = append(node.Children, newExpandNode(&n.Children[i]))
+	}
+	return node
+}
@@ -0,0 +1,115 @@
+package scim
+
+// The discovery endpoints describe what this server supports so identity providers
+// can adapt their requests (RFC 7643 section 5-7).
+
+type object = map[string]interface{}
+
+func serviceProviderConfig(base string) object {
+	return object{
+		"schemas":        []string{schemaServiceConfig},
+		"patch":          object{"supported": true},
+		"bulk":           object{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         object{"supported": true, "maxResults": maxCount},
+		"changePassword": object{"supported": false},
+		"sort":           object{"supported": false},
+		"etag":           object{"supported": true},
+		"authenticationSchemes": []object{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "Authentication with the token configured in SCIM_TOKEN",
+			"primary":     true,
+		}},
+		"meta": object{
+			"resourceType": "ServiceProviderConfig",
+			"location":     base + "/ServiceProviderConfig",
+		},
+	}
+}
+
+func resourceTypes(base string) []interface{} {
+	return []interface{}{
+		object{
+			"schemas":  []string{schemaResourceType},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   schemaUser,
+			"meta":     object{"resourceType": "ResourceType", "location": base + "/ResourceTypes/User"},
+		},
+		object{
+			"schemas":  []string{schemaResourceType},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   schemaGroup,
+			"meta":     object{"resourceType": "ResourceType", "location": base + "/ResourceTypes/Group"},
+		},
+	}
+}
+
+func schemas() []interface{} {
+	return []interface{}{
+		object{
+			"schemas":     []string{schemaSchema},
+			"id":          schemaUser,
+			"name":        "User",
+			"description": "User Account",
+			"attributes": []object{
+				attribute("userName", "string", false, "immutable", "server"),
+				attribute("active", "boolean", false, "readWrite", "none"),
+				attribute("password", "string", false, "writeOnly", "none"),
+				multiValued("emails", "readWrite"),
+				multiValued("phoneNumbers", "readWrite"),
+				multiValued("groups", "readOnly"),
+			},
+		},
+		object{
+			"schemas":     []string{schemaSchema},
+			"id":          schemaGroup,
+			"name":        "Group",
+			"description": "Group, one per role",
+			"attributes": []object{
+				attribute("displayName", "string", false, "readOnly", "server"),
+				multiValued("members", "readWrite"),
+			},
+		},
+	}
+}
+
+func attribute(name, typ string, multi bool, mutability, uniqueness string) object {
+	returned := "default"
+	if mutability == "writeOnly" {
+		returned = "never"
+	}
+	return object{
+		"name":        name,
+		"type":        typ,
+		"multiValued": multi,
+		"required":    name == "userName",
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    returned,
+		"uniqueness":  uniqueness,
+	}
+}
+
+func multiValued(name, mutability string) object {
+	a := attribute(name, "complex", true, mutability, "none")
+	a["subAttributes"] = []object{
+		attribute("value", "string", false, mutability, "none"),
+		attribute("type", "string", false, mutability, "none"),
+		attribute("primary", "boolean", false, mutability, "none"),
+	}
+	return a
+}
+
+func listOf(resources []interface{}) *listResponse {
+	return &listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
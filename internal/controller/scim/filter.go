@@ -0,0 +1,140 @@
+package scim
+
+import (
+	"strings"
+
+	"authenticator/internal/model"
+)
+
+// parseFilter parses the subset of the SCIM filter grammar we can serve:
+// comparisons joined by "and", for example `userName eq "bjensen" and active eq true`.
+// "or", "not" and grouping are rejected.
+func parseFilter(filter string, resolve func(attr, op, value string) (model.UserCondition, error)) ([]model.UserCondition, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []model.UserCondition
+	for i := 0; i < len(tokens); {
+		if len(conditions) > 0 {
+			if !strings.EqualFold(tokens[i], "and") {
+				return nil, errInvalidFilter
+			}
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return nil, errInvalidFilter
+		}
+
+		attr, op := tokens[i], strings.ToLower(tokens[i+1])
+		i += 2
+
+		value := ""
+		if op != model.OpPresent {
+			if i >= len(tokens) {
+				return nil, errInvalidFilter
+			}
+			value = unquote(tokens[i])
+			i++
+		}
+
+		cond, err := resolve(attr, op, value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+
+	return conditions, nil
+}
+
+// tokenize splits the filter on spaces outside of quoted strings.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	var b strings.Builder
+	quoted, escaped := false, false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			b.WriteRune(r)
+			escaped = true
+		case r == '"':
+			b.WriteRune(r)
+			quoted = !quoted
+		case !quoted && (r == '(' || r == ')' || r == '['):
+			return nil, errInvalidFilter
+		case !quoted && r == ' ':
+			if b.Len() > 0 {
+				tokens = append(tokens, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, errInvalidFilter
+	}
+	if b.Len() > 0 {
+		tokens = append(tokens, b.String())
+	}
+
+	return tokens, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+	}
+	return s
+}
+
+// userCondition maps a filter on a SCIM user attribute to a user search condition.
+func userCondition(attr, op, value string) (model.UserCondition, error) {
+	switch op {
+	case model.OpEq, model.OpNe, model.OpCo, model.OpSw, model.OpEw, model.OpPresent:
+	default:
+		return model.UserCondition{}, errInvalidFilter
+	}
+
+	switch strings.ToLower(attr) {
+	case "id":
+		return model.UserCondition{Field: model.UserFieldId, Op: op, Value: value}, nil
+	case "username":
+		return model.UserCondition{Field: model.UserFieldUsername, Op: op, Value: value}, nil
+	case "emails", "emails.value":
+		return model.UserCondition{Field: model.UserFieldEmail, Op: op, Value: value}, nil
+	case "phonenumbers", "phonenumbers.value":
+		return model.UserCondition{Field: model.UserFieldPhone, Op: op, Value: value}, nil
+	case "groups", "groups.value", "groups.display", "roles", "roles.value":
+		return model.UserCondition{Field: model.UserFieldRole, Op: op, Value: value}, nil
+	case "active":
+		active, ok := parseBool(value)
+		if !ok || (op != model.OpEq && op != model.OpNe) {
+			return model.UserCondition{}, errInvalidFilter
+		}
+		// only enabled users are active, disabled and invited ones are not
+		if active == (op == model.OpEq) {
+			return model.UserCondition{Field: model.UserFieldState, Op: model.OpEq, Value: string(model.Enabled)}, nil
+		}
+		return model.UserCondition{Field: model.UserFieldState, Op: model.OpNe, Value: string(model.Enabled)}, nil
+	}
+
+	return model.UserCondition{}, errInvalidFilter
+}
+
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
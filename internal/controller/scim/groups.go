@@ -0,0 +1,338 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+)
+
+// Groups are the built-in roles the handler may grant: the id and displayName of a group is the role name
+// and its members are the users having the role.
+
+func (h *Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	names := h.roleNames()
+	if f := q.Get("filter"); f != "" {
+		conditions, err := parseFilter(f, groupCondition)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, c := range conditions {
+			names = filterRoles(names, c.Value)
+		}
+	}
+
+	startIndex, count, err := paging(q.Get("startIndex"), q.Get("count"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	total := len(names)
+	names = names[min(startIndex-1, total):min(startIndex-1+count, total)]
+
+	resources := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		g, err := h.group(r, name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resources = append(resources, g)
+	}
+
+	writeJSON(w, http.StatusOK, &listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *Handler) getGroup(w http.ResponseWriter, r *http.Request, id string) {
+	if !slices.Contains(h.roleNames(), id) {
+		writeError(w, model.ErrNotFound)
+		return
+	}
+
+	g, err := h.group(r, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, g)
+}
+
+// patchGroup adds or removes members of a group by changing the roles of the users.
+func (h *Handler) patchGroup(w http.ResponseWriter, r *http.Request, id string) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "patchGroup").Logger()
+
+	if !slices.Contains(h.roleNames(), id) {
+		writeError(w, model.ErrNotFound)
+		return
+	}
+
+	var in patchRequest
+	if err := decode(r, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// membership per user id, true to add and false to remove
+	changes := map[uuid.UUID]bool{}
+	for _, op := range in.Operations {
+		if err := h.patchMembers(r, id, op, changes); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	for userId, member := range changes {
+		u, err := h.u.GetUserById(r.Context(), userId)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		roles := withRole(u.Roles, id, member)
+		if len(roles) == len(u.Roles) {
+			continue
+		}
+
+		if err = h.u.SetRoles(r.Context(), u.Username, roles); err != nil {
+			zLog.Err(err).Msg("Handler - error processing h.u.SetRoles")
+			writeError(w, err)
+			return
+		}
+	}
+
+	g, err := h.group(r, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, g)
+}
+
+func (h *Handler) patchMembers(r *http.Request, role string, op patchOperation, changes map[uuid.UUID]bool) error {
+	kind := strings.ToLower(op.Op)
+	path := strings.ToLower(op.Path)
+
+	switch {
+	case path == "" && (kind == "add" || kind == "replace"):
+		// the value is the group itself, only the members are mutable
+		var g struct {
+			Members json.RawMessage `json:"members"`
+		}
+		if err := json.Unmarshal(op.Value, &g); err != nil {
+			return errInvalidValue
+		}
+		if g.Members == nil {
+			return nil
+		}
+		return h.patchMembers(r, role, patchOperation{Op: op.Op, Path: "members", Value: g.Members}, changes)
+
+	case path == "members" && kind == "replace":
+		ids, err := memberIds(op.Value)
+		if err != nil {
+			return err
+		}
+		current, err := h.members(r, role)
+		if err != nil {
+			return err
+		}
+		for _, m := range current {
+			changes[uuid.MustParse(m.Value)] = false
+		}
+		for _, id := range ids {
+			changes[id] = true
+		}
+
+	case path == "members" && (kind == "add" || kind == "remove"):
+		if kind == "remove" && len(op.Value) == 0 {
+			current, err := h.members(r, role)
+			if err != nil {
+				return err
+			}
+			for _, m := range current {
+				changes[uuid.MustParse(m.Value)] = false
+			}
+			return nil
+		}
+		ids, err := memberIds(op.Value)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			changes[id] = kind == "add"
+		}
+
+	case strings.HasPrefix(path, "members[") && kind == "remove":
+		// members[value eq "<id>"]
+		conditions, err := parseFilter(strings.TrimSuffix(op.Path[len("members["):], "]"), memberCondition)
+		if err != nil || len(conditions) != 1 {
+			return errInvalidPath
+		}
+		id, err := uuid.Parse(conditions[0].Value)
+		if err != nil {
+			return errInvalidValue
+		}
+		changes[id] = false
+
+	case path == "displayname":
+		return errMutability
+
+	default:
+		return errInvalidPath
+	}
+
+	return nil
+}
+
+func (h *Handler) group(r *http.Request, role string) (*group, error) {
+	base := baseURL(r)
+	g := &group{
+		Schemas:     []string{schemaGroup},
+		Id:          role,
+		DisplayName: role,
+		Meta: &meta{
+			ResourceType: "Group",
+			Location:     base + "/Groups/" + role,
+		},
+	}
+
+	for _, excluded := range queryList(r, "excludedAttributes") {
+		if strings.EqualFold(excluded, "members") {
+			return g, nil
+		}
+	}
+
+	members, err := h.members(r, role)
+	if err != nil {
+		return nil, err
+	}
+	g.Members = members
+
+	return g, nil
+}
+
+// members lists the users having the role, at most maxCount of them.
+func (h *Handler) members(r *http.Request, role string) ([]multiValue, error) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "members").Logger()
+
+	users, _, err := h.u.SearchUsers(r.Context(), &model.UserFilter{
+		Conditions: []model.UserCondition{{Field: model.UserFieldRole, Op: model.OpEq, Value: role}},
+		Limit:      maxCount,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.u.SearchUsers")
+		return nil, err
+	}
+
+	base := baseURL(r)
+	members := make([]multiValue, 0, len(users))
+	for _, u := range users {
+		members = append(members, multiValue{
+			Value:   u.Id.String(),
+			Display: u.Username,
+			Ref:     base + "/Users/" + u.Id.String(),
+		})
+	}
+
+	return members, nil
+}
+
+func memberIds(raw json.RawMessage) ([]uuid.UUID, error) {
+	var values []multiValue
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, errInvalidValue
+	}
+
+	ids := make([]uuid.UUID, 0, len(values))
+	for _, v := range values {
+		id, err := uuid.Parse(v.Value)
+		if err != nil {
+			return nil, errInvalidValue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// groupCondition accepts `displayName eq "<role>"` and `id eq "<role>"`.
+func groupCondition(attr, op, value string) (model.UserCondition, error) {
+	attr = strings.ToLower(attr)
+	if op != model.OpEq || (attr != "displayname" && attr != "id") {
+		return model.UserCondition{}, errInvalidFilter
+	}
+	return model.UserCondition{Field: model.UserFieldRole, Op: op, Value: value}, nil
+}
+
+// memberCondition accepts `value eq "<user id>"`.
+func memberCondition(attr, op, value string) (model.UserCondition, error) {
+	if op != model.OpEq || !strings.EqualFold(attr, "value") {
+		return model.UserCondition{}, errInvalidPath
+	}
+	return model.UserCondition{Field: model.UserFieldId, Op: op, Value: value}, nil
+}
+
+// roleNames returns the roles the handler may grant that are built-in roles, sorted.
+func (h *Handler) roleNames() []string {
+	names := make([]string, 0, len(h.roles))
+	for _, role := range h.roles {
+		if _, ok := model.RolePermissions[role]; ok {
+			names = append(names, role)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func filterRoles(names []string, role string) []string {
+	for _, name := range names {
+		if name == role {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// withRole returns the roles with the role added or removed.
+func withRole(roles []string, role string, member bool) []string {
+	out := make([]string, 0, len(roles)+1)
+	for _, r := range roles {
+		if r != role {
+			out = append(out, r)
+		}
+	}
+	if member {
+		out = append(out, role)
+	}
+	return out
+}
+
+// queryList returns the comma separated values of the query parameter.
+func queryList(r *http.Request, name string) []string {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
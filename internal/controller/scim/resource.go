@@ -0,0 +1,186 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"authenticator/internal/model"
+)
+
+const (
+	contentType = "application/scim+json"
+
+	schemaUser           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaListResponse   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaPatchOp        = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError          = "urn:ietf:params:scim:api:messages:2.0:Error"
+	schemaServiceConfig  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	schemaResourceType   = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	schemaSchema         = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	defaultCount         = 100
+	maxCount             = 1000
+	maxBodyBytes         = 1 << 20
+	generatedPasswordLen = 32
+)
+
+var (
+	errInvalidFilter = errors.New("invalid filter")
+	errInvalidPath   = errors.New("invalid path")
+	errInvalidValue  = errors.New("invalid value")
+	errMutability    = errors.New("attribute is immutable")
+	errPrecondition  = errors.New("version mismatch")
+)
+
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+type multiValue struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type user struct {
+	Schemas      []string     `json:"schemas"`
+	Id           string       `json:"id,omitempty"`
+	UserName     string       `json:"userName"`
+	Active       *bool        `json:"active,omitempty"`
+	Password     string       `json:"password,omitempty"`
+	Emails       []multiValue `json:"emails,omitempty"`
+	PhoneNumbers []multiValue `json:"phoneNumbers,omitempty"`
+	Groups       []multiValue `json:"groups,omitempty"`
+	Meta         *meta        `json:"meta,omitempty"`
+}
+
+type group struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id"`
+	DisplayName string       `json:"displayName"`
+	Members     []multiValue `json:"members,omitempty"`
+	Meta        *meta        `json:"meta,omitempty"`
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type errorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func etag(version int) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+func formatTs(ts time.Time) string {
+	return ts.UTC().Format(time.RFC3339)
+}
+
+func newUser(u *model.User, base string) *user {
+	active := u.State == model.Enabled
+	item := &user{
+		Schemas:  []string{schemaUser},
+		Id:       u.Id.String(),
+		UserName: u.Username,
+		Active:   &active,
+		Meta: &meta{
+			ResourceType: "User",
+			Created:      formatTs(u.CreateTs),
+			LastModified: formatTs(u.UpdateTs),
+			Version:      etag(u.Version),
+			Location:     base + "/Users/" + u.Id.String(),
+		},
+	}
+
+	if u.Email != "" {
+		item.Emails = []multiValue{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	if u.Phone != "" {
+		item.PhoneNumbers = []multiValue{{Value: u.Phone, Type: "work", Primary: true}}
+	}
+	for _, role := range u.Roles {
+		item.Groups = append(item.Groups, multiValue{Value: role, Display: role, Ref: base + "/Groups/" + role})
+	}
+
+	return item
+}
+
+// primary returns the primary value of a multi-valued attribute, or the first one.
+func primary(values []multiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, scimType := http.StatusInternalServerError, ""
+	detail := "Internal server error"
+
+	switch {
+	case errors.Is(err, errInvalidFilter):
+		status, scimType, detail = http.StatusBadRequest, "invalidFilter", err.Error()
+	case errors.Is(err, errInvalidPath):
+		status, scimType, detail = http.StatusBadRequest, "invalidPath", err.Error()
+	case errors.Is(err, errInvalidValue), errors.Is(err, model.ErrBadRequest):
+		status, scimType, detail = http.StatusBadRequest, "invalidValue", "Bad request"
+	case errors.Is(err, errMutability):
+		status, scimType, detail = http.StatusBadRequest, "mutability", err.Error()
+	case errors.Is(err, errPrecondition), errors.Is(err, model.ErrNoRowsAffected):
+		status, detail = http.StatusPreconditionFailed, "Resource was modified"
+	case errors.Is(err, model.ErrNotFound):
+		status, detail = http.StatusNotFound, "Not found"
+	case errors.Is(err, model.ErrConflict):
+		status, scimType, detail = http.StatusConflict, "uniqueness", "Conflict"
+	case errors.Is(err, model.ErrUnauthorized):
+		status, detail = http.StatusUnauthorized, "Unauthorized"
+	}
+
+	writeJSON(w, status, &errorResponse{
+		Schemas:  []string{schemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
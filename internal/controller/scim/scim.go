@@ -0,0 +1,129 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"authenticator/internal/controller/clientinfo"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// Prefix is the path the SCIM API is served under.
+const Prefix = "/scim/v2"
+
+// Handler serves the SCIM 2.0 API (RFC 7643, RFC 7644) for identity providers that provision users.
+// Users map to users and groups map to the roles the handler may grant, any other role is neither listed
+// nor granted. Every request must carry the configured bearer token.
+type Handler struct {
+	u       usecase.User
+	token   string
+	roles   []string
+	proxies clientinfo.Proxies
+}
+
+func NewHandler(u usecase.User, token string, roles []string, proxies clientinfo.Proxies) *Handler {
+	return &Handler{
+		u:       u,
+		token:   token,
+		roles:   roles,
+		proxies: proxies,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+		writeError(w, model.ErrUnauthorized)
+		return
+	}
+
+	r = r.WithContext(dto.WithClientInfo(r.Context(), h.proxies.FromRequest(r)))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	resource, id, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/"), "/")
+
+	switch {
+	case resource == "Users" && id == "":
+		h.routeMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:  h.listUsers,
+			http.MethodPost: h.createUser,
+		})
+	case resource == "Users":
+		h.routeMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:    func(w http.ResponseWriter, r *http.Request) { h.getUser(w, r, id) },
+			http.MethodPut:    func(w http.ResponseWriter, r *http.Request) { h.replaceUser(w, r, id) },
+			http.MethodPatch:  func(w http.ResponseWriter, r *http.Request) { h.patchUser(w, r, id) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { h.deleteUser(w, r, id) },
+		})
+	case resource == "Groups" && id == "":
+		h.routeMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: h.listGroups,
+		})
+	case resource == "Groups":
+		h.routeMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:   func(w http.ResponseWriter, r *http.Request) { h.getGroup(w, r, id) },
+			http.MethodPatch: func(w http.ResponseWriter, r *http.Request) { h.patchGroup(w, r, id) },
+		})
+	case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, serviceProviderConfig(baseURL(r)))
+	case resource == "ResourceTypes" && id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, listOf(resourceTypes(baseURL(r))))
+	case resource == "Schemas" && id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, listOf(schemas()))
+	case resource == "Schemas" && r.Method == http.MethodGet:
+		for _, s := range schemas() {
+			if s.(map[string]interface{})["id"] == id {
+				writeJSON(w, http.StatusOK, s)
+				return
+			}
+		}
+		writeError(w, model.ErrNotFound)
+	default:
+		writeError(w, model.ErrNotFound)
+	}
+}
+
+func (h *Handler) routeMethods(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	handler, ok := handlers[r.Method]
+	if !ok {
+		w.Header().Set("Allow", allowed(handlers))
+		writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{
+			Schemas: []string{schemaError},
+			Status:  "405",
+			Detail:  "Method not allowed",
+		})
+		return
+	}
+	handler(w, r)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || h.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// baseURL is the absolute url of the API as the client reached it.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + Prefix
+}
+
+func allowed(handlers map[string]http.HandlerFunc) string {
+	methods := make([]string, 0, len(handlers))
+	for m := range handlers {
+		methods = append(methods, m)
+	}
+	return strings.Join(methods, ", ")
+}
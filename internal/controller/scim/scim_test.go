@@ -0,0 +1,133 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+const testToken = "scim-token"
+
+// fakeUsers is the part of the user use case the handler calls, the rest panics.
+type fakeUsers struct {
+	usecase.User
+	user       model.User
+	provisions []dto.Provision
+	changes    []dto.ChangeState
+	roles      map[string][]string
+}
+
+func (f *fakeUsers) GetUserById(_ context.Context, id uuid.UUID) (*model.User, error) {
+	if id != f.user.Id {
+		return nil, model.ErrNotFound
+	}
+	u := f.user
+	return &u, nil
+}
+
+func (f *fakeUsers) SearchUsers(context.Context, *model.UserFilter) ([]model.User, int, error) {
+	return nil, 0, nil
+}
+
+func (f *fakeUsers) Provision(_ context.Context, in *dto.Provision) error {
+	f.provisions = append(f.provisions, *in)
+	return nil
+}
+
+func (f *fakeUsers) ChangeState(_ context.Context, in *dto.ChangeState) error {
+	f.changes = append(f.changes, *in)
+	return nil
+}
+
+func (f *fakeUsers) SetRoles(_ context.Context, username string, roles []string) error {
+	f.roles[username] = roles
+	return nil
+}
+
+func newTestHandler(roles ...string) (*Handler, *fakeUsers) {
+	f := &fakeUsers{
+		user:  model.User{Id: uuid.New(), Username: "alice", State: model.Enabled, Version: 7},
+		roles: make(map[string][]string),
+	}
+	return NewHandler(f, testToken, roles, nil), f
+}
+
+func serve(h *Handler, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, Prefix+path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestGroupsAreLimitedToAllowedRoles(t *testing.T) {
+	h, f := newTestHandler(model.RoleSupport)
+
+	w := serve(h, http.MethodGet, "/Groups", "", nil)
+	var list listResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || list.TotalResults != 1 {
+		t.Fatalf("GET /Groups = %d %+v, want only the support group", w.Code, list)
+	}
+
+	add := `{"Operations":[{"op":"add","path":"members","value":[{"value":"` + f.user.Id.String() + `"}]}]}`
+	if w = serve(h, http.MethodPatch, "/Groups/"+model.RoleAdmin, add, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("PATCH /Groups/admin = %d, want 404", w.Code)
+	}
+	if len(f.roles) != 0 {
+		t.Fatalf("roles were granted: %v", f.roles)
+	}
+
+	if w = serve(h, http.MethodPatch, "/Groups/"+model.RoleSupport, add, nil); w.Code != http.StatusOK {
+		t.Fatalf("PATCH /Groups/support = %d, want 200", w.Code)
+	}
+	if roles := f.roles["alice"]; len(roles) != 1 || roles[0] != model.RoleSupport {
+		t.Fatalf("roles = %v, want support", roles)
+	}
+}
+
+func TestNoGroupsByDefault(t *testing.T) {
+	h, _ := newTestHandler()
+
+	if w := serve(h, http.MethodGet, "/Groups/"+model.RoleAdmin, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("GET /Groups/admin = %d, want 404", w.Code)
+	}
+}
+
+func TestIfMatchVersionIsPassedToTheUpdate(t *testing.T) {
+	h, f := newTestHandler()
+	path := "/Users/" + f.user.Id.String()
+	patch := `{"Operations":[{"op":"replace","path":"active","value":false}]}`
+
+	if w := serve(h, http.MethodPatch, path, patch, map[string]string{"If-Match": `W/"6"`}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PATCH with a stale If-Match = %d, want 412", w.Code)
+	}
+	if len(f.provisions) != 0 {
+		t.Fatal("the user was changed with a stale If-Match")
+	}
+
+	if w := serve(h, http.MethodPatch, path, patch, map[string]string{"If-Match": `W/"7"`}); w.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d, want 200", w.Code)
+	}
+	if len(f.provisions) != 1 || f.provisions[0].Version != 7 || f.provisions[0].Active == nil || *f.provisions[0].Active {
+		t.Fatalf("provisions = %+v, want one deactivation at version 7", f.provisions)
+	}
+
+	if w := serve(h, http.MethodDelete, path, "", map[string]string{"If-Match": `W/"7"`}); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want 204", w.Code)
+	}
+	if len(f.changes) != 1 || f.changes[0].Version != 7 || f.changes[0].State != model.Deleted {
+		t.Fatalf("state changes = %+v, want a delete at version 7", f.changes)
+	}
+}
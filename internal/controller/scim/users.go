@@ -0,0 +1,454 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+// profile is the part of a user a SCIM client can change.
+type profile struct {
+	email  string
+	phone  string
+	active *bool
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "listUsers").Logger()
+
+	q := r.URL.Query()
+
+	filter := &model.UserFilter{}
+	if f := q.Get("filter"); f != "" {
+		conditions, err := parseFilter(f, userCondition)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		filter.Conditions = conditions
+	}
+
+	startIndex, count, err := paging(q.Get("startIndex"), q.Get("count"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filter.Offset, filter.Limit = startIndex-1, count
+
+	items, total, err := h.u.SearchUsers(r.Context(), filter)
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.u.SearchUsers")
+		writeError(w, err)
+		return
+	}
+
+	base := baseURL(r)
+	resources := make([]interface{}, 0, len(items))
+	for i := range items {
+		resources = append(resources, newUser(&items[i], base))
+	}
+
+	writeJSON(w, http.StatusOK, &listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request, id string) {
+	u, err := h.userById(r, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag(u.Version) {
+		w.Header().Set("ETag", etag(u.Version))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusOK, u)
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "createUser").Logger()
+
+	var in user
+	if err := decode(r, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if in.UserName == "" {
+		writeError(w, errInvalidValue)
+		return
+	}
+
+	password := in.Password
+	if password == "" {
+		// users provisioned without a password sign in through the identity provider
+		var err error
+		password, err = util.GenerateToken(generatedPasswordLen)
+		if err != nil {
+			zLog.Err(err).Msg("Handler - error util.GenerateToken")
+			writeError(w, err)
+			return
+		}
+	}
+
+	err := h.u.Create(r.Context(), &dto.Create{
+		Username: in.UserName,
+		Password: password,
+		Email:    primary(in.Emails),
+		Phone:    primary(in.PhoneNumbers),
+	})
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.u.Create")
+		writeError(w, err)
+		return
+	}
+
+	u, err := h.u.GetUser(r.Context(), in.UserName)
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.u.GetUser")
+		writeError(w, err)
+		return
+	}
+
+	if in.Active != nil && !*in.Active {
+		u, err = h.apply(r, u, u.Version, &profile{email: u.Email, phone: u.Phone, active: in.Active})
+		if err != nil {
+			zLog.Err(err).Msg("Handler - error processing h.apply")
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", baseURL(r)+"/Users/"+u.Id.String())
+	h.writeUser(w, r, http.StatusCreated, u)
+}
+
+func (h *Handler) replaceUser(w http.ResponseWriter, r *http.Request, id string) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "replaceUser").Logger()
+
+	u, err := h.userById(r, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	version, err := ifMatch(r, u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var in user
+	if err = decode(r, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if in.UserName != "" && in.UserName != u.Username {
+		writeError(w, errMutability)
+		return
+	}
+
+	u, err = h.apply(r, u, version, &profile{
+		email:  primary(in.Emails),
+		phone:  primary(in.PhoneNumbers),
+		active: in.Active,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.apply")
+		writeError(w, err)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusOK, u)
+}
+
+func (h *Handler) patchUser(w http.ResponseWriter, r *http.Request, id string) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "patchUser").Logger()
+
+	u, err := h.userById(r, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	version, err := ifMatch(r, u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var in patchRequest
+	if err = decode(r, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	p := &profile{email: u.Email, phone: u.Phone}
+	for _, op := range in.Operations {
+		if err = patchProfile(p, u, op); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	u, err = h.apply(r, u, version, p)
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.apply")
+		writeError(w, err)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusOK, u)
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, id string) {
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.scim.Handler").
+		Str("method", "deleteUser").Logger()
+
+	u, err := h.userById(r, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	version, err := ifMatch(r, u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = h.u.ChangeState(r.Context(), &dto.ChangeState{Username: u.Username, State: model.Deleted, Version: version})
+	if err != nil {
+		zLog.Err(err).Msg("Handler - error processing h.u.ChangeState")
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apply saves the changed parts of the profile and returns the user as stored. A version other than 0
+// is the one the client read, the user is not changed if it changed since.
+func (h *Handler) apply(r *http.Request, u *model.User, version int, p *profile) (*model.User, error) {
+	ctx := r.Context()
+
+	err := h.u.Provision(ctx, &dto.Provision{
+		Id:      u.Id,
+		Email:   p.email,
+		Phone:   p.phone,
+		Active:  p.active,
+		Version: version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h.u.GetUserById(ctx, u.Id)
+}
+
+// patchProfile applies one PATCH operation to the profile.
+// Attributes we do not store, like name or displayName, are accepted and ignored.
+func patchProfile(p *profile, u *model.User, op patchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return errInvalidValue
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return errInvalidPath
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errInvalidValue
+		}
+		for attr, value := range attrs {
+			if err := patchAttribute(p, u, kind, attr, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return patchAttribute(p, u, kind, op.Path, op.Value)
+}
+
+func patchAttribute(p *profile, u *model.User, kind, path string, value json.RawMessage) error {
+	switch attributePath(path) {
+	case "active":
+		if kind == "remove" {
+			return errInvalidValue
+		}
+		active, err := boolValue(value)
+		if err != nil {
+			return err
+		}
+		p.active = &active
+	case "username":
+		s, err := stringValue(value)
+		if err != nil || s != u.Username {
+			return errMutability
+		}
+	case "password":
+		return errMutability
+	case "emails", "emails.value":
+		if kind == "remove" {
+			p.email = ""
+			return nil
+		}
+		s, err := multiValueOf(value)
+		if err != nil {
+			return err
+		}
+		p.email = s
+	case "phonenumbers", "phonenumbers.value":
+		if kind == "remove" {
+			p.phone = ""
+			return nil
+		}
+		s, err := multiValueOf(value)
+		if err != nil {
+			return err
+		}
+		p.phone = s
+	}
+
+	return nil
+}
+
+// attributePath lowercases the path and drops a value filter,
+// so `emails[type eq "work"].value` becomes `emails.value`.
+func attributePath(path string) string {
+	path = strings.ToLower(strings.TrimPrefix(path, schemaUser+":"))
+	if i := strings.IndexByte(path, '['); i >= 0 {
+		if j := strings.IndexByte(path[i:], ']'); j >= 0 {
+			path = path[:i] + path[i+j+1:]
+		}
+	}
+	return path
+}
+
+// boolValue accepts a JSON boolean or the strings "true" and "false" some identity providers send.
+func boolValue(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, ok := parseBool(s); ok {
+			return b, nil
+		}
+	}
+	return false, errInvalidValue
+}
+
+func stringValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", errInvalidValue
+	}
+	return s, nil
+}
+
+// multiValueOf reads a plain value or a list of multi-valued attributes and returns the primary one.
+func multiValueOf(raw json.RawMessage) (string, error) {
+	if s, err := stringValue(raw); err == nil {
+		return s, nil
+	}
+	var values []multiValue
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return "", errInvalidValue
+	}
+	return primary(values), nil
+}
+
+func (h *Handler) userById(r *http.Request, id string) (*model.User, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, model.ErrNotFound
+	}
+	return h.u.GetUserById(r.Context(), uid)
+}
+
+func (h *Handler) writeUser(w http.ResponseWriter, r *http.Request, status int, u *model.User) {
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, status, newUser(u, baseURL(r)))
+}
+
+// ifMatch returns the version of the If-Match header, 0 when there is none or it is "*".
+// A version other than the current one of the user fails with errPrecondition.
+func ifMatch(r *http.Request, u *model.User) (int, error) {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return 0, nil
+	}
+	if match != etag(u.Version) {
+		return 0, errPrecondition
+	}
+	return u.Version, nil
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errInvalidValue
+	}
+	return nil
+}
+
+// paging reads the 1-based startIndex and the page size, applying the defaults and the limit.
+func paging(startIndex, count string) (int, int, error) {
+	start, size := 1, defaultCount
+
+	if startIndex != "" {
+		n, err := strconv.Atoi(startIndex)
+		if err != nil {
+			return 0, 0, errInvalidValue
+		}
+		if n > 1 {
+			start = n
+		}
+	}
+
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, 0, errInvalidValue
+		}
+		size = n
+		if size < 0 {
+			size = 0
+		}
+		if size > maxCount {
+			size = maxCount
+		}
+	}
+
+	return start, size, nil
+}
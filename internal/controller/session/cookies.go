@@ -0,0 +1,94 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HeaderCsrf is the request header that must repeat the CSRF cookie on state-changing requests.
+const HeaderCsrf = "X-CSRF-Token"
+
+// Cookies describes the session cookies. The access and CSRF cookies are sent on every path,
+// the refresh cookie only to the session endpoints.
+type Cookies struct {
+	Access   string
+	Refresh  string
+	Csrf     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge is the lifetime of the cookies, the one of the refresh token. The access cookie lives as long,
+	// since a refresh needs the expired access token.
+	MaxAge time.Duration
+}
+
+// ParseSameSite parses the SameSite attribute: strict, lax or none.
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite <%s>", s)
+}
+
+// ValidCsrf reports whether the request is safe from CSRF: it uses a safe method, or it repeats
+// the CSRF cookie in HeaderCsrf (double submit). Another site can make the browser send the cookie,
+// but can not read it to set the header.
+func (c Cookies) ValidCsrf(r *http.Request, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	cookie, err := r.Cookie(c.Csrf)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Header.Get(HeaderCsrf))) == 1
+}
+
+func (c Cookies) set(w http.ResponseWriter, accessToken, refreshToken, csrfToken string) {
+	maxAge := int(c.MaxAge / time.Second)
+	http.SetCookie(w, c.cookie(c.Access, accessToken, "/", true, maxAge))
+	http.SetCookie(w, c.cookie(c.Refresh, refreshToken, Prefix, true, maxAge))
+	if csrfToken != "" {
+		// read by the page to repeat it in HeaderCsrf
+		http.SetCookie(w, c.cookie(c.Csrf, csrfToken, "/", false, maxAge))
+	}
+}
+
+func (c Cookies) clear(w http.ResponseWriter) {
+	http.SetCookie(w, c.cookie(c.Access, "", "/", true, -1))
+	http.SetCookie(w, c.cookie(c.Refresh, "", Prefix, true, -1))
+	http.SetCookie(w, c.cookie(c.Csrf, "", "/", false, -1))
+}
+
+func (c Cookies) cookie(name, value, path string, httpOnly bool, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   c.Secure,
+		HttpOnly: httpOnly,
+		SameSite: c.SameSite,
+	}
+}
+
+func newCsrfToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
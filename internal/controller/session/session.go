@@ -0,0 +1,209 @@
+// Package session serves cookie based sessions for browsers, so pages never hold the tokens:
+// the access and refresh tokens live in HttpOnly cookies and state-changing requests are
+// protected with a double-submit CSRF token.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/controller/clientinfo"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// Prefix is the path the session endpoints are served under.
+const Prefix = "/session"
+
+const maxBodyBytes = 1 << 16
+
+// Handler serves
+//   - POST Prefix/login with {"username", "password"}, which starts a session
+//   - POST Prefix/refresh, which issues new tokens and rotates the refresh cookie
+//   - POST Prefix/logout, which ends the session
+//
+// Login and refresh reply with {"csrf_token"}, the value of the CSRF cookie.
+// Refresh and logout must carry the CSRF token in HeaderCsrf. A refresh long before the access
+// token expires is answered with 409, like UpdateToken.
+type Handler struct {
+	u       usecase.User
+	cookies Cookies
+	proxies clientinfo.Proxies
+}
+
+func NewHandler(u usecase.User, cookies Cookies, proxies clientinfo.Proxies) *Handler {
+	return &Handler{
+		u:       u,
+		cookies: cookies,
+		proxies: proxies,
+	}
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type sessionResponse struct {
+	CsrfToken string `json:"csrf_token"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r = r.WithContext(dto.WithClientInfo(r.Context(), h.proxies.FromRequest(r)))
+
+	switch strings.TrimPrefix(r.URL.Path, Prefix) {
+	case "/login":
+		h.login(w, r)
+	case "/refresh":
+		h.refresh(w, r)
+	case "/logout":
+		h.logout(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.session").
+		Str("method", "login").Logger()
+
+	var in loginRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&in); err != nil {
+		writeError(w, model.ErrBadRequest)
+		return
+	}
+
+	data, err := h.u.Auth(r.Context(), &dto.AuthRequest{
+		Username: in.Username,
+		Password: in.Password,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - session - login")
+		writeError(w, err)
+		return
+	}
+
+	csrfToken, err := newCsrfToken()
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - session - newCsrfToken")
+		writeError(w, err)
+		return
+	}
+
+	h.cookies.set(w, data.AccessToken, data.RefreshToken, csrfToken)
+	writeJSON(w, sessionResponse{CsrfToken: csrfToken})
+}
+
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request) {
+
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.session").
+		Str("method", "refresh").Logger()
+
+	if !h.cookies.ValidCsrf(r, r.Method) {
+		writeError(w, model.ErrForbidden)
+		return
+	}
+
+	accessToken, refreshToken := h.tokens(r)
+	if accessToken == "" || refreshToken == "" {
+		writeError(w, model.ErrUnauthorized)
+		return
+	}
+
+	data, err := h.u.UpdateToken(r.Context(), &dto.UpdateToken{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - session - refresh")
+		switch {
+		case errors.Is(err, model.ErrUnauthorized):
+			h.cookies.clear(w)
+		case errors.Is(err, model.ErrForbidden):
+			// not a CSRF failure, the access token is still valid
+			http.Error(w, "Access token is not about to expire", http.StatusConflict)
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	csrf, _ := r.Cookie(h.cookies.Csrf)
+	h.cookies.set(w, data.AccessToken, data.RefreshToken, "")
+	writeJSON(w, sessionResponse{CsrfToken: csrf.Value})
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+
+	zLog := zerolog.Ctx(r.Context()).With().
+		Str("unit", "internal.controller.session").
+		Str("method", "logout").Logger()
+
+	if !h.cookies.ValidCsrf(r, r.Method) {
+		writeError(w, model.ErrForbidden)
+		return
+	}
+
+	// the cookies are cleared even when the session already ended
+	accessToken, refreshToken := h.tokens(r)
+	if accessToken != "" && refreshToken != "" {
+		err := h.u.Logout(r.Context(), &dto.UpdateToken{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+		})
+		if err != nil && !errors.Is(err, model.ErrUnauthorized) {
+			zLog.Err(err).Msg("Error - Controller - session - logout")
+			writeError(w, err)
+			return
+		}
+	}
+
+	h.cookies.clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) tokens(r *http.Request) (accessToken, refreshToken string) {
+	if c, err := r.Cookie(h.cookies.Access); err == nil {
+		accessToken = c.Value
+	}
+	if c, err := r.Cookie(h.cookies.Refresh); err == nil {
+		refreshToken = c.Value
+	}
+	return
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrUnauthorized):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case errors.Is(err, model.ErrForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, model.ErrBadRequest):
+		http.Error(w, "Bad request", http.StatusBadRequest)
+	case errors.Is(err, model.ErrTooManyRequests):
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
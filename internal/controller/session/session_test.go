@@ -0,0 +1,225 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"authenticator/config"
+	"authenticator/internal/controller/clientinfo"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase"
+)
+
+// fakeUsers logs in alice with "secret" and refreshes the tokens "access" and "refresh".
+type fakeUsers struct {
+	usecase.User
+	refreshed int
+	loggedOut int
+}
+
+func (f *fakeUsers) Auth(_ context.Context, in *dto.AuthRequest) (*dto.AuthResponse, error) {
+	if in.Username != "alice" || in.Password != "secret" {
+		return nil, model.ErrUnauthorized
+	}
+	return &dto.AuthResponse{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+func (f *fakeUsers) UpdateToken(_ context.Context, in *dto.UpdateToken) (*dto.UpdateToken, error) {
+	if in.AccessToken != "access" || in.RefreshToken != "refresh" {
+		return nil, model.ErrUnauthorized
+	}
+	f.refreshed++
+	return &dto.UpdateToken{AccessToken: "access2", RefreshToken: "refresh2"}, nil
+}
+
+func (f *fakeUsers) Logout(_ context.Context, _ *dto.UpdateToken) error {
+	f.loggedOut++
+	return nil
+}
+
+var cookies = Cookies{Access: "access_token", Refresh: "refresh_token", Csrf: "csrf_token"}
+
+func newTestHandler(t *testing.T) (*Handler, *fakeUsers) {
+	t.Helper()
+
+	proxies, err := clientinfo.ParseProxies("")
+	if err != nil {
+		t.Fatalf("ParseProxies: %v", err)
+	}
+	f := &fakeUsers{}
+	return NewHandler(f, cookies, proxies), f
+}
+
+// sessionRequest posts to the endpoint with the session cookies, the CSRF cookie when csrfCookie
+// is set and the CSRF header when csrfHeader is set.
+func sessionRequest(path, csrfCookie, csrfHeader string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, Prefix+path, nil)
+	r.AddCookie(&http.Cookie{Name: cookies.Access, Value: "access"})
+	r.AddCookie(&http.Cookie{Name: cookies.Refresh, Value: "refresh"})
+	if csrfCookie != "" {
+		r.AddCookie(&http.Cookie{Name: cookies.Csrf, Value: csrfCookie})
+	}
+	if csrfHeader != "" {
+		r.Header.Set(HeaderCsrf, csrfHeader)
+	}
+	return r
+}
+
+func TestLoginSetsCookies(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, Prefix+"/login", strings.NewReader(`{"username":"alice","password":"secret"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login = %d, want 200", rec.Code)
+	}
+
+	var body sessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.CsrfToken == "" {
+		t.Fatalf("login body = %+v, %v, want a CSRF token", body, err)
+	}
+
+	set := make(map[string]*http.Cookie)
+	for _, c := range rec.Result().Cookies() {
+		set[c.Name] = c
+	}
+	if c := set[cookies.Access]; c == nil || c.Value != "access" || !c.HttpOnly {
+		t.Fatalf("access cookie = %+v, want HttpOnly access", c)
+	}
+	if c := set[cookies.Refresh]; c == nil || c.Value != "refresh" || !c.HttpOnly || c.Path != Prefix {
+		t.Fatalf("refresh cookie = %+v, want HttpOnly refresh on %s", c, Prefix)
+	}
+	if c := set[cookies.Csrf]; c == nil || c.Value != body.CsrfToken || c.HttpOnly {
+		t.Fatalf("CSRF cookie = %+v, want %q readable by the page", c, body.CsrfToken)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, Prefix+"/login", strings.NewReader(`{"username":"alice","password":"wrong"}`)))
+	if rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("login with a wrong password = %d with %d cookies, want 401 without cookies", rec.Code, len(rec.Result().Cookies()))
+	}
+}
+
+func TestSessionRequiresCsrf(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		path       string
+		csrfCookie string
+		csrfHeader string
+		want       int
+	}{
+		{name: "refresh without csrf", path: "/refresh", want: http.StatusForbidden},
+		{name: "refresh without header", path: "/refresh", csrfCookie: "c", want: http.StatusForbidden},
+		{name: "refresh without cookie", path: "/refresh", csrfHeader: "c", want: http.StatusForbidden},
+		{name: "refresh with another header", path: "/refresh", csrfCookie: "c", csrfHeader: "d", want: http.StatusForbidden},
+		{name: "refresh with csrf", path: "/refresh", csrfCookie: "c", csrfHeader: "c", want: http.StatusOK},
+		{name: "logout without csrf", path: "/logout", want: http.StatusForbidden},
+		{name: "logout with another header", path: "/logout", csrfCookie: "c", csrfHeader: "d", want: http.StatusForbidden},
+		{name: "logout with csrf", path: "/logout", csrfCookie: "c", csrfHeader: "c", want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, f := newTestHandler(t)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, sessionRequest(tt.path, tt.csrfCookie, tt.csrfHeader))
+			if rec.Code != tt.want {
+				t.Fatalf("%s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+
+			// a refused request reaches neither UpdateToken nor Logout
+			if calls := f.refreshed + f.loggedOut; (tt.want == http.StatusForbidden) != (calls == 0) {
+				t.Fatalf("%d calls of the usecase, want them only for an accepted request", calls)
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsCsrfToken(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, sessionRequest("/refresh", "c", "c"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh = %d, want 200", rec.Code)
+	}
+
+	var body sessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.CsrfToken != "c" {
+		t.Fatalf("refresh body = %+v, %v, want the CSRF token of the cookie", body, err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == cookies.Csrf {
+			t.Fatalf("refresh set the CSRF cookie %+v, want it kept", c)
+		}
+	}
+}
+
+func TestSessionAllowsOnlyPost(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/logout", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET logout = %d, want 405", rec.Code)
+	}
+}
+
+var aliceId = uuid.New()
+
+// expiredUsers refreshes the tokens of alice like UpdateToken: the access token must be signed by us
+// and may be expired.
+type expiredUsers struct {
+	usecase.User
+}
+
+func (expiredUsers) UpdateToken(_ context.Context, in *dto.UpdateToken) (*dto.UpdateToken, error) {
+	claims, err := dto.ParseAccessToken(in.AccessToken)
+	if (err != nil && !dto.IsExpired(err)) || claims.ID != aliceId || in.RefreshToken != "refresh" {
+		return nil, model.ErrUnauthorized
+	}
+	return &dto.UpdateToken{AccessToken: "access2", RefreshToken: "refresh2"}, nil
+}
+
+func TestRefreshWithExpiredAccessCookie(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Jwt.Secret = "test-secret"
+	cfg.Jwt.AccessTokenExpiry = -5
+	prev := config.Conf
+	config.Conf = cfg
+	t.Cleanup(func() { config.Conf = prev })
+
+	expired, err := dto.GenerateAccessToken(&model.User{Id: aliceId, Username: "alice"}, nil)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	proxies, _ := clientinfo.ParseProxies("")
+	h := NewHandler(expiredUsers{}, cookies, proxies)
+
+	r := httptest.NewRequest(http.MethodPost, Prefix+"/refresh", nil)
+	r.AddCookie(&http.Cookie{Name: cookies.Access, Value: expired})
+	r.AddCookie(&http.Cookie{Name: cookies.Refresh, Value: "refresh"})
+	r.AddCookie(&http.Cookie{Name: cookies.Csrf, Value: "c"})
+	r.Header.Set(HeaderCsrf, "c")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh with an expired access cookie = %d, want 200", rec.Code)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == cookies.Access && c.Value != "access2" {
+			t.Fatalf("access cookie = %q, want the new access token", c.Value)
+		}
+	}
+}
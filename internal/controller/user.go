@@ -2,8 +2,13 @@ package controller
 
 import (
 	"context"
+	"io"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"authenticator/internal/dto"
 	"authenticator/internal/model"
@@ -12,12 +17,22 @@ import (
 
 type UserRouter struct {
 	u usecase.User
+	w usecase.Webhook
+	e usecase.Events
+	g usecase.Group
+	p usecase.Policy
+	t usecase.Relation
 	AuthServiceServer
 }
 
-func NewUserRouter(u usecase.User) *UserRouter {
+func NewUserRouter(u usecase.User, w usecase.Webhook, e usecase.Events, g usecase.Group, p usecase.Policy, t usecase.Relation) *UserRouter {
 	return &UserRouter{
 		u: u,
+		w: w,
+		e: e,
+		g: g,
+		p: p,
+		t: t,
 	}
 }
 
@@ -52,8 +67,11 @@ func (r *UserRouter) Create(ctx context.Context, in *CreateRequest) (*CreateResp
 		Str("method", "Create").Logger()
 
 	createRequest := &dto.Create{
-		Username: in.Username,
-		Password: in.Password,
+		Username:   in.Username,
+		Password:   in.Password,
+		Email:      in.Email,
+		Phone:      in.Phone,
+		Attributes: in.Attributes,
 	}
 
 	err := r.u.Create(ctx, createRequest)
@@ -91,13 +109,102 @@ func (r *UserRouter) ValidateToken(ctx context.Context, in *ValidateTokenRequest
 		Str("unit", "internal.controller.User").
 		Str("method", "ValidateToken").Logger()
 
-	err := r.u.Validate(ctx, in.AccessToken)
+	validateRequest := &dto.Validate{
+		AccessToken: in.AccessToken,
+		Audience:    in.Audience,
+	}
+
+	data, err := r.u.Validate(ctx, validateRequest)
 	if err != nil {
 		zLog.Err(err).Msg("Error - Controller - User - ValidateToken")
 		return nil, dto.NewGrpcError(err)
 	}
 
-	return &ValidateTokenResponse{}, nil
+	return newValidateTokenResponse(data), nil
+}
+
+func (r *UserRouter) ValidateTokens(ctx context.Context, in *ValidateTokensRequest) (*ValidateTokensResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ValidateTokens").Logger()
+
+	res, err := r.validateTokens(ctx, in)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ValidateTokens")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return res, nil
+}
+
+// StreamValidateTokens answers every batch of tokens received with their results, in order.
+func (r *UserRouter) StreamValidateTokens(stream AuthService_StreamValidateTokensServer) error {
+
+	ctx := stream.Context()
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "StreamValidateTokens").Logger()
+
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		res, err := r.validateTokens(ctx, in)
+		if err != nil {
+			zLog.Err(err).Msg("Error - Controller - User - StreamValidateTokens")
+			return dto.NewGrpcError(err)
+		}
+
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *UserRouter) validateTokens(ctx context.Context, in *ValidateTokensRequest) (*ValidateTokensResponse, error) {
+	validateRequest := make([]dto.Validate, len(in.Tokens))
+	for i, t := range in.Tokens {
+		validateRequest[i] = dto.Validate{
+			AccessToken: t.AccessToken,
+			Audience:    t.Audience,
+		}
+	}
+
+	data, err := r.u.ValidateTokens(ctx, validateRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ValidateTokensResponse{Results: make([]*TokenValidation, len(data))}
+	for i, result := range data {
+		if result.Err != nil {
+			s := status.Convert(dto.NewGrpcError(result.Err))
+			res.Results[i] = &TokenValidation{Code: int32(s.Code()), Message: s.Message()}
+			continue
+		}
+		res.Results[i] = &TokenValidation{Code: int32(codes.OK), Token: newValidateTokenResponse(result.Info)}
+	}
+
+	return res, nil
+}
+
+func newValidateTokenResponse(data *dto.TokenInfo) *ValidateTokenResponse {
+	return &ValidateTokenResponse{
+		UserId:      data.UserId.String(),
+		Username:    data.Username,
+		Roles:       data.Roles,
+		Groups:      data.Groups,
+		Permissions: permissionStrings(data.Permissions),
+		Actor:       newActor(data.Actor),
+		Scopes:      data.Scopes,
+		Audience:    data.Audience,
+	}
 }
 
 func (r *UserRouter) UpdateToken(ctx context.Context, in *UpdateTokenRequest) (*UpdateTokenResponse, error) {
@@ -124,3 +231,419 @@ func (r *UserRouter) UpdateToken(ctx context.Context, in *UpdateTokenRequest) (*
 
 	return res, nil
 }
+
+func (r *UserRouter) RestoreUser(ctx context.Context, in *RestoreUserRequest) (*RestoreUserResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "RestoreUser").Logger()
+
+	err := r.u.RestoreUser(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - RestoreUser")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &RestoreUserResponse{}, nil
+}
+
+func (r *UserRouter) PurgeUser(ctx context.Context, in *PurgeUserRequest) (*PurgeUserResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "PurgeUser").Logger()
+
+	err := r.u.PurgeUser(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - PurgeUser")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &PurgeUserResponse{}, nil
+}
+
+func (r *UserRouter) UpdateUser(ctx context.Context, in *UpdateUserRequest) (*UpdateUserResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "UpdateUser").Logger()
+
+	updateRequest := &dto.UpdateUser{
+		Username:   in.Username,
+		Email:      in.Email,
+		Phone:      in.Phone,
+		Attributes: in.Attributes,
+		Mask:       in.UpdateMask,
+	}
+
+	err := r.u.UpdateUser(ctx, updateRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - UpdateUser")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &UpdateUserResponse{}, nil
+}
+
+func (r *UserRouter) GetUser(ctx context.Context, in *GetUserRequest) (*GetUserResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "GetUser").Logger()
+
+	user, err := r.u.GetUser(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - GetUser")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &GetUserResponse{
+		Id:            user.Id.String(),
+		Username:      user.Username,
+		State:         string(user.State),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Phone:         user.Phone,
+		PhoneVerified: user.PhoneVerified,
+		Attributes:    user.Attributes,
+		CreateTs:      user.CreateTs.Unix(),
+		UpdateTs:      user.UpdateTs.Unix(),
+		Roles:         user.Roles,
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) StartVerification(ctx context.Context, in *StartVerificationRequest) (*StartVerificationResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "StartVerification").Logger()
+
+	channel, err := model.ParseChannel(in.Channel)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - StartVerification - model.ParseChannel")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	startRequest := &dto.StartVerification{
+		AccessToken: in.AccessToken,
+		Channel:     channel,
+	}
+
+	expiresIn, err := r.u.StartVerification(ctx, startRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - StartVerification")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &StartVerificationResponse{ExpiresIn: int64(expiresIn.Seconds())}, nil
+}
+
+func (r *UserRouter) ConfirmVerification(ctx context.Context, in *ConfirmVerificationRequest) (*ConfirmVerificationResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ConfirmVerification").Logger()
+
+	channel, err := model.ParseChannel(in.Channel)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ConfirmVerification - model.ParseChannel")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	confirmRequest := &dto.ConfirmVerification{
+		AccessToken: in.AccessToken,
+		Channel:     channel,
+		Code:        in.Code,
+	}
+
+	err = r.u.ConfirmVerification(ctx, confirmRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ConfirmVerification")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &ConfirmVerificationResponse{}, nil
+}
+
+func (r *UserRouter) RequestLoginCode(ctx context.Context, in *RequestLoginCodeRequest) (*RequestLoginCodeResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "RequestLoginCode").Logger()
+
+	err := r.u.RequestLoginCode(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - RequestLoginCode")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &RequestLoginCodeResponse{}, nil
+}
+
+func (r *UserRouter) CompleteLoginCode(ctx context.Context, in *CompleteLoginCodeRequest) (*AuthResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "CompleteLoginCode").Logger()
+
+	completeRequest := &dto.CompleteLoginCode{
+		Username: in.Username,
+		Code:     in.Code,
+	}
+
+	data, err := r.u.CompleteLoginCode(ctx, completeRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - CompleteLoginCode")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &AuthResponse{
+		AccessToken:  data.AccessToken,
+		RefreshToken: data.RefreshToken,
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) InviteUser(ctx context.Context, in *InviteUserRequest) (*InviteUserResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "InviteUser").Logger()
+
+	inviteRequest := &dto.InviteUser{
+		Username:  in.Username,
+		Email:     in.Email,
+		Roles:     in.Roles,
+		ExpiresIn: time.Duration(in.ExpiresIn) * time.Second,
+	}
+
+	data, err := r.u.InviteUser(ctx, inviteRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - InviteUser")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &InviteUserResponse{
+		InviteToken: data.InviteToken,
+		ExpireTs:    data.ExpireTs.Unix(),
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) AcceptInvite(ctx context.Context, in *AcceptInviteRequest) (*AcceptInviteResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "AcceptInvite").Logger()
+
+	acceptRequest := &dto.AcceptInvite{
+		Token:    in.Token,
+		Password: in.Password,
+	}
+
+	err := r.u.AcceptInvite(ctx, acceptRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - AcceptInvite")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &AcceptInviteResponse{}, nil
+}
+
+func (r *UserRouter) Impersonate(ctx context.Context, in *ImpersonateRequest) (*ImpersonateResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "Impersonate").Logger()
+
+	impersonateRequest := &dto.Impersonate{
+		AdminToken:     in.AdminToken,
+		TargetUsername: in.TargetUsername,
+		Reason:         in.Reason,
+	}
+
+	data, err := r.u.Impersonate(ctx, impersonateRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - Impersonate")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ImpersonateResponse{
+		AccessToken: data.AccessToken,
+		ExpireTs:    data.ExpireTs.Unix(),
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) ExchangeToken(ctx context.Context, in *ExchangeTokenRequest) (*ExchangeTokenResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ExchangeToken").Logger()
+
+	if !isAccessTokenType(in.SubjectTokenType) || (in.ActorToken != "" && !isAccessTokenType(in.ActorTokenType)) {
+		zLog.Error().Msg("Error - Controller - User - ExchangeToken - unsupported token type")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	exchangeRequest := &dto.ExchangeToken{
+		SubjectToken: in.SubjectToken,
+		ActorToken:   in.ActorToken,
+		Scopes:       in.Scopes,
+		Audience:     in.Audience,
+		ExpiresIn:    time.Duration(in.ExpiresIn) * time.Second,
+	}
+
+	data, err := r.u.ExchangeToken(ctx, exchangeRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ExchangeToken")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ExchangeTokenResponse{
+		AccessToken:     data.AccessToken,
+		IssuedTokenType: dto.AccessTokenType,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(data.ExpiresIn.Seconds()),
+		Scopes:          data.Scopes,
+	}
+
+	return res, nil
+}
+
+func isAccessTokenType(t string) bool {
+	return t == "" || t == dto.AccessTokenType || t == dto.JwtTokenType
+}
+
+func newActor(a *dto.ActorClaim) *Actor {
+	if a == nil {
+		return nil
+	}
+
+	return &Actor{
+		UserId:   a.Sub,
+		Username: a.Username,
+		Actor:    newActor(a.Act),
+	}
+}
+
+func (r *UserRouter) ListAuditEvents(ctx context.Context, in *ListAuditEventsRequest) (*ListAuditEventsResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListAuditEvents").Logger()
+
+	filter, err := newAuditFilter(in)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListAuditEvents - newAuditFilter")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	listRequest := &dto.ListAuditEvents{
+		Filter:    filter,
+		PageSize:  int(in.PageSize),
+		PageToken: in.PageToken,
+	}
+
+	data, err := r.u.ListAuditEvents(ctx, listRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListAuditEvents")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ListAuditEventsResponse{
+		Events:        make([]*AuditEvent, 0, len(data.Events)),
+		NextPageToken: data.NextPageToken,
+	}
+
+	for _, ev := range data.Events {
+		item := &AuditEvent{
+			Id:             ev.Id.String(),
+			Type:           ev.Type,
+			ActorUsername:  ev.ActorUsername,
+			TargetUsername: ev.TargetUsername,
+			Ip:             ev.Ip,
+			UserAgent:      ev.UserAgent,
+			Outcome:        string(ev.Outcome),
+			Details:        ev.Details,
+			CreateTs:       ev.CreateTs.Unix(),
+			Seq:            ev.Seq,
+			PrevHash:       ev.PrevHash,
+			Hash:           ev.Hash,
+		}
+		if ev.ActorId != uuid.Nil {
+			item.ActorId = ev.ActorId.String()
+		}
+		if ev.TargetId != uuid.Nil {
+			item.TargetId = ev.TargetId.String()
+		}
+		res.Events = append(res.Events, item)
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) VerifyAuditLog(ctx context.Context, in *VerifyAuditLogRequest) (*VerifyAuditLogResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "VerifyAuditLog").Logger()
+
+	data, err := r.u.VerifyAuditLog(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - VerifyAuditLog")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &VerifyAuditLogResponse{
+		Verified:      data.Verified,
+		Checked:       data.Checked,
+		HeadSeq:       data.HeadSeq,
+		HeadHash:      data.HeadHash,
+		CheckpointSeq: data.CheckpointSeq,
+		BrokenSeq:     data.BrokenSeq,
+		Reason:        data.Reason,
+	}
+	if data.BrokenId != uuid.Nil {
+		res.BrokenId = data.BrokenId.String()
+	}
+
+	return res, nil
+}
+
+func newAuditFilter(in *ListAuditEventsRequest) (filter model.AuditFilter, err error) {
+	filter.Type = in.Type
+
+	if in.ActorId != "" {
+		if filter.ActorId, err = uuid.Parse(in.ActorId); err != nil {
+			return
+		}
+	}
+
+	if in.TargetId != "" {
+		if filter.TargetId, err = uuid.Parse(in.TargetId); err != nil {
+			return
+		}
+	}
+
+	if in.Outcome != "" {
+		if filter.Outcome, err = model.ParseAuditOutcome(in.Outcome); err != nil {
+			return
+		}
+	}
+
+	if in.FromTs > 0 {
+		filter.From = time.Unix(in.FromTs, 0).UTC()
+	}
+
+	if in.ToTs > 0 {
+		filter.To = time.Unix(in.ToTs, 0).UTC()
+	}
+
+	return
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func (r *UserRouter) CreateWebhook(ctx context.Context, in *CreateWebhookRequest) (*CreateWebhookResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "CreateWebhook").Logger()
+
+	createRequest := &dto.CreateWebhook{
+		Url:        in.Url,
+		EventTypes: in.EventTypes,
+		Secret:     in.Secret,
+	}
+
+	data, err := r.w.CreateWebhook(ctx, createRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - CreateWebhook")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &CreateWebhookResponse{
+		Webhook: newWebhook(data),
+		Secret:  data.Secret,
+	}, nil
+}
+
+func (r *UserRouter) ListWebhooks(ctx context.Context, in *ListWebhooksRequest) (*ListWebhooksResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListWebhooks").Logger()
+
+	data, err := r.w.ListWebhooks(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListWebhooks")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ListWebhooksResponse{
+		Webhooks: make([]*Webhook, 0, len(data)),
+	}
+	for i := range data {
+		res.Webhooks = append(res.Webhooks, newWebhook(&data[i]))
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) DeleteWebhook(ctx context.Context, in *DeleteWebhookRequest) (*DeleteWebhookResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "DeleteWebhook").Logger()
+
+	id, err := uuid.Parse(in.Id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - DeleteWebhook - uuid.Parse")
+		return nil, dto.NewGrpcError(model.ErrBadRequest)
+	}
+
+	err = r.w.DeleteWebhook(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - DeleteWebhook")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &DeleteWebhookResponse{}, nil
+}
+
+func (r *UserRouter) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ListWebhookDeliveries").Logger()
+
+	filter := &model.WebhookDeliveryFilter{
+		Limit: int(in.PageSize),
+	}
+
+	var err error
+	if in.WebhookId != "" {
+		if filter.WebhookId, err = uuid.Parse(in.WebhookId); err != nil {
+			zLog.Err(err).Msg("Error - Controller - User - ListWebhookDeliveries - uuid.Parse")
+			return nil, dto.NewGrpcError(model.ErrBadRequest)
+		}
+	}
+	if in.Status != "" {
+		if filter.Status, err = model.ParseDeliveryStatus(in.Status); err != nil {
+			zLog.Err(err).Msg("Error - Controller - User - ListWebhookDeliveries - model.ParseDeliveryStatus")
+			return nil, dto.NewGrpcError(model.ErrBadRequest)
+		}
+	}
+
+	data, err := r.w.ListWebhookDeliveries(ctx, filter)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ListWebhookDeliveries")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	res := &ListWebhookDeliveriesResponse{
+		Deliveries: make([]*WebhookDelivery, 0, len(data)),
+	}
+	for _, d := range data {
+		res.Deliveries = append(res.Deliveries, &WebhookDelivery{
+			Id:             d.Id.String(),
+			WebhookId:      d.WebhookId.String(),
+			EventId:        d.EventId.String(),
+			EventType:      d.EventType,
+			Status:         string(d.Status),
+			Attempts:       int32(d.Attempts),
+			NextAttemptTs:  d.NextAttemptTs.Unix(),
+			LastStatusCode: int32(d.LastStatusCode),
+			LastError:      d.LastError,
+			CreateTs:       d.CreateTs.Unix(),
+		})
+	}
+
+	return res, nil
+}
+
+func (r *UserRouter) ReplayWebhookDeliveries(ctx context.Context, in *ReplayWebhookDeliveriesRequest) (*ReplayWebhookDeliveriesResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.controller.User").
+		Str("method", "ReplayWebhookDeliveries").Logger()
+
+	replayRequest := &dto.ReplayWebhookDeliveries{}
+
+	var err error
+	if in.WebhookId != "" {
+		if replayRequest.WebhookId, err = uuid.Parse(in.WebhookId); err != nil {
+			zLog.Err(err).Msg("Error - Controller - User - ReplayWebhookDeliveries - uuid.Parse")
+			return nil, dto.NewGrpcError(model.ErrBadRequest)
+		}
+	}
+	for _, s := range in.DeliveryIds {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			zLog.Err(err).Msg("Error - Controller - User - ReplayWebhookDeliveries - uuid.Parse")
+			return nil, dto.NewGrpcError(model.ErrBadRequest)
+		}
+		replayRequest.DeliveryIds = append(replayRequest.DeliveryIds, id)
+	}
+
+	n, err := r.w.ReplayWebhookDeliveries(ctx, replayRequest)
+	if err != nil {
+		zLog.Err(err).Msg("Error - Controller - User - ReplayWebhookDeliveries")
+		return nil, dto.NewGrpcError(err)
+	}
+
+	return &ReplayWebhookDeliveriesResponse{Replayed: n}, nil
+}
+
+func newWebhook(w *model.Webhook) *Webhook {
+	return &Webhook{
+		Id:         w.Id.String(),
+		Url:        w.Url,
+		EventTypes: w.EventTypes,
+		CreateTs:   w.CreateTs.Unix(),
+	}
+}
@@ -0,0 +1,33 @@
+package dto
+
+import "context"
+
+type clientInfoKey struct{}
+
+// ClientInfo describes the caller of an RPC for the audit log.
+type ClientInfo struct {
+	Ip        string
+	UserAgent string
+}
+
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+func ClientInfoFrom(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
+
+type principalKey struct{}
+
+// WithPrincipal stores the validated token of the caller of an RPC.
+func WithPrincipal(ctx context.Context, principal *TokenInfo) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the validated token of the caller, nil for a call without a token.
+func PrincipalFrom(ctx context.Context) *TokenInfo {
+	principal, _ := ctx.Value(principalKey{}).(*TokenInfo)
+	return principal
+}
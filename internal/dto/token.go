@@ -2,7 +2,9 @@ package dto
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -10,24 +12,39 @@ import (
 	"github.com/rs/zerolog"
 
 	"authenticator/config"
+	"authenticator/internal/model"
 )
 
-func GenerateAccessToken(id uuid.UUID) (accessToken string, err error) {
+// Token type identifiers of RFC 8693.
+const (
+	AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	JwtTokenType    = "urn:ietf:params:oauth:token-type:jwt"
+)
 
-	ctx := context.Background()
-	zLog := zerolog.Ctx(ctx).With().
-		Str("unit", "internal.dto").
-		Str("method", "GenerateAccessToken").Logger()
+// GenerateAccessToken issues an access token for the user carrying its effective roles.
+func GenerateAccessToken(user *model.User, roles []string) (accessToken string, err error) {
 
 	expiresAt := time.Now().Add(time.Minute * time.Duration(config.Conf.Jwt.AccessTokenExpiry)).Unix()
 
 	claims := &AuthTokenClaim{
-		ID: id,
+		ID:      user.Id,
+		Profile: profileClaims(user, config.Conf.Jwt.Claims),
+		Roles:   roles,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expiresAt,
 		},
 	}
 
+	return signToken(claims)
+}
+
+func signToken(claims *AuthTokenClaim) (accessToken string, err error) {
+
+	ctx := context.Background()
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.dto").
+		Str("method", "signToken").Logger()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	accessToken, err = token.SignedString([]byte(config.Conf.Jwt.Secret))
@@ -40,30 +57,133 @@ func GenerateAccessToken(id uuid.UUID) (accessToken string, err error) {
 	return
 }
 
+// profileClaims picks the allowlisted profile fields of the user. Names other than
+// the built-in profile fields are looked up in the user's custom attributes.
+func profileClaims(user *model.User, allowlist []string) map[string]interface{} {
+	claims := make(map[string]interface{}, len(allowlist))
+	for _, name := range allowlist {
+		switch name {
+		case "":
+			continue
+		case "email":
+			if user.Email != "" {
+				claims[name] = user.Email
+			}
+		case "email_verified":
+			claims[name] = user.EmailVerified
+		case "phone":
+			if user.Phone != "" {
+				claims[name] = user.Phone
+			}
+		case "phone_verified":
+			claims[name] = user.PhoneVerified
+		default:
+			if v, ok := user.Attributes[name]; ok {
+				claims[name] = v
+			}
+		}
+	}
+
+	if len(claims) == 0 {
+		return nil
+	}
+
+	return claims
+}
+
 func VerifyAccessToken(token string) (id uuid.UUID, err error) {
 
+	claims, err := ParseAccessToken(token)
+	if claims == nil {
+		return uuid.Nil, err
+	}
+
+	return claims.ID, err
+}
+
+// ParseAccessToken returns the claims of the token. Like VerifyAccessToken the claims
+// of an expired token are returned together with the validation error.
+func ParseAccessToken(token string) (claims *AuthTokenClaim, err error) {
+
 	ctx := context.Background()
 	zLog := zerolog.Ctx(ctx).With().
 		Str("unit", "internal.dto").
-		Str("method", "VerifyAccessToken").Logger()
+		Str("method", "ParseAccessToken").Logger()
 
-	claims := jwt.MapClaims{}
+	claims = &AuthTokenClaim{}
 	_, err = jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
 		return []byte(config.Conf.Jwt.Secret), nil
 	})
 
-	idStr := fmt.Sprintf("%v", claims["ID"])
-	id = uuid.MustParse(idStr)
+	if claims.ID == uuid.Nil {
+		zLog.Err(err).Msg("An error occurred on jwt.parse, no ID claim")
+		return nil, model.ErrUnauthorized
+	}
 
 	if err != nil {
 		eMsg := "An error occurred on jwt.parse"
 		zLog.Err(err).Msg(eMsg)
-		return id, err
+		return claims, err
 	}
 
 	return
 }
 
+// IsExpired reports whether the only error of ParseAccessToken is that the token expired,
+// so it is signed by us and its claims can be trusted.
+func IsExpired(err error) bool {
+	var ve *jwt.ValidationError
+	return errors.As(err, &ve) && ve.Errors == jwt.ValidationErrorExpired
+}
+
+// GenerateImpersonationToken issues a short-lived access token for the target user carrying
+// an act claim that identifies the actor. The token id is the id of the impersonation record.
+func GenerateImpersonationToken(id uuid.UUID, target, actor *model.User, roles []string, expiresAt time.Time) (accessToken string, err error) {
+
+	claims := &AuthTokenClaim{
+		ID:      target.Id,
+		Profile: profileClaims(target, config.Conf.Jwt.Claims),
+		Roles:   roles,
+		Act: &ActorClaim{
+			Sub:      actor.Id.String(),
+			Username: actor.Username,
+		},
+		StandardClaims: jwt.StandardClaims{
+			Id:        id.String(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// GenerateExchangedToken issues a token for the subject of an exchanged token (RFC 8693)
+// restricted to the scopes and the audience. The actor, if any, replaces the act claim.
+func GenerateExchangedToken(subject *AuthTokenClaim, actor *ActorClaim, scopes []string, audience string, expiresAt time.Time) (accessToken string, err error) {
+
+	claims := &AuthTokenClaim{
+		ID:      subject.ID,
+		Profile: subject.Profile,
+		Act:     subject.Act,
+		Scope:   strings.Join(scopes, " "),
+		Roles:   subject.Roles,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Audience:  audience,
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	if actor != nil {
+		claims.Act = actor
+	}
+
+	return signToken(claims)
+}
+
 func GenerateRefreshToken() (refreshToken uuid.UUID, err error) {
 	ctx := context.Background()
 	zLog := zerolog.Ctx(ctx).With().
@@ -2,6 +2,9 @@ package dto
 
 import (
 	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
@@ -12,8 +15,11 @@ import (
 )
 
 type Create struct {
-	Username string
-	Password string
+	Username   string
+	Password   string
+	Email      string
+	Phone      string
+	Attributes map[string]string
 }
 
 type AuthRequest struct {
@@ -26,8 +32,14 @@ type AuthResponse struct {
 	RefreshToken string
 }
 
+type CompleteLoginCode struct {
+	Username string
+	Code     string
+}
+
 type Validate struct {
 	AccessToken string
+	Audience    string
 }
 
 type UpdateUsername struct {
@@ -38,6 +50,111 @@ type UpdateUsername struct {
 type ChangeState struct {
 	Username string
 	State    model.State
+	// Version, when not 0, is the version of the user the change was made against,
+	// the state is not changed if the user changed since.
+	Version int
+}
+
+// Provision is a change of a provisioning client, like a SCIM identity provider.
+type Provision struct {
+	Id    uuid.UUID
+	Email string
+	Phone string
+	// Active enables or disables the user, nil leaves the state as it is.
+	Active *bool
+	// Version, when not 0, is the version of the user the change was made against,
+	// the user is not changed if it changed since.
+	Version int
+}
+
+type UpdateUser struct {
+	Username   string
+	Email      string
+	Phone      string
+	Attributes map[string]string
+	// Mask lists the fields to replace: email, phone, attributes or attributes.<key>. When empty the
+	// email and phone given are set and the attributes given are merged, nothing is cleared.
+	Mask []string
+}
+
+type StartVerification struct {
+	AccessToken string
+	Channel     model.Channel
+}
+
+type ConfirmVerification struct {
+	AccessToken string
+	Channel     model.Channel
+	Code        string
+}
+
+type Message struct {
+	Channel model.Channel
+	To      string
+	Subject string
+	Body    string
+}
+
+type InviteUser struct {
+	Username  string
+	Email     string
+	Roles     []string
+	ExpiresIn time.Duration
+}
+
+type InviteUserResponse struct {
+	InviteToken string
+	ExpireTs    time.Time
+}
+
+type AcceptInvite struct {
+	Token    string
+	Password string
+}
+
+type ListAuditEvents struct {
+	Filter    model.AuditFilter
+	PageSize  int
+	PageToken string
+}
+
+type ListAuditEventsResponse struct {
+	Events        []model.AuditEvent
+	NextPageToken string
+}
+
+// AuditVerification is the result of walking the audit chain.
+// On a broken chain BrokenSeq and BrokenId point at the first event that fails and Reason says why.
+type AuditVerification struct {
+	Verified      bool
+	Checked       int64
+	HeadSeq       int64
+	HeadHash      string
+	CheckpointSeq int64
+	BrokenSeq     int64
+	BrokenId      uuid.UUID
+	Reason        string
+}
+
+type CreateWebhook struct {
+	Url        string
+	EventTypes []string
+	Secret     string
+}
+
+// ReplayWebhookDeliveries selects dead deliveries by id or, without ids, every dead delivery of the webhook.
+type ReplayWebhookDeliveries struct {
+	WebhookId   uuid.UUID
+	DeliveryIds []uuid.UUID
+}
+
+// WatchUserEvents starts after Cursor, at the oldest retained event with FromOldest,
+// or with the next event when neither is set. Empty filters match every event.
+type WatchUserEvents struct {
+	Cursor     int64
+	FromOldest bool
+	Types      []string
+	UserId     uuid.UUID
 }
 
 type UpdateToken struct {
@@ -46,10 +163,76 @@ type UpdateToken struct {
 }
 
 type AuthTokenClaim struct {
-	ID uuid.UUID
+	ID      uuid.UUID
+	Profile map[string]interface{} `json:"profile,omitempty"`
+	Act     *ActorClaim            `json:"act,omitempty"`
+	Scope   string                 `json:"scope,omitempty"`
+	Roles   []string               `json:"roles,omitempty"`
 	jwt.StandardClaims
 }
 
+// Scopes returns the scopes of the token, an empty list means the token is not restricted.
+func (c *AuthTokenClaim) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Allows reports whether the scopes of the token allow the permission. A token without scopes
+// allows every permission of its owner.
+func (c *AuthTokenClaim) Allows(p model.Permission) bool {
+	scopes := c.Scopes()
+	return len(scopes) == 0 || slices.Contains(scopes, string(p))
+}
+
+// ActorClaim identifies who acts on behalf of the token subject (RFC 8693).
+// A nested actor is the previous actor in the delegation chain.
+type ActorClaim struct {
+	Sub      string      `json:"sub"`
+	Username string      `json:"username,omitempty"`
+	Act      *ActorClaim `json:"act,omitempty"`
+}
+
+type TokenInfo struct {
+	UserId      uuid.UUID
+	Username    string
+	Roles       []string
+	Groups      []string
+	Permissions []model.Permission
+	Actor       *ActorClaim
+	Scopes      []string
+	Audience    string
+}
+
+// TokenResult is the result of one token of a batch validation, either Info or Err is set.
+type TokenResult struct {
+	Info *TokenInfo
+	Err  error
+}
+
+type ExchangeToken struct {
+	SubjectToken string
+	ActorToken   string
+	Scopes       []string
+	Audience     string
+	ExpiresIn    time.Duration
+}
+
+type ExchangeTokenResponse struct {
+	AccessToken string
+	ExpiresIn   time.Duration
+	Scopes      []string
+}
+
+type Impersonate struct {
+	AdminToken     string
+	TargetUsername string
+	Reason         string
+}
+
+type ImpersonateResponse struct {
+	AccessToken string
+	ExpireTs    time.Time
+}
+
 func NewGrpcError(err error) error {
 
 	switch {
@@ -61,7 +244,89 @@ func NewGrpcError(err error) error {
 		return status.Errorf(codes.AlreadyExists, "Conflict")
 	case errors.Is(err, model.ErrForbidden):
 		return status.Errorf(codes.Canceled, "Canceled")
+	case errors.Is(err, model.ErrBadRequest):
+		return status.Errorf(codes.InvalidArgument, "Bad request")
+	case errors.Is(err, model.ErrPermissionDenied):
+		return status.Errorf(codes.PermissionDenied, "Permission denied")
+	case errors.Is(err, model.ErrTooManyRequests):
+		return status.Errorf(codes.ResourceExhausted, "Too many requests")
+	case errors.Is(err, model.ErrCursorExpired):
+		return status.Errorf(codes.OutOfRange, "Cursor expired")
+	case errors.Is(err, model.ErrMaxDepth):
+		return status.Errorf(codes.FailedPrecondition, "Max depth exceeded")
 	}
 
-	return nil
+	return status.Errorf(codes.Internal, "Internal server error")
+}
+
+type CreateGroup struct {
+	Name        string
+	Description string
+	Roles       []string
+}
+
+type UpdateGroup struct {
+	Id          uuid.UUID
+	Description string
+	Roles       []string
+}
+
+// GroupMember names the member of a group, either a user by Username or a nested group by MemberGroupId.
+type GroupMember struct {
+	GroupId       uuid.UUID
+	Username      string
+	MemberGroupId uuid.UUID
+}
+
+type PutPolicy struct {
+	Realm       string
+	Name        string
+	Description string
+	Effect      string
+	Actions     []string
+	Condition   string
+}
+
+// CheckAccess asks whether the owner of SubjectToken may perform Action on the resource.
+// Context carries attributes of the request, such as the network or the device of the caller.
+// Explain returns the trace of every policy of the realm covering the action.
+type CheckAccess struct {
+	SubjectToken       string
+	Realm              string
+	Action             string
+	ResourceType       string
+	ResourceId         string
+	ResourceAttributes map[string]string
+	Context            map[string]string
+	Explain            bool
+}
+
+// Consistency picks the revision of the relation store a read is evaluated at: the latest one by default,
+// at least as fresh as Token, or exactly the revision of Token with Exact.
+type Consistency struct {
+	Token string
+	Exact bool
+}
+
+type WriteTuples struct {
+	Writes  []model.RelationTuple
+	Deletes []model.RelationTuple
+}
+
+type CheckRelation struct {
+	Tuple       model.RelationTuple
+	Consistency Consistency
+}
+
+// ExpandRelation expands the userset Object, whose Relation names the relation to expand.
+type ExpandRelation struct {
+	Object      model.Subject
+	Consistency Consistency
+}
+
+type ListObjects struct {
+	Namespace   string
+	Relation    string
+	Subject     model.Subject
+	Consistency Consistency
 }
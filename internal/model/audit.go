@@ -0,0 +1,124 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditEventTableName      = "tbl_audit_event"
+	AuditCheckpointTableName = "tbl_audit_checkpoint"
+)
+
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+)
+
+const (
+	AuditAuthLogin        = "auth.login"
+	AuditTokenRefresh     = "auth.token_refresh"
+	AuditLogout           = "auth.logout"
+	AuditImpersonate      = "auth.impersonate"
+	AuditUserCreate       = "user.create"
+	AuditUserUpdate       = "user.update"
+	AuditUserStateChange  = "user.state_change"
+	AuditUserRestore      = "user.restore"
+	AuditUserPurge        = "user.purge"
+	AuditUserInvite       = "user.invite"
+	AuditUserPasswordSet  = "user.password_set"
+	AuditUserVerification = "user.verification"
+	AuditGroupCreate      = "group.create"
+	AuditGroupUpdate      = "group.update"
+	AuditGroupDelete      = "group.delete"
+	AuditGroupMemberAdd   = "group.member_add"
+	AuditGroupMemberDel   = "group.member_remove"
+	AuditPolicyPut        = "policy.put"
+	AuditPolicyDelete     = "policy.delete"
+)
+
+func ParseAuditOutcome(s string) (r AuditOutcome, err error) {
+	rt := AuditOutcome(s)
+	switch rt {
+	case AuditSuccess,
+		AuditFailure:
+		r = rt
+		return
+	default:
+		return "", ErrTypeNotMatched
+	}
+}
+
+type AuditEvent struct {
+	Id             uuid.UUID         `db:"id"`
+	Type           string            `db:"type"`
+	ActorId        uuid.UUID         `db:"actor_id"`
+	ActorUsername  string            `db:"actor_username"`
+	TargetId       uuid.UUID         `db:"target_id"`
+	TargetUsername string            `db:"target_username"`
+	Ip             string            `db:"ip"`
+	UserAgent      string            `db:"user_agent"`
+	Outcome        AuditOutcome      `db:"outcome"`
+	Details        map[string]string `db:"details"`
+	CreateTs       time.Time         `db:"create_ts"`
+	Seq            int64             `db:"chain_seq"` // position in the chain, 0 while pending
+	PrevHash       string            `db:"prev_hash"`
+	Hash           string            `db:"hash"`
+}
+
+// ComputeHash returns the hex encoded sha256 of the event content chained to the previous hash.
+func (e *AuditEvent) ComputeHash() string {
+	details := e.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+
+	content, _ := json.Marshal(struct {
+		Id             uuid.UUID         `json:"id"`
+		Type           string            `json:"type"`
+		ActorId        uuid.UUID         `json:"actor_id"`
+		ActorUsername  string            `json:"actor_username"`
+		TargetId       uuid.UUID         `json:"target_id"`
+		TargetUsername string            `json:"target_username"`
+		Ip             string            `json:"ip"`
+		UserAgent      string            `json:"user_agent"`
+		Outcome        AuditOutcome      `json:"outcome"`
+		Details        map[string]string `json:"details"`
+		CreateTs       int64             `json:"create_ts"`
+	}{e.Id, e.Type, e.ActorId, e.ActorUsername, e.TargetId, e.TargetUsername,
+		e.Ip, e.UserAgent, e.Outcome, details, e.CreateTs.UnixMicro()})
+
+	h := sha256.New()
+	h.Write([]byte(e.PrevHash))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditCheckpoint is a signed snapshot of the head of the audit chain.
+type AuditCheckpoint struct {
+	Id        uuid.UUID `db:"id"`
+	Seq       int64     `db:"seq"`
+	Hash      string    `db:"hash"`
+	Signature string    `db:"signature"`
+	CreateTs  time.Time `db:"create_ts"`
+}
+
+type AuditFilter struct {
+	Type     string
+	ActorId  uuid.UUID
+	TargetId uuid.UUID
+	Outcome  AuditOutcome
+	From     time.Time
+	To       time.Time
+
+	// keyset pagination, events older than the cursor are returned
+	AfterTs time.Time
+	AfterId uuid.UUID
+	Limit   int
+}
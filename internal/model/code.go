@@ -0,0 +1,8 @@
+package model
+
+// OneTimeCode is a short-lived code sent to the user, only its hash is kept.
+type OneTimeCode struct {
+	CodeHash string
+	Target   string
+	Attempts int
+}
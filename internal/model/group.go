@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	GroupTableName       = "tbl_group"
+	GroupUserTableName   = "tbl_group_user"
+	GroupMemberTableName = "tbl_group_member"
+)
+
+// Group grants its roles to its users and, through nesting, to the users of every group it contains.
+type Group struct {
+	Id          uuid.UUID `db:"id"`
+	Name        string    `db:"name"`
+	Description string    `db:"description"`
+	Roles       []string  `db:"roles"`
+	CreateTs    time.Time `db:"create_ts"`
+	UpdateTs    time.Time `db:"update_ts"`
+}
+
+// GroupMembers are the direct members of a group.
+type GroupMembers struct {
+	Users  []User
+	Groups []Group
+}
+
+// GroupGrant is what a user gets from its groups, nested groups included.
+type GroupGrant struct {
+	Groups []string `json:"groups"`
+	Roles  []string `json:"roles"`
+}
+
+// Access is the effective access of a user: its own roles merged with the roles of its groups.
+type Access struct {
+	Groups      []string
+	Roles       []string
+	Permissions []Permission
+}
+
+// NewAccess flattens the roles of the user and the grant of its groups.
+func NewAccess(roles []string, grant *GroupGrant) *Access {
+	access := &Access{}
+
+	seen := make(map[string]bool)
+	for _, list := range [][]string{roles, grant.Roles} {
+		for _, role := range list {
+			if !seen[role] {
+				seen[role] = true
+				access.Roles = append(access.Roles, role)
+			}
+		}
+	}
+	access.Groups = grant.Groups
+	access.Permissions = PermissionList(access.Roles)
+
+	return access
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const ImpersonationTableName = "tbl_impersonation"
+
+type Impersonation struct {
+	Id       uuid.UUID `db:"id"`
+	ActorId  uuid.UUID `db:"actor_id"`
+	TargetId uuid.UUID `db:"target_id"`
+	Reason   string    `db:"reason"`
+	ExpireTs time.Time `db:"expire_ts"`
+	CreateTs time.Time `db:"create_ts"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const InviteTableName = "tbl_user_invite"
+
+type Invite struct {
+	UserId    uuid.UUID `db:"user_id"`
+	TokenHash string    `db:"token_hash"`
+	ExpireTs  time.Time `db:"expire_ts"`
+	CreateTs  time.Time `db:"create_ts"`
+}
@@ -7,6 +7,8 @@ import (
 
 type State string
 
+type Channel string
+
 var (
 	ErrTypeNotMatched      = errors.New("type not matched")
 	ErrUnauthorized        = errors.New("unauthorized")
@@ -17,12 +19,17 @@ var (
 	ErrConflict            = errors.New("conflict")
 	ErrInternalServerError = errors.New("internal server error")
 	ErrNoRowsAffected      = errors.New("no rows affected")
+	ErrTooManyRequests     = errors.New("too many requests")
+	ErrPermissionDenied    = errors.New("permission denied")
+	ErrCursorExpired       = errors.New("cursor expired")
+	ErrMaxDepth            = errors.New("max depth exceeded")
 )
 
 const (
 	Enabled  State = "enabled"
 	Disabled State = "disabled"
 	Deleted  State = "deleted"
+	Invited  State = "invited"
 )
 
 func ParseState(s string) (r State, err error) {
@@ -30,7 +37,25 @@ func ParseState(s string) (r State, err error) {
 	switch rt {
 	case Enabled,
 		Disabled,
-		Deleted:
+		Deleted,
+		Invited:
+		r = rt
+		return
+	default:
+		return "", ErrTypeNotMatched
+	}
+}
+
+const (
+	Email Channel = "email"
+	Phone Channel = "phone"
+)
+
+func ParseChannel(s string) (r Channel, err error) {
+	rt := Channel(s)
+	switch rt {
+	case Email,
+		Phone:
 		r = rt
 		return
 	default:
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OutboxTableName       = "tbl_outbox"
+	OutboxPosSequenceName = "seq_outbox_pos"
+)
+
+// user lifecycle and security events published through the outbox
+const (
+	EventUserCreated      = "user.created"
+	EventUserInvited      = "user.invited"
+	EventUserUpdated      = "user.updated"
+	EventUserEnabled      = "user.enabled"
+	EventUserDisabled     = "user.disabled"
+	EventUserDeleted      = "user.deleted"
+	EventUserPurged       = "user.purged"
+	EventUserLockedOut    = "user.locked_out"
+	EventUserImpersonated = "user.impersonated"
+
+	EventUserPasswordChanged = "user.password_changed"
+	EventUserSessionsRevoked = "user.sessions_revoked"
+)
+
+// StateEvent returns the lifecycle event of a user moving to the given state.
+func StateEvent(s State) string {
+	switch s {
+	case Invited:
+		return EventUserInvited
+	case Disabled:
+		return EventUserDisabled
+	case Deleted:
+		return EventUserDeleted
+	default:
+		return EventUserEnabled
+	}
+}
+
+// OutboxEvent is written in the transaction of the change it describes and published later by the relay.
+// Id is the idempotency key, consumers use it to drop events delivered more than once.
+// Pos orders the events of WatchUserEvents, it is 0 until the event committed and was numbered.
+type OutboxEvent struct {
+	Seq         int64             `db:"seq"`
+	Pos         int64             `db:"pos"`
+	Id          uuid.UUID         `db:"id"`
+	Type        string            `db:"type"`
+	AggregateId uuid.UUID         `db:"aggregate_id"`
+	Payload     map[string]string `db:"payload"`
+	CreateTs    time.Time         `db:"create_ts"`
+	PublishedTs *time.Time        `db:"published_ts"`
+	Attempts    int               `db:"attempts"`
+	LastError   string            `db:"last_error"`
+}
@@ -0,0 +1,74 @@
+package model
+
+import "sort"
+
+type Permission string
+
+const (
+	PermissionUserRead      Permission = "user:read"
+	PermissionUserWrite     Permission = "user:write"
+	PermissionImpersonate   Permission = "user:impersonate"
+	PermissionAuditRead     Permission = "audit:read"
+	PermissionWebhook       Permission = "webhook:manage"
+	PermissionGroup         Permission = "group:manage"
+	PermissionPolicy        Permission = "policy:manage"
+	PermissionRelationRead  Permission = "relation:read"
+	PermissionRelationWrite Permission = "relation:write"
+	PermissionTokenValidate Permission = "token:validate"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleService = "service"
+)
+
+// RolePermissions lists the permissions granted by the built-in roles.
+var RolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermissionUserRead,
+		PermissionUserWrite,
+		PermissionImpersonate,
+		PermissionAuditRead,
+		PermissionWebhook,
+		PermissionGroup,
+		PermissionPolicy,
+		PermissionRelationRead,
+		PermissionRelationWrite,
+		PermissionTokenValidate,
+	},
+	RoleSupport: {
+		PermissionUserRead,
+		PermissionImpersonate,
+	},
+	RoleService: {
+		PermissionTokenValidate,
+	},
+}
+
+// PermissionsOf returns the set of permissions granted by the roles.
+func PermissionsOf(roles []string) map[Permission]bool {
+	perms := make(map[Permission]bool)
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			perms[p] = true
+		}
+	}
+	return perms
+}
+
+// PermissionList returns the permissions granted by the roles, sorted.
+func PermissionList(roles []string) []Permission {
+	perms := PermissionsOf(roles)
+	list := make([]Permission, 0, len(perms))
+	for p := range perms {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// HasPermission reports whether any of the roles grants the permission.
+func HasPermission(roles []string, p Permission) bool {
+	return PermissionsOf(roles)[p]
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const PolicyTableName = "tbl_policy"
+
+// DefaultRealm is the realm of policies and access checks that do not name one.
+const DefaultRealm = "default"
+
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "allow"
+	PolicyDeny  PolicyEffect = "deny"
+)
+
+func ParsePolicyEffect(s string) (r PolicyEffect, err error) {
+	rt := PolicyEffect(s)
+	switch rt {
+	case PolicyAllow,
+		PolicyDeny:
+		r = rt
+		return
+	default:
+		return "", ErrTypeNotMatched
+	}
+}
+
+// Policy is a rule of a realm: it applies its effect to the Actions when its Condition holds.
+// The condition is written in the language of pkg/policy, an empty condition always holds.
+type Policy struct {
+	Id          uuid.UUID    `db:"id"`
+	Realm       string       `db:"realm"`
+	Name        string       `db:"name"`
+	Description string       `db:"description"`
+	Effect      PolicyEffect `db:"effect"`
+	Actions     []string     `db:"actions"`
+	Condition   string       `db:"condition"`
+	CreateTs    time.Time    `db:"create_ts"`
+	UpdateTs    time.Time    `db:"update_ts"`
+}
+
+// Covers reports whether the policy applies to the action. An action pattern is the action itself,
+// "*" for every action or a prefix ending in "*", so "document:*" covers "document:read".
+func (p *Policy) Covers(action string) bool {
+	for _, pattern := range p.Actions {
+		if pattern == action || pattern == "*" {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(action, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyTrace is how one policy fared in an access check. Error holds why its condition
+// could not be evaluated, such a policy does not match.
+type PolicyTrace struct {
+	Policy  string
+	Effect  PolicyEffect
+	Matched bool
+	Error   string
+}
+
+// AccessDecision is the outcome of an access check. Deny policies override allow policies,
+// without a matching policy access is denied. Policy names the policy that decided, if any.
+type AccessDecision struct {
+	Allowed bool
+	Policy  string
+	Reason  string
+	Trace   []PolicyTrace
+}
@@ -0,0 +1,192 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	RelationTupleTableName    = "tbl_relation_tuple"
+	RelationRevisionTableName = "tbl_relation_revision"
+)
+
+var (
+	relationNameValidator = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+	objectIdValidator     = regexp.MustCompile(`^[A-Za-z0-9_\-.:|@]{1,128}$`)
+)
+
+// Subject is who a relation tuple relates to: an object, such as user:<id>, or, with Relation,
+// the userset of every subject having that relation to the object, such as group:eng#member.
+type Subject struct {
+	Namespace string
+	ObjectId  string
+	Relation  string
+}
+
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Namespace + ":" + s.ObjectId
+	}
+	return s.Namespace + ":" + s.ObjectId + "#" + s.Relation
+}
+
+// ParseSubject parses namespace:object_id or namespace:object_id#relation.
+func ParseSubject(s string) (Subject, error) {
+	object, relation, _ := strings.Cut(s, "#")
+	namespace, objectId, ok := strings.Cut(object, ":")
+	subject := Subject{Namespace: namespace, ObjectId: objectId, Relation: relation}
+	if !ok || !relationNameValidator.MatchString(namespace) || !objectIdValidator.MatchString(objectId) ||
+		(strings.Contains(s, "#") && !relationNameValidator.MatchString(relation)) {
+		return Subject{}, fmt.Errorf("%w: subject %q", ErrBadRequest, s)
+	}
+	return subject, nil
+}
+
+// RelationTuple states that Subject has Relation to the object, written object#relation@subject.
+type RelationTuple struct {
+	Namespace string
+	ObjectId  string
+	Relation  string
+	Subject   Subject
+}
+
+func (t RelationTuple) String() string {
+	return t.Namespace + ":" + t.ObjectId + "#" + t.Relation + "@" + t.Subject.String()
+}
+
+// Object is the object#relation side of the tuple as a userset.
+func (t RelationTuple) Object() Subject {
+	return Subject{Namespace: t.Namespace, ObjectId: t.ObjectId, Relation: t.Relation}
+}
+
+// ParseRelationTuple parses namespace:object_id#relation@subject.
+func ParseRelationTuple(s string) (RelationTuple, error) {
+	object, subject, ok := strings.Cut(s, "@")
+	if !ok {
+		return RelationTuple{}, fmt.Errorf("%w: tuple %q", ErrBadRequest, s)
+	}
+	o, err := ParseSubject(object)
+	if err != nil || o.Relation == "" {
+		return RelationTuple{}, fmt.Errorf("%w: tuple %q", ErrBadRequest, s)
+	}
+	sub, err := ParseSubject(subject)
+	if err != nil {
+		return RelationTuple{}, err
+	}
+	return RelationTuple{Namespace: o.Namespace, ObjectId: o.ObjectId, Relation: o.Relation, Subject: sub}, nil
+}
+
+// Rewrite adds subjects to a relation beyond its own tuples: the subjects of another relation of
+// the same object (Computed), or the subjects of the Computed relation of every object the
+// object relates to by Tupleset (tuple to userset), such as the viewers of the parent folder.
+type Rewrite struct {
+	Computed string `json:"computed"`
+	Tupleset string `json:"tupleset,omitempty"`
+}
+
+type RelationConfig struct {
+	Name    string    `json:"name"`
+	Rewrite []Rewrite `json:"rewrite,omitempty"`
+}
+
+type NamespaceConfig struct {
+	Name      string           `json:"name"`
+	Relations []RelationConfig `json:"relations"`
+}
+
+// Namespaces is the namespace configuration of the relation store, keyed by namespace.
+type Namespaces map[string]*NamespaceConfig
+
+// DefaultNamespaces are used unless RELATION_NAMESPACES names a configuration file.
+var DefaultNamespaces = []NamespaceConfig{
+	{
+		Name: "group",
+		Relations: []RelationConfig{
+			{Name: "member"},
+		},
+	},
+	{
+		Name: "folder",
+		Relations: []RelationConfig{
+			{Name: "parent"},
+			{Name: "owner"},
+			{Name: "editor", Rewrite: []Rewrite{{Computed: "owner"}}},
+			{Name: "viewer", Rewrite: []Rewrite{{Computed: "editor"}, {Tupleset: "parent", Computed: "viewer"}}},
+		},
+	},
+	{
+		Name: "document",
+		Relations: []RelationConfig{
+			{Name: "parent"},
+			{Name: "owner"},
+			{Name: "editor", Rewrite: []Rewrite{{Computed: "owner"}, {Tupleset: "parent", Computed: "editor"}}},
+			{Name: "viewer", Rewrite: []Rewrite{{Computed: "editor"}, {Tupleset: "parent", Computed: "viewer"}}},
+		},
+	},
+}
+
+// NewNamespaces checks the configuration: names must be valid and unique and every computed
+// relation must exist in its namespace. The relation reached through a tupleset is resolved in
+// the namespace of the related object, so it is checked at evaluation.
+func NewNamespaces(configs []NamespaceConfig) (Namespaces, error) {
+	namespaces := make(Namespaces, len(configs))
+	for i := range configs {
+		ns := &configs[i]
+		if !relationNameValidator.MatchString(ns.Name) || namespaces[ns.Name] != nil {
+			return nil, fmt.Errorf("namespace %q: invalid or duplicate name", ns.Name)
+		}
+		for _, rel := range ns.Relations {
+			if !relationNameValidator.MatchString(rel.Name) || len(ns.relations(rel.Name)) != 1 {
+				return nil, fmt.Errorf("namespace %q: relation %q: invalid or duplicate name", ns.Name, rel.Name)
+			}
+			for _, rw := range rel.Rewrite {
+				if !relationNameValidator.MatchString(rw.Computed) {
+					return nil, fmt.Errorf("namespace %q: relation %q: invalid computed relation %q", ns.Name, rel.Name, rw.Computed)
+				}
+				if rw.Tupleset == "" && ns.Relation(rw.Computed) == nil {
+					return nil, fmt.Errorf("namespace %q: relation %q: unknown computed relation %q", ns.Name, rel.Name, rw.Computed)
+				}
+				if rw.Tupleset != "" && ns.Relation(rw.Tupleset) == nil {
+					return nil, fmt.Errorf("namespace %q: relation %q: unknown tupleset %q", ns.Name, rel.Name, rw.Tupleset)
+				}
+			}
+		}
+		namespaces[ns.Name] = ns
+	}
+	return namespaces, nil
+}
+
+// Relation returns the configuration of the relation or nil.
+func (ns *NamespaceConfig) Relation(name string) *RelationConfig {
+	if r := ns.relations(name); len(r) > 0 {
+		return r[0]
+	}
+	return nil
+}
+
+func (ns *NamespaceConfig) relations(name string) []*RelationConfig {
+	var found []*RelationConfig
+	for i := range ns.Relations {
+		if ns.Relations[i].Name == name {
+			found = append(found, &ns.Relations[i])
+		}
+	}
+	return found
+}
+
+// Relation returns the configuration of the relation of the namespace or nil.
+func (n Namespaces) Relation(namespace, relation string) *RelationConfig {
+	if ns := n[namespace]; ns != nil {
+		return ns.Relation(relation)
+	}
+	return nil
+}
+
+// ExpandNode is a node of the userset tree of object#relation. Subjects are the subjects of its
+// own tuples, usersets among them are not expanded. Children are the usersets its rewrites add.
+type ExpandNode struct {
+	Object   Subject
+	Subjects []Subject
+	Children []ExpandNode
+}
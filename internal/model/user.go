@@ -9,11 +9,50 @@ import (
 const UserTableName = "tbl_user"
 
 type User struct {
-	Id       uuid.UUID `db:"id"`
-	Username string    `db:"username"`
-	Password string    `db:"password"`
-	State    State     `db:"state"`
-	CreateTs time.Time `db:"create_ts"`
-	UpdateTs time.Time `db:"update_ts"`
-	Version  int       `db:"version"`
+	Id            uuid.UUID         `db:"id"`
+	Username      string            `db:"username"`
+	Password      string            `db:"password"`
+	Email         string            `db:"email"`
+	EmailVerified bool              `db:"email_verified"`
+	Phone         string            `db:"phone"`
+	PhoneVerified bool              `db:"phone_verified"`
+	Attributes    map[string]string `db:"attributes"`
+	Roles         []string          `db:"roles"`
+	State         State             `db:"state"`
+	CreateTs      time.Time         `db:"create_ts"`
+	UpdateTs      time.Time         `db:"update_ts"`
+	Version       int               `db:"version"`
+}
+
+// user fields that can be searched on
+const (
+	UserFieldId       = "id"
+	UserFieldUsername = "username"
+	UserFieldEmail    = "email"
+	UserFieldPhone    = "phone"
+	UserFieldState    = "state"
+	UserFieldRole     = "role"
+)
+
+// search operators, named after the SCIM filter operators
+const (
+	OpEq      = "eq"
+	OpNe      = "ne"
+	OpCo      = "co"
+	OpSw      = "sw"
+	OpEw      = "ew"
+	OpPresent = "pr"
+)
+
+type UserCondition struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// UserFilter matches users satisfying all conditions, deleted users are never matched.
+type UserFilter struct {
+	Conditions []UserCondition
+	Offset     int
+	Limit      int
 }
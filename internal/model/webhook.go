@@ -0,0 +1,65 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	WebhookTableName         = "tbl_webhook"
+	WebhookDeliveryTableName = "tbl_webhook_delivery"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+func ParseDeliveryStatus(s string) (DeliveryStatus, error) {
+	switch DeliveryStatus(s) {
+	case DeliveryPending,
+		DeliveryDelivered,
+		DeliveryDead:
+		return DeliveryStatus(s), nil
+	}
+	return "", ErrBadRequest
+}
+
+// Webhook is a subscription of an HTTP endpoint to events.
+// An empty EventTypes list subscribes to every event.
+type Webhook struct {
+	Id         uuid.UUID `db:"id"`
+	Url        string    `db:"url"`
+	EventTypes []string  `db:"event_types"`
+	Secret     string    `db:"secret"`
+	CreateTs   time.Time `db:"create_ts"`
+	UpdateTs   time.Time `db:"update_ts"`
+}
+
+// WebhookDelivery is one event queued for one webhook.
+// The event is copied so the delivery outlives the outbox row it came from.
+type WebhookDelivery struct {
+	Id             uuid.UUID         `db:"id"`
+	WebhookId      uuid.UUID         `db:"webhook_id"`
+	EventId        uuid.UUID         `db:"event_id"`
+	EventType      string            `db:"event_type"`
+	Payload        map[string]string `db:"payload"`
+	EventTs        time.Time         `db:"event_ts"`
+	Status         DeliveryStatus    `db:"status"`
+	Attempts       int               `db:"attempts"`
+	NextAttemptTs  time.Time         `db:"next_attempt_ts"`
+	LastStatusCode int               `db:"last_status_code"`
+	LastError      string            `db:"last_error"`
+	CreateTs       time.Time         `db:"create_ts"`
+	UpdateTs       time.Time         `db:"update_ts"`
+}
+
+type WebhookDeliveryFilter struct {
+	WebhookId uuid.UUID
+	Status    DeliveryStatus
+	Limit     int
+}
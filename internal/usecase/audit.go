@@ -0,0 +1,380 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+	maxAuditUserAgentLen = 256
+	maxAuditNameLen      = 64
+)
+
+// AuditChainBatchSize is the most events ChainAuditEvents chains in one transaction.
+const AuditChainBatchSize = 500
+
+// audit records the event in the transaction of the change it describes.
+func (uc *UserUseCase) audit(ctx context.Context, txId int, ev *model.AuditEvent) error {
+
+	// events without their own actor were done by the caller of the RPC
+	if principal := dto.PrincipalFrom(ctx); principal != nil && ev.ActorId == uuid.Nil {
+		ev.ActorId = principal.UserId
+		ev.ActorUsername = principal.Username
+	}
+
+	// the names come from requests too, they are cut to the width of their columns
+	client := dto.ClientInfoFrom(ctx)
+	ev.Ip = truncate(client.Ip, maxAuditNameLen)
+	ev.UserAgent = truncate(client.UserAgent, maxAuditUserAgentLen)
+	ev.ActorUsername = truncate(ev.ActorUsername, maxAuditNameLen)
+	ev.TargetUsername = truncate(ev.TargetUsername, maxAuditNameLen)
+	if ev.Outcome == "" {
+		ev.Outcome = model.AuditSuccess
+	}
+	ev.CreateTs = util.NowUTC()
+
+	return uc.auditRepo.Create(ctx, ev, txId)
+}
+
+// auditAlone records an event that is not part of a change, such as a login, in its own transaction.
+func (uc *UserUseCase) auditAlone(ctx context.Context, ev *model.AuditEvent) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "auditAlone").Logger()
+
+	var txId int
+	txId, err := uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	err = uc.audit(ctx, txId, ev)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	return nil
+}
+
+// auditFailure records a failed attempt. The original error is what the caller sees,
+// so a failing audit write is only logged.
+func (uc *UserUseCase) auditFailure(ctx context.Context, ev *model.AuditEvent, reason error) {
+
+	ev.Outcome = model.AuditFailure
+	if ev.Details == nil {
+		ev.Details = map[string]string{}
+	}
+	ev.Details["reason"] = reason.Error()
+
+	if err := uc.auditAlone(ctx, ev); err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Str("unit", "internal.usecase.UserUseCase").
+			Str("method", "auditFailure").
+			Msg("UserUseCase - error processing uc.auditAlone")
+	}
+}
+
+func (uc *UserUseCase) ListAuditEvents(ctx context.Context, in *dto.ListAuditEvents) (*dto.ListAuditEventsResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ListAuditEvents").Logger()
+
+	filter := in.Filter
+	switch {
+	case in.PageSize <= 0:
+		filter.Limit = defaultAuditPageSize
+	case in.PageSize > maxAuditPageSize:
+		filter.Limit = maxAuditPageSize
+	default:
+		filter.Limit = in.PageSize
+	}
+
+	if in.PageToken != "" {
+		ts, id, err := decodeAuditPageToken(in.PageToken)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error decodeAuditPageToken")
+			return nil, model.ErrBadRequest
+		}
+		filter.AfterTs, filter.AfterId = ts, id
+	}
+
+	// one extra row tells whether there is a next page
+	filter.Limit++
+	events, err := uc.auditRepo.List(ctx, &filter)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.List")
+		return nil, err
+	}
+
+	item := &dto.ListAuditEventsResponse{}
+	if len(events) == filter.Limit {
+		events = events[:len(events)-1]
+		last := events[len(events)-1]
+		item.NextPageToken = encodeAuditPageToken(last.CreateTs, last.Id)
+	}
+	item.Events = events
+
+	return item, nil
+}
+
+// ChainAuditEvents links the pending events into the chain in the order they were written and
+// returns how many it chained. Only this job appends to the chain, so audited transactions never
+// wait on each other.
+func (uc *UserUseCase) ChainAuditEvents(ctx context.Context) (n int, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ChainAuditEvents").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return 0, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			n = 0
+		}
+	}()
+
+	err = uc.auditRepo.LockChain(ctx, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.LockChain")
+		return 0, err
+	}
+
+	events, err := uc.auditRepo.ListPending(ctx, AuditChainBatchSize, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.ListPending")
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	head, err := uc.auditRepo.GetHead(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.GetHead")
+		return 0, err
+	}
+
+	var seq int64
+	prevHash := ""
+	if head != nil {
+		seq, prevHash = head.Seq, head.Hash
+	}
+
+	for i := range events {
+		ev := &events[i]
+		seq++
+		ev.Seq = seq
+		ev.PrevHash = prevHash
+		ev.Hash = ev.ComputeHash()
+
+		err = uc.auditRepo.Chain(ctx, ev, txId)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.Chain")
+			return 0, err
+		}
+		prevHash = ev.Hash
+	}
+
+	return len(events), nil
+}
+
+// CreateAuditCheckpoint signs the current head of the audit chain.
+// Nothing is written when the head did not move since the last checkpoint.
+func (uc *UserUseCase) CreateAuditCheckpoint(ctx context.Context) (*model.AuditCheckpoint, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "CreateAuditCheckpoint").Logger()
+
+	head, err := uc.auditRepo.GetHead(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.GetHead")
+		return nil, err
+	}
+	if head == nil {
+		return nil, nil
+	}
+
+	last, err := uc.auditRepo.GetLastCheckpoint(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.GetLastCheckpoint")
+		return nil, err
+	}
+	if last != nil && last.Seq == head.Seq {
+		return nil, nil
+	}
+
+	item := &model.AuditCheckpoint{
+		Seq:      head.Seq,
+		Hash:     head.Hash,
+		CreateTs: util.NowUTC(),
+	}
+	item.Signature = util.Sign(auditCheckpointSecret(), checkpointPayload(item))
+
+	err = uc.auditRepo.CreateCheckpoint(ctx, item)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.CreateCheckpoint")
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// VerifyAuditLog walks the whole audit chain and stops at the first broken link.
+// Every event must hash to its stored hash and point at the hash of the event before it,
+// and every checkpoint must carry a valid signature and match the event it was taken at,
+// which also catches events removed from the end of the chain.
+func (uc *UserUseCase) VerifyAuditLog(ctx context.Context) (*dto.AuditVerification, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "VerifyAuditLog").Logger()
+
+	checkpoints, err := uc.auditRepo.ListCheckpoints(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.ListCheckpoints")
+		return nil, err
+	}
+
+	item := &dto.AuditVerification{}
+	secret := auditCheckpointSecret()
+	bySeq := make(map[int64][]model.AuditCheckpoint, len(checkpoints))
+	for _, cp := range checkpoints {
+		if !util.VerifySignature(secret, checkpointPayload(&cp), cp.Signature) {
+			item.BrokenSeq = cp.Seq
+			item.Reason = fmt.Sprintf("checkpoint %s has an invalid signature", cp.Id)
+			return item, nil
+		}
+		bySeq[cp.Seq] = append(bySeq[cp.Seq], cp)
+		item.CheckpointSeq = cp.Seq
+	}
+
+	var afterSeq int64
+	prevHash := ""
+	for {
+		events, err := uc.auditRepo.ListChain(ctx, afterSeq, AuditChainBatchSize)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.auditRepo.ListChain")
+			return nil, err
+		}
+
+		for i := range events {
+			ev := &events[i]
+
+			reason := ""
+			switch {
+			case ev.PrevHash != prevHash:
+				reason = "previous hash does not match the preceding event"
+			case ev.ComputeHash() != ev.Hash:
+				reason = "content does not match the stored hash"
+			}
+			for _, cp := range bySeq[ev.Seq] {
+				if reason == "" && cp.Hash != ev.Hash {
+					reason = fmt.Sprintf("hash does not match checkpoint %s", cp.Id)
+				}
+			}
+			delete(bySeq, ev.Seq)
+
+			if reason != "" {
+				item.BrokenSeq = ev.Seq
+				item.BrokenId = ev.Id
+				item.Reason = reason
+				return item, nil
+			}
+
+			prevHash = ev.Hash
+			item.Checked++
+			item.HeadSeq = ev.Seq
+			item.HeadHash = ev.Hash
+		}
+
+		if len(events) < AuditChainBatchSize {
+			break
+		}
+		afterSeq = events[len(events)-1].Seq
+	}
+
+	// checkpoints left over were taken at events that are no longer in the chain
+	for _, cp := range checkpoints {
+		if _, ok := bySeq[cp.Seq]; ok {
+			item.BrokenSeq = cp.Seq
+			item.Reason = fmt.Sprintf("event of checkpoint %s is missing", cp.Id)
+			return item, nil
+		}
+	}
+
+	item.Verified = true
+	return item, nil
+}
+
+func auditCheckpointSecret() string {
+	if config.Conf.Audit.CheckpointSecret != "" {
+		return config.Conf.Audit.CheckpointSecret
+	}
+	return config.Conf.Jwt.Secret
+}
+
+func checkpointPayload(cp *model.AuditCheckpoint) string {
+	return fmt.Sprintf("%d|%s|%d", cp.Seq, cp.Hash, cp.CreateTs.UnixMicro())
+}
+
+func encodeAuditPageToken(ts time.Time, id uuid.UUID) string {
+	raw := fmt.Sprintf("%d|%s", ts.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeAuditPageToken(token string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	tsStr, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, model.ErrBadRequest
+	}
+
+	micro, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	return time.UnixMicro(micro).UTC(), id, nil
+}
@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestAuditTruncatesRequestFields(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+
+	ctx := dto.WithClientInfo(context.Background(), dto.ClientInfo{
+		Ip:        strings.Repeat("1", 100),
+		UserAgent: strings.Repeat("a", 300),
+	})
+	err := uc.auditAlone(ctx, &model.AuditEvent{
+		Type:           model.AuditImpersonate,
+		TargetUsername: strings.Repeat("u", 100),
+	})
+	if err != nil {
+		t.Fatalf("auditAlone: %v", err)
+	}
+
+	ev := f.audit.events[0]
+	if len(ev.Ip) != maxAuditNameLen || len(ev.TargetUsername) != maxAuditNameLen {
+		t.Fatalf("ip %d and target username %d bytes, want %d", len(ev.Ip), len(ev.TargetUsername), maxAuditNameLen)
+	}
+	if len(ev.UserAgent) != maxAuditUserAgentLen {
+		t.Fatalf("user agent %d bytes, want %d", len(ev.UserAgent), maxAuditUserAgentLen)
+	}
+}
+
+func TestAuditChainAndVerify(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	ctx := context.Background()
+
+	for _, username := range []string{"alice", "bob"} {
+		if err := uc.auditAlone(ctx, &model.AuditEvent{Type: model.AuditImpersonate, TargetUsername: username}); err != nil {
+			t.Fatalf("auditAlone: %v", err)
+		}
+	}
+	if f.audit.events[0].Hash != "" {
+		t.Fatal("an event was chained when written")
+	}
+
+	n, err := uc.ChainAuditEvents(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("ChainAuditEvents = %d, %v, want 2 events chained", n, err)
+	}
+	if _, err = uc.CreateAuditCheckpoint(ctx); err != nil {
+		t.Fatalf("CreateAuditCheckpoint: %v", err)
+	}
+
+	// events written after the checkpoint continue the chain from its head
+	if err = uc.auditAlone(ctx, &model.AuditEvent{Type: model.AuditImpersonate, TargetUsername: "carol"}); err != nil {
+		t.Fatalf("auditAlone: %v", err)
+	}
+	if n, err = uc.ChainAuditEvents(ctx); err != nil || n != 1 {
+		t.Fatalf("ChainAuditEvents = %d, %v, want 1 event chained", n, err)
+	}
+	if ev := f.audit.events[2]; ev.Seq != 3 || ev.PrevHash != f.audit.events[1].Hash {
+		t.Fatalf("third event has seq %d and prev hash %s, want it linked to the second", ev.Seq, ev.PrevHash)
+	}
+
+	res, err := uc.VerifyAuditLog(ctx)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if !res.Verified || res.Checked != 3 || res.CheckpointSeq != 2 {
+		t.Fatalf("VerifyAuditLog = %+v, want 3 events verified with a checkpoint at 2", res)
+	}
+
+	// a tampered event breaks the chain at that event
+	f.audit.events[1].TargetUsername = "mallory"
+	res, err = uc.VerifyAuditLog(ctx)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if res.Verified || res.BrokenSeq != 2 {
+		t.Fatalf("VerifyAuditLog = %+v, want the chain broken at 2", res)
+	}
+}
+
+func TestVerifyAuditLogDetectsTruncatedChain(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := uc.auditAlone(ctx, &model.AuditEvent{Type: model.AuditImpersonate}); err != nil {
+			t.Fatalf("auditAlone: %v", err)
+		}
+	}
+	if _, err := uc.ChainAuditEvents(ctx); err != nil {
+		t.Fatalf("ChainAuditEvents: %v", err)
+	}
+	if _, err := uc.CreateAuditCheckpoint(ctx); err != nil {
+		t.Fatalf("CreateAuditCheckpoint: %v", err)
+	}
+
+	f.audit.events = f.audit.events[:1]
+	res, err := uc.VerifyAuditLog(ctx)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if res.Verified {
+		t.Fatalf("VerifyAuditLog = %+v, want the missing checkpoint event reported", res)
+	}
+}
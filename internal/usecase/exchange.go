@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+// ExchangeToken implements OAuth 2.0 token exchange (RFC 8693). The issued token belongs to the
+// subject, can only narrow the scopes and the audience of the subject token and never outlives it.
+// With an actor token the result is a delegation token whose act claim names the actor.
+func (uc *UserUseCase) ExchangeToken(ctx context.Context, in *dto.ExchangeToken) (*dto.ExchangeTokenResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ExchangeToken").Logger()
+
+	_, subject, err := uc.tokenOwner(ctx, in.SubjectToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.tokenOwner - subject")
+		return nil, err
+	}
+
+	scopes, err := narrowScopes(subject.Scopes(), in.Scopes)
+	if err != nil {
+		zLog.Err(err).Msgf("UserUseCase - scopes %v exceed the subject token", in.Scopes)
+		return nil, err
+	}
+
+	audience := subject.Audience
+	if in.Audience != "" {
+		if audience != "" && audience != in.Audience {
+			zLog.Error().Msgf("UserUseCase - subject token is restricted to audience <%s>", audience)
+			return nil, model.ErrPermissionDenied
+		}
+		audience = in.Audience
+	}
+
+	var actor *dto.ActorClaim
+	if in.ActorToken != "" {
+		actorUser, actorClaims, err := uc.tokenOwner(ctx, in.ActorToken)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error uc.tokenOwner - actor")
+			return nil, err
+		}
+
+		actor = &dto.ActorClaim{
+			Sub:      actorUser.Id.String(),
+			Username: actorUser.Username,
+			Act:      subject.Act,
+		}
+		if actorClaims.Act != nil {
+			zLog.Error().Msg("UserUseCase - actor token is itself delegated")
+			return nil, model.ErrPermissionDenied
+		}
+	}
+
+	lifetime := time.Duration(config.Conf.Jwt.ExchangeExpiry) * time.Minute
+	if in.ExpiresIn > 0 && in.ExpiresIn < lifetime {
+		lifetime = in.ExpiresIn
+	}
+
+	expiresAt := time.Now().Add(lifetime)
+	if subjectExp := time.Unix(subject.ExpiresAt, 0); subjectExp.Before(expiresAt) {
+		expiresAt = subjectExp
+	}
+
+	accessToken, err := dto.GenerateExchangedToken(subject, actor, scopes, audience, expiresAt)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing dto.GenerateExchangedToken()")
+		return nil, err
+	}
+
+	item := &dto.ExchangeTokenResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   time.Until(expiresAt).Round(time.Second),
+		Scopes:      scopes,
+	}
+
+	return item, nil
+}
+
+// narrowScopes returns the requested scopes if the granted ones allow them. No granted scopes
+// means an unrestricted token, no requested scopes keeps the granted ones.
+func narrowScopes(granted, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	if len(granted) == 0 {
+		return requested, nil
+	}
+
+	allowed := make(map[string]bool, len(granted))
+	for _, s := range granted {
+		allowed[s] = true
+	}
+
+	for _, s := range requested {
+		if !allowed[s] {
+			return nil, model.ErrPermissionDenied
+		}
+	}
+
+	return requested, nil
+}
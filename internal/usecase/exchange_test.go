@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestExchangeTokenIssuesNewTokenId(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	alice := f.addUser("alice")
+	subjectToken := accessToken(t, alice)
+
+	item, err := uc.ExchangeToken(context.Background(), &dto.ExchangeToken{SubjectToken: subjectToken})
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+
+	subject, _ := dto.ParseAccessToken(subjectToken)
+	exchanged, err := dto.ParseAccessToken(item.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if exchanged.StandardClaims.Id == "" || exchanged.StandardClaims.Id == subject.StandardClaims.Id {
+		t.Fatalf("exchanged jti = %q, want a new id", exchanged.StandardClaims.Id)
+	}
+}
+
+func TestExchangeTokenScopesNarrowPermissions(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	admin := f.addUser("root", model.RoleAdmin)
+
+	item, err := uc.ExchangeToken(context.Background(), &dto.ExchangeToken{
+		SubjectToken: accessToken(t, admin),
+		Scopes:       []string{string(model.PermissionUserRead)},
+	})
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+
+	info, err := uc.Validate(context.Background(), &dto.Validate{AccessToken: item.AccessToken})
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !slices.Equal(info.Permissions, []model.Permission{model.PermissionUserRead}) {
+		t.Fatalf("permissions = %v, want only %s", info.Permissions, model.PermissionUserRead)
+	}
+
+	// the scopes of the token keep it from impersonating although its owner may
+	f.addUser("alice")
+	_, err = uc.Impersonate(context.Background(), &dto.Impersonate{
+		AdminToken:     item.AccessToken,
+		TargetUsername: "alice",
+		Reason:         "ticket 42",
+	})
+	if !errors.Is(err, model.ErrPermissionDenied) {
+		t.Fatalf("Impersonate = %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestExchangeTokenRefusesWiderScopes(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	alice := f.addUser("alice")
+
+	narrowed, err := uc.ExchangeToken(context.Background(), &dto.ExchangeToken{
+		SubjectToken: accessToken(t, alice),
+		Scopes:       []string{string(model.PermissionUserRead)},
+	})
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+
+	_, err = uc.ExchangeToken(context.Background(), &dto.ExchangeToken{
+		SubjectToken: narrowed.AccessToken,
+		Scopes:       []string{string(model.PermissionUserWrite)},
+	})
+	if !errors.Is(err, model.ErrPermissionDenied) {
+		t.Fatalf("ExchangeToken = %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestValidateAudienceBoundToken(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	alice := f.addUser("alice")
+
+	item, err := uc.ExchangeToken(context.Background(), &dto.ExchangeToken{
+		SubjectToken: accessToken(t, alice),
+		Audience:     "billing",
+	})
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+
+	for _, audience := range []string{"", "reports"} {
+		_, err = uc.Validate(context.Background(), &dto.Validate{AccessToken: item.AccessToken, Audience: audience})
+		if !errors.Is(err, model.ErrUnauthorized) {
+			t.Fatalf("Validate for audience %q = %v, want ErrUnauthorized", audience, err)
+		}
+	}
+
+	info, err := uc.Validate(context.Background(), &dto.Validate{AccessToken: item.AccessToken, Audience: "billing"})
+	if err != nil {
+		t.Fatalf("Validate for its audience: %v", err)
+	}
+	if info.Audience != "billing" {
+		t.Fatalf("audience = %q, want billing", info.Audience)
+	}
+}
+
+func TestAudienceBoundTokenCanNotCallThisService(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	admin := f.addUser("root", model.RoleAdmin)
+	f.addUser("alice")
+
+	for _, audience := range []string{"billing", "authenticator"} {
+		item, err := uc.ExchangeToken(context.Background(), &dto.ExchangeToken{
+			SubjectToken: accessToken(t, admin),
+			Audience:     audience,
+		})
+		if err != nil {
+			t.Fatalf("ExchangeToken: %v", err)
+		}
+
+		_, err = uc.Impersonate(context.Background(), &dto.Impersonate{
+			AdminToken:     item.AccessToken,
+			TargetUsername: "alice",
+			Reason:         "ticket 42",
+		})
+		if (audience == "billing") != errors.Is(err, model.ErrUnauthorized) {
+			t.Fatalf("Impersonate with a token for %s = %v, want ErrUnauthorized only for another audience", audience, err)
+		}
+
+		_, err = uc.ExchangeToken(context.Background(), &dto.ExchangeToken{SubjectToken: item.AccessToken})
+		if (audience == "billing") != errors.Is(err, model.ErrUnauthorized) {
+			t.Fatalf("ExchangeToken of a token for %s = %v, want ErrUnauthorized only for another audience", audience, err)
+		}
+	}
+}
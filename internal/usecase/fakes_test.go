@@ -0,0 +1,921 @@
+package usecase
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+// The fakes keep their state in memory. Interfaces are embedded so a fake only implements what the
+// tests reach, anything else panics.
+
+// testConfig sets the configuration read by the use cases.
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Jwt.AccessTokenExpiry = 15
+	cfg.Jwt.RefreshTokenExpiry = 60
+	cfg.Jwt.Secret = "test-secret"
+	cfg.Jwt.Audience = "authenticator"
+	cfg.Jwt.ImpersonationExpiry = 15
+	cfg.Jwt.ExchangeExpiry = 5
+	cfg.Jwt.RefreshWindow = 60
+	cfg.Verification.CodeLength = 6
+	cfg.Verification.CodeExpiry = 10
+	cfg.Verification.MaxAttempts = 3
+	cfg.Login.MaxFailures = 3
+	cfg.Login.Lockout = 15
+	cfg.Login.CodeExpiry = 10
+	cfg.Invite.Expiry = 60
+	cfg.Invite.MaxExpiry = 43200
+	cfg.Group.CacheTTL = 300
+	cfg.Watch.BatchSize = 100
+	cfg.Watch.MaxStreams = 10
+
+	prev := config.Conf
+	config.Conf = cfg
+	t.Cleanup(func() { config.Conf = prev })
+
+	return cfg
+}
+
+type fakes struct {
+	users         *fakeUserRepo
+	invites       *fakeInviteRepo
+	impersonation *fakeImpersonationRepo
+	audit         *fakeAuditRepo
+	outbox        *fakeOutboxRepo
+	groups        *fakeGroupRepo
+	policies      *fakePolicyRepo
+	tx            *fakeTxRepo
+	web           *fakeWebAPI
+	sender        *fakeSender
+}
+
+func newTestUserUseCase(t *testing.T) (*UserUseCase, *fakes) {
+	t.Helper()
+	testConfig(t)
+
+	f := &fakes{
+		users:         &fakeUserRepo{byId: make(map[uuid.UUID]*model.User)},
+		invites:       &fakeInviteRepo{},
+		impersonation: &fakeImpersonationRepo{},
+		audit:         &fakeAuditRepo{},
+		outbox:        newFakeOutboxRepo(),
+		groups:        &fakeGroupRepo{grants: make(map[uuid.UUID]*model.GroupGrant)},
+		policies:      &fakePolicyRepo{byRealm: make(map[string][]model.Policy)},
+		tx:            &fakeTxRepo{},
+		web:           newFakeWebAPI(),
+		sender:        &fakeSender{},
+	}
+
+	uc := NewUserUseCase(f.users, f.invites, f.impersonation, f.audit, f.outbox, f.groups, f.policies, f.tx, f.web, f.sender)
+
+	return uc, f
+}
+
+// addUser stores an enabled user with the roles and returns it.
+func (f *fakes) addUser(username string, roles ...string) *model.User {
+	u := &model.User{
+		Id:         uuid.New(),
+		Username:   username,
+		Password:   "$2a$10$invalid",
+		Attributes: map[string]string{},
+		Roles:      roles,
+		State:      model.Enabled,
+	}
+	f.users.put(u)
+	return u
+}
+
+// accessToken issues an access token to the user like a login does.
+func accessToken(t *testing.T, u *model.User) string {
+	t.Helper()
+
+	token, err := dto.GenerateAccessToken(u, u.Roles)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	return token
+}
+
+type fakeTxRepo struct {
+	mu        sync.Mutex
+	next      int
+	open      int
+	committed int
+	rolled    int
+	// commitErr fails the commits when set
+	commitErr error
+}
+
+func (r *fakeTxRepo) NewTxId(_ context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next++
+	r.open++
+	return r.next, nil
+}
+
+// inTx reports whether a transaction is open.
+func (r *fakeTxRepo) inTx() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.open > 0
+}
+
+func (r *fakeTxRepo) TxEnd(_ context.Context, _ int, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.open--
+	if err == nil {
+		err = r.commitErr
+	}
+	if err == nil {
+		r.committed++
+	} else {
+		r.rolled++
+	}
+	return err
+}
+
+type fakeUserRepo struct {
+	UserRepo
+	mu   sync.Mutex
+	byId map[uuid.UUID]*model.User
+	// err is returned by GetById when set
+	err error
+	// stale are returned by GetById instead of the stored users, like an outdated cache
+	stale map[uuid.UUID]*model.User
+}
+
+func (r *fakeUserRepo) put(u *model.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *u
+	r.byId[u.Id] = &c
+}
+
+func (r *fakeUserRepo) get(id uuid.UUID) *model.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byId[id]
+	if !ok {
+		return nil
+	}
+	c := *u
+	return &c
+}
+
+func (r *fakeUserRepo) Create(_ context.Context, in *model.User, _ int) error {
+	if in.Id == uuid.Nil {
+		in.Id = uuid.New()
+	}
+	r.put(in)
+	return nil
+}
+
+func (r *fakeUserRepo) GetById(_ context.Context, id uuid.UUID) (*model.User, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.mu.Lock()
+	stale, ok := r.stale[id]
+	r.mu.Unlock()
+	if ok {
+		u := *stale
+		return &u, nil
+	}
+	u := r.get(id)
+	if u == nil || u.State == model.Deleted {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func (r *fakeUserRepo) GetByIdForUpdate(_ context.Context, id uuid.UUID, _ int) (*model.User, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	u := r.get(id)
+	if u == nil || u.State == model.Deleted {
+		return nil, nil
+	}
+	return u, nil
+}
+
+func (r *fakeUserRepo) GetByIds(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	var items []model.User
+	for _, id := range ids {
+		if u, _ := r.GetById(ctx, id); u != nil {
+			items = append(items, *u)
+		}
+	}
+	return items, nil
+}
+
+func (r *fakeUserRepo) GetByUsername(_ context.Context, username string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.byId {
+		if u.Username == username && u.State != model.Deleted {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeUserRepo) GetPasswordById(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	return r.GetById(ctx, id)
+}
+
+func (r *fakeUserRepo) ChangeState(_ context.Context, old, new *model.User, _ int) error {
+	return r.update(old, func(u *model.User) { u.State = new.State })
+}
+
+func (r *fakeUserRepo) UpdateProfile(_ context.Context, old, new *model.User, _ int) error {
+	return r.update(old, func(u *model.User) {
+		u.Email, u.EmailVerified = new.Email, new.EmailVerified
+		u.Phone, u.PhoneVerified = new.Phone, new.PhoneVerified
+		u.Attributes = new.Attributes
+	})
+}
+
+func (r *fakeUserRepo) UpdateRoles(_ context.Context, old, new *model.User, _ int) error {
+	return r.update(old, func(u *model.User) { u.Roles = new.Roles })
+}
+
+// update applies the change when the stored version is the one read, like the optimistic locking of the repo.
+func (r *fakeUserRepo) update(old *model.User, apply func(u *model.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byId[old.Id]
+	if !ok || u.Version != old.Version {
+		return model.ErrNoRowsAffected
+	}
+	apply(u)
+	u.Version++
+	return nil
+}
+
+type fakeInviteRepo struct {
+	InviteRepo
+	mu    sync.Mutex
+	items []model.Invite
+}
+
+func (r *fakeInviteRepo) Create(_ context.Context, in *model.Invite, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, *in)
+	return nil
+}
+
+type fakeImpersonationRepo struct {
+	mu    sync.Mutex
+	items []model.Impersonation
+}
+
+func (r *fakeImpersonationRepo) Create(_ context.Context, in *model.Impersonation, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in.Id = uuid.New()
+	r.items = append(r.items, *in)
+	return nil
+}
+
+// fakeAuditRepo writes the events pending and chains them on Chain like the repo does.
+type fakeAuditRepo struct {
+	AuditRepo
+	mu          sync.Mutex
+	events      []model.AuditEvent
+	checkpoints []model.AuditCheckpoint
+}
+
+func (r *fakeAuditRepo) Create(_ context.Context, in *model.AuditEvent, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in.Id = uuid.New()
+	r.events = append(r.events, *in)
+	return nil
+}
+
+func (r *fakeAuditRepo) LockChain(_ context.Context, _ int) error {
+	return nil
+}
+
+func (r *fakeAuditRepo) ListPending(_ context.Context, limit int, _ int) ([]model.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []model.AuditEvent
+	for _, ev := range r.events {
+		if ev.Hash == "" && len(items) < limit {
+			items = append(items, ev)
+		}
+	}
+	return items, nil
+}
+
+func (r *fakeAuditRepo) Chain(_ context.Context, in *model.AuditEvent, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.events {
+		if r.events[i].Id == in.Id && r.events[i].Hash == "" {
+			r.events[i].Seq, r.events[i].PrevHash, r.events[i].Hash = in.Seq, in.PrevHash, in.Hash
+			return nil
+		}
+	}
+	return model.ErrConflict
+}
+
+func (r *fakeAuditRepo) ListChain(_ context.Context, afterSeq int64, limit int) ([]model.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []model.AuditEvent
+	for _, ev := range r.events {
+		if ev.Seq > afterSeq {
+			items = append(items, ev)
+		}
+	}
+	slices.SortFunc(items, func(a, b model.AuditEvent) int { return cmp.Compare(a.Seq, b.Seq) })
+	return items[:min(limit, len(items))], nil
+}
+
+func (r *fakeAuditRepo) GetHead(_ context.Context) (*model.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var head *model.AuditEvent
+	for i := range r.events {
+		if r.events[i].Seq > 0 && (head == nil || r.events[i].Seq > head.Seq) {
+			ev := r.events[i]
+			head = &ev
+		}
+	}
+	return head, nil
+}
+
+func (r *fakeAuditRepo) CreateCheckpoint(_ context.Context, in *model.AuditCheckpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in.Id = uuid.New()
+	r.checkpoints = append(r.checkpoints, *in)
+	return nil
+}
+
+func (r *fakeAuditRepo) GetLastCheckpoint(_ context.Context) (*model.AuditCheckpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.checkpoints) == 0 {
+		return nil, nil
+	}
+	cp := r.checkpoints[len(r.checkpoints)-1]
+	return &cp, nil
+}
+
+func (r *fakeAuditRepo) ListCheckpoints(_ context.Context) ([]model.AuditCheckpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.AuditCheckpoint(nil), r.checkpoints...), nil
+}
+
+func (r *fakeAuditRepo) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []string
+	for _, ev := range r.events {
+		items = append(items, ev.Type)
+	}
+	return items
+}
+
+type fakeOutboxRepo struct {
+	OutboxRepo
+	mu      sync.Mutex
+	events  []model.OutboxEvent
+	claimed map[int64]time.Time
+	dead    map[int64]bool
+}
+
+func newFakeOutboxRepo() *fakeOutboxRepo {
+	return &fakeOutboxRepo{claimed: make(map[int64]time.Time), dead: make(map[int64]bool)}
+}
+
+func (r *fakeOutboxRepo) Create(_ context.Context, in *model.OutboxEvent, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in.Seq = 1
+	if n := len(r.events); n > 0 {
+		in.Seq = r.events[n-1].Seq + 1
+	}
+	r.events = append(r.events, *in)
+	return nil
+}
+
+// AssignPositions numbers every event, they are all committed.
+func (r *fakeOutboxRepo) AssignPositions(_ context.Context, limit int, _ int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var newest, n int64
+	for i := range r.events {
+		newest = max(newest, r.events[i].Pos)
+	}
+	for i := range r.events {
+		if r.events[i].Pos == 0 && n < int64(limit) {
+			n++
+			r.events[i].Pos = newest + n
+		}
+	}
+	return n, nil
+}
+
+func (r *fakeOutboxRepo) ListAfter(_ context.Context, afterPos int64, types []string, aggregateId uuid.UUID, limit int) ([]model.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []model.OutboxEvent
+	for _, ev := range r.events {
+		if ev.Pos <= afterPos || (len(types) > 0 && !slices.Contains(types, ev.Type)) ||
+			(aggregateId != uuid.Nil && ev.AggregateId != aggregateId) {
+			continue
+		}
+		if len(items) == limit {
+			break
+		}
+		items = append(items, ev)
+	}
+	return items, nil
+}
+
+func (r *fakeOutboxRepo) PosRange(_ context.Context) (oldest int64, newest int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if ev.Pos > 0 && (oldest == 0 || ev.Pos < oldest) {
+			oldest = ev.Pos
+		}
+		newest = max(newest, ev.Pos)
+	}
+	return oldest, newest, nil
+}
+
+// purge drops the events up to the position, like the retention of the outbox.
+func (r *fakeOutboxRepo) purge(pos int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.events) > 0 && r.events[0].Pos <= pos {
+		r.events = r.events[1:]
+	}
+}
+
+func (r *fakeOutboxRepo) ClaimPending(_ context.Context, limit int, now, until time.Time, _ int) ([]model.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []model.OutboxEvent
+	for i := range r.events {
+		ev := &r.events[i]
+		if ev.PublishedTs != nil || r.dead[ev.Seq] || r.claimed[ev.Seq].After(now) || len(items) == limit {
+			continue
+		}
+		r.claimed[ev.Seq] = until
+		items = append(items, *ev)
+	}
+	return items, nil
+}
+
+func (r *fakeOutboxRepo) MarkPublished(_ context.Context, seqs []int64, ts time.Time, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, seq := range seqs {
+		r.events[seq-1].PublishedTs = &ts
+		r.events[seq-1].Attempts++
+		delete(r.claimed, seq)
+	}
+	return nil
+}
+
+func (r *fakeOutboxRepo) MarkFailed(_ context.Context, seq int64, reason string, deadTs *time.Time, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[seq-1].Attempts++
+	r.events[seq-1].LastError = reason
+	r.dead[seq] = deadTs != nil
+	delete(r.claimed, seq)
+	return nil
+}
+
+func (r *fakeOutboxRepo) Release(_ context.Context, seqs []int64, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, seq := range seqs {
+		delete(r.claimed, seq)
+	}
+	return nil
+}
+
+func (r *fakeOutboxRepo) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []string
+	for _, ev := range r.events {
+		items = append(items, ev.Type)
+	}
+	return items
+}
+
+// fakeWebhookRepo keeps the webhooks and their deliveries, ClaimDue claims like the repo does.
+type fakeWebhookRepo struct {
+	WebhookRepo
+	mu         sync.Mutex
+	hooks      []model.Webhook
+	deliveries []model.WebhookDelivery
+	enqueued   []int64
+}
+
+func (r *fakeWebhookRepo) Create(_ context.Context, in *model.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in.Id = uuid.New()
+	r.hooks = append(r.hooks, *in)
+	return nil
+}
+
+func (r *fakeWebhookRepo) List(_ context.Context) ([]model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.Webhook(nil), r.hooks...), nil
+}
+
+func (r *fakeWebhookRepo) Enqueue(_ context.Context, ev *model.OutboxEvent, _ time.Time, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enqueued = append(r.enqueued, ev.Seq)
+	return nil
+}
+
+func (r *fakeWebhookRepo) ClaimDue(_ context.Context, now, until time.Time, limit int, _ int) ([]model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []model.WebhookDelivery
+	for i := range r.deliveries {
+		d := &r.deliveries[i]
+		if d.Status != model.DeliveryPending || d.NextAttemptTs.After(now) || len(items) == limit {
+			continue
+		}
+		items = append(items, *d)
+		d.NextAttemptTs = until
+	}
+	return items, nil
+}
+
+func (r *fakeWebhookRepo) UpdateDelivery(_ context.Context, in *model.WebhookDelivery, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.deliveries {
+		if r.deliveries[i].Id == in.Id {
+			r.deliveries[i] = *in
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
+
+type fakeGroupRepo struct {
+	GroupRepo
+	mu     sync.Mutex
+	groups []model.Group
+	grants map[uuid.UUID]*model.GroupGrant
+	// grantOf is called by GrantOf when set, after the grant is read
+	grantOf func(userId uuid.UUID)
+	// grantsOf counts the calls of GrantsOf
+	grantsOf int
+}
+
+func (r *fakeGroupRepo) GetById(_ context.Context, id uuid.UUID) (*model.Group, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.groups {
+		if g.Id == id {
+			return &g, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeGroupRepo) Update(_ context.Context, in *model.Group, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.groups {
+		if r.groups[i].Id == in.Id {
+			r.groups[i].Description, r.groups[i].Roles, r.groups[i].UpdateTs = in.Description, in.Roles, in.UpdateTs
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
+
+func (r *fakeGroupRepo) GrantOf(_ context.Context, userId uuid.UUID) (*model.GroupGrant, error) {
+	r.mu.Lock()
+	grant, ok := r.grants[userId]
+	hook := r.grantOf
+	r.mu.Unlock()
+
+	if !ok {
+		grant = &model.GroupGrant{}
+	}
+	if hook != nil {
+		hook(userId)
+	}
+	return grant, nil
+}
+
+func (r *fakeGroupRepo) GrantsOf(_ context.Context, userIds []uuid.UUID) (map[uuid.UUID]*model.GroupGrant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grantsOf++
+
+	grants := make(map[uuid.UUID]*model.GroupGrant, len(userIds))
+	for _, id := range userIds {
+		grant, ok := r.grants[id]
+		if !ok {
+			grant = &model.GroupGrant{}
+		}
+		grants[id] = grant
+	}
+	return grants, nil
+}
+
+type fakePolicyRepo struct {
+	PolicyRepo
+	mu      sync.Mutex
+	byRealm map[string][]model.Policy
+}
+
+func (r *fakePolicyRepo) Upsert(_ context.Context, in *model.Policy, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items := r.byRealm[in.Realm]
+	for i := range items {
+		if items[i].Name == in.Name {
+			items[i] = *in
+			return nil
+		}
+	}
+	r.byRealm[in.Realm] = append(items, *in)
+	return nil
+}
+
+func (r *fakePolicyRepo) ListByRealm(_ context.Context, realm string) ([]model.Policy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.Policy(nil), r.byRealm[realm]...), nil
+}
+
+type fakeWebAPI struct {
+	WebAPI
+	mu            sync.Mutex
+	refreshTokens map[uuid.UUID]string
+	codes         map[string]*model.OneTimeCode
+	failures      map[string]int
+	grants        map[uuid.UUID]*model.GroupGrant
+	generation    int64
+	// err is returned by the login failure counters when set
+	err error
+	// codeErr is returned by UseOneTimeCode when set
+	codeErr error
+}
+
+func newFakeWebAPI() *fakeWebAPI {
+	return &fakeWebAPI{
+		refreshTokens: make(map[uuid.UUID]string),
+		codes:         make(map[string]*model.OneTimeCode),
+		failures:      make(map[string]int),
+		grants:        make(map[uuid.UUID]*model.GroupGrant),
+	}
+}
+
+func (w *fakeWebAPI) AddRefreshToken(_ context.Context, id uuid.UUID, refreshToken string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.refreshTokens[id] = refreshToken
+	return nil
+}
+
+func (w *fakeWebAPI) GetRefreshTokenByID(_ context.Context, id uuid.UUID) (uuid.UUID, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	token, ok := w.refreshTokens[id]
+	if !ok {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(token)
+}
+
+func (w *fakeWebAPI) DeleteRefreshToken(_ context.Context, id uuid.UUID) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.refreshTokens, id)
+	return nil
+}
+
+func codeKey(purpose string, id uuid.UUID) string {
+	return purpose + ":" + id.String()
+}
+
+func (w *fakeWebAPI) AddOneTimeCode(_ context.Context, purpose string, id uuid.UUID, code *model.OneTimeCode, _ time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := *code
+	w.codes[codeKey(purpose, id)] = &c
+	return nil
+}
+
+func (w *fakeWebAPI) UseOneTimeCode(_ context.Context, purpose string, id uuid.UUID) (*model.OneTimeCode, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.codeErr != nil {
+		return nil, w.codeErr
+	}
+	code, ok := w.codes[codeKey(purpose, id)]
+	if !ok {
+		return nil, nil
+	}
+	code.Attempts++
+	c := *code
+	return &c, nil
+}
+
+func (w *fakeWebAPI) DeleteOneTimeCode(_ context.Context, purpose string, id uuid.UUID) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.codes, codeKey(purpose, id))
+	return nil
+}
+
+func (w *fakeWebAPI) GetLoginFailures(_ context.Context, username string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.failures[username], nil
+}
+
+func (w *fakeWebAPI) IncrLoginFailures(_ context.Context, username string, _ time.Duration) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.failures[username]++
+	return w.failures[username], nil
+}
+
+func (w *fakeWebAPI) ResetLoginFailures(_ context.Context, username string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.failures, username)
+	return nil
+}
+
+func (w *fakeWebAPI) GetGroupGrant(_ context.Context, id uuid.UUID) (*model.GroupGrant, int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.grants[id], w.generation, nil
+}
+
+// AddGroupGrant drops a grant of an older generation, the cache would never look it up again.
+func (w *fakeWebAPI) AddGroupGrant(_ context.Context, id uuid.UUID, generation int64, grant *model.GroupGrant, _ time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if generation == w.generation {
+		w.grants[id] = grant
+	}
+	return nil
+}
+
+func (w *fakeWebAPI) InvalidateGroupGrants(_ context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.generation++
+	w.grants = make(map[uuid.UUID]*model.GroupGrant)
+	return nil
+}
+
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []dto.Message
+}
+
+func (s *fakeSender) Send(_ context.Context, msg *dto.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, *msg)
+	return nil
+}
+
+func (s *fakeSender) last() *dto.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		return nil
+	}
+	m := s.messages[len(s.messages)-1]
+	return &m
+}
+
+// fakeRelationRepo keeps every tuple with the revisions it was written and deleted at.
+type fakeRelationRepo struct {
+	RelationRepo
+	mu     sync.Mutex
+	head   int64
+	tuples []fakeRelationTuple
+}
+
+type fakeRelationTuple struct {
+	model.RelationTuple
+	created int64
+	deleted int64
+}
+
+func (r *fakeRelationRepo) NewRevision(_ context.Context, _ time.Time, _ int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head++
+	return r.head, nil
+}
+
+func (r *fakeRelationRepo) Head(_ context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.head, nil
+}
+
+func (r *fakeRelationRepo) Write(_ context.Context, tuples []model.RelationTuple, revision int64, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range tuples {
+		if r.live(t, revision) < 0 {
+			r.tuples = append(r.tuples, fakeRelationTuple{RelationTuple: t, created: revision})
+		}
+	}
+	return nil
+}
+
+func (r *fakeRelationRepo) Delete(_ context.Context, tuples []model.RelationTuple, revision int64, _ int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range tuples {
+		if i := r.live(t, revision); i >= 0 {
+			r.tuples[i].deleted = revision
+		}
+	}
+	return nil
+}
+
+func (r *fakeRelationRepo) Subjects(_ context.Context, object model.Subject, revision int64) ([]model.Subject, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var subjects []model.Subject
+	for _, t := range r.tuples {
+		if t.visible(revision) && t.Object() == object {
+			subjects = append(subjects, t.Subject)
+		}
+	}
+	return subjects, nil
+}
+
+func (r *fakeRelationRepo) BySubject(_ context.Context, subject model.Subject, revision int64) ([]model.RelationTuple, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tuples []model.RelationTuple
+	for _, t := range r.tuples {
+		if t.visible(revision) && t.Subject == subject {
+			tuples = append(tuples, t.RelationTuple)
+		}
+	}
+	return tuples, nil
+}
+
+// live returns the index of the tuple visible at the revision, or -1.
+func (r *fakeRelationRepo) live(t model.RelationTuple, revision int64) int {
+	for i := range r.tuples {
+		if r.tuples[i].RelationTuple == t && r.tuples[i].visible(revision) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t fakeRelationTuple) visible(revision int64) bool {
+	return t.created <= revision && (t.deleted == 0 || t.deleted > revision)
+}
@@ -0,0 +1,471 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+	"authenticator/pkg/validation"
+)
+
+func (uc *UserUseCase) CreateGroup(ctx context.Context, in *dto.CreateGroup) (*model.Group, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "CreateGroup").Logger()
+
+	if err := validation.StringMustBeKey(in.Name); err != nil {
+		zLog.Err(err).Msg("UserUseCase - invalid group name")
+		return nil, model.ErrBadRequest
+	}
+
+	if err := validateGroup(in.Description, in.Roles); err != nil {
+		zLog.Err(err).Msg("UserUseCase - error validateGroup")
+		return nil, model.ErrBadRequest
+	}
+
+	var txId int
+	txId, err := uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	now := util.NowUTC()
+	group := &model.Group{
+		Name:        in.Name,
+		Description: in.Description,
+		Roles:       in.Roles,
+		CreateTs:    now,
+		UpdateTs:    now,
+	}
+
+	err = uc.groupRepo.Create(ctx, group, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.Create")
+		return nil, err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:    model.AuditGroupCreate,
+		Details: map[string]string{"group_id": group.Id.String(), "group": group.Name, "roles": strings.Join(group.Roles, ",")},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return nil, err
+	}
+
+	return group, nil
+}
+
+func (uc *UserUseCase) GetGroup(ctx context.Context, id uuid.UUID) (*model.Group, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "GetGroup").Logger()
+
+	group, err := uc.groupRepo.GetById(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.GetById")
+		return nil, err
+	}
+
+	if group == nil {
+		return nil, model.ErrNotFound
+	}
+
+	return group, nil
+}
+
+func (uc *UserUseCase) ListGroups(ctx context.Context) ([]model.Group, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ListGroups").Logger()
+
+	items, err := uc.groupRepo.List(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.List")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// UpdateGroup replaces the description and the roles of the group.
+func (uc *UserUseCase) UpdateGroup(ctx context.Context, in *dto.UpdateGroup) (*model.Group, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "UpdateGroup").Logger()
+
+	if err := validateGroup(in.Description, in.Roles); err != nil {
+		zLog.Err(err).Msg("UserUseCase - error validateGroup")
+		return nil, model.ErrBadRequest
+	}
+
+	// the name can not be changed, it is read for the audit event and the group returned
+	current, err := uc.groupRepo.GetById(ctx, in.Id)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.GetById")
+		return nil, err
+	}
+
+	if current == nil {
+		return nil, model.ErrNotFound
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+		uc.invalidateGrants(ctx)
+	}()
+
+	group := &model.Group{
+		Id:          in.Id,
+		Name:        current.Name,
+		Description: in.Description,
+		Roles:       in.Roles,
+		CreateTs:    current.CreateTs,
+		UpdateTs:    util.NowUTC(),
+	}
+
+	err = uc.groupRepo.Update(ctx, group, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.Update")
+		return nil, err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:    model.AuditGroupUpdate,
+		Details: map[string]string{"group_id": group.Id.String(), "group": group.Name, "roles": strings.Join(group.Roles, ",")},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// DeleteGroup removes the group and its memberships, its users lose the roles it granted.
+func (uc *UserUseCase) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "DeleteGroup").Logger()
+
+	group, err := uc.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+		uc.invalidateGrants(ctx)
+	}()
+
+	err = uc.groupRepo.Delete(ctx, id, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.Delete")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:    model.AuditGroupDelete,
+		Details: map[string]string{"group_id": group.Id.String(), "group": group.Name},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	return nil
+}
+
+// AddGroupMember adds a user or a nested group to the group.
+// Nesting a group in itself or in one of its own members returns ErrConflict.
+func (uc *UserUseCase) AddGroupMember(ctx context.Context, in *dto.GroupMember) error {
+	return uc.changeGroupMember(ctx, in, true)
+}
+
+func (uc *UserUseCase) RemoveGroupMember(ctx context.Context, in *dto.GroupMember) error {
+	return uc.changeGroupMember(ctx, in, false)
+}
+
+func (uc *UserUseCase) changeGroupMember(ctx context.Context, in *dto.GroupMember, add bool) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "changeGroupMember").
+		Bool("add", add).Logger()
+
+	if (in.Username == "") == (in.MemberGroupId == uuid.Nil) {
+		zLog.Error().Msg("UserUseCase - exactly one of username and member group is required")
+		return model.ErrBadRequest
+	}
+
+	group, err := uc.GetGroup(ctx, in.GroupId)
+	if err != nil {
+		return err
+	}
+
+	var user *model.User
+	var member *model.Group
+	if in.Username != "" {
+		user, err = uc.repo.GetByUsername(ctx, in.Username)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+			return err
+		}
+		if user == nil {
+			return model.ErrNotFound
+		}
+	} else {
+		member, err = uc.GetGroup(ctx, in.MemberGroupId)
+		if err != nil {
+			return err
+		}
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+		uc.invalidateGrants(ctx)
+	}()
+
+	now := util.NowUTC()
+	ev := &model.AuditEvent{
+		Type:    model.AuditGroupMemberAdd,
+		Details: map[string]string{"group_id": group.Id.String(), "group": group.Name},
+	}
+	if !add {
+		ev.Type = model.AuditGroupMemberDel
+	}
+
+	switch {
+	case user != nil && add:
+		err = uc.groupRepo.AddUser(ctx, group.Id, user.Id, now, txId)
+	case user != nil:
+		err = uc.groupRepo.RemoveUser(ctx, group.Id, user.Id, txId)
+	case add:
+		err = uc.nestGroup(ctx, group.Id, member.Id, now, txId)
+	default:
+		err = uc.groupRepo.RemoveMember(ctx, group.Id, member.Id, txId)
+	}
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo")
+		return err
+	}
+
+	if user != nil {
+		ev.TargetId = user.Id
+		ev.TargetUsername = user.Username
+	} else {
+		ev.Details["member_group_id"] = member.Id.String()
+		ev.Details["member_group"] = member.Name
+	}
+
+	err = uc.audit(ctx, txId, ev)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	if user != nil {
+		err = uc.emit(ctx, txId, model.EventUserUpdated, user.Id, user.Username, user.State, "group", group.Name)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nestGroup makes member a member of group unless that closes a cycle. The lock serializes
+// concurrent nesting, so the check and the insert see the same graph.
+func (uc *UserUseCase) nestGroup(ctx context.Context, groupId, memberId uuid.UUID, ts time.Time, txId int) error {
+
+	if groupId == memberId {
+		return model.ErrConflict
+	}
+
+	err := uc.groupRepo.LockMemberships(ctx, txId)
+	if err != nil {
+		return err
+	}
+
+	cycle, err := uc.groupRepo.Contains(ctx, memberId, groupId, txId)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return model.ErrConflict
+	}
+
+	return uc.groupRepo.AddMember(ctx, groupId, memberId, ts, txId)
+}
+
+func (uc *UserUseCase) ListGroupMembers(ctx context.Context, id uuid.UUID) (*model.GroupMembers, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ListGroupMembers").Logger()
+
+	if _, err := uc.GetGroup(ctx, id); err != nil {
+		return nil, err
+	}
+
+	members, err := uc.groupRepo.ListMembers(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.ListMembers")
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// GetEffectiveAccess returns the roles and permissions of the user, including those granted by its groups.
+func (uc *UserUseCase) GetEffectiveAccess(ctx context.Context, username string) (*model.Access, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "GetEffectiveAccess").Logger()
+
+	user, err := uc.repo.GetByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, model.ErrNotFound
+	}
+
+	return uc.effectiveAccess(ctx, user)
+}
+
+// effectiveAccess flattens the roles of the user with the roles of every group it belongs to,
+// directly or through nested groups. The group part is cached for GROUP_CACHE_TTL seconds.
+func (uc *UserUseCase) effectiveAccess(ctx context.Context, user *model.User) (*model.Access, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "effectiveAccess").Logger()
+
+	// the generation is read before the grant, a group change committed in between bumps it
+	// and the grant is added where it is no longer looked up
+	grant, generation, err := uc.webAPI.GetGroupGrant(ctx, user.Id)
+	cached := err == nil
+	if err != nil {
+		// the cache is an optimization, fall back to the database
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.GetGroupGrant")
+	}
+
+	if grant == nil {
+		grant, err = uc.groupRepo.GrantOf(ctx, user.Id)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.GrantOf")
+			return nil, err
+		}
+
+		if cached {
+			ttl := time.Duration(config.Conf.Group.CacheTTL) * time.Second
+			if err = uc.webAPI.AddGroupGrant(ctx, user.Id, generation, grant, ttl); err != nil {
+				zLog.Err(err).Msg("UserUseCase - error uc.webAPI.AddGroupGrant")
+			}
+		}
+	}
+
+	return model.NewAccess(user.Roles, grant), nil
+}
+
+// effectiveAccesses is effectiveAccess for many users, with one query for the grants of all of them.
+// It reads the database directly, a batch would otherwise cost one cache round trip per user.
+func (uc *UserUseCase) effectiveAccesses(ctx context.Context, users []model.User) (map[uuid.UUID]*model.Access, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "effectiveAccesses").Logger()
+
+	ids := make([]uuid.UUID, len(users))
+	for i := range users {
+		ids[i] = users[i].Id
+	}
+
+	grants, err := uc.groupRepo.GrantsOf(ctx, ids)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.groupRepo.GrantsOf")
+		return nil, err
+	}
+
+	access := make(map[uuid.UUID]*model.Access, len(users))
+	for i := range users {
+		access[users[i].Id] = model.NewAccess(users[i].Roles, grants[users[i].Id])
+	}
+
+	return access, nil
+}
+
+// invalidateGrants drops the cached group grants once a group change committed.
+// When it fails cached grants expire on their own after GROUP_CACHE_TTL seconds.
+func (uc *UserUseCase) invalidateGrants(ctx context.Context) {
+	if err := uc.webAPI.InvalidateGroupGrants(ctx); err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("UserUseCase - error uc.webAPI.InvalidateGroupGrants")
+	}
+}
+
+func validateGroup(description string, roles []string) error {
+	if err := validation.StringCanBeEmptyButNotExceedMaxLength(description, 256); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := validation.StringMustBeKey(role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestEffectiveAccessDoesNotCacheGrantReadBeforeInvalidation(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	f.groups.grants[u.Id] = &model.GroupGrant{Groups: []string{"ops"}, Roles: []string{model.RoleAdmin}}
+
+	// the group loses its role and the change commits while the old grant is being read
+	f.groups.grantOf = func(userId uuid.UUID) {
+		f.groups.grantOf = nil
+		f.groups.grants[userId] = &model.GroupGrant{}
+		uc.invalidateGrants(context.Background())
+	}
+
+	access, err := uc.effectiveAccess(context.Background(), u)
+	if err != nil {
+		t.Fatalf("effectiveAccess: %v", err)
+	}
+	if !slices.Contains(access.Roles, model.RoleAdmin) {
+		t.Fatalf("roles = %v, want the grant read", access.Roles)
+	}
+
+	access, err = uc.effectiveAccess(context.Background(), u)
+	if err != nil {
+		t.Fatalf("effectiveAccess: %v", err)
+	}
+	if slices.Contains(access.Roles, model.RoleAdmin) {
+		t.Fatalf("roles = %v, the grant read before the invalidation was cached", access.Roles)
+	}
+}
+
+func TestEffectiveAccessCachesGrant(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	f.groups.grants[u.Id] = &model.GroupGrant{Groups: []string{"ops"}, Roles: []string{model.RoleSupport}}
+
+	if _, err := uc.effectiveAccess(context.Background(), u); err != nil {
+		t.Fatalf("effectiveAccess: %v", err)
+	}
+	if f.web.grants[u.Id] == nil {
+		t.Fatal("the grant was not cached")
+	}
+}
+
+func TestUpdateGroupAuditsGroupName(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	id := uuid.New()
+	f.groups.groups = append(f.groups.groups, model.Group{Id: id, Name: "ops", Roles: []string{model.RoleSupport}})
+
+	group, err := uc.UpdateGroup(context.Background(), &dto.UpdateGroup{Id: id, Description: "operations", Roles: []string{model.RoleAdmin}})
+	if err != nil {
+		t.Fatalf("UpdateGroup: %v", err)
+	}
+	if group.Name != "ops" {
+		t.Fatalf("group name = %q, want ops", group.Name)
+	}
+
+	ev := f.audit.events[len(f.audit.events)-1]
+	if ev.Type != model.AuditGroupUpdate || ev.Details["group"] != "ops" {
+		t.Fatalf("audit event = %+v, want the update of ops", ev)
+	}
+}
+
+func TestUpdateGroupNotFound(t *testing.T) {
+	uc, _ := newTestUserUseCase(t)
+
+	_, err := uc.UpdateGroup(context.Background(), &dto.UpdateGroup{Id: uuid.New()})
+	if err != model.ErrNotFound {
+		t.Fatalf("UpdateGroup = %v, want ErrNotFound", err)
+	}
+}
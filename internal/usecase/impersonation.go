@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+	"authenticator/pkg/validation"
+)
+
+// Impersonate issues a short-lived access token for the target user on behalf of an admin.
+// The token carries an act claim identifying the admin, has no refresh token and every
+// issued token is recorded.
+func (uc *UserUseCase) Impersonate(ctx context.Context, in *dto.Impersonate) (*dto.ImpersonateResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "Impersonate").Logger()
+
+	if err := validation.StringMustBeNotEmptyWithMaxLength(in.Reason, 256); err != nil {
+		zLog.Err(err).Msg("UserUseCase - invalid reason")
+		return nil, model.ErrBadRequest
+	}
+
+	admin, claims, err := uc.tokenOwner(ctx, in.AdminToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.tokenOwner")
+		return nil, err
+	}
+
+	if claims.Act != nil || !claims.Allows(model.PermissionImpersonate) ||
+		!model.HasPermission(admin.Roles, model.PermissionImpersonate) {
+		zLog.Error().Msgf("User <%s> is not allowed to impersonate", admin.Id)
+		uc.auditFailure(ctx, &model.AuditEvent{
+			Type:           model.AuditImpersonate,
+			ActorId:        admin.Id,
+			ActorUsername:  admin.Username,
+			TargetUsername: in.TargetUsername,
+		}, model.ErrPermissionDenied)
+		return nil, model.ErrPermissionDenied
+	}
+
+	target, err := uc.repo.GetByUsername(ctx, in.TargetUsername)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return nil, err
+	}
+
+	if target == nil || target.State != model.Enabled {
+		return nil, model.ErrNotFound
+	}
+
+	if target.Id == admin.Id {
+		return nil, model.ErrBadRequest
+	}
+
+	access, err := uc.effectiveAccess(ctx, target)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.effectiveAccess")
+		return nil, err
+	}
+
+	// the token acts with the permissions of the target, which must not exceed the ones of the actor
+	actorPermissions := model.PermissionList(admin.Roles)
+	for _, p := range access.Permissions {
+		if !slices.Contains(actorPermissions, p) || !claims.Allows(p) {
+			zLog.Error().Msgf("User <%s> can not impersonate <%s> having the permission %s", admin.Id, target.Id, p)
+			uc.auditFailure(ctx, &model.AuditEvent{
+				Type:           model.AuditImpersonate,
+				ActorId:        admin.Id,
+				ActorUsername:  admin.Username,
+				TargetId:       target.Id,
+				TargetUsername: target.Username,
+			}, model.ErrPermissionDenied)
+			return nil, model.ErrPermissionDenied
+		}
+	}
+
+	// the impersonation is recorded before the token exists
+	record, err := uc.recordImpersonation(ctx, admin, target, in.Reason)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.recordImpersonation")
+		return nil, err
+	}
+
+	accessToken, err := dto.GenerateImpersonationToken(record.Id, target, admin, access.Roles, record.ExpireTs)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing dto.GenerateImpersonationToken()")
+		return nil, err
+	}
+
+	zLog.Warn().
+		Str("actor", admin.Username).
+		Str("target", target.Username).
+		Str("reason", in.Reason).
+		Msg("UserUseCase - impersonation token issued")
+
+	item := &dto.ImpersonateResponse{
+		AccessToken: accessToken,
+		ExpireTs:    record.ExpireTs,
+	}
+
+	return item, nil
+}
+
+// recordImpersonation stores, audits and emits the impersonation in a transaction. It returns once
+// the transaction committed, a failed commit is returned too.
+func (uc *UserUseCase) recordImpersonation(ctx context.Context, admin, target *model.User, reason string) (record *model.Impersonation, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "recordImpersonation").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			record = nil
+		}
+	}()
+
+	now := util.NowUTC()
+	record = &model.Impersonation{
+		ActorId:  admin.Id,
+		TargetId: target.Id,
+		Reason:   reason,
+		ExpireTs: now.Add(time.Duration(config.Conf.Jwt.ImpersonationExpiry) * time.Minute),
+		CreateTs: now,
+	}
+
+	err = uc.impersonationRepo.Create(ctx, record, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.impersonationRepo.Create")
+		return nil, err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditImpersonate,
+		ActorId:        admin.Id,
+		ActorUsername:  admin.Username,
+		TargetId:       target.Id,
+		TargetUsername: target.Username,
+		Details:        map[string]string{"reason": reason, "impersonation_id": record.Id.String()},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return nil, err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserImpersonated, target.Id, target.Username, target.State,
+		"actor_id", admin.Id.String(), "actor_username", admin.Username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return nil, err
+	}
+
+	return record, nil
+}
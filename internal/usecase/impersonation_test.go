@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestImpersonate(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	support := f.addUser("support", model.RoleSupport)
+	target := f.addUser("alice")
+
+	item, err := uc.Impersonate(context.Background(), &dto.Impersonate{
+		AdminToken:     accessToken(t, support),
+		TargetUsername: "alice",
+		Reason:         "ticket 42",
+	})
+	if err != nil {
+		t.Fatalf("Impersonate: %v", err)
+	}
+
+	claims, err := dto.ParseAccessToken(item.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.ID != target.Id || claims.Act == nil || claims.Act.Sub != support.Id.String() {
+		t.Fatalf("claims = %+v, want the target acted on by the admin", claims)
+	}
+	if len(f.impersonation.items) != 1 || claims.StandardClaims.Id != f.impersonation.items[0].Id.String() {
+		t.Fatalf("token id %s does not name the impersonation record", claims.StandardClaims.Id)
+	}
+}
+
+func TestImpersonateRefusesMorePrivilegedTarget(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	support := f.addUser("support", model.RoleSupport)
+	f.addUser("root", model.RoleAdmin)
+
+	_, err := uc.Impersonate(context.Background(), &dto.Impersonate{
+		AdminToken:     accessToken(t, support),
+		TargetUsername: "root",
+		Reason:         "ticket 42",
+	})
+	if !errors.Is(err, model.ErrPermissionDenied) {
+		t.Fatalf("Impersonate = %v, want ErrPermissionDenied", err)
+	}
+	if len(f.impersonation.items) != 0 {
+		t.Fatal("a refused impersonation was recorded")
+	}
+}
+
+func TestImpersonateRefusesTargetPrivilegedThroughGroups(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	support := f.addUser("support", model.RoleSupport)
+	target := f.addUser("alice")
+	f.groups.grants[target.Id] = &model.GroupGrant{Groups: []string{"ops"}, Roles: []string{model.RoleAdmin}}
+
+	_, err := uc.Impersonate(context.Background(), &dto.Impersonate{
+		AdminToken:     accessToken(t, support),
+		TargetUsername: "alice",
+		Reason:         "ticket 42",
+	})
+	if !errors.Is(err, model.ErrPermissionDenied) {
+		t.Fatalf("Impersonate = %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestImpersonateIssuesNoTokenWhenCommitFails(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	support := f.addUser("support", model.RoleSupport)
+	f.addUser("alice")
+	commitErr := errors.New("commit failed")
+	f.tx.commitErr = commitErr
+
+	item, err := uc.Impersonate(context.Background(), &dto.Impersonate{
+		AdminToken:     accessToken(t, support),
+		TargetUsername: "alice",
+		Reason:         "ticket 42",
+	})
+	if !errors.Is(err, commitErr) || item != nil {
+		t.Fatalf("Impersonate = %+v, %v, want no token and the commit error", item, err)
+	}
+}
@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -12,10 +13,68 @@ import (
 type (
 	User interface {
 		Auth(ctx context.Context, in *dto.AuthRequest) (*dto.AuthResponse, error)
+		RequestLoginCode(ctx context.Context, username string) error
+		CompleteLoginCode(ctx context.Context, in *dto.CompleteLoginCode) (*dto.AuthResponse, error)
 		Create(ctx context.Context, in *dto.Create) error
 		ChangeState(ctx context.Context, in *dto.ChangeState) error
-		Validate(ctx context.Context, token string) error
+		Validate(ctx context.Context, in *dto.Validate) (*dto.TokenInfo, error)
+		ValidateTokens(ctx context.Context, in []dto.Validate) ([]dto.TokenResult, error)
+		ExchangeToken(ctx context.Context, in *dto.ExchangeToken) (*dto.ExchangeTokenResponse, error)
+		Impersonate(ctx context.Context, in *dto.Impersonate) (*dto.ImpersonateResponse, error)
 		UpdateToken(ctx context.Context, in *dto.UpdateToken) (*dto.UpdateToken, error)
+		Logout(ctx context.Context, in *dto.UpdateToken) error
+		UpdateUser(ctx context.Context, in *dto.UpdateUser) error
+		Provision(ctx context.Context, in *dto.Provision) error
+		GetUser(ctx context.Context, username string) (*model.User, error)
+		GetUserById(ctx context.Context, id uuid.UUID) (*model.User, error)
+		SearchUsers(ctx context.Context, filter *model.UserFilter) ([]model.User, int, error)
+		SetRoles(ctx context.Context, username string, roles []string) error
+		StartVerification(ctx context.Context, in *dto.StartVerification) (time.Duration, error)
+		ConfirmVerification(ctx context.Context, in *dto.ConfirmVerification) error
+		InviteUser(ctx context.Context, in *dto.InviteUser) (*dto.InviteUserResponse, error)
+		AcceptInvite(ctx context.Context, in *dto.AcceptInvite) error
+		ListAuditEvents(ctx context.Context, in *dto.ListAuditEvents) (*dto.ListAuditEventsResponse, error)
+		VerifyAuditLog(ctx context.Context) (*dto.AuditVerification, error)
+		RestoreUser(ctx context.Context, username string) error
+		PurgeUser(ctx context.Context, username string) error
+	}
+
+	Group interface {
+		CreateGroup(ctx context.Context, in *dto.CreateGroup) (*model.Group, error)
+		GetGroup(ctx context.Context, id uuid.UUID) (*model.Group, error)
+		ListGroups(ctx context.Context) ([]model.Group, error)
+		UpdateGroup(ctx context.Context, in *dto.UpdateGroup) (*model.Group, error)
+		DeleteGroup(ctx context.Context, id uuid.UUID) error
+		AddGroupMember(ctx context.Context, in *dto.GroupMember) error
+		RemoveGroupMember(ctx context.Context, in *dto.GroupMember) error
+		ListGroupMembers(ctx context.Context, id uuid.UUID) (*model.GroupMembers, error)
+		GetEffectiveAccess(ctx context.Context, username string) (*model.Access, error)
+	}
+
+	Policy interface {
+		PutPolicy(ctx context.Context, in *dto.PutPolicy) (*model.Policy, error)
+		ListPolicies(ctx context.Context, realm string) ([]model.Policy, error)
+		DeletePolicy(ctx context.Context, realm, name string) error
+		CheckAccess(ctx context.Context, in *dto.CheckAccess) (*model.AccessDecision, error)
+	}
+
+	Relation interface {
+		WriteTuples(ctx context.Context, in *dto.WriteTuples) (string, error)
+		Check(ctx context.Context, in *dto.CheckRelation) (bool, string, error)
+		Expand(ctx context.Context, in *dto.ExpandRelation) (*model.ExpandNode, string, error)
+		ListObjects(ctx context.Context, in *dto.ListObjects) ([]string, string, error)
+	}
+
+	Webhook interface {
+		CreateWebhook(ctx context.Context, in *dto.CreateWebhook) (*model.Webhook, error)
+		ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+		DeleteWebhook(ctx context.Context, id uuid.UUID) error
+		ListWebhookDeliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]model.WebhookDelivery, error)
+		ReplayWebhookDeliveries(ctx context.Context, in *dto.ReplayWebhookDeliveries) (int64, error)
+	}
+
+	Events interface {
+		WatchUserEvents(ctx context.Context, in *dto.WatchUserEvents, send func(ev *model.OutboxEvent) error) error
 	}
 )
 
@@ -28,9 +87,99 @@ type (
 	UserRepo interface {
 		Create(ctx context.Context, in *model.User, txId int) error
 		GetById(ctx context.Context, id uuid.UUID) (*model.User, error)
+		GetByIdForUpdate(ctx context.Context, id uuid.UUID, txId int) (*model.User, error)
+		GetByIds(ctx context.Context, ids []uuid.UUID) ([]model.User, error)
 		GetByUsername(ctx context.Context, username string) (*model.User, error)
 		GetPasswordById(ctx context.Context, id uuid.UUID) (*model.User, error)
 		ChangeState(ctx context.Context, old, new *model.User, txId int) error
+		UpdateProfile(ctx context.Context, old, new *model.User, txId int) error
+		Activate(ctx context.Context, old, new *model.User, txId int) error
+		GetDeletedByUsername(ctx context.Context, username string) (*model.User, error)
+		PurgeByUsername(ctx context.Context, username string, txId int) ([]uuid.UUID, error)
+		PurgeDeletedBefore(ctx context.Context, ts time.Time, txId int) ([]uuid.UUID, error)
+		Search(ctx context.Context, filter *model.UserFilter) ([]model.User, int, error)
+		UpdateRoles(ctx context.Context, old, new *model.User, txId int) error
+	}
+
+	InviteRepo interface {
+		Create(ctx context.Context, in *model.Invite, txId int) error
+		GetByTokenHash(ctx context.Context, tokenHash string) (*model.Invite, error)
+		Delete(ctx context.Context, userId uuid.UUID, txId int) error
+		PurgeExpired(ctx context.Context, ts time.Time, txId int) ([]uuid.UUID, error)
+	}
+
+	ImpersonationRepo interface {
+		Create(ctx context.Context, in *model.Impersonation, txId int) error
+	}
+
+	AuditRepo interface {
+		Create(ctx context.Context, in *model.AuditEvent, txId int) error
+		LockChain(ctx context.Context, txId int) error
+		ListPending(ctx context.Context, limit int, txId int) ([]model.AuditEvent, error)
+		Chain(ctx context.Context, in *model.AuditEvent, txId int) error
+		List(ctx context.Context, filter *model.AuditFilter) ([]model.AuditEvent, error)
+		ListChain(ctx context.Context, afterSeq int64, limit int) ([]model.AuditEvent, error)
+		GetHead(ctx context.Context) (*model.AuditEvent, error)
+		CreateCheckpoint(ctx context.Context, in *model.AuditCheckpoint) error
+		GetLastCheckpoint(ctx context.Context) (*model.AuditCheckpoint, error)
+		ListCheckpoints(ctx context.Context) ([]model.AuditCheckpoint, error)
+	}
+
+	OutboxRepo interface {
+		Create(ctx context.Context, in *model.OutboxEvent, txId int) error
+		ClaimPending(ctx context.Context, limit int, now, until time.Time, txId int) ([]model.OutboxEvent, error)
+		MarkPublished(ctx context.Context, seqs []int64, ts time.Time, txId int) error
+		MarkFailed(ctx context.Context, seq int64, reason string, deadTs *time.Time, txId int) error
+		Release(ctx context.Context, seqs []int64, txId int) error
+		PurgePublished(ctx context.Context, ts time.Time) (int64, error)
+		AssignPositions(ctx context.Context, limit int, txId int) (int64, error)
+		ListAfter(ctx context.Context, afterPos int64, types []string, aggregateId uuid.UUID, limit int) ([]model.OutboxEvent, error)
+		PosRange(ctx context.Context) (oldest int64, newest int64, err error)
+		CountPending(ctx context.Context) (int64, error)
+	}
+
+	GroupRepo interface {
+		Create(ctx context.Context, in *model.Group, txId int) error
+		GetById(ctx context.Context, id uuid.UUID) (*model.Group, error)
+		List(ctx context.Context) ([]model.Group, error)
+		Update(ctx context.Context, in *model.Group, txId int) error
+		Delete(ctx context.Context, id uuid.UUID, txId int) error
+		AddUser(ctx context.Context, groupId, userId uuid.UUID, ts time.Time, txId int) error
+		RemoveUser(ctx context.Context, groupId, userId uuid.UUID, txId int) error
+		LockMemberships(ctx context.Context, txId int) error
+		Contains(ctx context.Context, groupId, memberId uuid.UUID, txId int) (bool, error)
+		AddMember(ctx context.Context, groupId, memberId uuid.UUID, ts time.Time, txId int) error
+		RemoveMember(ctx context.Context, groupId, memberId uuid.UUID, txId int) error
+		ListMembers(ctx context.Context, groupId uuid.UUID) (*model.GroupMembers, error)
+		GrantOf(ctx context.Context, userId uuid.UUID) (*model.GroupGrant, error)
+		GrantsOf(ctx context.Context, userIds []uuid.UUID) (map[uuid.UUID]*model.GroupGrant, error)
+	}
+
+	RelationRepo interface {
+		NewRevision(ctx context.Context, ts time.Time, txId int) (int64, error)
+		Head(ctx context.Context) (int64, error)
+		Write(ctx context.Context, tuples []model.RelationTuple, revision int64, txId int) error
+		Delete(ctx context.Context, tuples []model.RelationTuple, revision int64, txId int) error
+		Subjects(ctx context.Context, object model.Subject, revision int64) ([]model.Subject, error)
+		BySubject(ctx context.Context, subject model.Subject, revision int64) ([]model.RelationTuple, error)
+	}
+
+	PolicyRepo interface {
+		Upsert(ctx context.Context, in *model.Policy, txId int) error
+		ListByRealm(ctx context.Context, realm string) ([]model.Policy, error)
+		Delete(ctx context.Context, realm, name string, txId int) (*model.Policy, error)
+	}
+
+	WebhookRepo interface {
+		Create(ctx context.Context, in *model.Webhook) error
+		List(ctx context.Context) ([]model.Webhook, error)
+		Delete(ctx context.Context, id uuid.UUID) error
+		Enqueue(ctx context.Context, ev *model.OutboxEvent, ts time.Time, txId int) error
+		ClaimDue(ctx context.Context, now, until time.Time, limit int, txId int) ([]model.WebhookDelivery, error)
+		UpdateDelivery(ctx context.Context, in *model.WebhookDelivery, txId int) error
+		ListDeliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]model.WebhookDelivery, error)
+		Replay(ctx context.Context, webhookId uuid.UUID, ids []uuid.UUID, ts time.Time) (int64, error)
+		PurgeDelivered(ctx context.Context, ts time.Time) (int64, error)
 	}
 )
 
@@ -38,5 +187,29 @@ type (
 	WebAPI interface {
 		AddRefreshToken(ctx context.Context, id uuid.UUID, refreshToken string) (err error)
 		GetRefreshTokenByID(ctx context.Context, id uuid.UUID) (refreshToken uuid.UUID, err error)
+		DeleteRefreshToken(ctx context.Context, id uuid.UUID) (err error)
+		CountRefreshTokens(ctx context.Context) (n int64, err error)
+		AddOneTimeCode(ctx context.Context, purpose string, id uuid.UUID, code *model.OneTimeCode, ttl time.Duration) (err error)
+		UseOneTimeCode(ctx context.Context, purpose string, id uuid.UUID) (code *model.OneTimeCode, err error)
+		DeleteOneTimeCode(ctx context.Context, purpose string, id uuid.UUID) (err error)
+		GetLoginFailures(ctx context.Context, username string) (failures int, err error)
+		IncrLoginFailures(ctx context.Context, username string, window time.Duration) (failures int, err error)
+		ResetLoginFailures(ctx context.Context, username string) (err error)
+		GetGroupGrant(ctx context.Context, id uuid.UUID) (grant *model.GroupGrant, generation int64, err error)
+		AddGroupGrant(ctx context.Context, id uuid.UUID, generation int64, grant *model.GroupGrant, ttl time.Duration) (err error)
+		InvalidateGroupGrants(ctx context.Context) (err error)
+	}
+
+	Sender interface {
+		Send(ctx context.Context, msg *dto.Message) error
+	}
+
+	Publisher interface {
+		Publish(ctx context.Context, ev *model.OutboxEvent) error
+	}
+
+	WebhookDeliverer interface {
+		CheckUrl(ctx context.Context, rawUrl string) error
+		Deliver(ctx context.Context, hook *model.Webhook, in *model.WebhookDelivery) (statusCode int, err error)
 	}
 )
@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+	"authenticator/pkg/validation"
+)
+
+// InviteUser creates a pending user and issues a single-use invite token for it.
+// The token is returned to the caller and, when an email is given, sent to the invitee.
+func (uc *UserUseCase) InviteUser(ctx context.Context, in *dto.InviteUser) (*dto.InviteUserResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "InviteUser").Logger()
+
+	if err := validateProfile(in.Email, "", nil); err != nil {
+		zLog.Err(err).Msg("UserUseCase - error validateProfile")
+		return nil, model.ErrBadRequest
+	}
+
+	for _, role := range in.Roles {
+		if err := validation.StringMustBeKey(role); err != nil {
+			zLog.Err(err).Msgf("UserUseCase - invalid role <%s>", role)
+			return nil, model.ErrBadRequest
+		}
+	}
+
+	if in.ExpiresIn > time.Duration(config.Conf.Invite.MaxExpiry)*time.Minute {
+		zLog.Error().Msgf("Invite expiry %s is longer than allowed", in.ExpiresIn)
+		return nil, model.ErrBadRequest
+	}
+
+	// an inviter can not hand out more than it has
+	inviter := dto.PrincipalFrom(ctx)
+	for _, role := range in.Roles {
+		if inviter == nil || !slices.Contains(inviter.Roles, role) {
+			zLog.Error().Msgf("Inviter does not have the role <%s>", role)
+			return nil, model.ErrPermissionDenied
+		}
+	}
+
+	user, err := uc.repo.GetByUsername(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return nil, err
+	}
+
+	if user != nil {
+		return nil, model.ErrConflict
+	}
+
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error util.GenerateToken")
+		return nil, err
+	}
+
+	expiresIn := in.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = time.Duration(config.Conf.Invite.Expiry) * time.Minute
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	now := util.NowUTC()
+	userModel := &model.User{
+		Username: in.Username,
+		Email:    in.Email,
+		Roles:    in.Roles,
+		State:    model.Invited,
+		CreateTs: now,
+		UpdateTs: now,
+	}
+
+	err = uc.repo.Create(ctx, userModel, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.Create")
+		return nil, err
+	}
+
+	invite := &model.Invite{
+		UserId:    userModel.Id,
+		TokenHash: util.HashCode(token),
+		ExpireTs:  now.Add(expiresIn),
+		CreateTs:  now,
+	}
+
+	err = uc.inviteRepo.Create(ctx, invite, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.inviteRepo.Create")
+		return nil, err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserInvite,
+		TargetId:       userModel.Id,
+		TargetUsername: userModel.Username,
+		Details:        map[string]string{"roles": strings.Join(in.Roles, ",")},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return nil, err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserInvited, userModel.Id, userModel.Username, userModel.State)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return nil, err
+	}
+
+	if in.Email != "" {
+		body := fmt.Sprintf("You are invited as %s, your invite token is %s", in.Username, token)
+		if link := config.Conf.Invite.URL; link != "" {
+			q := url.Values{}
+			q.Set("token", token)
+			body = fmt.Sprintf("You are invited as %s, follow the link to set your password: %s?%s", in.Username, link, q.Encode())
+		}
+
+		err = uc.sender.Send(ctx, &dto.Message{
+			Channel: model.Email,
+			To:      in.Email,
+			Subject: "Invitation",
+			Body:    body,
+		})
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error uc.sender.Send")
+			return nil, err
+		}
+	}
+
+	item := &dto.InviteUserResponse{
+		InviteToken: token,
+		ExpireTs:    invite.ExpireTs,
+	}
+
+	return item, nil
+}
+
+// AcceptInvite sets the password of an invited user and activates the account.
+func (uc *UserUseCase) AcceptInvite(ctx context.Context, in *dto.AcceptInvite) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "AcceptInvite").Logger()
+
+	invite, err := uc.inviteRepo.GetByTokenHash(ctx, util.HashCode(in.Token))
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.inviteRepo.GetByTokenHash")
+		return err
+	}
+
+	if invite == nil || invite.ExpireTs.Before(util.NowUTC()) {
+		return model.ErrNotFound
+	}
+
+	user, err := uc.repo.GetPasswordById(ctx, invite.UserId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetPasswordById")
+		return err
+	}
+
+	if user == nil || user.State != model.Invited {
+		return model.ErrNotFound
+	}
+
+	pwdHash, err := util.HashPassword(in.Password)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error util.HashPassword")
+		return err
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	err = uc.inviteRepo.Delete(ctx, invite.UserId, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.inviteRepo.Delete")
+		return err
+	}
+
+	userModel := &model.User{
+		Password: pwdHash,
+		State:    model.Enabled,
+		UpdateTs: util.NowUTC(),
+		Version:  util.VersionInc(user.Version),
+	}
+
+	err = uc.repo.Activate(ctx, user, userModel, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.Activate")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserPasswordSet,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+		Details:        map[string]string{"via": "invite"},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserEnabled, user.Id, user.Username, model.Enabled)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserPasswordChanged, user.Id, user.Username, model.Enabled)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
+	return nil
+}
+
+// PurgeExpiredInvites removes invited users whose invite expired before the given time.
+// It is run periodically by the purge job.
+func (uc *UserUseCase) PurgeExpiredInvites(ctx context.Context, before time.Time) (int, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "PurgeExpiredInvites").Logger()
+
+	var txId int
+	txId, err := uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return 0, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	ids, err := uc.inviteRepo.PurgeExpired(ctx, before, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.inviteRepo.PurgeExpired")
+		return 0, err
+	}
+
+	for _, id := range ids {
+		err = uc.emit(ctx, txId, model.EventUserPurged, id, "", "")
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+			return 0, err
+		}
+	}
+
+	return len(ids), nil
+}
@@ -0,0 +1,60 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestInviteUserLimitsRolesToInviter(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	inviter := f.addUser("support", model.RoleSupport)
+	ctx := dto.WithPrincipal(context.Background(), &dto.TokenInfo{
+		UserId:   inviter.Id,
+		Username: inviter.Username,
+		Roles:    []string{model.RoleSupport},
+	})
+
+	_, err := uc.InviteUser(ctx, &dto.InviteUser{Username: "bob", Roles: []string{model.RoleAdmin}})
+	if !errors.Is(err, model.ErrPermissionDenied) {
+		t.Fatalf("InviteUser with a role the inviter lacks = %v, want ErrPermissionDenied", err)
+	}
+
+	_, err = uc.InviteUser(ctx, &dto.InviteUser{Username: "bob", Roles: []string{model.RoleSupport}})
+	if err != nil {
+		t.Fatalf("InviteUser: %v", err)
+	}
+	if types := f.outbox.types(); len(types) != 1 || types[0] != model.EventUserInvited {
+		t.Fatalf("events %v, want %s", types, model.EventUserInvited)
+	}
+
+	_, err = uc.InviteUser(context.Background(), &dto.InviteUser{Username: "carol", Roles: []string{model.RoleSupport}})
+	if !errors.Is(err, model.ErrPermissionDenied) {
+		t.Fatalf("InviteUser without an inviter = %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestInviteUserCapsExpiry(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	config.Conf.Invite.MaxExpiry = 60
+	inviter := f.addUser("admin", model.RoleAdmin)
+	ctx := dto.WithPrincipal(context.Background(), &dto.TokenInfo{UserId: inviter.Id, Username: inviter.Username})
+
+	_, err := uc.InviteUser(ctx, &dto.InviteUser{Username: "bob", ExpiresIn: 61 * time.Minute})
+	if !errors.Is(err, model.ErrBadRequest) {
+		t.Fatalf("InviteUser = %v, want ErrBadRequest", err)
+	}
+
+	item, err := uc.InviteUser(ctx, &dto.InviteUser{Username: "bob", ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("InviteUser: %v", err)
+	}
+	if d := time.Until(item.ExpireTs); d > time.Hour || d < 59*time.Minute {
+		t.Fatalf("invite expires in %v, want an hour", d)
+	}
+}
@@ -0,0 +1,237 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/metrics"
+	"authenticator/pkg/util"
+)
+
+const loginCodePurpose = "login"
+
+const (
+	loginMethodPassword = "password"
+	loginMethodCode     = "code"
+)
+
+// reasons of failed logins, as counted by the login failures metric
+const (
+	loginFailureLockedOut     = "locked_out"
+	loginFailureUnknownUser   = "unknown_user"
+	loginFailureNotEnabled    = "not_enabled"
+	loginFailureWrongPassword = "wrong_password"
+	loginFailureWrongCode     = "wrong_code"
+)
+
+// RequestLoginCode sends a single-use login code, or a magic link carrying it, to the
+// verified email or phone of the user. Unknown users are not reported to the caller.
+func (uc *UserUseCase) RequestLoginCode(ctx context.Context, username string) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "RequestLoginCode").Logger()
+
+	err := uc.checkLoginFailures(ctx, username, loginMethodCode)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkLoginFailures")
+		return err
+	}
+
+	user, err := uc.repo.GetByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return err
+	}
+
+	if user == nil || user.State != model.Enabled {
+		zLog.Warn().Msgf("User with username = <%s> can not log in", username)
+		return nil
+	}
+
+	channel, target := model.Email, user.Email
+	if !user.EmailVerified {
+		channel, target = model.Phone, user.Phone
+		if !user.PhoneVerified {
+			zLog.Warn().Msgf("User with username = <%s> has no verified contact", username)
+			return nil
+		}
+	}
+
+	cfg := config.Conf
+
+	code, err := util.GenerateNumericCode(cfg.Verification.CodeLength)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error util.GenerateNumericCode")
+		return err
+	}
+
+	err = uc.webAPI.AddOneTimeCode(ctx, loginCodePurpose, user.Id, &model.OneTimeCode{
+		CodeHash: util.HashCode(code),
+		Target:   target,
+	}, time.Duration(cfg.Login.CodeExpiry)*time.Minute)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.AddOneTimeCode")
+		return err
+	}
+
+	body := fmt.Sprintf("Your login code is %s", code)
+	if cfg.Login.MagicLinkURL != "" {
+		q := url.Values{}
+		q.Set("username", user.Username)
+		q.Set("code", code)
+		body = fmt.Sprintf("Follow the link to log in: %s?%s", cfg.Login.MagicLinkURL, q.Encode())
+	}
+
+	err = uc.sender.Send(ctx, &dto.Message{
+		Channel: channel,
+		To:      target,
+		Subject: "Login code",
+		Body:    body,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.sender.Send")
+		return err
+	}
+
+	return nil
+}
+
+// CompleteLoginCode exchanges a code sent by RequestLoginCode for a new session.
+func (uc *UserUseCase) CompleteLoginCode(ctx context.Context, in *dto.CompleteLoginCode) (*dto.AuthResponse, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "CompleteLoginCode").Logger()
+
+	err := uc.checkLoginFailures(ctx, in.Username, loginMethodCode)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkLoginFailures")
+		return nil, err
+	}
+
+	user, err := uc.repo.GetByUsername(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, uc.loginFailed(ctx, in.Username, loginMethodCode, loginFailureUnknownUser)
+	}
+	if user.State != model.Enabled {
+		return nil, uc.loginFailed(ctx, in.Username, loginMethodCode, loginFailureNotEnabled)
+	}
+
+	// the code is bound to the contact it was sent to, which must still be verified
+	var targets []string
+	if user.EmailVerified {
+		targets = append(targets, user.Email)
+	}
+	if user.PhoneVerified {
+		targets = append(targets, user.Phone)
+	}
+
+	err = uc.checkOneTimeCode(ctx, loginCodePurpose, user, in.Code, targets...)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkOneTimeCode")
+		// only a rejected code is a failed login, errors of the store are returned as they are
+		if errors.Is(err, model.ErrUnauthorized) || errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrTooManyRequests) {
+			return nil, uc.loginFailed(ctx, in.Username, loginMethodCode, loginFailureWrongCode)
+		}
+		return nil, err
+	}
+
+	err = uc.webAPI.DeleteOneTimeCode(ctx, loginCodePurpose, user.Id)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.DeleteOneTimeCode")
+		return nil, err
+	}
+
+	item, err := uc.issueTokens(ctx, user, loginMethodCode)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.issueTokens")
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// checkLoginFailures rejects logins for usernames that failed too many times recently.
+func (uc *UserUseCase) checkLoginFailures(ctx context.Context, username, method string) error {
+
+	failures, err := uc.webAPI.GetLoginFailures(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if failures >= config.Conf.Login.MaxFailures {
+		metrics.LoginFailures.WithLabelValues(method, loginFailureLockedOut).Inc()
+		uc.auditFailure(ctx, &model.AuditEvent{
+			Type:           model.AuditAuthLogin,
+			TargetUsername: username,
+			Details:        map[string]string{"method": method},
+		}, model.ErrTooManyRequests)
+		return model.ErrTooManyRequests
+	}
+
+	return nil
+}
+
+// loginFailed counts a failed login attempt and returns the error for the caller.
+func (uc *UserUseCase) loginFailed(ctx context.Context, username, method, reason string) error {
+
+	metrics.LoginFailures.WithLabelValues(method, reason).Inc()
+
+	uc.auditFailure(ctx, &model.AuditEvent{
+		Type:           model.AuditAuthLogin,
+		TargetUsername: username,
+		Details:        map[string]string{"method": method},
+	}, model.ErrUnauthorized)
+
+	window := time.Duration(config.Conf.Login.Lockout) * time.Minute
+
+	failures, err := uc.webAPI.IncrLoginFailures(ctx, username, window)
+	if err != nil {
+		return err
+	}
+
+	if failures == config.Conf.Login.MaxFailures {
+		uc.lockedOut(ctx, username)
+	}
+
+	return model.ErrUnauthorized
+}
+
+// lockedOut emits the lockout of an existing user. The login error is what the caller sees,
+// so failures here are only logged.
+func (uc *UserUseCase) lockedOut(ctx context.Context, username string) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "lockedOut").Logger()
+
+	user, err := uc.repo.GetByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return
+	}
+	if user == nil {
+		return
+	}
+
+	lockout := strconv.Itoa(config.Conf.Login.Lockout * 60)
+	err = uc.emitAlone(ctx, model.EventUserLockedOut, user.Id, user.Username, user.State, "lockout_seconds", lockout)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emitAlone")
+	}
+}
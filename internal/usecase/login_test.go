@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+// addUserWithPassword stores an enabled user that logs in with the password.
+func (f *fakes) addUserWithPassword(t *testing.T, username, password string) *model.User {
+	t.Helper()
+
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	u := f.addUser(username)
+	u.Password = hash
+	f.users.put(u)
+	return u
+}
+
+func TestAuthLocksOutAfterFailures(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	f.addUserWithPassword(t, "alice", "correct-password")
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.Auth(ctx, &dto.AuthRequest{Username: "alice", Password: "wrong"})
+		if !errors.Is(err, model.ErrUnauthorized) {
+			t.Fatalf("attempt %d: Auth = %v, want ErrUnauthorized", i+1, err)
+		}
+	}
+
+	_, err := uc.Auth(ctx, &dto.AuthRequest{Username: "alice", Password: "correct-password"})
+	if !errors.Is(err, model.ErrTooManyRequests) {
+		t.Fatalf("locked out: Auth = %v, want ErrTooManyRequests", err)
+	}
+
+	if types := f.outbox.types(); len(types) != 1 || types[0] != model.EventUserLockedOut {
+		t.Fatalf("events = %v, want a single %s", types, model.EventUserLockedOut)
+	}
+}
+
+func TestAuthResetsFailuresOnLogin(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	f.addUserWithPassword(t, "alice", "correct-password")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, _ = uc.Auth(ctx, &dto.AuthRequest{Username: "alice", Password: "wrong"})
+	}
+
+	if _, err := uc.Auth(ctx, &dto.AuthRequest{Username: "alice", Password: "correct-password"}); err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if n := f.web.failures["alice"]; n != 0 {
+		t.Fatalf("failures = %d after a login, want 0", n)
+	}
+}
+
+func TestCompleteLoginCodeStoreErrorIsNotFailure(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Email, u.EmailVerified = "alice@example.com", true
+	f.users.put(u)
+	ctx := context.Background()
+
+	if err := uc.RequestLoginCode(ctx, "alice"); err != nil {
+		t.Fatalf("RequestLoginCode: %v", err)
+	}
+	fields := strings.Fields(f.sender.last().Body)
+	code := fields[len(fields)-1]
+
+	storeErr := errors.New("redis: connection refused")
+	f.web.codeErr = storeErr
+
+	_, err := uc.CompleteLoginCode(ctx, &dto.CompleteLoginCode{Username: "alice", Code: code})
+	if !errors.Is(err, storeErr) {
+		t.Fatalf("CompleteLoginCode = %v, want the store error", err)
+	}
+	if n := f.web.failures["alice"]; n != 0 {
+		t.Fatalf("failures = %d, a store error must not count", n)
+	}
+
+	f.web.codeErr = nil
+	if _, err = uc.CompleteLoginCode(ctx, &dto.CompleteLoginCode{Username: "alice", Code: code}); err != nil {
+		t.Fatalf("CompleteLoginCode: %v", err)
+	}
+}
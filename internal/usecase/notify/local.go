@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/pkg/util"
+)
+
+// LocalSender delivers messages to the log and, when a file is configured, appends them to it.
+// It is meant for development, real deployments plug in their own sender.
+type LocalSender struct {
+	file string
+	mu   sync.Mutex
+}
+
+func NewLocalSender(file string) *LocalSender {
+	return &LocalSender{
+		file: file,
+	}
+}
+
+func (s *LocalSender) Send(ctx context.Context, msg *dto.Message) error {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.notify.LocalSender").
+		Str("method", "Send").Logger()
+
+	zLog.Info().
+		Str("channel", string(msg.Channel)).
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Msg(msg.Body)
+
+	if s.file == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		zLog.Err(err).Msg("LocalSender - Send - os.OpenFile")
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s [%s] to: %s | %s | %s\n",
+		util.NowUTC().Format("2006-01-02T15:04:05Z"), msg.Channel, msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		zLog.Err(err).Msg("LocalSender - Send - fmt.Fprintf")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package usecase
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+// emit writes an event to the outbox in the transaction of the change it describes,
+// so the event is published if and only if the change commits. Extra fields are added to the payload.
+func (uc *UserUseCase) emit(ctx context.Context, txId int, eventType string, id uuid.UUID, username string, state model.State, extra ...string) error {
+	payload := map[string]string{
+		"user_id":  id.String(),
+		"username": username,
+		"state":    string(state),
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		payload[extra[i]] = extra[i+1]
+	}
+
+	ev := &model.OutboxEvent{
+		Id:          uuid.New(),
+		Type:        eventType,
+		AggregateId: id,
+		Payload:     payload,
+		CreateTs:    util.NowUTC(),
+	}
+
+	return uc.outboxRepo.Create(ctx, ev, txId)
+}
+
+// emitAlone writes an event that is not part of a change, such as a lockout, in its own transaction.
+func (uc *UserUseCase) emitAlone(ctx context.Context, eventType string, id uuid.UUID, username string, state model.State, extra ...string) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "emitAlone").Logger()
+
+	var txId int
+	txId, err := uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	err = uc.emit(ctx, txId, eventType, id, username, state, extra...)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
+	return nil
+}
+
+// OutboxUseCase relays outbox events to the publisher and queues them for the subscribed webhooks.
+type OutboxUseCase struct {
+	repo        OutboxRepo
+	webhookRepo WebhookRepo
+	txRepo      TxRepo
+	publisher   Publisher
+	head        *headNotifier
+	streams     atomic.Int64
+}
+
+// NewOutboxUseCase -.
+func NewOutboxUseCase(r OutboxRepo, w WebhookRepo, tx TxRepo, p Publisher) *OutboxUseCase {
+	return &OutboxUseCase{
+		repo:        r,
+		webhookRepo: w,
+		txRepo:      tx,
+		publisher:   p,
+		head:        newHeadNotifier(),
+	}
+}
+
+// Relay publishes up to limit pending events in order and returns how many were published.
+// The events are claimed in a short transaction and published outside of it, then the results are
+// recorded in another one. Events are marked published only after the publisher accepted them, so
+// delivery is at-least-once, and a claim left by a crashed relay expires after OUTBOX_CLAIM_TIMEOUT.
+// The first failing event stops the batch to keep the order, it is retried on the next run until
+// OUTBOX_MAX_ATTEMPTS attempts failed and it is dead-lettered.
+func (uc *OutboxUseCase) Relay(ctx context.Context, limit int) (int, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.OutboxUseCase").
+		Str("method", "Relay").Logger()
+
+	events, err := uc.claim(ctx, limit)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.claim")
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	published := 0
+	var pubErr error
+	for i := range events {
+		pubErr = uc.publisher.Publish(ctx, &events[i])
+		if pubErr != nil {
+			zLog.Err(pubErr).Int64("seq", events[i].Seq).Msg("OutboxUseCase - error processing uc.publisher.Publish")
+			break
+		}
+		published++
+	}
+
+	err = uc.record(ctx, events, published, pubErr)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.record")
+		return 0, err
+	}
+
+	return published, nil
+}
+
+// claim claims the oldest pending events for this relay and commits.
+func (uc *OutboxUseCase) claim(ctx context.Context, limit int) (events []model.OutboxEvent, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.OutboxUseCase").
+		Str("method", "claim").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("OutboxUseCase - error processing r.txRepo.TxEnd")
+			events = nil
+		}
+	}()
+
+	now := util.NowUTC()
+	until := now.Add(time.Duration(config.Conf.Outbox.ClaimTimeout) * time.Second)
+
+	events, err = uc.repo.ClaimPending(ctx, limit, now, until, txId)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.ClaimPending")
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// record queues the first published events of the batch for the webhooks and marks them published.
+// The event that failed counts an attempt, the ones after it were not tried and are released.
+func (uc *OutboxUseCase) record(ctx context.Context, events []model.OutboxEvent, published int, pubErr error) (err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.OutboxUseCase").
+		Str("method", "record").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("OutboxUseCase - error processing r.txRepo.TxEnd")
+		}
+	}()
+
+	now := util.NowUTC()
+	if published > 0 {
+		seqs := make([]int64, 0, published)
+		for i := range events[:published] {
+			err = uc.webhookRepo.Enqueue(ctx, &events[i], now, txId)
+			if err != nil {
+				zLog.Err(err).Msg("OutboxUseCase - error processing uc.webhookRepo.Enqueue")
+				return err
+			}
+			seqs = append(seqs, events[i].Seq)
+		}
+
+		err = uc.repo.MarkPublished(ctx, seqs, now, txId)
+		if err != nil {
+			zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.MarkPublished")
+			return err
+		}
+	}
+
+	if pubErr == nil {
+		return nil
+	}
+
+	failed := &events[published]
+	var deadTs *time.Time
+	if failed.Attempts+1 >= config.Conf.Outbox.MaxAttempts {
+		deadTs = &now
+		zLog.Warn().Int64("seq", failed.Seq).Str("id", failed.Id.String()).
+			Msg("OutboxUseCase - event dead-lettered")
+	}
+
+	err = uc.repo.MarkFailed(ctx, failed.Seq, pubErr.Error(), deadTs, txId)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.MarkFailed")
+		return err
+	}
+
+	rest := events[published+1:]
+	if len(rest) == 0 {
+		return nil
+	}
+
+	seqs := make([]int64, len(rest))
+	for i := range rest {
+		seqs[i] = rest[i].Seq
+	}
+	err = uc.repo.Release(ctx, seqs, txId)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.Release")
+		return err
+	}
+
+	return nil
+}
+
+// Backlog returns the number of events waiting to be published.
+func (uc *OutboxUseCase) Backlog(ctx context.Context) (int64, error) {
+	return uc.repo.CountPending(ctx)
+}
+
+// PurgePublished removes events published before the given time.
+func (uc *OutboxUseCase) PurgePublished(ctx context.Context, before time.Time) (int64, error) {
+	return uc.repo.PurgePublished(ctx, before)
+}
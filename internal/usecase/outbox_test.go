@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+type fakePublisher struct {
+	mu sync.Mutex
+	tx *fakeTxRepo
+	// failSeq fails the event with this seq
+	failSeq   int64
+	published []int64
+	inTx      bool
+}
+
+func (p *fakePublisher) Publish(_ context.Context, ev *model.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tx.inTx() {
+		p.inTx = true
+	}
+	if ev.Seq == p.failSeq {
+		return errors.New("broker down")
+	}
+	p.published = append(p.published, ev.Seq)
+	return nil
+}
+
+func newTestOutboxUseCase(t *testing.T, events int) (*OutboxUseCase, *fakeOutboxRepo, *fakeWebhookRepo, *fakePublisher) {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.Outbox.ClaimTimeout = 60
+	cfg.Outbox.MaxAttempts = 2
+
+	repo := newFakeOutboxRepo()
+	for i := 0; i < events; i++ {
+		_ = repo.Create(context.Background(), &model.OutboxEvent{Id: uuid.New(), Type: model.EventUserCreated, CreateTs: util.NowUTC()}, 0)
+	}
+	_, _ = repo.AssignPositions(context.Background(), events, 0)
+	tx := &fakeTxRepo{}
+	webhooks := &fakeWebhookRepo{}
+	publisher := &fakePublisher{tx: tx}
+
+	return NewOutboxUseCase(repo, webhooks, tx, publisher), repo, webhooks, publisher
+}
+
+func TestRelayPublishesOutsideTransaction(t *testing.T) {
+	uc, repo, webhooks, publisher := newTestOutboxUseCase(t, 3)
+
+	n, err := uc.Relay(context.Background(), 10)
+	if err != nil || n != 3 {
+		t.Fatalf("Relay = %d, %v, want 3 events published", n, err)
+	}
+	if publisher.inTx {
+		t.Fatal("events were published inside a transaction")
+	}
+	if len(webhooks.enqueued) != 3 {
+		t.Fatalf("%d events queued for webhooks, want 3", len(webhooks.enqueued))
+	}
+	for _, ev := range repo.events {
+		if ev.PublishedTs == nil {
+			t.Fatalf("event %d was not marked published", ev.Seq)
+		}
+	}
+
+	if n, _ = uc.Relay(context.Background(), 10); n != 0 {
+		t.Fatalf("Relay published %d events twice", n)
+	}
+}
+
+func TestRelayStopsAtFailureAndReleasesTheRest(t *testing.T) {
+	uc, repo, webhooks, publisher := newTestOutboxUseCase(t, 3)
+	publisher.failSeq = 2
+
+	n, err := uc.Relay(context.Background(), 10)
+	if err != nil || n != 1 {
+		t.Fatalf("Relay = %d, %v, want 1 event published", n, err)
+	}
+	if len(webhooks.enqueued) != 1 || repo.events[1].Attempts != 1 || len(repo.claimed) != 0 {
+		t.Fatalf("enqueued %v, attempts %d, claimed %v: want the failure counted and no claim left",
+			webhooks.enqueued, repo.events[1].Attempts, repo.claimed)
+	}
+
+	// the failed event and the ones after it are tried again, in order
+	publisher.failSeq = 0
+	n, err = uc.Relay(context.Background(), 10)
+	if err != nil || n != 2 {
+		t.Fatalf("Relay = %d, %v, want the 2 remaining events published", n, err)
+	}
+	if len(publisher.published) != 3 || publisher.published[1] != 2 || publisher.published[2] != 3 {
+		t.Fatalf("published %v, want 1, 2, 3", publisher.published)
+	}
+}
+
+func TestRelayDeadLettersAfterMaxAttempts(t *testing.T) {
+	uc, repo, _, publisher := newTestOutboxUseCase(t, 2)
+	publisher.failSeq = 1
+
+	for i := 0; i < 2; i++ {
+		if _, err := uc.Relay(context.Background(), 10); err != nil {
+			t.Fatalf("Relay: %v", err)
+		}
+	}
+	if !repo.dead[1] {
+		t.Fatal("event was not dead-lettered after the last attempt")
+	}
+
+	// a dead event no longer holds back the ones after it
+	n, err := uc.Relay(context.Background(), 10)
+	if err != nil || n != 1 || repo.events[1].PublishedTs == nil {
+		t.Fatalf("Relay = %d, %v, want the event after the dead one published", n, err)
+	}
+}
+
+func TestRelaySkipsClaimedEvents(t *testing.T) {
+	uc, repo, _, _ := newTestOutboxUseCase(t, 2)
+	repo.claimed[1] = util.NowUTC().Add(time.Minute)
+
+	n, err := uc.Relay(context.Background(), 10)
+	if err != nil || n != 1 || repo.events[0].PublishedTs != nil {
+		t.Fatalf("Relay = %d, %v, want only the unclaimed event published", n, err)
+	}
+
+	// an expired claim, left by a relay that crashed, is taken over
+	repo.claimed[1] = util.NowUTC().Add(-time.Second)
+	if n, err = uc.Relay(context.Background(), 10); err != nil || n != 1 {
+		t.Fatalf("Relay = %d, %v, want the event of the expired claim published", n, err)
+	}
+}
+
+func TestStateEventOfInvitedUser(t *testing.T) {
+	if got := model.StateEvent(model.Invited); got != model.EventUserInvited {
+		t.Fatalf("StateEvent(Invited) = %s, want %s", got, model.EventUserInvited)
+	}
+	if got := model.StateEvent(model.Enabled); got != model.EventUserEnabled {
+		t.Fatalf("StateEvent(Enabled) = %s, want %s", got, model.EventUserEnabled)
+	}
+}
@@ -0,0 +1,349 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/policy"
+	"authenticator/pkg/util"
+	"authenticator/pkg/validation"
+)
+
+const (
+	maxPolicyActions = 32
+	// compiledPolicies is how many compiled conditions are kept
+	compiledPolicies = 1024
+)
+
+// PutPolicy creates the policy or replaces the policy of the same name in the realm.
+func (uc *UserUseCase) PutPolicy(ctx context.Context, in *dto.PutPolicy) (*model.Policy, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "PutPolicy").Logger()
+
+	p, err := newPolicy(in)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error newPolicy")
+		return nil, model.ErrBadRequest
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	err = uc.policyRepo.Upsert(ctx, p, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.policyRepo.Upsert")
+		return nil, err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type: model.AuditPolicyPut,
+		Details: map[string]string{
+			"realm":     p.Realm,
+			"policy":    p.Name,
+			"effect":    string(p.Effect),
+			"actions":   strings.Join(p.Actions, ","),
+			"condition": p.Condition,
+		},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (uc *UserUseCase) ListPolicies(ctx context.Context, realm string) ([]model.Policy, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ListPolicies").Logger()
+
+	realm, err := realmOrDefault(realm)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - invalid realm")
+		return nil, model.ErrBadRequest
+	}
+
+	items, err := uc.policyRepo.ListByRealm(ctx, realm)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.policyRepo.ListByRealm")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (uc *UserUseCase) DeletePolicy(ctx context.Context, realm, name string) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "DeletePolicy").Logger()
+
+	realm, err := realmOrDefault(realm)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - invalid realm")
+		return model.ErrBadRequest
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	p, err := uc.policyRepo.Delete(ctx, realm, name, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.policyRepo.Delete")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:    model.AuditPolicyDelete,
+		Details: map[string]string{"realm": p.Realm, "policy": p.Name},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	return nil
+}
+
+// CheckAccess evaluates the policies of the realm that cover the action against the owner of the
+// subject token, the resource and the request context. A matching deny policy wins over any allow
+// policy, a deny policy whose condition fails to evaluate denies too. Without a matching policy
+// access is denied. An invalid subject token returns ErrUnauthorized. The trace and the deciding
+// policy tell which policies exist and what they check, they are only returned to a caller that
+// manages policies.
+func (uc *UserUseCase) CheckAccess(ctx context.Context, in *dto.CheckAccess) (*model.AccessDecision, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "CheckAccess").
+		Str("action", in.Action).Logger()
+
+	realm, err := realmOrDefault(in.Realm)
+	if err != nil || in.Action == "" {
+		zLog.Error().Msg("UserUseCase - invalid realm or empty action")
+		return nil, model.ErrBadRequest
+	}
+
+	user, claims, err := uc.tokenOwner(ctx, in.SubjectToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.tokenOwner")
+		return nil, err
+	}
+
+	access, err := uc.effectiveAccess(ctx, user)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.effectiveAccess")
+		return nil, err
+	}
+
+	policies, err := uc.policyRepo.ListByRealm(ctx, realm)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.policyRepo.ListByRealm")
+		return nil, err
+	}
+
+	attrs := accessAttributes(user, claims, access, in)
+	decision := &model.AccessDecision{Reason: "no policy matched"}
+
+	for i := range policies {
+		p := &policies[i]
+		if !p.Covers(in.Action) {
+			continue
+		}
+
+		trace := model.PolicyTrace{Policy: p.Name, Effect: p.Effect}
+		expr, err := uc.compilePolicy(p.Condition)
+		if err == nil {
+			trace.Matched, err = expr.Eval(attrs)
+		}
+		if err != nil {
+			trace.Error = err.Error()
+		}
+		decision.Trace = append(decision.Trace, trace)
+
+		// a deny whose condition can not be evaluated, like one reading an undefined attribute, denies
+		failed := err != nil && p.Effect == model.PolicyDeny
+
+		switch {
+		case !trace.Matched && !failed:
+		case p.Effect == model.PolicyDeny && (decision.Allowed || decision.Policy == ""):
+			decision.Allowed = false
+			decision.Policy = p.Name
+			decision.Reason = "denied by policy"
+			if failed {
+				decision.Reason = "denied by policy, condition failed"
+			}
+		case p.Effect == model.PolicyAllow && decision.Policy == "":
+			decision.Allowed = true
+			decision.Policy = p.Name
+			decision.Reason = "allowed by policy"
+		}
+	}
+
+	zLog.Debug().Bool("allowed", decision.Allowed).Str("policy", decision.Policy).Msg("UserUseCase - access checked")
+
+	if !in.Explain || !managesPolicies(dto.PrincipalFrom(ctx)) {
+		decision.Policy = ""
+		decision.Trace = nil
+	}
+
+	return decision, nil
+}
+
+// managesPolicies reports whether the caller may read the policies, an actor can not act for it
+// like it can not change them.
+func managesPolicies(principal *dto.TokenInfo) bool {
+	return principal != nil && principal.Actor == nil && slices.Contains(principal.Permissions, model.PermissionPolicy)
+}
+
+// compilePolicy returns the compiled condition. Conditions are compiled once and kept by their source,
+// the source of a changed policy is another key.
+func (uc *UserUseCase) compilePolicy(condition string) (*policy.Expr, error) {
+	uc.policyMu.Lock()
+	defer uc.policyMu.Unlock()
+
+	if expr, ok := uc.policies.Get(condition); ok {
+		return expr, nil
+	}
+
+	expr, err := policy.Compile(condition)
+	if err != nil {
+		return nil, err
+	}
+	uc.policies.Add(condition, expr, time.Time{})
+
+	return expr, nil
+}
+
+// accessAttributes are what policy conditions see: subject, action, resource, context and env.
+func accessAttributes(user *model.User, claims *dto.AuthTokenClaim, access *model.Access, in *dto.CheckAccess) map[string]interface{} {
+
+	permissions := make([]string, 0, len(access.Permissions))
+	for _, p := range access.Permissions {
+		permissions = append(permissions, string(p))
+	}
+
+	subject := map[string]interface{}{
+		"id":             user.Id.String(),
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"phone_verified": user.PhoneVerified,
+		"roles":          access.Roles,
+		"groups":         access.Groups,
+		"permissions":    permissions,
+		"scopes":         claims.Scopes(),
+		"attributes":     stringMap(user.Attributes),
+	}
+	if claims.Act != nil {
+		subject["actor"] = claims.Act.Username
+	}
+
+	now := util.NowUTC()
+
+	return map[string]interface{}{
+		"subject": subject,
+		"action":  in.Action,
+		"resource": map[string]interface{}{
+			"type":       in.ResourceType,
+			"id":         in.ResourceId,
+			"attributes": stringMap(in.ResourceAttributes),
+		},
+		"context": stringMap(in.Context),
+		"env": map[string]interface{}{
+			"time":    now.Format(time.RFC3339),
+			"date":    now.Format("2006-01-02"),
+			"hour":    float64(now.Hour()),
+			"minute":  float64(now.Minute()),
+			"weekday": strings.ToLower(now.Weekday().String()),
+		},
+	}
+}
+
+func stringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+func realmOrDefault(realm string) (string, error) {
+	if realm == "" {
+		return model.DefaultRealm, nil
+	}
+	return realm, validation.StringMustBeKey(realm)
+}
+
+func newPolicy(in *dto.PutPolicy) (*model.Policy, error) {
+
+	realm, err := realmOrDefault(in.Realm)
+	if err != nil {
+		return nil, fmt.Errorf("realm: %w", err)
+	}
+	if err = validation.StringMustBeKey(in.Name); err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+	if err = validation.StringCanBeEmptyButNotExceedMaxLength(in.Description, 256); err != nil {
+		return nil, fmt.Errorf("description: %w", err)
+	}
+	effect, err := model.ParsePolicyEffect(in.Effect)
+	if err != nil {
+		return nil, fmt.Errorf("effect: %w", err)
+	}
+	if len(in.Actions) == 0 || len(in.Actions) > maxPolicyActions {
+		return nil, fmt.Errorf("actions: between 1 and %d required", maxPolicyActions)
+	}
+	for _, action := range in.Actions {
+		if err = validation.StringMustBeNotEmptyWithMaxLength(action, 128); err != nil {
+			return nil, fmt.Errorf("actions: %w", err)
+		}
+	}
+	if _, err = policy.Compile(in.Condition); err != nil {
+		return nil, fmt.Errorf("condition: %w", err)
+	}
+
+	now := util.NowUTC()
+	return &model.Policy{
+		Realm:       realm,
+		Name:        in.Name,
+		Description: in.Description,
+		Effect:      effect,
+		Actions:     in.Actions,
+		Condition:   in.Condition,
+		CreateTs:    now,
+		UpdateTs:    now,
+	}, nil
+}
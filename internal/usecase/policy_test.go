@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestCheckAccessPolicies(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		policies []model.Policy
+		context  map[string]string
+		want     bool
+	}{
+		{
+			name:     "no policy denies",
+			policies: nil,
+			want:     false,
+		},
+		{
+			name:     "allow matches",
+			policies: []model.Policy{{Name: "read", Effect: model.PolicyAllow, Actions: []string{"doc:*"}}},
+			want:     true,
+		},
+		{
+			name: "matching deny wins",
+			policies: []model.Policy{
+				{Name: "read", Effect: model.PolicyAllow, Actions: []string{"doc:*"}},
+				{Name: "night", Effect: model.PolicyDeny, Actions: []string{"*"}, Condition: `context.shift == "night"`},
+			},
+			context: map[string]string{"shift": "night"},
+			want:    false,
+		},
+		{
+			name: "deny with an undefined attribute fails closed",
+			policies: []model.Policy{
+				{Name: "read", Effect: model.PolicyAllow, Actions: []string{"doc:*"}},
+				{Name: "night", Effect: model.PolicyDeny, Actions: []string{"*"}, Condition: `context.shift == "night"`},
+			},
+			want: false,
+		},
+		{
+			name: "deny with a type error fails closed",
+			policies: []model.Policy{
+				{Name: "read", Effect: model.PolicyAllow, Actions: []string{"doc:*"}},
+				{Name: "level", Effect: model.PolicyDeny, Actions: []string{"*"}, Condition: `context.level > 3 && true`},
+			},
+			context: map[string]string{"level": "high"},
+			want:    false,
+		},
+		{
+			name: "allow with an undefined attribute does not match",
+			policies: []model.Policy{
+				{Name: "read", Effect: model.PolicyAllow, Actions: []string{"doc:*"}, Condition: `context.shift == "day"`},
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, f := newTestUserUseCase(t)
+			u := f.addUser("alice")
+			f.policies.byRealm[model.DefaultRealm] = tt.policies
+
+			decision, err := uc.CheckAccess(context.Background(), &dto.CheckAccess{
+				SubjectToken: accessToken(t, u),
+				Action:       "doc:read",
+				Context:      tt.context,
+				Explain:      true,
+			})
+			if err != nil {
+				t.Fatalf("CheckAccess: %v", err)
+			}
+			if decision.Allowed != tt.want {
+				t.Fatalf("allowed = %v (%s), want %v", decision.Allowed, decision.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePolicyOnce(t *testing.T) {
+	uc, _ := newTestUserUseCase(t)
+
+	first, err := uc.compilePolicy(`"admin" in subject.roles`)
+	if err != nil {
+		t.Fatalf("compilePolicy: %v", err)
+	}
+	second, err := uc.compilePolicy(`"admin" in subject.roles`)
+	if err != nil {
+		t.Fatalf("compilePolicy: %v", err)
+	}
+	if first != second {
+		t.Fatal("the condition was compiled again")
+	}
+
+	if _, err = uc.compilePolicy(`subject.roles ==`); err == nil {
+		t.Fatal("compilePolicy accepted an invalid condition")
+	}
+}
+
+func TestCheckAccessExplainsOnlyToPolicyManagers(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	f.policies.byRealm[model.DefaultRealm] = []model.Policy{
+		{Name: "read", Effect: model.PolicyAllow, Actions: []string{"doc:*"}, Condition: `"alice" == subject.username`},
+	}
+	manager := &dto.TokenInfo{Username: "root", Permissions: []model.Permission{model.PermissionPolicy}}
+
+	tests := []struct {
+		name      string
+		principal *dto.TokenInfo
+		explained bool
+	}{
+		{"without a caller", nil, false},
+		{"caller without policy:manage", &dto.TokenInfo{Username: "gateway", Permissions: []model.Permission{model.PermissionUserRead}}, false},
+		{"actor for a policy manager", &dto.TokenInfo{Username: "root", Permissions: manager.Permissions, Actor: &dto.ActorClaim{Username: "support"}}, false},
+		{"policy manager", manager, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = dto.WithPrincipal(ctx, tt.principal)
+			}
+
+			decision, err := uc.CheckAccess(ctx, &dto.CheckAccess{
+				SubjectToken: accessToken(t, u),
+				Action:       "doc:read",
+				Explain:      true,
+			})
+			if err != nil {
+				t.Fatalf("CheckAccess: %v", err)
+			}
+			if !decision.Allowed {
+				t.Fatalf("allowed = false (%s), want true", decision.Reason)
+			}
+			if explained := decision.Policy != "" || len(decision.Trace) > 0; explained != tt.explained {
+				t.Fatalf("decision = %+v, want the policies explained: %v", decision, tt.explained)
+			}
+		})
+	}
+}
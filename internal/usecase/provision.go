@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+// Provision applies the change of a provisioning client to the email, phone and state of the user in one
+// transaction, so a failed part leaves the user as it was. Active only toggles between enabled and disabled,
+// invited users stay invited until they accept the invite. The user is read and its version checked in
+// the transaction, not from the cache, so a stale cached user neither fails nor passes the check.
+func (uc *UserUseCase) Provision(ctx context.Context, in *dto.Provision) (err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "Provision").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+		}
+	}()
+
+	user, err := uc.repo.GetByIdForUpdate(ctx, in.Id, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByIdForUpdate")
+		return err
+	}
+
+	if user == nil {
+		return model.ErrNotFound
+	}
+
+	if in.Version != 0 && in.Version != user.Version {
+		return model.ErrNoRowsAffected
+	}
+
+	state := user.State
+	if in.Active != nil && user.State != model.Invited {
+		state = model.Disabled
+		if *in.Active {
+			state = model.Enabled
+		}
+	}
+
+	profileChanged := in.Email != user.Email || in.Phone != user.Phone
+	if !profileChanged && state == user.State {
+		return nil
+	}
+
+	if err = validateProfile(in.Email, in.Phone, nil); err != nil {
+		zLog.Err(err).Msg("UserUseCase - error validateProfile")
+		return model.ErrBadRequest
+	}
+
+	// the updates match the version read, which is locked until the transaction ends
+	current := *user
+	now := util.NowUTC()
+
+	if profileChanged {
+		userModel := &model.User{
+			Email:         in.Email,
+			EmailVerified: user.EmailVerified && user.Email == in.Email,
+			Phone:         in.Phone,
+			PhoneVerified: user.PhoneVerified && user.Phone == in.Phone,
+			Attributes:    user.Attributes,
+			UpdateTs:      now,
+			Version:       util.VersionInc(current.Version),
+		}
+
+		err = uc.repo.UpdateProfile(ctx, &current, userModel, txId)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.repo.UpdateProfile")
+			return err
+		}
+		current.Version = userModel.Version
+
+		err = uc.audit(ctx, txId, &model.AuditEvent{
+			Type:           model.AuditUserUpdate,
+			TargetId:       user.Id,
+			TargetUsername: user.Username,
+		})
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+			return err
+		}
+
+		err = uc.emit(ctx, txId, model.EventUserUpdated, user.Id, user.Username, user.State)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+			return err
+		}
+	}
+
+	if state != user.State {
+		userModel := &model.User{
+			State:    state,
+			UpdateTs: now,
+			Version:  util.VersionInc(current.Version),
+		}
+
+		err = uc.repo.ChangeState(ctx, &current, userModel, txId)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.repo.ChangeState")
+			return err
+		}
+
+		err = uc.audit(ctx, txId, &model.AuditEvent{
+			Type:           model.AuditUserStateChange,
+			TargetId:       user.Id,
+			TargetUsername: user.Username,
+			Details:        map[string]string{"from": string(user.State), "to": string(state)},
+		})
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+			return err
+		}
+
+		err = uc.emit(ctx, txId, model.StateEvent(state), user.Id, user.Username, state)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestProvisionUpdatesProfileAndStateInOneTransaction(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+
+	active := false
+	err := uc.Provision(context.Background(), &dto.Provision{
+		Id:      u.Id,
+		Email:   "alice@example.com",
+		Phone:   "99361000001",
+		Active:  &active,
+		Version: u.Version,
+	})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+
+	got := f.users.get(u.Id)
+	if got.Email != "alice@example.com" || got.Phone != "99361000001" || got.State != model.Disabled {
+		t.Fatalf("user = %+v, want the profile changed and the user disabled", got)
+	}
+	if got.Version != u.Version+2 {
+		t.Fatalf("version = %d, want %d", got.Version, u.Version+2)
+	}
+	if f.tx.committed != 1 {
+		t.Fatalf("%d transactions committed, want 1", f.tx.committed)
+	}
+
+	var types []string
+	for _, ev := range f.outbox.events {
+		types = append(types, ev.Type)
+	}
+	if len(types) != 2 || types[0] != model.EventUserUpdated || types[1] != model.EventUserDisabled {
+		t.Fatalf("events = %v, want user.updated and user.disabled", types)
+	}
+}
+
+func TestProvisionRefusesStaleVersion(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Version = 3
+	f.users.put(u)
+
+	active := false
+	err := uc.Provision(context.Background(), &dto.Provision{Id: u.Id, Email: "alice@example.com", Active: &active, Version: 2})
+	if !errors.Is(err, model.ErrNoRowsAffected) {
+		t.Fatalf("Provision = %v, want ErrNoRowsAffected", err)
+	}
+
+	if got := f.users.get(u.Id); got.Email != "" || got.State != model.Enabled || got.Version != 3 {
+		t.Fatalf("user = %+v, want it unchanged", got)
+	}
+}
+
+func TestProvisionChecksVersionAgainstDatabase(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Version = 3
+	f.users.put(u)
+
+	// the cache still has version 2
+	stale := *u
+	stale.Version = 2
+	f.users.stale = map[uuid.UUID]*model.User{u.Id: &stale}
+
+	err := uc.Provision(context.Background(), &dto.Provision{Id: u.Id, Email: "old@example.com", Version: 2})
+	if !errors.Is(err, model.ErrNoRowsAffected) {
+		t.Fatalf("Provision with the cached version = %v, want ErrNoRowsAffected", err)
+	}
+
+	err = uc.Provision(context.Background(), &dto.Provision{Id: u.Id, Email: "alice@example.com", Version: 3})
+	if err != nil {
+		t.Fatalf("Provision with the current version: %v", err)
+	}
+	if got := f.users.get(u.Id); got.Email != "alice@example.com" || got.Version != 4 {
+		t.Fatalf("user = %+v, want the email changed at version 4", got)
+	}
+}
+
+func TestProvisionKeepsInvitedUsersInvited(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.State = model.Invited
+	f.users.put(u)
+
+	active := true
+	if err := uc.Provision(context.Background(), &dto.Provision{Id: u.Id, Active: &active}); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if got := f.users.get(u.Id); got.State != model.Invited {
+		t.Fatalf("state = %s, want invited", got.State)
+	}
+}
+
+func TestChangeStateRefusesStaleVersion(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Version = 5
+	f.users.put(u)
+
+	err := uc.ChangeState(context.Background(), &dto.ChangeState{Username: "alice", State: model.Deleted, Version: 4})
+	if !errors.Is(err, model.ErrNoRowsAffected) {
+		t.Fatalf("ChangeState = %v, want ErrNoRowsAffected", err)
+	}
+
+	err = uc.ChangeState(context.Background(), &dto.ChangeState{Username: "alice", State: model.Deleted, Version: 5})
+	if err != nil {
+		t.Fatalf("ChangeState: %v", err)
+	}
+}
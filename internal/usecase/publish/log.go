@@ -0,0 +1,30 @@
+package publish
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+)
+
+// LogPublisher publishes events to the log only.
+// It is meant for development and for deployments where nothing consumes the events yet.
+type LogPublisher struct{}
+
+func NewLogPublisher() *LogPublisher {
+	return &LogPublisher{}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, ev *model.OutboxEvent) error {
+	zerolog.Ctx(ctx).Info().
+		Str("unit", "internal.usecase.publish.LogPublisher").
+		Str("method", "Publish").
+		Str("idempotency_key", ev.Id.String()).
+		Str("type", ev.Type).
+		Str("aggregate_id", ev.AggregateId.String()).
+		Interface("payload", ev.Payload).
+		Msg("event published")
+
+	return nil
+}
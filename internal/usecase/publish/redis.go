@@ -0,0 +1,46 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+
+	"authenticator/internal/model"
+)
+
+// RedisStreamPublisher appends events to a Redis stream.
+// The idempotency key travels with every entry, since a relay retry can add the same event twice.
+type RedisStreamPublisher struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+func NewRedisStreamPublisher(client *redis.Client, stream string, maxLen int64) *RedisStreamPublisher {
+	return &RedisStreamPublisher{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (p *RedisStreamPublisher) Publish(ctx context.Context, ev *model.OutboxEvent) error {
+	payload, err := json.Marshal(ev.Payload)
+	if err != nil {
+		return err
+	}
+
+	return p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: map[string]interface{}{
+			"idempotency_key": ev.Id.String(),
+			"type":            ev.Type,
+			"aggregate_id":    ev.AggregateId.String(),
+			"payload":         string(payload),
+			"create_ts":       ev.CreateTs.Unix(),
+		},
+	}).Err()
+}
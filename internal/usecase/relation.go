@@ -0,0 +1,462 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+const (
+	maxWriteTuples       = 100
+	consistencyTokenTag  = "rev:"
+	maxListedRelationSet = 10000
+)
+
+// RelationUseCase answers who has which relation to which object from relation tuples and the
+// relation rewrites of the namespace configuration. Every read is evaluated at one revision of the
+// store and returns it as a consistency token.
+type RelationUseCase struct {
+	repo       RelationRepo
+	txRepo     TxRepo
+	namespaces model.Namespaces
+	maxDepth   int
+}
+
+// NewRelationUseCase -.
+func NewRelationUseCase(r RelationRepo, tx TxRepo, ns model.Namespaces, maxDepth int) *RelationUseCase {
+	return &RelationUseCase{
+		repo:       r,
+		txRepo:     tx,
+		namespaces: ns,
+		maxDepth:   maxDepth,
+	}
+}
+
+// WriteTuples writes and deletes the tuples in one revision and returns its consistency token.
+// Writing a tuple that exists or deleting one that does not changes nothing.
+func (uc *RelationUseCase) WriteTuples(ctx context.Context, in *dto.WriteTuples) (token string, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.RelationUseCase").
+		Str("method", "WriteTuples").Logger()
+
+	if n := len(in.Writes) + len(in.Deletes); n == 0 || n > maxWriteTuples {
+		zLog.Error().Int("tuples", n).Msgf("RelationUseCase - between 1 and %d tuples required", maxWriteTuples)
+		return "", model.ErrBadRequest
+	}
+	for _, list := range [][]model.RelationTuple{in.Writes, in.Deletes} {
+		for _, t := range list {
+			if err = uc.validateTuple(t); err != nil {
+				zLog.Err(err).Msg("RelationUseCase - error uc.validateTuple")
+				return "", model.ErrBadRequest
+			}
+		}
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error processing r.txRepo.NewTxId")
+		return "", err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("RelationUseCase - error processing r.txRepo.TxEnd")
+			token = ""
+			return
+		}
+	}()
+
+	revision, err := uc.repo.NewRevision(ctx, util.NowUTC(), txId)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error processing uc.repo.NewRevision")
+		return "", err
+	}
+
+	err = uc.repo.Delete(ctx, in.Deletes, revision, txId)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error processing uc.repo.Delete")
+		return "", err
+	}
+
+	err = uc.repo.Write(ctx, in.Writes, revision, txId)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error processing uc.repo.Write")
+		return "", err
+	}
+
+	return encodeConsistencyToken(revision), nil
+}
+
+// Check reports whether the subject of the tuple has its relation to its object, directly,
+// through a userset or through the rewrites of the relation. When the subject is not found and
+// a walk was cut at RELATION_MAX_DEPTH the answer is unknown and ErrMaxDepth is returned.
+func (uc *RelationUseCase) Check(ctx context.Context, in *dto.CheckRelation) (bool, string, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.RelationUseCase").
+		Str("method", "Check").
+		Str("tuple", in.Tuple.String()).Logger()
+
+	if err := uc.validateTuple(in.Tuple); err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error uc.validateTuple")
+		return false, "", model.ErrBadRequest
+	}
+
+	revision, err := uc.revision(ctx, in.Consistency)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error uc.revision")
+		return false, "", err
+	}
+
+	w := &checkWalk{
+		subject:  in.Tuple.Subject,
+		revision: revision,
+		path:     make(map[model.Subject]bool),
+		done:     make(map[model.Subject]bool),
+	}
+	found, complete, err := uc.check(ctx, in.Tuple.Object(), 0, w)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error uc.check")
+		return false, "", err
+	}
+	if !found && !complete {
+		zLog.Error().Int("maxDepth", uc.maxDepth).Msg("RelationUseCase - max depth reached")
+		return false, "", model.ErrMaxDepth
+	}
+
+	return found, encodeConsistencyToken(revision), nil
+}
+
+// checkWalk is the state of one check. A userset on the path is a cycle, a userset in done was
+// walked completely without finding the subject, so neither is walked again.
+type checkWalk struct {
+	subject  model.Subject
+	revision int64
+	path     map[model.Subject]bool
+	done     map[model.Subject]bool
+}
+
+// check walks the usersets and rewrites of the object depth first. It is not complete when a walk
+// below it was cut at the max depth, such a userset is not done and is walked again when it is
+// reached on a shorter path, so the answer does not depend on the order of the walk.
+func (uc *RelationUseCase) check(ctx context.Context, object model.Subject, depth int, w *checkWalk) (found bool, complete bool, err error) {
+
+	if depth > uc.maxDepth {
+		return false, false, nil
+	}
+	if w.done[object] || w.path[object] {
+		return false, true, nil
+	}
+	w.path[object] = true
+	defer delete(w.path, object)
+
+	subjects, err := uc.repo.Subjects(ctx, object, w.revision)
+	if err != nil {
+		return false, false, err
+	}
+	for _, s := range subjects {
+		if s == w.subject {
+			return true, true, nil
+		}
+	}
+
+	complete = true
+	for _, s := range subjects {
+		if s.Relation == "" {
+			continue
+		}
+		found, ok, err := uc.check(ctx, s, depth+1, w)
+		if found || err != nil {
+			return found, true, err
+		}
+		complete = complete && ok
+	}
+
+	usersets, err := uc.rewrites(ctx, object, w.revision)
+	if err != nil {
+		return false, false, err
+	}
+	for _, u := range usersets {
+		found, ok, err := uc.check(ctx, u, depth+1, w)
+		if found || err != nil {
+			return found, true, err
+		}
+		complete = complete && ok
+	}
+
+	if complete {
+		w.done[object] = true
+	}
+	return false, complete, nil
+}
+
+// Expand returns the userset tree of object#relation.
+func (uc *RelationUseCase) Expand(ctx context.Context, in *dto.ExpandRelation) (*model.ExpandNode, string, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.RelationUseCase").
+		Str("method", "Expand").
+		Str("object", in.Object.String()).Logger()
+
+	if uc.namespaces.Relation(in.Object.Namespace, in.Object.Relation) == nil {
+		zLog.Error().Msg("RelationUseCase - unknown namespace or relation")
+		return nil, "", model.ErrBadRequest
+	}
+
+	revision, err := uc.revision(ctx, in.Consistency)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error uc.revision")
+		return nil, "", err
+	}
+
+	node, err := uc.expand(ctx, in.Object, revision, 0, make(map[model.Subject]bool))
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error uc.expand")
+		return nil, "", err
+	}
+
+	return node, encodeConsistencyToken(revision), nil
+}
+
+// expand builds the tree depth first. A userset already on the path is a cycle and is left as a leaf.
+func (uc *RelationUseCase) expand(ctx context.Context, object model.Subject, revision int64, depth int, path map[model.Subject]bool) (*model.ExpandNode, error) {
+
+	node := &model.ExpandNode{Object: object}
+	if path[object] || depth > uc.maxDepth {
+		return node, nil
+	}
+	path[object] = true
+	defer delete(path, object)
+
+	var err error
+	node.Subjects, err = uc.repo.Subjects(ctx, object, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	usersets, err := uc.rewrites(ctx, object, revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range usersets {
+		child, err := uc.expand(ctx, u, revision, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, *child)
+	}
+
+	return node, nil
+}
+
+// ListObjects returns the ids of the objects of the namespace the subject has the relation to.
+// It walks the usersets containing the subject breadth first, the reverse of check.
+func (uc *RelationUseCase) ListObjects(ctx context.Context, in *dto.ListObjects) ([]string, string, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.RelationUseCase").
+		Str("method", "ListObjects").
+		Str("subject", in.Subject.String()).Logger()
+
+	if uc.namespaces.Relation(in.Namespace, in.Relation) == nil || uc.validateSubject(in.Subject) != nil {
+		zLog.Error().Msg("RelationUseCase - unknown namespace or relation")
+		return nil, "", model.ErrBadRequest
+	}
+
+	revision, err := uc.revision(ctx, in.Consistency)
+	if err != nil {
+		zLog.Err(err).Msg("RelationUseCase - error uc.revision")
+		return nil, "", err
+	}
+
+	seen := make(map[model.Subject]bool)
+	var objects []string
+	level := []model.Subject{in.Subject}
+
+	for depth := 0; len(level) > 0 && depth <= uc.maxDepth; depth++ {
+		var next []model.Subject
+		for _, s := range level {
+			usersets, err := uc.containing(ctx, s, revision)
+			if err != nil {
+				zLog.Err(err).Msg("RelationUseCase - error uc.containing")
+				return nil, "", err
+			}
+			for _, u := range usersets {
+				if seen[u] {
+					continue
+				}
+				seen[u] = true
+				if u.Namespace == in.Namespace && u.Relation == in.Relation {
+					objects = append(objects, u.ObjectId)
+				}
+				next = append(next, u)
+			}
+		}
+		if len(seen) > maxListedRelationSet {
+			zLog.Error().Int("usersets", len(seen)).Msg("RelationUseCase - too many usersets")
+			return nil, "", model.ErrTooManyRequests
+		}
+		level = next
+	}
+
+	sort.Strings(objects)
+
+	return objects, encodeConsistencyToken(revision), nil
+}
+
+// containing returns the usersets that directly contain the subject: those with a tuple naming it
+// and, for a userset, those its relation is rewritten into.
+func (uc *RelationUseCase) containing(ctx context.Context, subject model.Subject, revision int64) ([]model.Subject, error) {
+
+	tuples, err := uc.repo.BySubject(ctx, subject, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	usersets := make([]model.Subject, 0, len(tuples))
+	for _, t := range tuples {
+		usersets = append(usersets, t.Object())
+	}
+
+	if subject.Relation == "" {
+		return usersets, nil
+	}
+
+	// computed usersets: relations of the same object that include this relation
+	if ns := uc.namespaces[subject.Namespace]; ns != nil {
+		for _, rel := range ns.Relations {
+			for _, rw := range rel.Rewrite {
+				if rw.Tupleset == "" && rw.Computed == subject.Relation {
+					usersets = append(usersets, model.Subject{Namespace: subject.Namespace, ObjectId: subject.ObjectId, Relation: rel.Name})
+				}
+			}
+		}
+	}
+
+	// tuple to userset: objects related to this object by a tupleset whose relation includes this relation
+	var related []model.RelationTuple
+	object := model.Subject{Namespace: subject.Namespace, ObjectId: subject.ObjectId}
+	for _, ns := range uc.namespaces {
+		for _, rel := range ns.Relations {
+			for _, rw := range rel.Rewrite {
+				if rw.Tupleset == "" || rw.Computed != subject.Relation {
+					continue
+				}
+				if related == nil {
+					if related, err = uc.repo.BySubject(ctx, object, revision); err != nil {
+						return nil, err
+					}
+				}
+				for _, t := range related {
+					if t.Namespace == ns.Name && t.Relation == rw.Tupleset {
+						usersets = append(usersets, model.Subject{Namespace: ns.Name, ObjectId: t.ObjectId, Relation: rel.Name})
+					}
+				}
+			}
+		}
+	}
+
+	return usersets, nil
+}
+
+// rewrites returns the usersets the rewrites of the relation add to object#relation.
+func (uc *RelationUseCase) rewrites(ctx context.Context, object model.Subject, revision int64) ([]model.Subject, error) {
+
+	rel := uc.namespaces.Relation(object.Namespace, object.Relation)
+	if rel == nil {
+		return nil, nil
+	}
+
+	var usersets []model.Subject
+	for _, rw := range rel.Rewrite {
+		if rw.Tupleset == "" {
+			usersets = append(usersets, model.Subject{Namespace: object.Namespace, ObjectId: object.ObjectId, Relation: rw.Computed})
+			continue
+		}
+
+		related, err := uc.repo.Subjects(ctx, model.Subject{Namespace: object.Namespace, ObjectId: object.ObjectId, Relation: rw.Tupleset}, revision)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range related {
+			usersets = append(usersets, model.Subject{Namespace: r.Namespace, ObjectId: r.ObjectId, Relation: rw.Computed})
+		}
+	}
+
+	return usersets, nil
+}
+
+// revision picks the revision a read is evaluated at. Without a token or with a token that is not
+// exact it is the latest revision, which is at least as fresh as any token handed out.
+func (uc *RelationUseCase) revision(ctx context.Context, c dto.Consistency) (int64, error) {
+
+	head, err := uc.repo.Head(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if c.Token == "" {
+		if c.Exact {
+			return 0, model.ErrBadRequest
+		}
+		return head, nil
+	}
+
+	revision, err := decodeConsistencyToken(c.Token)
+	if err != nil || revision > head {
+		return 0, model.ErrBadRequest
+	}
+
+	if c.Exact {
+		return revision, nil
+	}
+	return head, nil
+}
+
+func (uc *RelationUseCase) validateTuple(t model.RelationTuple) error {
+	if uc.namespaces.Relation(t.Namespace, t.Relation) == nil {
+		return fmt.Errorf("unknown relation %s:%s", t.Namespace, t.Relation)
+	}
+	if t.ObjectId == "" {
+		return fmt.Errorf("empty object id")
+	}
+	return uc.validateSubject(t.Subject)
+}
+
+// validateSubject accepts any object as a subject, such as user:<id>, but a userset must name a configured relation.
+func (uc *RelationUseCase) validateSubject(s model.Subject) error {
+	if s.Namespace == "" || s.ObjectId == "" {
+		return fmt.Errorf("empty subject")
+	}
+	if s.Relation != "" && uc.namespaces.Relation(s.Namespace, s.Relation) == nil {
+		return fmt.Errorf("unknown relation %s:%s", s.Namespace, s.Relation)
+	}
+	return nil
+}
+
+func encodeConsistencyToken(revision int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(consistencyTokenTag + strconv.FormatInt(revision, 10)))
+}
+
+func decodeConsistencyToken(token string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	s, ok := strings.CutPrefix(string(b), consistencyTokenTag)
+	if !ok {
+		return 0, fmt.Errorf("invalid consistency token")
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,268 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func newTestRelationUseCase(t *testing.T, tuples ...string) (*RelationUseCase, *fakeRelationRepo) {
+	t.Helper()
+
+	ns, err := model.NewNamespaces(append([]model.NamespaceConfig(nil), model.DefaultNamespaces...))
+	if err != nil {
+		t.Fatalf("NewNamespaces: %v", err)
+	}
+	repo := &fakeRelationRepo{}
+	uc := NewRelationUseCase(repo, &fakeTxRepo{}, ns, 16)
+
+	if len(tuples) > 0 {
+		writeTuples(t, uc, tuples...)
+	}
+	return uc, repo
+}
+
+// writeTuples writes the tuples in one revision and returns its consistency token.
+func writeTuples(t *testing.T, uc *RelationUseCase, tuples ...string) string {
+	t.Helper()
+
+	in := &dto.WriteTuples{}
+	for _, s := range tuples {
+		in.Writes = append(in.Writes, tuple(t, s))
+	}
+	token, err := uc.WriteTuples(context.Background(), in)
+	if err != nil {
+		t.Fatalf("WriteTuples: %v", err)
+	}
+	return token
+}
+
+func tuple(t *testing.T, s string) model.RelationTuple {
+	t.Helper()
+
+	tp, err := model.ParseRelationTuple(s)
+	if err != nil {
+		t.Fatalf("ParseRelationTuple(%q): %v", s, err)
+	}
+	return tp
+}
+
+func subject(t *testing.T, s string) model.Subject {
+	t.Helper()
+
+	sub, err := model.ParseSubject(s)
+	if err != nil {
+		t.Fatalf("ParseSubject(%q): %v", s, err)
+	}
+	return sub
+}
+
+func TestCheckFollowsRewrites(t *testing.T) {
+	uc, _ := newTestRelationUseCase(t,
+		"folder:f1#owner@user:alice",
+		"document:d1#parent@folder:f1",
+		"group:eng#member@user:bob",
+		"document:d1#viewer@group:eng#member",
+	)
+
+	tests := []struct {
+		tuple string
+		want  bool
+	}{
+		{"folder:f1#editor@user:alice", true},         // computed userset
+		{"folder:f1#viewer@user:alice", true},         // computed twice
+		{"document:d1#editor@user:alice", true},       // tuple to userset
+		{"document:d1#viewer@user:alice", true},       // computed, then tuple to userset
+		{"document:d1#viewer@user:bob", true},         // userset
+		{"document:d1#editor@user:bob", false},        // viewers are not editors
+		{"document:d1#owner@user:alice", false},       // the owner of the folder does not own the document
+		{"folder:f1#viewer@user:carol", false},        // unrelated subject
+		{"document:d1#viewer@group:eng#member", true}, // the userset itself
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tuple, func(t *testing.T) {
+			ok, token, err := uc.Check(context.Background(), &dto.CheckRelation{Tuple: tuple(t, tt.tuple)})
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if ok != tt.want {
+				t.Fatalf("Check = %v, want %v", ok, tt.want)
+			}
+			if token == "" {
+				t.Fatalf("Check returned no consistency token")
+			}
+		})
+	}
+}
+
+func TestCheckStopsAtCycles(t *testing.T) {
+	uc, _ := newTestRelationUseCase(t,
+		"group:a#member@group:b#member",
+		"group:b#member@group:a#member",
+	)
+
+	ok, _, err := uc.Check(context.Background(), &dto.CheckRelation{Tuple: tuple(t, "group:a#member@user:alice")})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if ok {
+		t.Fatalf("Check = true, want false for a cycle without the subject")
+	}
+}
+
+func TestCheckFailsBeyondMaxDepth(t *testing.T) {
+	uc, _ := newTestRelationUseCase(t,
+		"group:a#member@group:b#member",
+		"group:b#member@group:c#member",
+		"group:c#member@group:d#member",
+		"group:d#member@user:alice",
+	)
+	uc.maxDepth = 2
+
+	_, _, err := uc.Check(context.Background(), &dto.CheckRelation{Tuple: tuple(t, "group:a#member@user:alice")})
+	if !errors.Is(err, model.ErrMaxDepth) {
+		t.Fatalf("Check = %v, want ErrMaxDepth rather than a denial", err)
+	}
+
+	uc.maxDepth = 3
+	ok, _, err := uc.Check(context.Background(), &dto.CheckRelation{Tuple: tuple(t, "group:a#member@user:alice")})
+	if err != nil || !ok {
+		t.Fatalf("Check = %v, %v, want true within the max depth", ok, err)
+	}
+
+	// a subject found on a short path is an answer although another path is cut
+	writeTuples(t, uc, "group:a#member@user:alice")
+	uc.maxDepth = 2
+	ok, _, err = uc.Check(context.Background(), &dto.CheckRelation{Tuple: tuple(t, "group:a#member@user:alice")})
+	if err != nil || !ok {
+		t.Fatalf("Check = %v, %v, want true", ok, err)
+	}
+}
+
+func TestCheckDoesNotDependOnWalkOrder(t *testing.T) {
+	// group:x is reached from group:r in 1 step and through group:a and group:b in 3, alice is 1 step below it
+	long := []string{"group:r#member@group:a#member", "group:a#member@group:b#member", "group:b#member@group:x#member"}
+	short := []string{"group:r#member@group:x#member"}
+
+	for name, tuples := range map[string][]string{
+		"long path first":  append(append([]string{}, long...), short...),
+		"short path first": append(append([]string{}, short...), long...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			uc, _ := newTestRelationUseCase(t, append(tuples, "group:x#member@group:y#member", "group:y#member@user:alice")...)
+			uc.maxDepth = 3
+
+			ok, _, err := uc.Check(context.Background(), &dto.CheckRelation{Tuple: tuple(t, "group:r#member@user:alice")})
+			if err != nil || !ok {
+				t.Fatalf("Check = %v, %v, want true through the short path", ok, err)
+			}
+		})
+	}
+}
+
+func TestCheckAtExactRevision(t *testing.T) {
+	uc, _ := newTestRelationUseCase(t)
+	token := writeTuples(t, uc, "folder:f1#owner@user:alice")
+
+	_, err := uc.WriteTuples(context.Background(), &dto.WriteTuples{Deletes: []model.RelationTuple{tuple(t, "folder:f1#owner@user:alice")}})
+	if err != nil {
+		t.Fatalf("WriteTuples: %v", err)
+	}
+
+	in := &dto.CheckRelation{Tuple: tuple(t, "folder:f1#viewer@user:alice")}
+	if ok, _, err := uc.Check(context.Background(), in); err != nil || ok {
+		t.Fatalf("Check at the latest revision = %v, %v, want false", ok, err)
+	}
+
+	in.Consistency = dto.Consistency{Token: token, Exact: true}
+	if ok, _, err := uc.Check(context.Background(), in); err != nil || !ok {
+		t.Fatalf("Check at the revision of the write = %v, %v, want true", ok, err)
+	}
+
+	in.Consistency = dto.Consistency{Token: "bogus"}
+	if _, _, err := uc.Check(context.Background(), in); !errors.Is(err, model.ErrBadRequest) {
+		t.Fatalf("Check with a bogus token = %v, want ErrBadRequest", err)
+	}
+}
+
+func TestExpandRewrites(t *testing.T) {
+	uc, _ := newTestRelationUseCase(t,
+		"document:d1#owner@user:alice",
+		"document:d1#parent@folder:f1",
+		"folder:f1#owner@user:bob",
+	)
+
+	node, _, err := uc.Expand(context.Background(), &dto.ExpandRelation{Object: subject(t, "document:d1#editor")})
+	if err != nil {
+		t.Fatalf("Expand: %v", err)
+	}
+
+	var children []string
+	for _, c := range node.Children {
+		children = append(children, c.Object.String())
+	}
+	if want := []string{"document:d1#owner", "folder:f1#editor"}; !reflect.DeepEqual(children, want) {
+		t.Fatalf("children = %v, want %v", children, want)
+	}
+	if got := node.Children[0].Subjects; !reflect.DeepEqual(got, []model.Subject{subject(t, "user:alice")}) {
+		t.Fatalf("owners of the document = %v, want [user:alice]", got)
+	}
+	owner := node.Children[1].Children[0]
+	if owner.Object.String() != "folder:f1#owner" || !reflect.DeepEqual(owner.Subjects, []model.Subject{subject(t, "user:bob")}) {
+		t.Fatalf("owners of the folder = %+v, want folder:f1#owner with [user:bob]", owner)
+	}
+}
+
+func TestListObjectsReversesRewrites(t *testing.T) {
+	uc, _ := newTestRelationUseCase(t,
+		"folder:f1#owner@user:alice",
+		"document:d1#parent@folder:f1",
+		"document:d2#parent@folder:f1",
+		"document:d3#owner@user:bob",
+		"group:eng#member@user:alice",
+		"document:d4#viewer@group:eng#member",
+	)
+
+	tests := []struct {
+		namespace, relation string
+		want                []string
+	}{
+		{"document", "viewer", []string{"d1", "d2", "d4"}},
+		{"document", "editor", []string{"d1", "d2"}},
+		{"document", "owner", nil},
+		{"folder", "viewer", []string{"f1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace+"#"+tt.relation, func(t *testing.T) {
+			objects, _, err := uc.ListObjects(context.Background(), &dto.ListObjects{
+				Namespace: tt.namespace,
+				Relation:  tt.relation,
+				Subject:   subject(t, "user:alice"),
+			})
+			if err != nil {
+				t.Fatalf("ListObjects: %v", err)
+			}
+			if !reflect.DeepEqual(objects, tt.want) {
+				t.Fatalf("ListObjects = %v, want %v", objects, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTuplesRejectsUnknownRelations(t *testing.T) {
+	uc, repo := newTestRelationUseCase(t)
+
+	_, err := uc.WriteTuples(context.Background(), &dto.WriteTuples{Writes: []model.RelationTuple{tuple(t, "folder:f1#admin@user:alice")}})
+	if !errors.Is(err, model.ErrBadRequest) {
+		t.Fatalf("WriteTuples = %v, want ErrBadRequest", err)
+	}
+	if repo.head != 0 {
+		t.Fatalf("revision %d written, want none", repo.head)
+	}
+}
@@ -0,0 +1,477 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// auditChainLockId is the advisory lock key that serializes the chain job.
+const auditChainLockId = 0x61756469
+
+// AuditRepo -.
+type AuditRepo struct {
+	*postgres.Postgres
+}
+
+// NewAudit -.
+func NewAudit(pg *postgres.Postgres) *AuditRepo {
+	return &AuditRepo{pg}
+}
+
+// Create writes the event pending in the transaction of the change. It takes no lock, the chain
+// job links pending events into the chain later.
+func (r *AuditRepo) Create(ctx context.Context, in *model.AuditEvent, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "Create").
+		Str("type", in.Type).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - Create - r.GetTxById")
+		return err
+	}
+
+	in.Id = uuid.New()
+
+	query, args, err := r.Builder.
+		Insert(model.AuditEventTableName).
+		Columns("id",
+			"type",
+			"actor_id",
+			"actor_username",
+			"target_id",
+			"target_username",
+			"ip",
+			"user_agent",
+			"outcome",
+			"details",
+			"create_ts").
+		Values(in.Id,
+			in.Type,
+			nullUUID(in.ActorId),
+			nullString(in.ActorUsername),
+			nullUUID(in.TargetId),
+			nullString(in.TargetUsername),
+			nullString(in.Ip),
+			nullString(in.UserAgent),
+			in.Outcome,
+			attributesOrEmpty(in.Details),
+			in.CreateTs).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - Create - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - Create - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// LockChain serializes the chain job across instances until the transaction ends.
+func (r *AuditRepo) LockChain(ctx context.Context, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "LockChain").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - LockChain - r.GetTxById")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLockId)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - LockChain - pg_advisory_xact_lock")
+		return err
+	}
+
+	return nil
+}
+
+// ListPending returns the committed events that are not chained yet, in the order they were written.
+func (r *AuditRepo) ListPending(ctx context.Context, limit int, txId int) ([]model.AuditEvent, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "ListPending").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListPending - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Select(auditEventColumns...).
+		From(model.AuditEventTableName).
+		Where("pending").
+		OrderBy("seq").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListPending - r.Builder")
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListPending - tx.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.AuditEvent
+	for rows.Next() {
+		var item model.AuditEvent
+		if err = scanAuditEvent(rows, &item); err != nil {
+			zLog.Err(err).Msgf("AuditRepo - ListPending - scanAuditEvent")
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListPending - rows.Err")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Chain stores the chain position and the hashes of a pending event.
+func (r *AuditRepo) Chain(ctx context.Context, in *model.AuditEvent, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "Chain").
+		Int64("seq", in.Seq).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - Chain - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.AuditEventTableName).
+		Set("chain_seq", in.Seq).
+		Set("prev_hash", in.PrevHash).
+		Set("hash", in.Hash).
+		Set("pending", false).
+		Where("id = ?", in.Id).
+		Where("pending").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - Chain - r.Builder")
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - Chain - tx.Exec - query: %s", query)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrConflict
+	}
+
+	return nil
+}
+
+var auditEventColumns = []string{
+	"id",
+	"type",
+	"actor_id",
+	"COALESCE(actor_username, '')",
+	"target_id",
+	"COALESCE(target_username, '')",
+	"COALESCE(ip, '')",
+	"COALESCE(user_agent, '')",
+	"outcome",
+	"details",
+	"create_ts",
+	"COALESCE(chain_seq, 0)",
+	"COALESCE(prev_hash, '')",
+	"COALESCE(hash, '')",
+}
+
+func (r *AuditRepo) List(ctx context.Context, filter *model.AuditFilter) ([]model.AuditEvent, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "List").Logger()
+
+	b := r.Builder.
+		Select(auditEventColumns...).
+		From(model.AuditEventTableName).
+		OrderBy("create_ts DESC", "id DESC").
+		Limit(uint64(filter.Limit))
+
+	if filter.Type != "" {
+		b = b.Where("type = ?", filter.Type)
+	}
+	if filter.ActorId != uuid.Nil {
+		b = b.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.TargetId != uuid.Nil {
+		b = b.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.Outcome != "" {
+		b = b.Where("outcome = ?", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		b = b.Where("create_ts >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		b = b.Where("create_ts < ?", filter.To)
+	}
+	if !filter.AfterTs.IsZero() {
+		b = b.Where(sq.Expr("(create_ts, id) < (?, ?)", filter.AfterTs, filter.AfterId))
+	}
+
+	query, args, err := b.ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - List - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - List - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.AuditEvent
+	for rows.Next() {
+		var item model.AuditEvent
+		if err = scanAuditEvent(rows, &item); err != nil {
+			zLog.Err(err).Msgf("AuditRepo - List - scanAuditEvent")
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("AuditRepo - List - rows.Err")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// ListChain returns events in chain order starting after the given sequence number. Events logged
+// before the chain existed and pending events are not part of it.
+func (r *AuditRepo) ListChain(ctx context.Context, afterSeq int64, limit int) ([]model.AuditEvent, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "ListChain").
+		Int64("afterSeq", afterSeq).Logger()
+
+	query, args, err := r.Builder.
+		Select(auditEventColumns...).
+		From(model.AuditEventTableName).
+		Where("chain_seq > ?", afterSeq).
+		OrderBy("chain_seq").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListChain - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListChain - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.AuditEvent
+	for rows.Next() {
+		var item model.AuditEvent
+		if err = scanAuditEvent(rows, &item); err != nil {
+			zLog.Err(err).Msgf("AuditRepo - ListChain - scanAuditEvent")
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListChain - rows.Err")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetHead returns the last event of the chain, nil if the log is empty.
+func (r *AuditRepo) GetHead(ctx context.Context) (*model.AuditEvent, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "GetHead").Logger()
+
+	query, args, err := r.Builder.
+		Select(auditEventColumns...).
+		From(model.AuditEventTableName).
+		Where("chain_seq IS NOT NULL").
+		OrderBy("chain_seq DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - GetHead - r.Builder")
+		return nil, err
+	}
+
+	var item model.AuditEvent
+	err = scanAuditEvent(r.Pool.QueryRow(ctx, query, args...), &item)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - GetHead - r.Pool.QueryRow - query: %s", query)
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (r *AuditRepo) CreateCheckpoint(ctx context.Context, in *model.AuditCheckpoint) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "CreateCheckpoint").
+		Int64("seq", in.Seq).Logger()
+
+	query, args, err := r.Builder.
+		Insert(model.AuditCheckpointTableName).
+		Columns("seq", "hash", "signature", "create_ts").
+		Values(in.Seq, in.Hash, in.Signature, in.CreateTs).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - CreateCheckpoint - r.Builder")
+		return err
+	}
+
+	err = r.Pool.QueryRow(ctx, query, args...).Scan(&in.Id)
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - CreateCheckpoint - r.Pool.QueryRow - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// GetLastCheckpoint returns the most recent checkpoint, nil if none was made yet.
+func (r *AuditRepo) GetLastCheckpoint(ctx context.Context) (*model.AuditCheckpoint, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "GetLastCheckpoint").Logger()
+
+	items, err := r.listCheckpoints(ctx, r.Builder.
+		Select("id", "seq", "hash", "signature", "create_ts").
+		From(model.AuditCheckpointTableName).
+		OrderBy("seq DESC", "create_ts DESC").
+		Limit(1))
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - GetLastCheckpoint - r.listCheckpoints")
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	return &items[0], nil
+}
+
+// ListCheckpoints returns all checkpoints in chain order.
+func (r *AuditRepo) ListCheckpoints(ctx context.Context) ([]model.AuditCheckpoint, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.AuditRepo").
+		Str("method", "ListCheckpoints").Logger()
+
+	items, err := r.listCheckpoints(ctx, r.Builder.
+		Select("id", "seq", "hash", "signature", "create_ts").
+		From(model.AuditCheckpointTableName).
+		OrderBy("seq", "create_ts"))
+	if err != nil {
+		zLog.Err(err).Msgf("AuditRepo - ListCheckpoints - r.listCheckpoints")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (r *AuditRepo) listCheckpoints(ctx context.Context, b sq.SelectBuilder) ([]model.AuditCheckpoint, error) {
+
+	query, args, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.AuditCheckpoint
+	for rows.Next() {
+		var item model.AuditCheckpoint
+		if err = rows.Scan(&item.Id, &item.Seq, &item.Hash, &item.Signature, &item.CreateTs); err != nil {
+			return nil, err
+		}
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func scanAuditEvent(row pgx.Row, item *model.AuditEvent) (err error) {
+	// id, type, actor_id, actor_username, target_id, target_username, ip, user_agent, outcome, details, create_ts,
+	// chain_seq, prev_hash, hash
+
+	var actorId, targetId *uuid.UUID
+	err = row.Scan(&item.Id, &item.Type, &actorId, &item.ActorUsername, &targetId, &item.TargetUsername,
+		&item.Ip, &item.UserAgent, &item.Outcome, &item.Details, &item.CreateTs, &item.Seq, &item.PrevHash, &item.Hash)
+	if err == nil {
+		if actorId != nil {
+			item.ActorId = *actorId
+		}
+		if targetId != nil {
+			item.TargetId = *targetId
+		}
+		item.CreateTs = item.CreateTs.In(time.UTC)
+	}
+	return
+}
+
+func nullUUID(id uuid.UUID) interface{} {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// auditMigrations build the audit tables and their triggers.
+var auditMigrations = []string{"006_audit.sql", "007_audit_chain.sql", "013_audit_chain_job.sql"}
+
+// newTestAuditDB applies the audit migrations in a schema of its own on the database of
+// TEST_DATABASE_URL, the test is skipped when it is not set.
+func newTestAuditDB(t *testing.T) *pgx.Conn {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	schema := fmt.Sprintf("test_audit_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		_, _ = conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		_ = conn.Close(ctx)
+	})
+	if _, err = conn.Exec(ctx, "CREATE SCHEMA "+schema+"; SET search_path TO "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+
+	for _, name := range auditMigrations {
+		sql, err := os.ReadFile(filepath.Join("..", "..", "..", "migrations", name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if _, err = conn.Exec(ctx, string(sql)); err != nil {
+			t.Fatalf("apply %s: %v", name, err)
+		}
+	}
+
+	return conn
+}
+
+func insertTestAuditEvent(t *testing.T, conn *pgx.Conn) uuid.UUID {
+	t.Helper()
+
+	id := uuid.New()
+	_, err := conn.Exec(context.Background(),
+		"INSERT INTO tbl_audit_event (id, type, outcome, create_ts) VALUES ($1, 'user.create', 'success', now())", id)
+	if err != nil {
+		t.Fatalf("insert event: %v", err)
+	}
+
+	return id
+}
+
+func chainTestAuditEvent(conn *pgx.Conn, id uuid.UUID, seq int64, prevHash, hash string) error {
+	_, err := conn.Exec(context.Background(),
+		"UPDATE tbl_audit_event SET chain_seq = $2, prev_hash = $3, hash = $4, pending = FALSE WHERE id = $1",
+		id, seq, prevHash, hash)
+	return err
+}
+
+func TestAuditEventTrigger(t *testing.T) {
+	conn := newTestAuditDB(t)
+	ctx := context.Background()
+
+	first := insertTestAuditEvent(t, conn)
+	second := insertTestAuditEvent(t, conn)
+
+	if _, err := conn.Exec(ctx, "UPDATE tbl_audit_event SET type = 'user.delete' WHERE id = $1", first); err == nil {
+		t.Fatalf("content of a pending event was updated")
+	}
+	if _, err := conn.Exec(ctx,
+		"UPDATE tbl_audit_event SET type = 'user.delete', chain_seq = 1, prev_hash = '', hash = 'h1', pending = FALSE WHERE id = $1",
+		first); err == nil {
+		t.Fatalf("content of an event was updated while chaining it")
+	}
+	if err := chainTestAuditEvent(conn, first, 2, "", "h1"); err == nil {
+		t.Fatalf("event was chained past the head")
+	}
+	if err := chainTestAuditEvent(conn, first, 1, "", "h1"); err != nil {
+		t.Fatalf("chain first event: %v", err)
+	}
+	if err := chainTestAuditEvent(conn, first, 1, "", "h1bis"); err == nil {
+		t.Fatalf("chained event was hashed again")
+	}
+	if _, err := conn.Exec(ctx, "UPDATE tbl_audit_event SET pending = TRUE WHERE id = $1", first); err == nil {
+		t.Fatalf("chained event was made pending again")
+	}
+	if err := chainTestAuditEvent(conn, second, 2, "other", "h2"); err == nil {
+		t.Fatalf("event was chained to a hash other than the head")
+	}
+	if err := chainTestAuditEvent(conn, second, 2, "h1", "h2"); err != nil {
+		t.Fatalf("chain second event: %v", err)
+	}
+
+	for _, id := range []uuid.UUID{first, second, insertTestAuditEvent(t, conn)} {
+		if _, err := conn.Exec(ctx, "DELETE FROM tbl_audit_event WHERE id = $1", id); err == nil {
+			t.Fatalf("event %s was deleted", id)
+		}
+	}
+}
+
+func TestAuditCheckpointTrigger(t *testing.T) {
+	conn := newTestAuditDB(t)
+	ctx := context.Background()
+
+	_, err := conn.Exec(ctx,
+		"INSERT INTO tbl_audit_checkpoint (seq, hash, signature, create_ts) VALUES (1, 'h1', 's1', now())")
+	if err != nil {
+		t.Fatalf("insert checkpoint: %v", err)
+	}
+
+	if _, err = conn.Exec(ctx, "UPDATE tbl_audit_checkpoint SET hash = 'h2'"); err == nil {
+		t.Fatalf("checkpoint was updated")
+	}
+	if _, err = conn.Exec(ctx, "DELETE FROM tbl_audit_checkpoint"); err == nil {
+		t.Fatalf("checkpoint was deleted")
+	}
+}
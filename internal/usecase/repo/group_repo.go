@@ -0,0 +1,632 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// groupMembershipLockId is the advisory lock key that serializes changes of nested groups,
+// so two concurrent changes can not close a cycle together.
+const groupMembershipLockId = 0x67727570
+
+var groupColumns = []string{
+	"id",
+	"name",
+	"description",
+	"roles",
+	"create_ts",
+	"update_ts",
+}
+
+// GroupRepo -.
+type GroupRepo struct {
+	*postgres.Postgres
+}
+
+// NewGroup -.
+func NewGroup(pg *postgres.Postgres) *GroupRepo {
+	return &GroupRepo{pg}
+}
+
+func (r *GroupRepo) Create(ctx context.Context, in *model.Group, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "Create").
+		Str("name", in.Name).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Create - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.GroupTableName).
+		Columns("name",
+			"description",
+			"roles",
+			"create_ts",
+			"update_ts").
+		Values(in.Name,
+			in.Description,
+			rolesOrEmpty(in.Roles),
+			in.CreateTs,
+			in.UpdateTs).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Create - r.Builder")
+		return err
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&in.Id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return model.ErrConflict
+		}
+		zLog.Err(err).Msgf("GroupRepo - Create - tx.QueryRow - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+func (r *GroupRepo) GetById(ctx context.Context, id uuid.UUID) (*model.Group, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "GetById").
+		Str("id", id.String()).Logger()
+
+	query, args, err := r.Builder.
+		Select(groupColumns...).
+		From(model.GroupTableName).
+		Where("id = ?", id).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GetById - r.Builder")
+		return nil, err
+	}
+
+	var item model.Group
+	err = scanGroup(r.Pool.QueryRow(ctx, query, args...), &item)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		zLog.Err(err).Msgf("GroupRepo - GetById - r.Pool.QueryRow - query: %s", query)
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (r *GroupRepo) List(ctx context.Context) ([]model.Group, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "List").Logger()
+
+	query, args, err := r.Builder.
+		Select(groupColumns...).
+		From(model.GroupTableName).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - List - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - List - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items, err := scanGroups(rows)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - List - scanGroups")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (r *GroupRepo) Update(ctx context.Context, in *model.Group, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "Update").
+		Str("id", in.Id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Update - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.GroupTableName).
+		Set("description", in.Description).
+		Set("roles", rolesOrEmpty(in.Roles)).
+		Set("update_ts", in.UpdateTs).
+		Where("id = ?", in.Id).
+		Suffix("RETURNING name, create_ts").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Update - r.Builder")
+		return err
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&in.Name, &in.CreateTs)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.ErrNotFound
+		}
+		zLog.Err(err).Msgf("GroupRepo - Update - tx.QueryRow - query: %s", query)
+		return err
+	}
+	in.CreateTs = in.CreateTs.In(time.UTC)
+
+	return nil
+}
+
+func (r *GroupRepo) Delete(ctx context.Context, id uuid.UUID, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "Delete").
+		Str("id", id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Delete - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.GroupTableName).
+		Where("id = ?", id).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Delete - r.Builder")
+		return err
+	}
+
+	cmdTag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Delete - tx.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+// AddUser makes the user a direct member of the group, adding an existing member changes nothing.
+func (r *GroupRepo) AddUser(ctx context.Context, groupId, userId uuid.UUID, ts time.Time, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "AddUser").
+		Str("group_id", groupId.String()).
+		Str("user_id", userId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - AddUser - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.GroupUserTableName).
+		Columns("group_id", "user_id", "create_ts").
+		Values(groupId, userId, ts).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - AddUser - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - AddUser - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+func (r *GroupRepo) RemoveUser(ctx context.Context, groupId, userId uuid.UUID, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "RemoveUser").
+		Str("group_id", groupId.String()).
+		Str("user_id", userId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - RemoveUser - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.GroupUserTableName).
+		Where("group_id = ?", groupId).
+		Where("user_id = ?", userId).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - RemoveUser - r.Builder")
+		return err
+	}
+
+	cmdTag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - RemoveUser - tx.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+// LockMemberships holds the nested groups lock until the end of the transaction.
+func (r *GroupRepo) LockMemberships(ctx context.Context, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "LockMemberships").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - LockMemberships - r.GetTxById")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", groupMembershipLockId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - LockMemberships - pg_advisory_xact_lock")
+		return err
+	}
+
+	return nil
+}
+
+// Contains reports whether the member group is nested in the group, at any depth.
+func (r *GroupRepo) Contains(ctx context.Context, groupId, memberId uuid.UUID, txId int) (bool, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "Contains").
+		Str("group_id", groupId.String()).
+		Str("member_id", memberId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Contains - r.GetTxById")
+		return false, err
+	}
+
+	// UNION drops rows already seen, so the walk ends even on a cyclic graph
+	query, args, err := r.Builder.
+		Select().
+		Column("EXISTS (SELECT 1 FROM nested WHERE member_id = ?)", memberId).
+		Prefix("WITH RECURSIVE nested AS (SELECT member_id FROM "+model.GroupMemberTableName+" WHERE group_id = ?"+
+			" UNION SELECT m.member_id FROM "+model.GroupMemberTableName+" m JOIN nested n ON m.group_id = n.member_id)", groupId).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Contains - r.Builder")
+		return false, err
+	}
+
+	var found bool
+	err = tx.QueryRow(ctx, query, args...).Scan(&found)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - Contains - tx.QueryRow - query: %s", query)
+		return false, err
+	}
+
+	return found, nil
+}
+
+// AddMember nests the member group in the group, adding an existing member changes nothing.
+func (r *GroupRepo) AddMember(ctx context.Context, groupId, memberId uuid.UUID, ts time.Time, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "AddMember").
+		Str("group_id", groupId.String()).
+		Str("member_id", memberId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - AddMember - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.GroupMemberTableName).
+		Columns("group_id", "member_id", "create_ts").
+		Values(groupId, memberId, ts).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - AddMember - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - AddMember - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+func (r *GroupRepo) RemoveMember(ctx context.Context, groupId, memberId uuid.UUID, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "RemoveMember").
+		Str("group_id", groupId.String()).
+		Str("member_id", memberId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - RemoveMember - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.GroupMemberTableName).
+		Where("group_id = ?", groupId).
+		Where("member_id = ?", memberId).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - RemoveMember - r.Builder")
+		return err
+	}
+
+	cmdTag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - RemoveMember - tx.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+// ListMembers returns the direct members of the group, deleted users are left out.
+func (r *GroupRepo) ListMembers(ctx context.Context, groupId uuid.UUID) (*model.GroupMembers, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "ListMembers").
+		Str("group_id", groupId.String()).Logger()
+
+	query, args, err := r.Builder.
+		Select(userColumns...).
+		From(model.UserTableName).
+		Where("id IN (SELECT user_id FROM "+model.GroupUserTableName+" WHERE group_id = ?)", groupId).
+		Where("state != ?", model.Deleted).
+		OrderBy("username").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - ListMembers - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - ListMembers - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := &model.GroupMembers{}
+	for rows.Next() {
+		var item model.User
+		if err = scanUser(rows, &item); err != nil {
+			zLog.Err(err).Msgf("GroupRepo - ListMembers - scanUser")
+			return nil, err
+		}
+		members.Users = append(members.Users, item)
+	}
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("GroupRepo - ListMembers - rows.Err")
+		return nil, err
+	}
+
+	query, args, err = r.Builder.
+		Select(groupColumns...).
+		From(model.GroupTableName).
+		Where("id IN (SELECT member_id FROM "+model.GroupMemberTableName+" WHERE group_id = ?)", groupId).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - ListMembers - r.Builder")
+		return nil, err
+	}
+
+	groupRows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - ListMembers - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer groupRows.Close()
+
+	members.Groups, err = scanGroups(groupRows)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - ListMembers - scanGroups")
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// GrantOf returns the groups of the user, directly or through nested groups, and the roles they grant.
+func (r *GroupRepo) GrantOf(ctx context.Context, userId uuid.UUID) (*model.GroupGrant, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "GrantOf").
+		Str("user_id", userId.String()).Logger()
+
+	// the users of a member group are members of the group containing it, so walk up from the direct groups
+	query, args, err := r.Builder.
+		Select("name", "roles").
+		Prefix("WITH RECURSIVE up AS (SELECT group_id FROM "+model.GroupUserTableName+" WHERE user_id = ?"+
+			" UNION SELECT m.group_id FROM "+model.GroupMemberTableName+" m JOIN up ON m.member_id = up.group_id)", userId).
+		From(model.GroupTableName).
+		Where("id IN (SELECT group_id FROM up)").
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GrantOf - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GrantOf - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	grant := &model.GroupGrant{Groups: []string{}, Roles: []string{}}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var roles []string
+		if err = rows.Scan(&name, &roles); err != nil {
+			zLog.Err(err).Msgf("GroupRepo - GrantOf - rows.Scan")
+			return nil, err
+		}
+		grant.Groups = append(grant.Groups, name)
+		for _, role := range roles {
+			if !seen[role] {
+				seen[role] = true
+				grant.Roles = append(grant.Roles, role)
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GrantOf - rows.Err")
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// GrantsOf returns the grants of the users like GrantOf, with one query. Every user has a grant,
+// an empty one when it belongs to no group.
+func (r *GroupRepo) GrantsOf(ctx context.Context, userIds []uuid.UUID) (map[uuid.UUID]*model.GroupGrant, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.GroupRepo").
+		Str("method", "GrantsOf").
+		Int("user_ids", len(userIds)).Logger()
+
+	grants := make(map[uuid.UUID]*model.GroupGrant, len(userIds))
+	for _, id := range userIds {
+		grants[id] = &model.GroupGrant{Groups: []string{}, Roles: []string{}}
+	}
+
+	if len(userIds) == 0 {
+		return grants, nil
+	}
+
+	// like GrantOf, walking up from the direct groups of every user and keeping whose walk it is
+	query, args, err := r.Builder.
+		Select("up.user_id", "g.name", "g.roles").
+		Prefix("WITH RECURSIVE up AS (SELECT user_id, group_id FROM "+model.GroupUserTableName+" WHERE user_id = ANY(?)"+
+			" UNION SELECT up.user_id, m.group_id FROM "+model.GroupMemberTableName+" m JOIN up ON m.member_id = up.group_id)", userIds).
+		From(model.GroupTableName+" g").
+		Join("up ON g.id = up.group_id").
+		OrderBy("up.user_id", "g.name").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GrantsOf - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GrantsOf - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[uuid.UUID]map[string]bool, len(userIds))
+	for rows.Next() {
+		var userId uuid.UUID
+		var name string
+		var roles []string
+		if err = rows.Scan(&userId, &name, &roles); err != nil {
+			zLog.Err(err).Msgf("GroupRepo - GrantsOf - rows.Scan")
+			return nil, err
+		}
+
+		grant, ok := grants[userId]
+		if !ok {
+			continue
+		}
+		if seen[userId] == nil {
+			seen[userId] = make(map[string]bool)
+		}
+		grant.Groups = append(grant.Groups, name)
+		for _, role := range roles {
+			if !seen[userId][role] {
+				seen[userId][role] = true
+				grant.Roles = append(grant.Roles, role)
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("GroupRepo - GrantsOf - rows.Err")
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+func scanGroup(row pgx.Row, item *model.Group) error {
+	err := row.Scan(&item.Id, &item.Name, &item.Description, &item.Roles, &item.CreateTs, &item.UpdateTs)
+	if err == nil {
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		item.UpdateTs = item.UpdateTs.In(time.UTC)
+	}
+	return err
+}
+
+func scanGroups(rows pgx.Rows) ([]model.Group, error) {
+	var items []model.Group
+	for rows.Next() {
+		var item model.Group
+		if err := scanGroup(rows, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
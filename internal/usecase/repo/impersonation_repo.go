@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// ImpersonationRepo -.
+type ImpersonationRepo struct {
+	*postgres.Postgres
+}
+
+// NewImpersonation -.
+func NewImpersonation(pg *postgres.Postgres) *ImpersonationRepo {
+	return &ImpersonationRepo{pg}
+}
+
+func (r *ImpersonationRepo) Create(ctx context.Context, in *model.Impersonation, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.ImpersonationRepo").
+		Str("method", "Create").
+		Str("actor_id", in.ActorId.String()).
+		Str("target_id", in.TargetId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("ImpersonationRepo - Create - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.ImpersonationTableName).
+		Columns("actor_id",
+			"target_id",
+			"reason",
+			"expire_ts",
+			"create_ts").
+		Values(in.ActorId,
+			in.TargetId,
+			in.Reason,
+			in.ExpireTs,
+			in.CreateTs).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("ImpersonationRepo - Create - r.Builder")
+		return err
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&in.Id)
+	if err != nil {
+		zLog.Err(err).Msgf("ImpersonationRepo - Create - tx.QueryRow - query: %s", query)
+		return err
+	}
+
+	return nil
+}
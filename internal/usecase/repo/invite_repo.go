@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// InviteRepo -.
+type InviteRepo struct {
+	*postgres.Postgres
+}
+
+// NewInvite -.
+func NewInvite(pg *postgres.Postgres) *InviteRepo {
+	return &InviteRepo{pg}
+}
+
+func (r *InviteRepo) Create(ctx context.Context, in *model.Invite, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.InviteRepo").
+		Str("method", "Create").
+		Str("user_id", in.UserId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - Create - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.InviteTableName).
+		Columns("user_id",
+			"token_hash",
+			"expire_ts",
+			"create_ts").
+		Values(in.UserId,
+			in.TokenHash,
+			in.ExpireTs,
+			in.CreateTs).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - Create - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - Create - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+func (r *InviteRepo) GetByTokenHash(ctx context.Context, tokenHash string) (*model.Invite, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.InviteRepo").
+		Str("method", "GetByTokenHash").Logger()
+
+	query, args, err := r.Builder.
+		Select("user_id",
+			"token_hash",
+			"expire_ts",
+			"create_ts").
+		From(model.InviteTableName).
+		Where("token_hash = ?", tokenHash).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - GetByTokenHash - r.Builder")
+		return nil, err
+	}
+
+	var data model.Invite
+	err = scanInvite(r.Pool.QueryRow(ctx, query, args...), &data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			zLog.Debug().Msg("token hash: no results")
+			return nil, nil
+		}
+		zLog.Err(err).Msgf("InviteRepo - GetByTokenHash - r.Pool.QueryRow - query: %s", query)
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (r *InviteRepo) Delete(ctx context.Context, userId uuid.UUID, txId int) error {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.InviteRepo").
+		Str("method", "Delete").
+		Str("user_id", userId.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - Delete - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.InviteTableName).
+		Where("user_id = ?", userId).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - Delete - r.Builder")
+		return err
+	}
+
+	cmdTag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - Delete - tx.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		zLog.Error().Msgf("InviteRepo - Delete - tx.Exec - no rows affected - query: %s", query)
+		return model.ErrNoRowsAffected
+	}
+
+	return nil
+}
+
+// PurgeExpired permanently removes invited users whose invite expired before ts.
+func (r *InviteRepo) PurgeExpired(ctx context.Context, ts time.Time, txId int) ([]uuid.UUID, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.InviteRepo").
+		Str("method", "PurgeExpired").
+		Time("ts", ts).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - PurgeExpired - r.GetTxById")
+		return nil, err
+	}
+
+	expired := r.Builder.
+		Select("user_id").
+		From(model.InviteTableName).
+		Where("expire_ts < ?", ts)
+
+	query, args, err := r.Builder.
+		Delete(model.UserTableName).
+		Where("state = ?", model.Invited).
+		Where(expired.Prefix("id IN (").Suffix(")")).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - PurgeExpired - r.Builder")
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - PurgeExpired - tx.Query - query: %s", query)
+		return nil, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		zLog.Err(err).Msgf("InviteRepo - PurgeExpired - pgx.CollectRows")
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func scanInvite(row pgx.Row, item *model.Invite) (err error) {
+	// user_id, token_hash, expire_ts, create_ts
+
+	err = row.Scan(&item.UserId, &item.TokenHash, &item.ExpireTs, &item.CreateTs)
+	if err == nil {
+		item.ExpireTs = item.ExpireTs.In(time.UTC)
+		item.CreateTs = item.CreateTs.In(time.UTC)
+	}
+	return
+}
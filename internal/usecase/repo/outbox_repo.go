@@ -0,0 +1,458 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// outboxPosLockId is the advisory lock key that lets one transaction at a time number outbox events.
+const outboxPosLockId = 0x6f757462
+
+// OutboxRepo -.
+type OutboxRepo struct {
+	*postgres.Postgres
+}
+
+// NewOutbox -.
+func NewOutbox(pg *postgres.Postgres) *OutboxRepo {
+	return &OutboxRepo{pg}
+}
+
+func (r *OutboxRepo) Create(ctx context.Context, in *model.OutboxEvent, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "Create").
+		Str("type", in.Type).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - Create - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.OutboxTableName).
+		Columns("id",
+			"type",
+			"aggregate_id",
+			"payload",
+			"create_ts").
+		Values(in.Id,
+			in.Type,
+			in.AggregateId,
+			attributesOrEmpty(in.Payload),
+			in.CreateTs).
+		Suffix("RETURNING seq").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - Create - r.Builder")
+		return err
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&in.Seq)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - Create - tx.QueryRow - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// ClaimPending returns the oldest events that are neither published, dead nor claimed by another
+// relay, and claims them until the given time. Rows locked by another relay are skipped.
+func (r *OutboxRepo) ClaimPending(ctx context.Context, limit int, now, until time.Time, txId int) ([]model.OutboxEvent, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "ClaimPending").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ClaimPending - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Select("seq",
+			"id",
+			"type",
+			"aggregate_id",
+			"payload",
+			"create_ts",
+			"attempts").
+		From(model.OutboxTableName).
+		Where("published_ts IS NULL").
+		Where("dead_ts IS NULL").
+		Where(sq.Or{sq.Eq{"claimed_until": nil}, sq.Lt{"claimed_until": now}}).
+		OrderBy("seq").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ClaimPending - r.Builder")
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ClaimPending - tx.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.OutboxEvent
+	for rows.Next() {
+		var item model.OutboxEvent
+		err = rows.Scan(&item.Seq, &item.Id, &item.Type, &item.AggregateId, &item.Payload, &item.CreateTs, &item.Attempts)
+		if err != nil {
+			zLog.Err(err).Msgf("OutboxRepo - ClaimPending - rows.Scan")
+			return nil, err
+		}
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ClaimPending - rows.Err")
+		return nil, err
+	}
+	rows.Close()
+
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	seqs := make([]int64, len(items))
+	for i := range items {
+		seqs[i] = items[i].Seq
+	}
+
+	query, args, err = r.Builder.
+		Update(model.OutboxTableName).
+		Set("claimed_until", until).
+		Where("seq = ANY(?)", seqs).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ClaimPending - r.Builder")
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ClaimPending - tx.Exec - query: %s", query)
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// AssignPositions numbers up to limit events that have no position yet in seq order and returns how
+// many it numbered. Seqs are taken by concurrent writers and may commit out of order, positions are
+// only given to committed events by one transaction at a time, so a reader that saw position n never
+// sees a smaller one appear later. It numbers nothing while another transaction holds the lock.
+func (r *OutboxRepo) AssignPositions(ctx context.Context, limit int, txId int) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "AssignPositions").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - AssignPositions - r.GetTxById")
+		return 0, err
+	}
+
+	var locked bool
+	err = tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxPosLockId).Scan(&locked)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - AssignPositions - pg_try_advisory_xact_lock")
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	unnumbered := r.Builder.
+		Select("seq").
+		From(model.OutboxTableName).
+		Where("pos IS NULL").
+		OrderBy("seq").
+		Limit(uint64(limit))
+
+	// nextval runs after the sort, so positions follow seqs
+	numbered := r.Builder.
+		Select("seq", "nextval('"+model.OutboxPosSequenceName+"') AS pos").
+		FromSelect(unnumbered, "u").
+		OrderBy("seq")
+
+	query, args, err := r.Builder.
+		Update(model.OutboxTableName).
+		Set("pos", sq.Expr("n.pos")).
+		FromSelect(numbered, "n").
+		Where(model.OutboxTableName + ".seq = n.seq").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - AssignPositions - r.Builder")
+		return 0, err
+	}
+
+	cmdTag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - AssignPositions - tx.Exec - query: %s", query)
+		return 0, err
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
+
+// ListAfter returns numbered events after the given position in order, published or not.
+// Empty filters match every event.
+func (r *OutboxRepo) ListAfter(ctx context.Context, afterPos int64, types []string, aggregateId uuid.UUID, limit int) ([]model.OutboxEvent, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "ListAfter").
+		Int64("afterPos", afterPos).Logger()
+
+	b := r.Builder.
+		Select("seq",
+			"pos",
+			"id",
+			"type",
+			"aggregate_id",
+			"payload",
+			"create_ts",
+			"attempts").
+		From(model.OutboxTableName).
+		Where("pos > ?", afterPos).
+		OrderBy("pos").
+		Limit(uint64(limit))
+
+	if len(types) > 0 {
+		b = b.Where("type = ANY(?)", types)
+	}
+	if aggregateId != uuid.Nil {
+		b = b.Where("aggregate_id = ?", aggregateId)
+	}
+
+	query, args, err := b.ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ListAfter - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ListAfter - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.OutboxEvent
+	for rows.Next() {
+		var item model.OutboxEvent
+		err = rows.Scan(&item.Seq, &item.Pos, &item.Id, &item.Type, &item.AggregateId, &item.Payload, &item.CreateTs, &item.Attempts)
+		if err != nil {
+			zLog.Err(err).Msgf("OutboxRepo - ListAfter - rows.Scan")
+			return nil, err
+		}
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - ListAfter - rows.Err")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// PosRange returns the oldest and newest retained position, both 0 when no event is numbered.
+func (r *OutboxRepo) PosRange(ctx context.Context) (oldest int64, newest int64, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "PosRange").Logger()
+
+	query, args, err := r.Builder.
+		Select("COALESCE(MIN(pos), 0)", "COALESCE(MAX(pos), 0)").
+		From(model.OutboxTableName).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - PosRange - r.Builder")
+		return 0, 0, err
+	}
+
+	err = r.Pool.QueryRow(ctx, query, args...).Scan(&oldest, &newest)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - PosRange - r.Pool.QueryRow - query: %s", query)
+		return 0, 0, err
+	}
+
+	return oldest, newest, nil
+}
+
+// CountPending returns the number of events not published yet, dead events are not counted.
+func (r *OutboxRepo) CountPending(ctx context.Context) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "CountPending").Logger()
+
+	query, args, err := r.Builder.
+		Select("COUNT(*)").
+		From(model.OutboxTableName).
+		Where("published_ts IS NULL").
+		Where("dead_ts IS NULL").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - CountPending - r.Builder")
+		return 0, err
+	}
+
+	var n int64
+	err = r.Pool.QueryRow(ctx, query, args...).Scan(&n)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - CountPending - r.Pool.QueryRow - query: %s", query)
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (r *OutboxRepo) MarkPublished(ctx context.Context, seqs []int64, ts time.Time, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "MarkPublished").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - MarkPublished - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.OutboxTableName).
+		Set("published_ts", ts).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("last_error", nil).
+		Set("claimed_until", nil).
+		Where("seq = ANY(?)", seqs).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - MarkPublished - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - MarkPublished - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// MarkFailed counts a failed attempt and releases the claim. A non-nil deadTs dead-letters the event.
+func (r *OutboxRepo) MarkFailed(ctx context.Context, seq int64, reason string, deadTs *time.Time, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "MarkFailed").
+		Int64("seq", seq).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - MarkFailed - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.OutboxTableName).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("last_error", reason).
+		Set("claimed_until", nil).
+		Set("dead_ts", deadTs).
+		Where("seq = ?", seq).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - MarkFailed - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - MarkFailed - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// Release gives up the claim on events that were not tried, so the next run picks them up.
+func (r *OutboxRepo) Release(ctx context.Context, seqs []int64, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "Release").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - Release - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.OutboxTableName).
+		Set("claimed_until", nil).
+		Where("seq = ANY(?)", seqs).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - Release - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - Release - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// PurgePublished removes events published before ts.
+func (r *OutboxRepo) PurgePublished(ctx context.Context, ts time.Time) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.OutboxRepo").
+		Str("method", "PurgePublished").
+		Time("ts", ts).Logger()
+
+	query, args, err := r.Builder.
+		Delete(model.OutboxTableName).
+		Where("published_ts < ?", ts).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - PurgePublished - r.Builder")
+		return 0, err
+	}
+
+	cmdTag, err := r.Pool.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("OutboxRepo - PurgePublished - r.Pool.Exec - query: %s", query)
+		return 0, err
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+var policyColumns = []string{
+	"id",
+	"realm",
+	"name",
+	"description",
+	"effect",
+	"actions",
+	"condition",
+	"create_ts",
+	"update_ts",
+}
+
+// PolicyRepo -.
+type PolicyRepo struct {
+	*postgres.Postgres
+}
+
+// NewPolicy -.
+func NewPolicy(pg *postgres.Postgres) *PolicyRepo {
+	return &PolicyRepo{pg}
+}
+
+// Upsert creates the policy or replaces the policy of the same name in the realm.
+// Id and CreateTs are set to those of the stored policy.
+func (r *PolicyRepo) Upsert(ctx context.Context, in *model.Policy, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.PolicyRepo").
+		Str("method", "Upsert").
+		Str("realm", in.Realm).
+		Str("name", in.Name).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - Upsert - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.PolicyTableName).
+		Columns("realm",
+			"name",
+			"description",
+			"effect",
+			"actions",
+			"condition",
+			"create_ts",
+			"update_ts").
+		Values(in.Realm,
+			in.Name,
+			in.Description,
+			in.Effect,
+			in.Actions,
+			in.Condition,
+			in.CreateTs,
+			in.UpdateTs).
+		Suffix("ON CONFLICT (realm, name) DO UPDATE SET " +
+			"description = EXCLUDED.description, " +
+			"effect = EXCLUDED.effect, " +
+			"actions = EXCLUDED.actions, " +
+			"condition = EXCLUDED.condition, " +
+			"update_ts = EXCLUDED.update_ts " +
+			"RETURNING id, create_ts").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - Upsert - r.Builder")
+		return err
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&in.Id, &in.CreateTs)
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - Upsert - tx.QueryRow - query: %s", query)
+		return err
+	}
+	in.CreateTs = in.CreateTs.In(time.UTC)
+
+	return nil
+}
+
+// ListByRealm returns the policies of the realm ordered by name.
+func (r *PolicyRepo) ListByRealm(ctx context.Context, realm string) ([]model.Policy, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.PolicyRepo").
+		Str("method", "ListByRealm").
+		Str("realm", realm).Logger()
+
+	query, args, err := r.Builder.
+		Select(policyColumns...).
+		From(model.PolicyTableName).
+		Where("realm = ?", realm).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - ListByRealm - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - ListByRealm - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items, err := scanPolicies(rows)
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - ListByRealm - scanPolicies")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Delete removes the named policy of the realm and returns it.
+func (r *PolicyRepo) Delete(ctx context.Context, realm, name string, txId int) (*model.Policy, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.PolicyRepo").
+		Str("method", "Delete").
+		Str("realm", realm).
+		Str("name", name).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - Delete - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.PolicyTableName).
+		Where("realm = ? AND name = ?", realm, name).
+		Suffix("RETURNING " + strings.Join(policyColumns, ", ")).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("PolicyRepo - Delete - r.Builder")
+		return nil, err
+	}
+
+	var item model.Policy
+	err = scanPolicy(tx.QueryRow(ctx, query, args...), &item)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, model.ErrNotFound
+		}
+		zLog.Err(err).Msgf("PolicyRepo - Delete - tx.QueryRow - query: %s", query)
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func scanPolicy(row pgx.Row, item *model.Policy) error {
+	err := row.Scan(&item.Id, &item.Realm, &item.Name, &item.Description, &item.Effect,
+		&item.Actions, &item.Condition, &item.CreateTs, &item.UpdateTs)
+	if err == nil {
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		item.UpdateTs = item.UpdateTs.In(time.UTC)
+	}
+	return err
+}
+
+func scanPolicies(rows pgx.Rows) ([]model.Policy, error) {
+	var items []model.Policy
+	for rows.Next() {
+		var item model.Policy
+		if err := scanPolicy(rows, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,293 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// relationRevisionLockId is the advisory lock key that serializes relation writes,
+// so revisions commit in the order they are numbered.
+const relationRevisionLockId = 0x72656c61
+
+// RelationRepo stores relation tuples. Tuples are never updated in place: a write creates a tuple
+// at a revision and a delete ends it at a later one, so every revision can be read as a snapshot.
+type RelationRepo struct {
+	*postgres.Postgres
+}
+
+// NewRelation -.
+func NewRelation(pg *postgres.Postgres) *RelationRepo {
+	return &RelationRepo{pg}
+}
+
+// NewRevision takes the write lock and allocates the revision of the transaction.
+func (r *RelationRepo) NewRevision(ctx context.Context, ts time.Time, txId int) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.RelationRepo").
+		Str("method", "NewRevision").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - NewRevision - r.GetTxById")
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", relationRevisionLockId)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - NewRevision - pg_advisory_xact_lock")
+		return 0, err
+	}
+
+	query, args, err := r.Builder.
+		Insert(model.RelationRevisionTableName).
+		Columns("create_ts").
+		Values(ts).
+		Suffix("RETURNING revision").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - NewRevision - r.Builder")
+		return 0, err
+	}
+
+	var revision int64
+	err = tx.QueryRow(ctx, query, args...).Scan(&revision)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - NewRevision - tx.QueryRow - query: %s", query)
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+// Head returns the latest committed revision. Writes commit in revision order,
+// so every revision up to the head is complete.
+func (r *RelationRepo) Head(ctx context.Context) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.RelationRepo").
+		Str("method", "Head").Logger()
+
+	query, args, err := r.Builder.
+		Select("COALESCE(MAX(revision), 0)").
+		From(model.RelationRevisionTableName).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Head - r.Builder")
+		return 0, err
+	}
+
+	var revision int64
+	err = r.Pool.QueryRow(ctx, query, args...).Scan(&revision)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Head - r.Pool.QueryRow - query: %s", query)
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+// Write creates the tuples at the revision, tuples that already exist are left alone.
+func (r *RelationRepo) Write(ctx context.Context, tuples []model.RelationTuple, revision int64, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.RelationRepo").
+		Str("method", "Write").
+		Int64("revision", revision).Logger()
+
+	if len(tuples) == 0 {
+		return nil
+	}
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Write - r.GetTxById")
+		return err
+	}
+
+	builder := r.Builder.
+		Insert(model.RelationTupleTableName).
+		Columns("namespace",
+			"object_id",
+			"relation",
+			"subject_namespace",
+			"subject_object_id",
+			"subject_relation",
+			"create_rev")
+	for _, t := range tuples {
+		builder = builder.Values(t.Namespace,
+			t.ObjectId,
+			t.Relation,
+			t.Subject.Namespace,
+			t.Subject.ObjectId,
+			t.Subject.Relation,
+			revision)
+	}
+
+	query, args, err := builder.
+		Suffix("ON CONFLICT (namespace, object_id, relation, subject_namespace, subject_object_id, subject_relation) " +
+			"WHERE delete_rev IS NULL DO NOTHING").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Write - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Write - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// Delete ends the tuples at the revision, deleting a tuple that does not exist changes nothing.
+func (r *RelationRepo) Delete(ctx context.Context, tuples []model.RelationTuple, revision int64, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.RelationRepo").
+		Str("method", "Delete").
+		Int64("revision", revision).Logger()
+
+	if len(tuples) == 0 {
+		return nil
+	}
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Delete - r.GetTxById")
+		return err
+	}
+
+	match := make(sq.Or, 0, len(tuples))
+	for _, t := range tuples {
+		match = append(match, sq.Eq{
+			"namespace":         t.Namespace,
+			"object_id":         t.ObjectId,
+			"relation":          t.Relation,
+			"subject_namespace": t.Subject.Namespace,
+			"subject_object_id": t.Subject.ObjectId,
+			"subject_relation":  t.Subject.Relation,
+		})
+	}
+
+	query, args, err := r.Builder.
+		Update(model.RelationTupleTableName).
+		Set("delete_rev", revision).
+		Where("delete_rev IS NULL").
+		Where(match).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Delete - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Delete - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// Subjects returns the subjects of the tuples of object#relation at the revision.
+func (r *RelationRepo) Subjects(ctx context.Context, object model.Subject, revision int64) ([]model.Subject, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.RelationRepo").
+		Str("method", "Subjects").
+		Str("object", object.String()).Logger()
+
+	query, args, err := r.Builder.
+		Select("subject_namespace", "subject_object_id", "subject_relation").
+		From(model.RelationTupleTableName).
+		Where(sq.Eq{
+			"namespace": object.Namespace,
+			"object_id": object.ObjectId,
+			"relation":  object.Relation,
+		}).
+		Where(visibleAt(revision)).
+		OrderBy("subject_namespace", "subject_object_id", "subject_relation").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Subjects - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Subjects - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.Subject, error) {
+		var s model.Subject
+		err := row.Scan(&s.Namespace, &s.ObjectId, &s.Relation)
+		return s, err
+	})
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - Subjects - pgx.CollectRows")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// BySubject returns the tuples whose subject is exactly the subject at the revision.
+func (r *RelationRepo) BySubject(ctx context.Context, subject model.Subject, revision int64) ([]model.RelationTuple, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.RelationRepo").
+		Str("method", "BySubject").
+		Str("subject", subject.String()).Logger()
+
+	query, args, err := r.Builder.
+		Select("namespace", "object_id", "relation").
+		From(model.RelationTupleTableName).
+		Where(sq.Eq{
+			"subject_namespace": subject.Namespace,
+			"subject_object_id": subject.ObjectId,
+			"subject_relation":  subject.Relation,
+		}).
+		Where(visibleAt(revision)).
+		OrderBy("namespace", "object_id", "relation").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - BySubject - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - BySubject - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.RelationTuple, error) {
+		t := model.RelationTuple{Subject: subject}
+		err := row.Scan(&t.Namespace, &t.ObjectId, &t.Relation)
+		return t, err
+	})
+	if err != nil {
+		zLog.Err(err).Msgf("RelationRepo - BySubject - pgx.CollectRows")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func visibleAt(revision int64) sq.Sqlizer {
+	return sq.And{
+		sq.LtOrEq{"create_rev": revision},
+		sq.Or{sq.Eq{"delete_rev": nil}, sq.Gt{"delete_rev": revision}},
+	}
+}
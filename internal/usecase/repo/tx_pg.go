@@ -9,11 +9,18 @@ import (
 // TxRepo -.
 type TxRepo struct {
 	*postgres.Postgres
+	afterEnd []func(ctx context.Context, tx postgres.Tx, committed bool)
 }
 
 // NewTx -.
 func NewTx(pg *postgres.Postgres) *TxRepo {
-	return &TxRepo{pg}
+	return &TxRepo{Postgres: pg}
+}
+
+// AfterEnd calls f after every transaction ended, with whether it was committed. The transaction
+// identifies it, tx ids are reused. Hooks are added at startup, before any transaction.
+func (t *TxRepo) AfterEnd(f func(ctx context.Context, tx postgres.Tx, committed bool)) {
+	t.afterEnd = append(t.afterEnd, f)
 }
 
 func (t *TxRepo) NewTxId(ctx context.Context) (int, error) {
@@ -25,5 +32,15 @@ func (t *TxRepo) NewTxId(ctx context.Context) (int, error) {
 }
 
 func (t *TxRepo) TxEnd(ctx context.Context, txId int, err error) error {
-	return t.PgTxEnd(ctx, txId, err)
+	tx, txErr := t.GetTxById(txId)
+
+	endErr := t.PgTxEnd(ctx, txId, err)
+
+	if txErr == nil {
+		for _, f := range t.afterEnd {
+			f(ctx, tx, err == nil && endErr == nil)
+		}
+	}
+
+	return endErr
 }
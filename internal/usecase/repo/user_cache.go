@@ -0,0 +1,424 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/cache"
+	"authenticator/pkg/postgres"
+)
+
+const userCache = "user:cache"
+
+// CachedUserRepo is a read-through cache of the users GetById and GetByIds return, which token
+// validation reads on every request. Users are kept in process and, when shared, in Redis for all
+// replicas, each for at most ttl. A committed write of a user drops it from this process, from Redis
+// and, through the invalidation channel, from the other replicas. A user read by one replica while
+// another writes it can still be cached stale, for at most ttl.
+type CachedUserRepo struct {
+	*UserRepo
+	client  *redis.Client
+	shared  bool
+	channel string
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	local *cache.LRU[uuid.UUID, *model.User]
+	// seq counts invalidations, a user read before one is not cached after it
+	seq uint64
+	// pending are the users written by open transactions, dropped when they commit
+	pending map[postgres.Tx][]uuid.UUID
+
+	hits, redisHits, misses, invalidations, evictions atomic.Int64
+}
+
+// cachedUser is a user in Redis, the expiry travels with it so no replica keeps it longer.
+type cachedUser struct {
+	User    *model.User
+	Expires time.Time
+}
+
+// NewCachedUser caches up to size users of r for ttl, in Redis too when shared. Invalidations are
+// published on channel, the ended transactions of tx tell when written users are dropped.
+func NewCachedUser(r *UserRepo, tx *TxRepo, client *redis.Client, size int, ttl time.Duration, shared bool, channel string) *CachedUserRepo {
+	c := &CachedUserRepo{
+		UserRepo: r,
+		client:   client,
+		shared:   shared,
+		channel:  channel,
+		ttl:      ttl,
+		local:    cache.NewLRU[uuid.UUID, *model.User](size, ttl),
+		pending:  make(map[postgres.Tx][]uuid.UUID),
+	}
+	tx.AfterEnd(c.endTx)
+	return c
+}
+
+func (r *CachedUserRepo) GetById(ctx context.Context, id uuid.UUID) (*model.User, error) {
+
+	r.mu.Lock()
+	user, ok := r.local.Get(id)
+	seq := r.seq
+	r.mu.Unlock()
+
+	if ok {
+		r.hits.Add(1)
+		return cloneUser(user), nil
+	}
+
+	if r.shared {
+		users := r.getShared(ctx, seq, []uuid.UUID{id})
+		if len(users) > 0 {
+			return &users[0], nil
+		}
+	}
+
+	r.misses.Add(1)
+
+	user, err := r.UserRepo.GetById(ctx, id)
+	if err != nil || user == nil {
+		return user, err
+	}
+
+	r.store(ctx, seq, []model.User{*user})
+
+	return user, nil
+}
+
+// GetByIds returns the users that exist of the ids, those not cached are read in one query.
+func (r *CachedUserRepo) GetByIds(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+
+	items := make([]model.User, 0, len(ids))
+	missing := make([]uuid.UUID, 0, len(ids))
+
+	r.mu.Lock()
+	for _, id := range ids {
+		if user, ok := r.local.Get(id); ok {
+			items = append(items, *cloneUser(user))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	seq := r.seq
+	r.mu.Unlock()
+
+	r.hits.Add(int64(len(items)))
+
+	if r.shared && len(missing) > 0 {
+		users := r.getShared(ctx, seq, missing)
+		if len(users) > 0 {
+			found := make(map[uuid.UUID]bool, len(users))
+			for _, user := range users {
+				found[user.Id] = true
+			}
+			items = append(items, users...)
+
+			rest := missing[:0]
+			for _, id := range missing {
+				if !found[id] {
+					rest = append(rest, id)
+				}
+			}
+			missing = rest
+		}
+	}
+
+	if len(missing) == 0 {
+		return items, nil
+	}
+
+	r.misses.Add(int64(len(missing)))
+
+	users, err := r.UserRepo.GetByIds(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store(ctx, seq, users)
+
+	return append(items, users...), nil
+}
+
+func (r *CachedUserRepo) ChangeState(ctx context.Context, old, new *model.User, txId int) error {
+	if err := r.UserRepo.ChangeState(ctx, old, new, txId); err != nil {
+		return err
+	}
+	r.invalidateAfter(ctx, txId, old.Id)
+	return nil
+}
+
+func (r *CachedUserRepo) UpdateProfile(ctx context.Context, old, new *model.User, txId int) error {
+	if err := r.UserRepo.UpdateProfile(ctx, old, new, txId); err != nil {
+		return err
+	}
+	r.invalidateAfter(ctx, txId, old.Id)
+	return nil
+}
+
+func (r *CachedUserRepo) Activate(ctx context.Context, old, new *model.User, txId int) error {
+	if err := r.UserRepo.Activate(ctx, old, new, txId); err != nil {
+		return err
+	}
+	r.invalidateAfter(ctx, txId, old.Id)
+	return nil
+}
+
+func (r *CachedUserRepo) UpdateRoles(ctx context.Context, old, new *model.User, txId int) error {
+	if err := r.UserRepo.UpdateRoles(ctx, old, new, txId); err != nil {
+		return err
+	}
+	r.invalidateAfter(ctx, txId, old.Id)
+	return nil
+}
+
+func (r *CachedUserRepo) PurgeByUsername(ctx context.Context, username string, txId int) ([]uuid.UUID, error) {
+	ids, err := r.UserRepo.PurgeByUsername(ctx, username, txId)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidateAfter(ctx, txId, ids...)
+	return ids, nil
+}
+
+func (r *CachedUserRepo) PurgeDeletedBefore(ctx context.Context, ts time.Time, txId int) ([]uuid.UUID, error) {
+	ids, err := r.UserRepo.PurgeDeletedBefore(ctx, ts, txId)
+	if err != nil {
+		return nil, err
+	}
+	r.invalidateAfter(ctx, txId, ids...)
+	return ids, nil
+}
+
+// Invalidate drops the users from every replica and from Redis.
+func (r *CachedUserRepo) Invalidate(ctx context.Context, ids ...uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.CachedUserRepo").
+		Str("method", "Invalidate").
+		Int("ids", len(ids)).Logger()
+
+	r.drop(ids)
+	r.invalidations.Add(int64(len(ids)))
+
+	// the write is committed, the invalidation must go out even when the caller is gone
+	ctx = context.WithoutCancel(ctx)
+
+	if r.shared {
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = userCacheKey(id)
+		}
+		if err := r.client.Del(ctx, keys...).Err(); err != nil {
+			zLog.Err(err).Msg("CachedUserRepo - Invalidate - r.client.Del")
+		}
+	}
+
+	message := make([]string, len(ids))
+	for i, id := range ids {
+		message[i] = id.String()
+	}
+	if err := r.client.Publish(ctx, r.channel, strings.Join(message, ",")).Err(); err != nil {
+		zLog.Err(err).Msg("CachedUserRepo - Invalidate - r.client.Publish")
+	}
+}
+
+// Subscribe drops the users invalidated by every replica until ctx is done.
+func (r *CachedUserRepo) Subscribe(ctx context.Context) {
+	pubsub := r.client.Subscribe(ctx, r.channel)
+	defer pubsub.Close()
+
+	ch := pubsub.ChannelWithSubscriptions(ctx, 100)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			switch m := msg.(type) {
+			case *redis.Subscription:
+				// invalidations published while we were not subscribed are lost, start over
+				r.purge()
+			case *redis.Message:
+				ids := make([]uuid.UUID, 0, strings.Count(m.Payload, ",")+1)
+				for _, s := range strings.Split(m.Payload, ",") {
+					id, err := uuid.Parse(s)
+					if err != nil {
+						log.Error().Str("payload", m.Payload).Msg("CachedUserRepo - Subscribe - uuid.Parse")
+						continue
+					}
+					ids = append(ids, id)
+				}
+				r.drop(ids)
+			}
+		}
+	}
+}
+
+// Stats returns the counters of the cache.
+func (r *CachedUserRepo) Stats() map[string]int64 {
+	r.mu.Lock()
+	size := r.local.Len()
+	r.mu.Unlock()
+
+	return map[string]int64{
+		"hits":          r.hits.Load(),
+		"redis_hits":    r.redisHits.Load(),
+		"misses":        r.misses.Load(),
+		"invalidations": r.invalidations.Load(),
+		"evictions":     r.evictions.Load(),
+		"size":          int64(size),
+	}
+}
+
+// invalidateAfter drops the users when the transaction commits, before that other calls still read
+// the old rows and could cache them again.
+func (r *CachedUserRepo) invalidateAfter(ctx context.Context, txId int, ids ...uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		r.Invalidate(ctx, ids...)
+		return
+	}
+
+	r.mu.Lock()
+	r.pending[tx] = append(r.pending[tx], ids...)
+	r.mu.Unlock()
+}
+
+func (r *CachedUserRepo) endTx(ctx context.Context, tx postgres.Tx, committed bool) {
+	r.mu.Lock()
+	ids := r.pending[tx]
+	delete(r.pending, tx)
+	r.mu.Unlock()
+
+	if committed {
+		r.Invalidate(ctx, ids...)
+	}
+}
+
+// store caches the users read since seq, unless users were invalidated meanwhile.
+func (r *CachedUserRepo) store(ctx context.Context, seq uint64, users []model.User) {
+	if len(users) == 0 || !r.add(seq, users, time.Time{}) || !r.shared {
+		return
+	}
+
+	expires := time.Now().Add(r.ttl)
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range users {
+			data, err := json.Marshal(cachedUser{User: &users[i], Expires: expires})
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, userCacheKey(users[i].Id), data, r.ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("CachedUserRepo - store - r.client.Pipelined")
+	}
+}
+
+// getShared returns the users of the ids found in Redis, and caches them in process.
+// Redis errors are logged, the users are then read from the database.
+func (r *CachedUserRepo) getShared(ctx context.Context, seq uint64, ids []uuid.UUID) []model.User {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = userCacheKey(id)
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("CachedUserRepo - getShared - r.client.MGet")
+		return nil
+	}
+
+	users := make([]model.User, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var c cachedUser
+		if err = json.Unmarshal([]byte(s), &c); err != nil || c.User == nil || time.Now().After(c.Expires) {
+			continue
+		}
+		users = append(users, *c.User)
+		r.add(seq, []model.User{*c.User}, c.Expires)
+	}
+
+	r.redisHits.Add(int64(len(users)))
+
+	return users
+}
+
+// add caches the users in process and reports whether they were, not when an invalidation came after seq.
+func (r *CachedUserRepo) add(seq uint64, users []model.User, expires time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seq != seq {
+		return false
+	}
+
+	for i := range users {
+		if r.local.Add(users[i].Id, cloneUser(&users[i]), expires) {
+			r.evictions.Add(1)
+		}
+	}
+
+	return true
+}
+
+func (r *CachedUserRepo) drop(ids []uuid.UUID) {
+	r.mu.Lock()
+	r.seq++
+	for _, id := range ids {
+		r.local.Remove(id)
+	}
+	r.mu.Unlock()
+}
+
+func (r *CachedUserRepo) purge() {
+	r.mu.Lock()
+	r.seq++
+	r.local.Purge()
+	r.mu.Unlock()
+}
+
+func userCacheKey(id uuid.UUID) string {
+	return fmt.Sprintf("%v:%v", userCache, id)
+}
+
+// cloneUser copies the user, callers change the users they get and must not change the cached ones.
+func cloneUser(in *model.User) *model.User {
+	out := *in
+	if in.Roles != nil {
+		out.Roles = append([]string(nil), in.Roles...)
+	}
+	if in.Attributes != nil {
+		out.Attributes = make(map[string]string, len(in.Attributes))
+		for k, v := range in.Attributes {
+			out.Attributes[k] = v
+		}
+	}
+	return &out
+}
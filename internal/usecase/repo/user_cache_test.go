@@ -0,0 +1,184 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/cache"
+	"authenticator/pkg/postgres"
+)
+
+const testChannel = "user:cache:invalidate"
+
+// newTestUserCache caches without a database, the tests only reach cached users.
+func newTestUserCache(t *testing.T, mr *miniredis.Miniredis) *CachedUserRepo {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return &CachedUserRepo{
+		client:  client,
+		shared:  true,
+		channel: testChannel,
+		ttl:     time.Minute,
+		local:   cache.NewLRU[uuid.UUID, *model.User](10, time.Minute),
+		pending: make(map[postgres.Tx][]uuid.UUID),
+	}
+}
+
+func testUser(username string) model.User {
+	return model.User{
+		Id:         uuid.New(),
+		Username:   username,
+		Roles:      []string{"admin"},
+		Attributes: map[string]string{"team": "ops"},
+		State:      model.Enabled,
+	}
+}
+
+func TestCachedUserServesCopies(t *testing.T) {
+	r := newTestUserCache(t, miniredis.RunT(t))
+	alice := testUser("alice")
+	r.store(context.Background(), r.seq, []model.User{alice})
+
+	user, err := r.GetById(context.Background(), alice.Id)
+	if err != nil || user == nil || user.Username != "alice" {
+		t.Fatalf("GetById = %+v, %v, want alice", user, err)
+	}
+
+	user.Roles[0] = "support"
+	user.Attributes["team"] = "sales"
+
+	again, _ := r.GetById(context.Background(), alice.Id)
+	if again.Roles[0] != "admin" || again.Attributes["team"] != "ops" {
+		t.Fatalf("cached user = %+v, want it unchanged by the caller", again)
+	}
+	if hits := r.Stats()["hits"]; hits != 2 {
+		t.Fatalf("%d hits, want 2", hits)
+	}
+}
+
+func TestCachedUserSharedThroughRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, b := newTestUserCache(t, mr), newTestUserCache(t, mr)
+	alice, bob := testUser("alice"), testUser("bob")
+	a.store(context.Background(), a.seq, []model.User{alice, bob})
+
+	if ttl := mr.TTL(userCacheKey(alice.Id)); ttl != time.Minute {
+		t.Fatalf("Redis ttl = %v, want %v", ttl, time.Minute)
+	}
+
+	users, err := b.GetByIds(context.Background(), []uuid.UUID{alice.Id, bob.Id})
+	if err != nil || len(users) != 2 {
+		t.Fatalf("GetByIds = %+v, %v, want alice and bob", users, err)
+	}
+	if stats := b.Stats(); stats["redis_hits"] != 2 || stats["size"] != 2 || stats["misses"] != 0 {
+		t.Fatalf("stats = %v, want 2 Redis hits cached in process", stats)
+	}
+
+	if _, err = b.GetById(context.Background(), alice.Id); err != nil || b.Stats()["hits"] != 1 {
+		t.Fatalf("GetById = %v with stats %v, want a hit in process", err, b.Stats())
+	}
+}
+
+func TestCachedUserIgnoresExpiredRedisEntries(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newTestUserCache(t, mr)
+	alice := testUser("alice")
+
+	data, _ := json.Marshal(cachedUser{User: &alice, Expires: time.Now().Add(-time.Second)})
+	if err := mr.Set(userCacheKey(alice.Id), string(data)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if users := r.getShared(context.Background(), r.seq, []uuid.UUID{alice.Id}); len(users) != 0 {
+		t.Fatalf("getShared = %+v, want the expired user ignored", users)
+	}
+	if r.local.Len() != 0 {
+		t.Fatalf("%d users cached, want none", r.local.Len())
+	}
+}
+
+func TestCachedUserSkipsReadsBeforeInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newTestUserCache(t, mr)
+	alice := testUser("alice")
+
+	seq := r.seq
+	r.Invalidate(context.Background(), alice.Id)
+	r.store(context.Background(), seq, []model.User{alice})
+
+	if r.local.Len() != 0 || mr.Exists(userCacheKey(alice.Id)) {
+		t.Fatalf("user read before an invalidation cached, want it dropped")
+	}
+}
+
+func TestCachedUserInvalidatesEveryReplica(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, b := newTestUserCache(t, mr), newTestUserCache(t, mr)
+	alice := testUser("alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Subscribe(ctx)
+	// the subscription purges the cache, it must be done before b caches alice
+	waitFor(t, func() bool { return seqOf(b) > 0 })
+
+	a.store(context.Background(), seqOf(a), []model.User{alice})
+	b.add(seqOf(b), []model.User{alice}, time.Time{})
+
+	a.Invalidate(context.Background(), alice.Id)
+
+	if a.local.Len() != 0 || mr.Exists(userCacheKey(alice.Id)) {
+		t.Fatalf("alice still cached by the writer or in Redis")
+	}
+	waitFor(t, func() bool { return b.Stats()["size"] == 0 })
+	if n := a.Stats()["invalidations"]; n != 1 {
+		t.Fatalf("%d invalidations, want 1", n)
+	}
+}
+
+func TestCachedUserInvalidatesOnCommit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newTestUserCache(t, mr)
+	alice := testUser("alice")
+	r.store(context.Background(), r.seq, []model.User{alice})
+
+	var tx postgres.Tx
+	r.pending[tx] = []uuid.UUID{alice.Id}
+	r.endTx(context.Background(), tx, false)
+	if r.local.Len() != 1 || len(r.pending) != 0 {
+		t.Fatalf("rolled back write dropped the user or stayed pending")
+	}
+
+	r.pending[tx] = []uuid.UUID{alice.Id}
+	r.endTx(context.Background(), tx, true)
+	if r.local.Len() != 0 || mr.Exists(userCacheKey(alice.Id)) {
+		t.Fatalf("committed write kept the user cached")
+	}
+}
+
+func seqOf(r *CachedUserRepo) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -2,8 +2,10 @@ package repo
 
 import (
 	"context"
+	"strings"
 	"time"
 
+	sq "github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -14,6 +16,24 @@ import (
 	"authenticator/pkg/postgres"
 )
 
+var (
+	userColumns = []string{
+		"id",
+		"username",
+		"COALESCE(email, '')",
+		"email_verified",
+		"COALESCE(phone, '')",
+		"phone_verified",
+		"attributes",
+		"roles",
+		"state",
+		"create_ts",
+		"update_ts",
+		"version",
+	}
+	detailUserColumns = append([]string{"password"}, userColumns...)
+)
+
 // UserRepo -.
 type UserRepo struct {
 	*postgres.Postgres
@@ -41,11 +61,19 @@ func (r *UserRepo) Create(ctx context.Context, in *model.User, txId int) error {
 		Insert(model.UserTableName).
 		Columns("username",
 			"password",
+			"email",
+			"phone",
+			"attributes",
+			"roles",
 			"state",
 			"create_ts",
 			"update_ts").
 		Values(in.Username,
 			in.Password,
+			nullString(in.Email),
+			nullString(in.Phone),
+			attributesOrEmpty(in.Attributes),
+			rolesOrEmpty(in.Roles),
 			in.State,
 			in.CreateTs,
 			in.UpdateTs).
@@ -59,6 +87,9 @@ func (r *UserRepo) Create(ctx context.Context, in *model.User, txId int) error {
 	err = tx.QueryRow(ctx, query, args...).Scan(&in.Id)
 	if err != nil {
 		zLog.Err(err).Msgf("UserRepo - Create - tx.QueryRow - query: %s", query)
+		if isUniqueViolation(err) {
+			return model.ErrConflict
+		}
 		return err
 	}
 
@@ -72,12 +103,7 @@ func (r *UserRepo) GetById(ctx context.Context, id uuid.UUID) (*model.User, erro
 		Str("id", id.String()).Logger()
 
 	query, args, err := r.Builder.
-		Select("id",
-			"username",
-			"state",
-			"create_ts",
-			"update_ts",
-			"version").
+		Select(userColumns...).
 		From(model.UserTableName).
 		Where("id = ?", id).
 		Where("state != ?", model.Deleted).
@@ -99,6 +125,90 @@ func (r *UserRepo) GetById(ctx context.Context, id uuid.UUID) (*model.User, erro
 	return &data, nil
 }
 
+// GetByIdForUpdate reads the user in the transaction and locks it until the transaction ends,
+// so checks of its version hold for the writes that follow.
+func (r *UserRepo) GetByIdForUpdate(ctx context.Context, id uuid.UUID, txId int) (*model.User, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "GetByIdForUpdate").
+		Str("id", id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - GetByIdForUpdate - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Select(userColumns...).
+		From(model.UserTableName).
+		Where("id = ?", id).
+		Where("state != ?", model.Deleted).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - GetByIdForUpdate - r.Builder")
+		return nil, err
+	}
+	var data model.User
+	err = scanUser(tx.QueryRow(ctx, query, args...), &data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		zLog.Err(err).Msgf("UserRepo - GetByIdForUpdate - tx.QueryRow - query: %s", query)
+		return nil, err
+	}
+	return &data, nil
+}
+
+// GetByIds returns the users that exist of the ids, in one query.
+func (r *UserRepo) GetByIds(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "GetByIds").
+		Int("ids", len(ids)).Logger()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := r.Builder.
+		Select(userColumns...).
+		From(model.UserTableName).
+		Where("id = ANY(?)", ids).
+		Where("state != ?", model.Deleted).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - GetByIds - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - GetByIds - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]model.User, 0, len(ids))
+	for rows.Next() {
+		var item model.User
+		if err = scanUser(rows, &item); err != nil {
+			zLog.Err(err).Msgf("UserRepo - GetByIds - scanUser")
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("UserRepo - GetByIds - rows.Err")
+		return nil, err
+	}
+
+	return items, nil
+}
+
 func (r *UserRepo) GetPasswordById(ctx context.Context, id uuid.UUID) (*model.User, error) {
 	zLog := zerolog.Ctx(ctx).With().
 		Str("unit", "internal.usecase.repo.UserRepo").
@@ -106,13 +216,7 @@ func (r *UserRepo) GetPasswordById(ctx context.Context, id uuid.UUID) (*model.Us
 		Str("id", id.String()).Logger()
 
 	query, args, err := r.Builder.
-		Select("id",
-			"username",
-			"password",
-			"state",
-			"create_ts",
-			"update_ts",
-			"version").
+		Select(detailUserColumns...).
 		From(model.UserTableName).
 		Where("id = ?", id).
 		Where("state != ?", model.Deleted).
@@ -142,13 +246,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*model.U
 		Str("username", username).Logger()
 
 	query, args, err := r.Builder.
-		Select("id",
-			"username",
-			"password",
-			"state",
-			"create_ts",
-			"update_ts",
-			"version").
+		Select(detailUserColumns...).
 		From(model.UserTableName).
 		Where("username = ?", username).
 		Where("state != ?", model.Deleted).
@@ -162,7 +260,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*model.U
 	err = scanDetailUser(r.Pool.QueryRow(ctx, query, args...), &data)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			zLog.Debug().Msgf("username: %d no results", username)
+			zLog.Debug().Msgf("username: %s no results", username)
 			return nil, nil
 		}
 		zLog.Err(err).Msgf("UserRepo - GetById - r.Pool.QueryRow - query: %s", query)
@@ -202,6 +300,9 @@ func (r *UserRepo) ChangeState(ctx context.Context, old, new *model.User, txId i
 	cmdTag, err = tx.Exec(ctx, query, args...)
 	if err != nil {
 		zLog.Err(err).Msgf("UserRepo - Update - tx.Exec - query: %s", query)
+		if isUniqueViolation(err) {
+			return model.ErrConflict
+		}
 		return err
 	}
 	if cmdTag.RowsAffected() == 0 {
@@ -212,10 +313,400 @@ func (r *UserRepo) ChangeState(ctx context.Context, old, new *model.User, txId i
 	return nil
 }
 
+func (r *UserRepo) UpdateProfile(ctx context.Context, old, new *model.User, txId int) error {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "UpdateProfile").
+		Str("id", old.Id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - UpdateProfile - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.UserTableName).
+		Where("id = ?", old.Id).
+		Where("version = ?", old.Version).
+		SetMap(map[string]interface{}{
+			"email":          nullString(new.Email),
+			"email_verified": new.EmailVerified,
+			"phone":          nullString(new.Phone),
+			"phone_verified": new.PhoneVerified,
+			"attributes":     attributesOrEmpty(new.Attributes),
+			"update_ts":      new.UpdateTs,
+			"version":        new.Version,
+		}).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - UpdateProfile - r.Builder")
+		return err
+	}
+
+	var cmdTag pgconn.CommandTag
+	cmdTag, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - UpdateProfile - tx.Exec - query: %s", query)
+		if isUniqueViolation(err) {
+			return model.ErrConflict
+		}
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		zLog.Error().Msgf("UserRepo - UpdateProfile - tx.Exec - no rows affected - query: %s", query)
+		return model.ErrNoRowsAffected
+	}
+
+	return nil
+}
+
+// Activate sets the password of an invited user and enables it.
+func (r *UserRepo) Activate(ctx context.Context, old, new *model.User, txId int) error {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "Activate").
+		Str("id", old.Id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Activate - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.UserTableName).
+		Where("id = ?", old.Id).
+		Where("version = ?", old.Version).
+		SetMap(map[string]interface{}{
+			"password":  new.Password,
+			"state":     new.State,
+			"update_ts": new.UpdateTs,
+			"version":   new.Version,
+		}).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Activate - r.Builder")
+		return err
+	}
+
+	var cmdTag pgconn.CommandTag
+	cmdTag, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Activate - tx.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		zLog.Error().Msgf("UserRepo - Activate - tx.Exec - no rows affected - query: %s", query)
+		return model.ErrNoRowsAffected
+	}
+
+	return nil
+}
+
+// GetDeletedByUsername returns the most recently deleted user with the given username.
+func (r *UserRepo) GetDeletedByUsername(ctx context.Context, username string) (*model.User, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "GetDeletedByUsername").
+		Str("username", username).Logger()
+
+	query, args, err := r.Builder.
+		Select(userColumns...).
+		From(model.UserTableName).
+		Where("username = ?", username).
+		Where("state = ?", model.Deleted).
+		OrderBy("update_ts DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - GetDeletedByUsername - r.Builder")
+		return nil, err
+	}
+
+	var data model.User
+	err = scanUser(r.Pool.QueryRow(ctx, query, args...), &data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			zLog.Debug().Msgf("username: %s no results", username)
+			return nil, nil
+		}
+		zLog.Err(err).Msgf("UserRepo - GetDeletedByUsername - r.Pool.QueryRow - query: %s", query)
+		return nil, err
+	}
+	return &data, nil
+}
+
+// PurgeByUsername permanently removes the deleted users with the given username. A user holding
+// the username again is kept.
+func (r *UserRepo) PurgeByUsername(ctx context.Context, username string, txId int) ([]uuid.UUID, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "PurgeByUsername").
+		Str("username", username).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeByUsername - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.UserTableName).
+		Where("username = ?", username).
+		Where("state = ?", model.Deleted).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeByUsername - r.Builder")
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeByUsername - tx.Query - query: %s", query)
+		return nil, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeByUsername - pgx.CollectRows")
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// PurgeDeletedBefore permanently removes users that were deleted before ts.
+func (r *UserRepo) PurgeDeletedBefore(ctx context.Context, ts time.Time, txId int) ([]uuid.UUID, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "PurgeDeletedBefore").
+		Time("ts", ts).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeDeletedBefore - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Delete(model.UserTableName).
+		Where("state = ?", model.Deleted).
+		Where("update_ts < ?", ts).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeDeletedBefore - r.Builder")
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeDeletedBefore - tx.Query - query: %s", query)
+		return nil, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - PurgeDeletedBefore - pgx.CollectRows")
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Search returns a page of users matching the filter ordered by creation, and the total number of matches.
+func (r *UserRepo) Search(ctx context.Context, filter *model.UserFilter) ([]model.User, int, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "Search").Logger()
+
+	where := sq.And{sq.NotEq{"state": model.Deleted}}
+	for _, c := range filter.Conditions {
+		cond, err := userCondition(c)
+		if err != nil {
+			return nil, 0, err
+		}
+		where = append(where, cond)
+	}
+
+	query, args, err := r.Builder.
+		Select("COUNT(*)").
+		From(model.UserTableName).
+		Where(where).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Search - r.Builder")
+		return nil, 0, err
+	}
+
+	var total int
+	err = r.Pool.QueryRow(ctx, query, args...).Scan(&total)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Search - r.Pool.QueryRow - query: %s", query)
+		return nil, 0, err
+	}
+
+	query, args, err = r.Builder.
+		Select(userColumns...).
+		From(model.UserTableName).
+		Where(where).
+		OrderBy("create_ts", "id").
+		Offset(uint64(filter.Offset)).
+		Limit(uint64(filter.Limit)).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Search - r.Builder")
+		return nil, 0, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - Search - r.Pool.Query - query: %s", query)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []model.User
+	for rows.Next() {
+		var item model.User
+		if err = scanUser(rows, &item); err != nil {
+			zLog.Err(err).Msgf("UserRepo - Search - scanUser")
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("UserRepo - Search - rows.Err")
+		return nil, 0, err
+	}
+
+	return items, total, nil
+}
+
+// UpdateRoles replaces the roles of the user.
+func (r *UserRepo) UpdateRoles(ctx context.Context, old, new *model.User, txId int) error {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.UserRepo").
+		Str("method", "UpdateRoles").
+		Str("id", old.Id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - UpdateRoles - r.GetTxById")
+		return err
+	}
+
+	query, args, err := r.Builder.
+		Update(model.UserTableName).
+		Where("id = ?", old.Id).
+		Where("version = ?", old.Version).
+		SetMap(map[string]interface{}{
+			"roles":     rolesOrEmpty(new.Roles),
+			"update_ts": new.UpdateTs,
+			"version":   new.Version,
+		}).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - UpdateRoles - r.Builder")
+		return err
+	}
+
+	var cmdTag pgconn.CommandTag
+	cmdTag, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("UserRepo - UpdateRoles - tx.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		zLog.Error().Msgf("UserRepo - UpdateRoles - tx.Exec - no rows affected - query: %s", query)
+		return model.ErrNoRowsAffected
+	}
+
+	return nil
+}
+
+// userCondition translates a search condition into SQL. Text comparisons ignore case.
+func userCondition(c model.UserCondition) (sq.Sqlizer, error) {
+	var column string
+	switch c.Field {
+	case model.UserFieldId:
+		if c.Op == model.OpPresent {
+			return sq.Expr("TRUE"), nil
+		}
+		id, err := uuid.Parse(c.Value)
+		if err != nil || (c.Op != model.OpEq && c.Op != model.OpNe) {
+			return nil, model.ErrBadRequest
+		}
+		if c.Op == model.OpNe {
+			return sq.NotEq{"id": id}, nil
+		}
+		return sq.Eq{"id": id}, nil
+	case model.UserFieldState:
+		if _, err := model.ParseState(c.Value); err != nil {
+			return nil, model.ErrBadRequest
+		}
+		switch c.Op {
+		case model.OpEq:
+			return sq.Eq{"state": c.Value}, nil
+		case model.OpNe:
+			return sq.NotEq{"state": c.Value}, nil
+		}
+		return nil, model.ErrBadRequest
+	case model.UserFieldRole:
+		switch c.Op {
+		case model.OpEq:
+			return sq.Expr("? = ANY(roles)", c.Value), nil
+		case model.OpNe:
+			return sq.Expr("NOT (? = ANY(roles))", c.Value), nil
+		case model.OpPresent:
+			return sq.Expr("cardinality(roles) > 0"), nil
+		}
+		return nil, model.ErrBadRequest
+	case model.UserFieldUsername:
+		column = "username"
+	case model.UserFieldEmail:
+		column = "email"
+	case model.UserFieldPhone:
+		column = "phone"
+	default:
+		return nil, model.ErrBadRequest
+	}
+
+	value := strings.ToLower(c.Value)
+	escaped := likeEscaper.Replace(value)
+	switch c.Op {
+	case model.OpEq:
+		return sq.Expr("lower("+column+") = ?", value), nil
+	case model.OpNe:
+		return sq.Expr("lower("+column+") IS DISTINCT FROM ?", value), nil
+	case model.OpCo:
+		return sq.Expr("lower("+column+") LIKE ?", "%"+escaped+"%"), nil
+	case model.OpSw:
+		return sq.Expr("lower("+column+") LIKE ?", escaped+"%"), nil
+	case model.OpEw:
+		return sq.Expr("lower("+column+") LIKE ?", "%"+escaped), nil
+	case model.OpPresent:
+		return sq.Expr(column + " IS NOT NULL"), nil
+	}
+
+	return nil, model.ErrBadRequest
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 func scanUser(row pgx.Row, item *model.User) (err error) {
-	// id, username, state, create_ts, update_ts, version
+	// id, username, email, email_verified, phone, phone_verified, attributes, roles, state, create_ts, update_ts, version
 
-	err = row.Scan(&item.Id, &item.Username, &item.State, &item.CreateTs, &item.UpdateTs, &item.Version)
+	err = row.Scan(&item.Id, &item.Username, &item.Email, &item.EmailVerified, &item.Phone, &item.PhoneVerified,
+		&item.Attributes, &item.Roles, &item.State, &item.CreateTs, &item.UpdateTs, &item.Version)
 	if err == nil {
 		item.CreateTs = item.CreateTs.In(time.UTC)
 		item.UpdateTs = item.UpdateTs.In(time.UTC)
@@ -224,12 +715,34 @@ func scanUser(row pgx.Row, item *model.User) (err error) {
 }
 
 func scanDetailUser(row pgx.Row, item *model.User) (err error) {
-	// id, username, password, state, create_ts, update_ts, version
+	// password, id, username, email, email_verified, phone, phone_verified, attributes, roles, state, create_ts, update_ts, version
 
-	err = row.Scan(&item.Id, &item.Username, &item.Password, &item.State, &item.CreateTs, &item.UpdateTs, &item.Version)
+	err = row.Scan(&item.Password, &item.Id, &item.Username, &item.Email, &item.EmailVerified, &item.Phone, &item.PhoneVerified,
+		&item.Attributes, &item.Roles, &item.State, &item.CreateTs, &item.UpdateTs, &item.Version)
 	if err == nil {
 		item.CreateTs = item.CreateTs.In(time.UTC)
 		item.UpdateTs = item.UpdateTs.In(time.UTC)
 	}
 	return
 }
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func rolesOrEmpty(roles []string) []string {
+	if roles == nil {
+		return []string{}
+	}
+	return roles
+}
+
+func attributesOrEmpty(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
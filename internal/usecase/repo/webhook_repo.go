@@ -0,0 +1,442 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/postgres"
+)
+
+// WebhookRepo -.
+type WebhookRepo struct {
+	*postgres.Postgres
+}
+
+// NewWebhook -.
+func NewWebhook(pg *postgres.Postgres) *WebhookRepo {
+	return &WebhookRepo{pg}
+}
+
+var webhookDeliveryColumns = []string{
+	"id",
+	"webhook_id",
+	"event_id",
+	"event_type",
+	"payload",
+	"event_ts",
+	"status",
+	"attempts",
+	"next_attempt_ts",
+	"COALESCE(last_status_code, 0)",
+	"COALESCE(last_error, '')",
+	"create_ts",
+	"update_ts",
+}
+
+func (r *WebhookRepo) Create(ctx context.Context, in *model.Webhook) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "Create").Logger()
+
+	query, args, err := r.Builder.
+		Insert(model.WebhookTableName).
+		Columns("url",
+			"event_types",
+			"secret",
+			"create_ts",
+			"update_ts").
+		Values(in.Url,
+			rolesOrEmpty(in.EventTypes),
+			in.Secret,
+			in.CreateTs,
+			in.UpdateTs).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Create - r.Builder")
+		return err
+	}
+
+	err = r.Pool.QueryRow(ctx, query, args...).Scan(&in.Id)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Create - r.Pool.QueryRow - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+func (r *WebhookRepo) List(ctx context.Context) ([]model.Webhook, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "List").Logger()
+
+	query, args, err := r.Builder.
+		Select("id",
+			"url",
+			"event_types",
+			"secret",
+			"create_ts",
+			"update_ts").
+		From(model.WebhookTableName).
+		OrderBy("create_ts").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - List - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - List - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []model.Webhook
+	for rows.Next() {
+		var item model.Webhook
+		err = rows.Scan(&item.Id, &item.Url, &item.EventTypes, &item.Secret, &item.CreateTs, &item.UpdateTs)
+		if err != nil {
+			zLog.Err(err).Msgf("WebhookRepo - List - rows.Scan")
+			return nil, err
+		}
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		item.UpdateTs = item.UpdateTs.In(time.UTC)
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - List - rows.Err")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (r *WebhookRepo) Delete(ctx context.Context, id uuid.UUID) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "Delete").
+		Str("id", id.String()).Logger()
+
+	query, args, err := r.Builder.
+		Delete(model.WebhookTableName).
+		Where("id = ?", id).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Delete - r.Builder")
+		return err
+	}
+
+	cmdTag, err := r.Pool.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Delete - r.Pool.Exec - query: %s", query)
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+// Enqueue queues the event for every webhook subscribed to its type.
+// A delivery that was already queued for the event is left as it is.
+func (r *WebhookRepo) Enqueue(ctx context.Context, ev *model.OutboxEvent, ts time.Time, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "Enqueue").
+		Str("event_id", ev.Id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Enqueue - r.GetTxById")
+		return err
+	}
+
+	subscribed := r.Builder.
+		Select("id").
+		Column("?::uuid", ev.Id).
+		Column("?::text", ev.Type).
+		Column("?::jsonb", attributesOrEmpty(ev.Payload)).
+		Column("?::timestamp", ev.CreateTs).
+		Column("?::timestamp", ts).
+		Column("?::timestamp", ts).
+		Column("?::timestamp", ts).
+		From(model.WebhookTableName).
+		Where("(cardinality(event_types) = 0 OR ? = ANY(event_types))", ev.Type)
+
+	query, args, err := r.Builder.
+		Insert(model.WebhookDeliveryTableName).
+		Columns("webhook_id",
+			"event_id",
+			"event_type",
+			"payload",
+			"event_ts",
+			"next_attempt_ts",
+			"create_ts",
+			"update_ts").
+		Select(subscribed).
+		Suffix("ON CONFLICT (webhook_id, event_id) DO NOTHING").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Enqueue - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Enqueue - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+// ClaimDue returns the oldest pending deliveries due at now and claims them by moving their next
+// attempt to until, so no other run picks them up while they are sent. Rows locked by another run
+// are skipped.
+func (r *WebhookRepo) ClaimDue(ctx context.Context, now, until time.Time, limit int, txId int) ([]model.WebhookDelivery, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "ClaimDue").Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ClaimDue - r.GetTxById")
+		return nil, err
+	}
+
+	query, args, err := r.Builder.
+		Select(webhookDeliveryColumns...).
+		From(model.WebhookDeliveryTableName).
+		Where("status = ?", model.DeliveryPending).
+		Where("next_attempt_ts <= ?", now).
+		OrderBy("next_attempt_ts").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ClaimDue - r.Builder")
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ClaimDue - tx.Query - query: %s", query)
+		return nil, err
+	}
+
+	items, err := scanWebhookDeliveries(rows)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ClaimDue - scanWebhookDeliveries")
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, len(items))
+	for i := range items {
+		ids[i] = items[i].Id
+	}
+
+	query, args, err = r.Builder.
+		Update(model.WebhookDeliveryTableName).
+		Set("next_attempt_ts", until).
+		Where("id = ANY(?)", ids).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ClaimDue - r.Builder")
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ClaimDue - tx.Exec - query: %s", query)
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// UpdateDelivery stores the outcome of a delivery attempt.
+func (r *WebhookRepo) UpdateDelivery(ctx context.Context, in *model.WebhookDelivery, txId int) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "UpdateDelivery").
+		Str("id", in.Id.String()).Logger()
+
+	tx, err := r.GetTxById(txId)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - UpdateDelivery - r.GetTxById")
+		return err
+	}
+
+	var statusCode interface{}
+	if in.LastStatusCode != 0 {
+		statusCode = in.LastStatusCode
+	}
+
+	query, args, err := r.Builder.
+		Update(model.WebhookDeliveryTableName).
+		Set("status", in.Status).
+		Set("attempts", in.Attempts).
+		Set("next_attempt_ts", in.NextAttemptTs).
+		Set("last_status_code", statusCode).
+		Set("last_error", nullString(in.LastError)).
+		Set("update_ts", in.UpdateTs).
+		Where("id = ?", in.Id).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - UpdateDelivery - r.Builder")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - UpdateDelivery - tx.Exec - query: %s", query)
+		return err
+	}
+
+	return nil
+}
+
+func (r *WebhookRepo) ListDeliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]model.WebhookDelivery, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "ListDeliveries").Logger()
+
+	b := r.Builder.
+		Select(webhookDeliveryColumns...).
+		From(model.WebhookDeliveryTableName).
+		OrderBy("create_ts DESC").
+		Limit(uint64(filter.Limit))
+
+	if filter.WebhookId != uuid.Nil {
+		b = b.Where("webhook_id = ?", filter.WebhookId)
+	}
+	if filter.Status != "" {
+		b = b.Where("status = ?", filter.Status)
+	}
+
+	query, args, err := b.ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ListDeliveries - r.Builder")
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ListDeliveries - r.Pool.Query - query: %s", query)
+		return nil, err
+	}
+
+	items, err := scanWebhookDeliveries(rows)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - ListDeliveries - scanWebhookDeliveries")
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Replay moves dead deliveries back to pending with a fresh attempt budget.
+// Either the given deliveries or every dead delivery of the webhook are replayed.
+func (r *WebhookRepo) Replay(ctx context.Context, webhookId uuid.UUID, ids []uuid.UUID, ts time.Time) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "Replay").Logger()
+
+	b := r.Builder.
+		Update(model.WebhookDeliveryTableName).
+		Set("status", model.DeliveryPending).
+		Set("attempts", 0).
+		Set("next_attempt_ts", ts).
+		Set("update_ts", ts).
+		Where("status = ?", model.DeliveryDead)
+
+	if webhookId != uuid.Nil {
+		b = b.Where("webhook_id = ?", webhookId)
+	}
+	if len(ids) > 0 {
+		b = b.Where("id = ANY(?)", ids)
+	}
+
+	query, args, err := b.ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Replay - r.Builder")
+		return 0, err
+	}
+
+	cmdTag, err := r.Pool.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - Replay - r.Pool.Exec - query: %s", query)
+		return 0, err
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
+
+// PurgeDelivered removes deliveries that succeeded before ts.
+func (r *WebhookRepo) PurgeDelivered(ctx context.Context, ts time.Time) (int64, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.repo.WebhookRepo").
+		Str("method", "PurgeDelivered").
+		Time("ts", ts).Logger()
+
+	query, args, err := r.Builder.
+		Delete(model.WebhookDeliveryTableName).
+		Where("status = ?", model.DeliveryDelivered).
+		Where("update_ts < ?", ts).
+		ToSql()
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - PurgeDelivered - r.Builder")
+		return 0, err
+	}
+
+	cmdTag, err := r.Pool.Exec(ctx, query, args...)
+	if err != nil {
+		zLog.Err(err).Msgf("WebhookRepo - PurgeDelivered - r.Pool.Exec - query: %s", query)
+		return 0, err
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
+
+func scanWebhookDeliveries(rows pgx.Rows) ([]model.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var items []model.WebhookDelivery
+	for rows.Next() {
+		var item model.WebhookDelivery
+		err := rows.Scan(&item.Id, &item.WebhookId, &item.EventId, &item.EventType, &item.Payload, &item.EventTs,
+			&item.Status, &item.Attempts, &item.NextAttemptTs, &item.LastStatusCode, &item.LastError,
+			&item.CreateTs, &item.UpdateTs)
+		if err != nil {
+			return nil, err
+		}
+		item.EventTs = item.EventTs.In(time.UTC)
+		item.NextAttemptTs = item.NextAttemptTs.In(time.UTC)
+		item.CreateTs = item.CreateTs.In(time.UTC)
+		item.UpdateTs = item.UpdateTs.In(time.UTC)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
@@ -0,0 +1,164 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestUpdateToken(t *testing.T) {
+	errDatabase := errors.New("database down")
+
+	tests := []struct {
+		name    string
+		prepare func(f *fakes, u *model.User)
+		wantErr error
+	}{
+		{
+			name:    "refreshes",
+			prepare: func(*fakes, *model.User) {},
+		},
+		{
+			name:    "user read fails",
+			prepare: func(f *fakes, _ *model.User) { f.users.err = errDatabase },
+			wantErr: errDatabase,
+		},
+		{
+			name: "user deleted",
+			prepare: func(f *fakes, u *model.User) {
+				u.State = model.Deleted
+				f.users.put(u)
+			},
+			wantErr: model.ErrUnauthorized,
+		},
+		{
+			name: "user disabled",
+			prepare: func(f *fakes, u *model.User) {
+				u.State = model.Disabled
+				f.users.put(u)
+			},
+			wantErr: model.ErrUnauthorized,
+		},
+		{
+			name:    "wrong refresh token",
+			prepare: func(f *fakes, u *model.User) { f.web.refreshTokens[u.Id] = "9c3e2f10-4b6d-4a8e-b1f2-3d4c5e6f7a8b" },
+			wantErr: model.ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, f := newTestUserUseCase(t)
+			// the access token of 15 minutes is in the refresh window
+			config.Conf.Jwt.RefreshWindow = 3600
+
+			u := f.addUser("alice")
+			refreshToken := "2b1c4d9e-7f3a-4e52-9a66-0d8f1b3c5e7a"
+			f.web.refreshTokens[u.Id] = refreshToken
+			in := &dto.UpdateToken{AccessToken: accessToken(t, u), RefreshToken: refreshToken}
+
+			tt.prepare(f, u)
+
+			item, err := uc.UpdateToken(context.Background(), in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateToken error = %v, want %v", err, tt.wantErr)
+			}
+			if (item != nil) != (tt.wantErr == nil) {
+				t.Fatalf("UpdateToken = %+v, %v, want tokens only without error", item, err)
+			}
+		})
+	}
+}
+
+// expiredAccessToken returns an access token of the user that expired the minutes ago.
+func expiredAccessToken(t *testing.T, u *model.User, minutes int) string {
+	t.Helper()
+
+	expiry := config.Conf.Jwt.AccessTokenExpiry
+	config.Conf.Jwt.AccessTokenExpiry = -minutes
+	defer func() { config.Conf.Jwt.AccessTokenExpiry = expiry }()
+
+	return accessToken(t, u)
+}
+
+func TestUpdateTokenExpired(t *testing.T) {
+	tests := []struct {
+		name    string
+		minutes int
+		wantErr error
+	}{
+		// the refresh token lives 60 minutes
+		{name: "expired within the refresh token lifetime", minutes: 5},
+		{name: "expired beyond the refresh token lifetime", minutes: 90, wantErr: model.ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, f := newTestUserUseCase(t)
+
+			u := f.addUser("alice")
+			refreshToken := "2b1c4d9e-7f3a-4e52-9a66-0d8f1b3c5e7a"
+			f.web.refreshTokens[u.Id] = refreshToken
+			in := &dto.UpdateToken{AccessToken: expiredAccessToken(t, u, tt.minutes), RefreshToken: refreshToken}
+
+			item, err := uc.UpdateToken(context.Background(), in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateToken error = %v, want %v", err, tt.wantErr)
+			}
+			if (item != nil) != (tt.wantErr == nil) {
+				t.Fatalf("UpdateToken = %+v, %v, want tokens only without error", item, err)
+			}
+		})
+	}
+}
+
+func TestUpdateTokenOutsideRefreshWindow(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	config.Conf.Jwt.RefreshWindow = 60
+
+	u := f.addUser("alice")
+	refreshToken := "2b1c4d9e-7f3a-4e52-9a66-0d8f1b3c5e7a"
+	f.web.refreshTokens[u.Id] = refreshToken
+
+	// the access token of 15 minutes is not about to expire
+	_, err := uc.UpdateToken(context.Background(), &dto.UpdateToken{AccessToken: accessToken(t, u), RefreshToken: refreshToken})
+	if !errors.Is(err, model.ErrForbidden) {
+		t.Fatalf("UpdateToken error = %v, want ErrForbidden", err)
+	}
+}
+
+func TestUpdateTokenRefusesForgedToken(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+
+	u := f.addUser("alice")
+	refreshToken := "2b1c4d9e-7f3a-4e52-9a66-0d8f1b3c5e7a"
+	f.web.refreshTokens[u.Id] = refreshToken
+	token := expiredAccessToken(t, u, 5)
+
+	// an expired token signed with another secret is not refreshed
+	config.Conf.Jwt.Secret = "other-secret"
+	_, err := uc.UpdateToken(context.Background(), &dto.UpdateToken{AccessToken: token, RefreshToken: refreshToken})
+	if !errors.Is(err, model.ErrUnauthorized) {
+		t.Fatalf("UpdateToken error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestLogoutExpired(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+
+	u := f.addUser("alice")
+	refreshToken := "2b1c4d9e-7f3a-4e52-9a66-0d8f1b3c5e7a"
+	f.web.refreshTokens[u.Id] = refreshToken
+
+	err := uc.Logout(context.Background(), &dto.UpdateToken{AccessToken: expiredAccessToken(t, u, 5), RefreshToken: refreshToken})
+	if err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, ok := f.web.refreshTokens[u.Id]; ok {
+		t.Fatalf("refresh token kept, want it deleted")
+	}
+}
@@ -1,23 +1,86 @@
 package usecase
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/go-redis/redis/v8"
 
+	"authenticator/config"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase/notify"
+	"authenticator/internal/usecase/publish"
 	"authenticator/internal/usecase/repo"
 	"authenticator/internal/usecase/web"
+	"authenticator/internal/usecase/webhook"
 	"authenticator/pkg/postgres"
 )
 
 type UseCases struct {
-	UserUseCase *UserUseCase
+	UserUseCase     *UserUseCase
+	OutboxUseCase   *OutboxUseCase
+	WebhookUseCase  *WebhookUseCase
+	RelationUseCase *RelationUseCase
+	// UserCache is nil when USER_CACHE_SIZE is 0
+	UserCache *repo.CachedUserRepo
 }
 
-func LoadUseCases(pg *postgres.Postgres, cache *redis.Client) *UseCases {
+func LoadUseCases(pg *postgres.Postgres, cache *redis.Client) (*UseCases, error) {
 	txRepo := repo.NewTx(pg)
-	userRepo := repo.NewUser(pg)
+	var userRepo UserRepo = repo.NewUser(pg)
+	var userCache *repo.CachedUserRepo
+	if cfg := config.Conf.UserCache; cfg.Size > 0 {
+		userCache = repo.NewCachedUser(repo.NewUser(pg), txRepo, cache, cfg.Size, time.Duration(cfg.TTL)*time.Second, cfg.Redis, cfg.Channel)
+		userRepo = userCache
+	}
+	inviteRepo := repo.NewInvite(pg)
+	impersonationRepo := repo.NewImpersonation(pg)
+	auditRepo := repo.NewAudit(pg)
+	outboxRepo := repo.NewOutbox(pg)
+	webhookRepo := repo.NewWebhook(pg)
+	groupRepo := repo.NewGroup(pg)
+	relationRepo := repo.NewRelation(pg)
+	policyRepo := repo.NewPolicy(pg)
 	w := web.NewWebAPI(cache)
+	sender := notify.NewLocalSender(config.Conf.Notify.File)
+
+	namespaces, err := loadNamespaces(config.Conf.Relation.Namespaces)
+	if err != nil {
+		return nil, err
+	}
 
 	return &UseCases{
-		UserUseCase: NewUserUseCase(userRepo, txRepo, w),
+		UserUseCase:     NewUserUseCase(userRepo, inviteRepo, impersonationRepo, auditRepo, outboxRepo, groupRepo, policyRepo, txRepo, w, sender),
+		OutboxUseCase:   NewOutboxUseCase(outboxRepo, webhookRepo, txRepo, newPublisher(cache)),
+		WebhookUseCase:  NewWebhookUseCase(webhookRepo, txRepo, webhook.NewHttpDeliverer(time.Duration(config.Conf.Webhook.Timeout)*time.Second, config.Conf.Webhook.AllowPrivate)),
+		RelationUseCase: NewRelationUseCase(relationRepo, txRepo, namespaces, config.Conf.Relation.MaxDepth),
+		UserCache:       userCache,
+	}, nil
+}
+
+// loadNamespaces reads the namespace configuration of the relation store from the json file,
+// an array of namespaces, or returns the built-in namespaces when there is no file.
+func loadNamespaces(file string) (model.Namespaces, error) {
+	configs := model.DefaultNamespaces
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		configs = nil
+		if err = json.Unmarshal(b, &configs); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return model.NewNamespaces(configs)
+}
+
+func newPublisher(cache *redis.Client) Publisher {
+	cfg := config.Conf.Outbox
+	if cfg.Publisher == "redis" {
+		return publish.NewRedisStreamPublisher(cache, cfg.Stream, cfg.StreamMaxLen)
 	}
+	return publish.NewLogPublisher()
 }
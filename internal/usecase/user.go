@@ -2,30 +2,57 @@ package usecase
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/bcrypt"
 
+	"authenticator/config"
 	"authenticator/internal/dto"
 	"authenticator/internal/model"
+	"authenticator/pkg/cache"
+	"authenticator/pkg/metrics"
+	"authenticator/pkg/policy"
 	"authenticator/pkg/util"
+	"authenticator/pkg/validation"
 )
 
 // UserUseCase -.
 type UserUseCase struct {
-	repo   UserRepo
-	txRepo TxRepo
-	webAPI WebAPI
+	repo              UserRepo
+	inviteRepo        InviteRepo
+	impersonationRepo ImpersonationRepo
+	auditRepo         AuditRepo
+	outboxRepo        OutboxRepo
+	groupRepo         GroupRepo
+	policyRepo        PolicyRepo
+	txRepo            TxRepo
+	webAPI            WebAPI
+	sender            Sender
+	policyMu          sync.Mutex
+	policies          *cache.LRU[string, *policy.Expr]
 }
 
 // NewUserUseCase -.
-func NewUserUseCase(r UserRepo, tx TxRepo, w WebAPI) *UserUseCase {
+func NewUserUseCase(r UserRepo, i InviteRepo, ir ImpersonationRepo, a AuditRepo, o OutboxRepo, g GroupRepo, p PolicyRepo, tx TxRepo, w WebAPI, s Sender) *UserUseCase {
 	return &UserUseCase{
-		repo:   r,
-		txRepo: tx,
-		webAPI: w,
+		repo:              r,
+		inviteRepo:        i,
+		impersonationRepo: ir,
+		auditRepo:         a,
+		outboxRepo:        o,
+		groupRepo:         g,
+		policyRepo:        p,
+		txRepo:            tx,
+		webAPI:            w,
+		sender:            s,
+		policies:          cache.NewLRU[string, *policy.Expr](compiledPolicies, 24*time.Hour),
 	}
 }
 
@@ -34,6 +61,12 @@ func (uc *UserUseCase) Auth(ctx context.Context, in *dto.AuthRequest) (*dto.Auth
 		Str("unit", "internal.usecase.UserUseCase").
 		Str("method", "Login").Logger()
 
+	err := uc.checkLoginFailures(ctx, in.Username, loginMethodPassword)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkLoginFailures")
+		return nil, err
+	}
+
 	user, err := uc.repo.GetByUsername(ctx, in.Username)
 	if err != nil {
 		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
@@ -43,7 +76,7 @@ func (uc *UserUseCase) Auth(ctx context.Context, in *dto.AuthRequest) (*dto.Auth
 	if user == nil {
 		eMsg := fmt.Sprintf("User with username = <%s> not found", in.Username)
 		zLog.Err(fmt.Errorf("user not found")).Msg(eMsg)
-		return nil, model.ErrUnauthorized
+		return nil, uc.loginFailed(ctx, in.Username, loginMethodPassword, loginFailureUnknownUser)
 	}
 	defer func() {
 		if err != nil {
@@ -51,17 +84,49 @@ func (uc *UserUseCase) Auth(ctx context.Context, in *dto.AuthRequest) (*dto.Auth
 		}
 	}()
 
+	if user.State == model.Invited {
+		zLog.Error().Msgf("User with username = <%s> has not accepted the invite", in.Username)
+		return nil, uc.loginFailed(ctx, in.Username, loginMethodPassword, loginFailureNotEnabled)
+	}
+
 	if err = util.VerifyPasswordFromHash(in.Password, user.Password); err != nil {
 		zLog.Err(err).Msg("error verifying password")
 
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			err = model.ErrUnauthorized
+			err = uc.loginFailed(ctx, in.Username, loginMethodPassword, loginFailureWrongPassword)
 		}
 
 		return nil, err
 	}
 
-	accessToken, err := dto.GenerateAccessToken(user.Id)
+	item, err := uc.issueTokens(ctx, user, loginMethodPassword)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.issueTokens")
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// issueTokens starts a new session for the user logged in with the method.
+func (uc *UserUseCase) issueTokens(ctx context.Context, user *model.User, method string) (*dto.AuthResponse, error) {
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "issueTokens").Logger()
+
+	err := uc.webAPI.ResetLoginFailures(ctx, user.Username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.ResetLoginFailures")
+		return nil, err
+	}
+
+	access, err := uc.effectiveAccess(ctx, user)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.effectiveAccess")
+		return nil, err
+	}
+
+	accessToken, err := dto.GenerateAccessToken(user, access.Roles)
 	if err != nil {
 		zLog.Err(err).Msg("UserUseCase - error processing dto.GenerateAccessToken()")
 		return nil, err
@@ -79,6 +144,19 @@ func (uc *UserUseCase) Auth(ctx context.Context, in *dto.AuthRequest) (*dto.Auth
 		return nil, err
 	}
 
+	err = uc.auditAlone(ctx, &model.AuditEvent{
+		Type:           model.AuditAuthLogin,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+		Details:        map[string]string{"method": method},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.auditAlone")
+		return nil, err
+	}
+
+	metrics.Logins.WithLabelValues(method).Inc()
+
 	item := &dto.AuthResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken.String(),
@@ -102,6 +180,11 @@ func (uc *UserUseCase) Create(ctx context.Context, in *dto.Create) error {
 		return model.ErrConflict
 	}
 
+	if err = validateProfile(in.Email, in.Phone, in.Attributes); err != nil {
+		zLog.Err(err).Msg("UserUseCase - error validateProfile")
+		return model.ErrBadRequest
+	}
+
 	pwdHash, err := util.HashPassword(in.Password)
 	if err != nil {
 		zLog.Err(err).Msg("UserUseCase - error util.HashPassword")
@@ -124,11 +207,14 @@ func (uc *UserUseCase) Create(ctx context.Context, in *dto.Create) error {
 
 	now := util.NowUTC()
 	userModel := &model.User{
-		Username: in.Username,
-		Password: pwdHash,
-		State:    model.Enabled,
-		CreateTs: now,
-		UpdateTs: now,
+		Username:   in.Username,
+		Password:   pwdHash,
+		Email:      in.Email,
+		Phone:      in.Phone,
+		Attributes: in.Attributes,
+		State:      model.Enabled,
+		CreateTs:   now,
+		UpdateTs:   now,
 	}
 
 	err = uc.repo.Create(ctx, userModel, txId)
@@ -137,6 +223,22 @@ func (uc *UserUseCase) Create(ctx context.Context, in *dto.Create) error {
 		return err
 	}
 
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserCreate,
+		TargetId:       userModel.Id,
+		TargetUsername: userModel.Username,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserCreated, userModel.Id, userModel.Username, userModel.State)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
 	return nil
 }
 
@@ -156,6 +258,11 @@ func (uc *UserUseCase) ChangeState(ctx context.Context, in *dto.ChangeState) err
 		return model.ErrNotFound
 	}
 
+	// the update matches the version, so it fails when the user changed since the given one
+	if in.Version != 0 {
+		user.Version = in.Version
+	}
+
 	var txId int
 	txId, err = uc.txRepo.NewTxId(ctx)
 	if err != nil {
@@ -182,28 +289,176 @@ func (uc *UserUseCase) ChangeState(ctx context.Context, in *dto.ChangeState) err
 		return err
 	}
 
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserStateChange,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+		Details:        map[string]string{"from": string(user.State), "to": string(in.State)},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.StateEvent(in.State), user.Id, user.Username, in.State)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
 	return nil
 }
 
-func (uc *UserUseCase) Validate(ctx context.Context, token string) error {
+func (uc *UserUseCase) Validate(ctx context.Context, in *dto.Validate) (*dto.TokenInfo, error) {
 
 	zLog := zerolog.Ctx(ctx).With().
 		Str("unit", "internal.usecase.UserUseCase").
 		Str("method", "Validate").Logger()
 
-	userId, err := dto.VerifyAccessToken(token)
+	claims, err := parseValidated(in)
 	if err != nil {
-		zLog.Err(err).Msg("UserUseCase - error dto.VerifyAccessToken")
-		return model.ErrUnauthorized
+		zLog.Err(err).Msg("UserUseCase - error parseValidated")
+		return nil, model.ErrUnauthorized
 	}
 
-	_, err1 := uc.repo.GetById(ctx, userId)
-	if err1 != nil {
+	user, err := uc.repo.GetById(ctx, claims.ID)
+	if err != nil {
 		zLog.Err(err).Msg("UserUseCase - error uc.repo.GetById")
-		return err1
+		return nil, err
 	}
 
-	return nil
+	if user == nil {
+		eMsg := fmt.Sprintf("User with id = <%s> not found", claims.ID)
+		zLog.Error().Msg(eMsg)
+		return nil, model.ErrUnauthorized
+	}
+
+	// the tokens of a disabled user are refused until it is enabled again
+	if user.State != model.Enabled {
+		zLog.Error().Msgf("User with id = <%s> is %s", claims.ID, user.State)
+		return nil, model.ErrUnauthorized
+	}
+
+	access, err := uc.effectiveAccess(ctx, user)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.effectiveAccess")
+		return nil, err
+	}
+
+	return newTokenInfo(claims, user, access), nil
+}
+
+// maxValidateBatch is the most tokens one ValidateTokens call validates.
+const maxValidateBatch = 1000
+
+// ValidateTokens validates the tokens like Validate, with one query for the users of all of them
+// and one for their group grants.
+// Every token has its result, the call only fails when the users can not be read.
+func (uc *UserUseCase) ValidateTokens(ctx context.Context, in []dto.Validate) ([]dto.TokenResult, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ValidateTokens").
+		Int("tokens", len(in)).Logger()
+
+	if len(in) > maxValidateBatch {
+		zLog.Error().Msgf("UserUseCase - more than %d tokens", maxValidateBatch)
+		return nil, model.ErrBadRequest
+	}
+
+	results := make([]dto.TokenResult, len(in))
+	claims := make([]*dto.AuthTokenClaim, len(in))
+	ids := make([]uuid.UUID, 0, len(in))
+	seen := make(map[uuid.UUID]bool, len(in))
+	for i := range in {
+		c, err := parseValidated(&in[i])
+		if err != nil {
+			zLog.Debug().Err(err).Int("token", i).Msg("UserUseCase - error parseValidated")
+			results[i].Err = model.ErrUnauthorized
+			continue
+		}
+		claims[i] = c
+		if !seen[c.ID] {
+			seen[c.ID] = true
+			ids = append(ids, c.ID)
+		}
+	}
+
+	users, err := uc.repo.GetByIds(ctx, ids)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.repo.GetByIds")
+		return nil, err
+	}
+
+	byId := make(map[uuid.UUID]*model.User, len(users))
+	for i := range users {
+		byId[users[i].Id] = &users[i]
+	}
+
+	enabled := make([]model.User, 0, len(users))
+	for i := range users {
+		if users[i].State == model.Enabled {
+			enabled = append(enabled, users[i])
+		}
+	}
+
+	access, err := uc.effectiveAccesses(ctx, enabled)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.effectiveAccesses")
+		return nil, err
+	}
+
+	for i, c := range claims {
+		if c == nil {
+			continue
+		}
+
+		user, ok := byId[c.ID]
+		if !ok || user.State != model.Enabled {
+			results[i].Err = model.ErrUnauthorized
+			continue
+		}
+
+		results[i].Info = newTokenInfo(c, user, access[c.ID])
+	}
+
+	return results, nil
+}
+
+// parseValidated parses the access token and checks it is valid for the audience.
+func parseValidated(in *dto.Validate) (*dto.AuthTokenClaim, error) {
+	claims, err := dto.ParseAccessToken(in.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens without audience are accepted by every service, an audience-bound token only by its audience
+	if claims.Audience != "" && claims.Audience != in.Audience {
+		return nil, fmt.Errorf("token audience <%s> does not match <%s>", claims.Audience, in.Audience)
+	}
+
+	return claims, nil
+}
+
+// newTokenInfo describes the token, its permissions are the ones of the owner allowed by the scopes.
+func newTokenInfo(claims *dto.AuthTokenClaim, user *model.User, access *model.Access) *dto.TokenInfo {
+	permissions := make([]model.Permission, 0, len(access.Permissions))
+	for _, p := range access.Permissions {
+		if claims.Allows(p) {
+			permissions = append(permissions, p)
+		}
+	}
+
+	return &dto.TokenInfo{
+		UserId:      user.Id,
+		Username:    user.Username,
+		Roles:       access.Roles,
+		Groups:      access.Groups,
+		Permissions: permissions,
+		Actor:       claims.Act,
+		Scopes:      claims.Scopes(),
+		Audience:    claims.Audience,
+	}
 }
 
 func (uc *UserUseCase) UpdateToken(ctx context.Context, in *dto.UpdateToken) (*dto.UpdateToken, error) {
@@ -212,32 +467,59 @@ func (uc *UserUseCase) UpdateToken(ctx context.Context, in *dto.UpdateToken) (*d
 		Str("unit", "internal.usecase.UserUseCase").
 		Str("method", "UpdateToken").Logger()
 
-	userId, err := dto.VerifyAccessToken(in.AccessToken)
-	if err != nil && err.Error() != "Token is expired" {
-		if err.Error() != "Token is expired" {
-			zLog.Err(err).Msg("UserUseCase - error dto.VerifyAccessToken")
-			return nil, model.ErrUnauthorized
-		}
+	claims, err := dto.ParseAccessToken(in.AccessToken)
+	expired := dto.IsExpired(err)
+	if err != nil && !expired {
+		zLog.Err(err).Msg("UserUseCase - error dto.ParseAccessToken")
+		return nil, model.ErrUnauthorized
 	}
 
-	if err == nil {
+	// a token may be refreshed in the window before it expires, so clients never send an expired one,
+	// and after it expired for as long as the refresh token lives
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	window := time.Duration(config.Conf.Jwt.RefreshWindow) * time.Second
+	if !expired && time.Until(expiresAt) > window {
 		zLog.Info().Msg("UserUseCase - info - accessToken is not expired")
 		return nil, model.ErrForbidden
 	}
+	if expired && time.Since(expiresAt) > time.Duration(config.Conf.Jwt.RefreshTokenExpiry)*time.Minute {
+		zLog.Error().Msg("UserUseCase - accessToken expired before the refresh token")
+		return nil, model.ErrUnauthorized
+	}
+
+	// impersonation and exchanged tokens can not be refreshed
+	if claims.Act != nil || claims.Scope != "" || claims.Audience != "" {
+		zLog.Error().Msg("UserUseCase - token can not be refreshed")
+		return nil, model.ErrUnauthorized
+	}
+
+	userId := claims.ID
 
 	userById, err1 := uc.repo.GetById(ctx, userId)
 	if err1 != nil {
-		zLog.Err(err).Msg("UserUseCase - error uc.repo.GetById")
+		zLog.Err(err1).Msg("UserUseCase - error uc.repo.GetById")
+		return nil, err1
+	}
+
+	if userById == nil || userById.State != model.Enabled {
+		eMsg := fmt.Sprintf("User with id = <%s> not found or not enabled", userId)
+		zLog.Error().Msg(eMsg)
+		return nil, model.ErrUnauthorized
+	}
+
+	err = uc.checkRefreshToken(ctx, userId, in.RefreshToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkRefreshToken")
 		return nil, err
 	}
 
-	if userById == nil {
-		eMsg := fmt.Sprintf("User with id = <%s> not found", userId)
-		zLog.Err(nil).Msg(eMsg)
+	access, err := uc.effectiveAccess(ctx, userById)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.effectiveAccess")
 		return nil, err
 	}
 
-	accessToken, err := dto.GenerateAccessToken(userId)
+	accessToken, err := dto.GenerateAccessToken(userById, access.Roles)
 	if err != nil {
 		zLog.Err(err).Msg("UserUseCase - error processing dto.GenerateAccessToken()")
 		return nil, err
@@ -255,12 +537,552 @@ func (uc *UserUseCase) UpdateToken(ctx context.Context, in *dto.UpdateToken) (*d
 		return nil, err
 	}
 
+	err = uc.auditAlone(ctx, &model.AuditEvent{
+		Type:           model.AuditTokenRefresh,
+		TargetId:       userById.Id,
+		TargetUsername: userById.Username,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.auditAlone")
+		return nil, err
+	}
+
 	item := &dto.UpdateToken{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken.String(),
 	}
 
-	fmt.Println(item)
-
 	return item, nil
 }
+
+// Logout ends the session of the tokens, the refresh token can no longer be used.
+// The access token may be expired but must be signed by us.
+func (uc *UserUseCase) Logout(ctx context.Context, in *dto.UpdateToken) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "Logout").Logger()
+
+	claims, err := dto.ParseAccessToken(in.AccessToken)
+	if err != nil && !dto.IsExpired(err) {
+		zLog.Err(err).Msg("UserUseCase - error dto.ParseAccessToken")
+		return model.ErrUnauthorized
+	}
+
+	err = uc.checkRefreshToken(ctx, claims.ID, in.RefreshToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkRefreshToken")
+		return err
+	}
+
+	err = uc.webAPI.DeleteRefreshToken(ctx, claims.ID)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.DeleteRefreshToken")
+		return err
+	}
+
+	err = uc.auditAlone(ctx, &model.AuditEvent{
+		Type:     model.AuditLogout,
+		TargetId: claims.ID,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.auditAlone")
+		return err
+	}
+
+	return nil
+}
+
+// ActiveSessions returns the number of users with an active session.
+func (uc *UserUseCase) ActiveSessions(ctx context.Context) (int64, error) {
+	return uc.webAPI.CountRefreshTokens(ctx)
+}
+
+// checkRefreshToken fails with ErrUnauthorized unless the refresh token is the current one of the user.
+func (uc *UserUseCase) checkRefreshToken(ctx context.Context, userId uuid.UUID, refreshToken string) error {
+	current, err := uc.webAPI.GetRefreshTokenByID(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if current == uuid.Nil || subtle.ConstantTimeCompare([]byte(current.String()), []byte(refreshToken)) != 1 {
+		return model.ErrUnauthorized
+	}
+	return nil
+}
+
+func (uc *UserUseCase) UpdateUser(ctx context.Context, in *dto.UpdateUser) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "UpdateUser").Logger()
+
+	user, err := uc.repo.GetByUsername(ctx, in.Username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return err
+	}
+
+	if user == nil {
+		return model.ErrNotFound
+	}
+
+	email, phone, attributes, err := patchProfile(user, in)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error patchProfile")
+		return model.ErrBadRequest
+	}
+
+	if err = validateProfile(email, phone, attributes); err != nil {
+		zLog.Err(err).Msg("UserUseCase - error validateProfile")
+		return model.ErrBadRequest
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	// a changed contact has to be verified again
+	userModel := &model.User{
+		Email:         email,
+		EmailVerified: user.EmailVerified && user.Email == email,
+		Phone:         phone,
+		PhoneVerified: user.PhoneVerified && user.Phone == phone,
+		Attributes:    attributes,
+		UpdateTs:      util.NowUTC(),
+		Version:       util.VersionInc(user.Version),
+	}
+
+	err = uc.repo.UpdateProfile(ctx, user, userModel, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.UpdateProfile")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserUpdate,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserUpdated, user.Id, user.Username, user.State)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
+	return nil
+}
+
+func (uc *UserUseCase) GetUser(ctx context.Context, username string) (*model.User, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "GetUser").Logger()
+
+	user, err := uc.repo.GetByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, model.ErrNotFound
+	}
+
+	user.Password = ""
+
+	return user, nil
+}
+
+// GetUserById returns the user with the id, deleted users are not found.
+func (uc *UserUseCase) GetUserById(ctx context.Context, id uuid.UUID) (*model.User, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "GetUserById").Logger()
+
+	user, err := uc.repo.GetById(ctx, id)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetById")
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, model.ErrNotFound
+	}
+
+	return user, nil
+}
+
+// SearchUsers returns a page of the users matching the filter and the number of all matching users.
+func (uc *UserUseCase) SearchUsers(ctx context.Context, filter *model.UserFilter) ([]model.User, int, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "SearchUsers").Logger()
+
+	items, total, err := uc.repo.Search(ctx, filter)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.Search")
+		return nil, 0, err
+	}
+
+	return items, total, nil
+}
+
+// SetRoles replaces the roles of the user.
+func (uc *UserUseCase) SetRoles(ctx context.Context, username string, roles []string) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "SetRoles").Logger()
+
+	for _, role := range roles {
+		if err := validation.StringMustBeKey(role); err != nil {
+			zLog.Err(err).Msgf("UserUseCase - invalid role <%s>", role)
+			return model.ErrBadRequest
+		}
+	}
+
+	user, err := uc.repo.GetByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return err
+	}
+
+	if user == nil {
+		return model.ErrNotFound
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	userModel := &model.User{
+		Roles:    roles,
+		UpdateTs: util.NowUTC(),
+		Version:  util.VersionInc(user.Version),
+	}
+
+	err = uc.repo.UpdateRoles(ctx, user, userModel, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.UpdateRoles")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserUpdate,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+		Details:        map[string]string{"roles": strings.Join(roles, ",")},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserUpdated, user.Id, user.Username, user.State)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
+	return nil
+}
+
+func (uc *UserUseCase) RestoreUser(ctx context.Context, username string) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "RestoreUser").Logger()
+
+	user, err := uc.repo.GetDeletedByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetDeletedByUsername")
+		return err
+	}
+
+	if user == nil {
+		return model.ErrNotFound
+	}
+
+	active, err := uc.repo.GetByUsername(ctx, username)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.GetByUsername")
+		return err
+	}
+
+	if active != nil {
+		eMsg := fmt.Sprintf("Username <%s> is already taken by another user", username)
+		zLog.Err(model.ErrConflict).Msg(eMsg)
+		return model.ErrConflict
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	userModel := &model.User{
+		State:    model.Enabled,
+		UpdateTs: util.NowUTC(),
+		Version:  util.VersionInc(user.Version),
+	}
+
+	err = uc.repo.ChangeState(ctx, user, userModel, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing d.repo.ChangeState")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserRestore,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.emit(ctx, txId, model.EventUserEnabled, user.Id, user.Username, model.Enabled)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+		return err
+	}
+
+	return nil
+}
+
+func (uc *UserUseCase) PurgeUser(ctx context.Context, username string) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "PurgeUser").Logger()
+
+	var txId int
+	txId, err := uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	ids, err := uc.repo.PurgeByUsername(ctx, username, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.PurgeByUsername")
+		return err
+	}
+
+	if len(ids) == 0 {
+		err = model.ErrNotFound
+		return err
+	}
+
+	for _, id := range ids {
+		err = uc.audit(ctx, txId, &model.AuditEvent{
+			Type:           model.AuditUserPurge,
+			TargetId:       id,
+			TargetUsername: username,
+		})
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+			return err
+		}
+
+		err = uc.emit(ctx, txId, model.EventUserPurged, id, username, "")
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+			return err
+		}
+	}
+
+	err = uc.revokeSessions(ctx, txId, ids)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.revokeSessions")
+		return err
+	}
+
+	return nil
+}
+
+// PurgeDeleted permanently removes users that were deleted before the given time,
+// together with their sessions. It is run periodically by the purge job.
+func (uc *UserUseCase) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "PurgeDeleted").Logger()
+
+	var txId int
+	txId, err := uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return 0, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	ids, err := uc.repo.PurgeDeletedBefore(ctx, before, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.PurgeDeletedBefore")
+		return 0, err
+	}
+
+	for _, id := range ids {
+		err = uc.audit(ctx, txId, &model.AuditEvent{
+			Type:     model.AuditUserPurge,
+			TargetId: id,
+			Details:  map[string]string{"reason": "retention"},
+		})
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+			return 0, err
+		}
+
+		err = uc.emit(ctx, txId, model.EventUserPurged, id, "", "")
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing uc.emit")
+			return 0, err
+		}
+	}
+
+	err = uc.revokeSessions(ctx, txId, ids)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.revokeSessions")
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func (uc *UserUseCase) revokeSessions(ctx context.Context, txId int, ids []uuid.UUID) error {
+	for _, id := range ids {
+		if err := uc.emit(ctx, txId, model.EventUserSessionsRevoked, id, "", ""); err != nil {
+			return err
+		}
+		if err := uc.webAPI.DeleteRefreshToken(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchProfile returns the profile of the user with the update applied. Without a mask the email and
+// phone given replace the stored ones and the attributes given are merged, with a mask exactly the
+// fields it names are replaced, empty values clearing them.
+func patchProfile(user *model.User, in *dto.UpdateUser) (email, phone string, attributes map[string]string, err error) {
+
+	email, phone = user.Email, user.Phone
+	attributes = make(map[string]string, len(user.Attributes)+len(in.Attributes))
+	for k, v := range user.Attributes {
+		attributes[k] = v
+	}
+
+	if len(in.Mask) == 0 {
+		if in.Email != "" {
+			email = in.Email
+		}
+		if in.Phone != "" {
+			phone = in.Phone
+		}
+		for k, v := range in.Attributes {
+			attributes[k] = v
+		}
+		return email, phone, attributes, nil
+	}
+
+	for _, path := range in.Mask {
+		switch {
+		case path == "email":
+			email = in.Email
+		case path == "phone":
+			phone = in.Phone
+		case path == "attributes":
+			attributes = make(map[string]string, len(in.Attributes))
+			for k, v := range in.Attributes {
+				attributes[k] = v
+			}
+		case strings.HasPrefix(path, "attributes."):
+			key := strings.TrimPrefix(path, "attributes.")
+			if v, ok := in.Attributes[key]; ok {
+				attributes[key] = v
+			} else {
+				delete(attributes, key)
+			}
+		default:
+			return "", "", nil, fmt.Errorf("unknown update mask path %q", path)
+		}
+	}
+
+	return email, phone, attributes, nil
+}
+
+func validateProfile(email, phone string, attributes map[string]string) error {
+	if email != "" {
+		if err := validation.EmailValidate(email); err != nil {
+			return err
+		}
+	}
+
+	if phone != "" {
+		if err := validation.MobilePhoneValidate(phone); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range attributes {
+		if err := validation.StringMustBeKey(k); err != nil {
+			return err
+		}
+		if err := validation.StringCanBeEmptyButNotExceedMaxLength(v, 256); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+func TestUpdateUserPatchesProfile(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		in        dto.UpdateUser
+		wantEmail string
+		wantPhone string
+		wantAttrs map[string]string
+	}{
+		{
+			name:      "without mask empty fields are kept",
+			in:        dto.UpdateUser{Attributes: map[string]string{"team": "blue"}},
+			wantEmail: "old@example.com",
+			wantPhone: "99361000001",
+			wantAttrs: map[string]string{"dept": "ops", "team": "blue"},
+		},
+		{
+			name:      "without mask given fields replace",
+			in:        dto.UpdateUser{Email: "new@example.com"},
+			wantEmail: "new@example.com",
+			wantPhone: "99361000001",
+			wantAttrs: map[string]string{"dept": "ops"},
+		},
+		{
+			name:      "mask clears the fields it names",
+			in:        dto.UpdateUser{Mask: []string{"phone"}},
+			wantEmail: "old@example.com",
+			wantAttrs: map[string]string{"dept": "ops"},
+		},
+		{
+			name:      "mask replaces the attributes",
+			in:        dto.UpdateUser{Email: "ignored@example.com", Attributes: map[string]string{"team": "red"}, Mask: []string{"attributes"}},
+			wantEmail: "old@example.com",
+			wantPhone: "99361000001",
+			wantAttrs: map[string]string{"team": "red"},
+		},
+		{
+			name:      "mask removes a single attribute",
+			in:        dto.UpdateUser{Mask: []string{"attributes.dept"}},
+			wantEmail: "old@example.com",
+			wantPhone: "99361000001",
+			wantAttrs: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, f := newTestUserUseCase(t)
+			u := f.addUser("alice")
+			u.Email, u.EmailVerified = "old@example.com", true
+			u.Phone, u.PhoneVerified = "99361000001", true
+			u.Attributes = map[string]string{"dept": "ops"}
+			f.users.put(u)
+
+			in := tt.in
+			in.Username = "alice"
+			if err := uc.UpdateUser(context.Background(), &in); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+
+			got := f.users.get(u.Id)
+			if got.Email != tt.wantEmail || got.Phone != tt.wantPhone {
+				t.Errorf("email, phone = %q, %q, want %q, %q", got.Email, got.Phone, tt.wantEmail, tt.wantPhone)
+			}
+			if !reflect.DeepEqual(got.Attributes, tt.wantAttrs) {
+				t.Errorf("attributes = %v, want %v", got.Attributes, tt.wantAttrs)
+			}
+			if got.EmailVerified != (got.Email == "old@example.com") {
+				t.Errorf("email verified = %v for email %q", got.EmailVerified, got.Email)
+			}
+		})
+	}
+}
+
+func TestUpdateUserRejectsUnknownMaskPath(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	f.addUser("alice")
+
+	err := uc.UpdateUser(context.Background(), &dto.UpdateUser{Username: "alice", Mask: []string{"password"}})
+	if !errors.Is(err, model.ErrBadRequest) {
+		t.Fatalf("UpdateUser = %v, want ErrBadRequest", err)
+	}
+}
+
+func TestValidateRefusesUsersNotEnabled(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	token := accessToken(t, u)
+
+	if _, err := uc.Validate(context.Background(), &dto.Validate{AccessToken: token}); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	u.State = model.Disabled
+	f.users.put(u)
+
+	if _, err := uc.Validate(context.Background(), &dto.Validate{AccessToken: token}); !errors.Is(err, model.ErrUnauthorized) {
+		t.Fatalf("Validate = %v, want ErrUnauthorized for a disabled user", err)
+	}
+
+	results, err := uc.ValidateTokens(context.Background(), []dto.Validate{{AccessToken: token}})
+	if err != nil {
+		t.Fatalf("ValidateTokens: %v", err)
+	}
+	if !errors.Is(results[0].Err, model.ErrUnauthorized) {
+		t.Fatalf("ValidateTokens = %+v, want ErrUnauthorized for a disabled user", results[0])
+	}
+}
+
+func TestValidateTokensReadsGrantsOnce(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	alice := f.addUser("alice")
+	bob := f.addUser("bob", model.RoleSupport)
+	f.groups.grants[alice.Id] = &model.GroupGrant{Groups: []string{"ops"}, Roles: []string{model.RoleAdmin}}
+	f.groups.grantOf = func(uuid.UUID) {
+		t.Errorf("GrantOf called, want the grants of the batch read with GrantsOf")
+	}
+
+	in := []dto.Validate{
+		{AccessToken: accessToken(t, alice)},
+		{AccessToken: accessToken(t, bob)},
+		{AccessToken: accessToken(t, alice)},
+	}
+	results, err := uc.ValidateTokens(context.Background(), in)
+	if err != nil {
+		t.Fatalf("ValidateTokens: %v", err)
+	}
+	if f.groups.grantsOf != 1 {
+		t.Fatalf("GrantsOf called %d times, want 1", f.groups.grantsOf)
+	}
+
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("result %d: %v", i, r.Err)
+		}
+	}
+	if !reflect.DeepEqual(results[0].Info.Groups, []string{"ops"}) {
+		t.Fatalf("groups of alice = %v, want [ops]", results[0].Info.Groups)
+	}
+	if !slices.Contains(results[0].Info.Permissions, model.PermissionUserWrite) {
+		t.Fatalf("permissions of alice = %v, want the ones of her group", results[0].Info.Permissions)
+	}
+	if slices.Contains(results[1].Info.Permissions, model.PermissionUserWrite) {
+		t.Fatalf("permissions of bob = %v, want only the ones of support", results[1].Info.Permissions)
+	}
+}
@@ -0,0 +1,232 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+func verificationPurpose(channel model.Channel) string {
+	return fmt.Sprintf("verification:%s", channel)
+}
+
+// StartVerification sends a one-time code to the email or phone of the token owner.
+func (uc *UserUseCase) StartVerification(ctx context.Context, in *dto.StartVerification) (time.Duration, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "StartVerification").Logger()
+
+	user, err := uc.userByToken(ctx, in.AccessToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.userByToken")
+		return 0, err
+	}
+
+	target, verified := contactOf(user, in.Channel)
+	if target == "" {
+		zLog.Error().Msgf("User <%s> has no %s", user.Id, in.Channel)
+		return 0, model.ErrBadRequest
+	}
+
+	if verified {
+		return 0, model.ErrConflict
+	}
+
+	cfg := config.Conf.Verification
+
+	code, err := util.GenerateNumericCode(cfg.CodeLength)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error util.GenerateNumericCode")
+		return 0, err
+	}
+
+	ttl := time.Duration(cfg.CodeExpiry) * time.Minute
+	err = uc.webAPI.AddOneTimeCode(ctx, verificationPurpose(in.Channel), user.Id, &model.OneTimeCode{
+		CodeHash: util.HashCode(code),
+		Target:   target,
+	}, ttl)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.AddOneTimeCode")
+		return 0, err
+	}
+
+	err = uc.sender.Send(ctx, &dto.Message{
+		Channel: in.Channel,
+		To:      target,
+		Subject: "Verification code",
+		Body:    fmt.Sprintf("Your verification code is %s", code),
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.sender.Send")
+		return 0, err
+	}
+
+	return ttl, nil
+}
+
+// ConfirmVerification checks the code sent by StartVerification and marks the contact as verified.
+func (uc *UserUseCase) ConfirmVerification(ctx context.Context, in *dto.ConfirmVerification) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.UserUseCase").
+		Str("method", "ConfirmVerification").Logger()
+
+	user, err := uc.userByToken(ctx, in.AccessToken)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.userByToken")
+		return err
+	}
+
+	purpose := verificationPurpose(in.Channel)
+
+	target, _ := contactOf(user, in.Channel)
+	err = uc.checkOneTimeCode(ctx, purpose, user, in.Code, target)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.checkOneTimeCode")
+		return err
+	}
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("UserUseCase - error processing r.txRepo.TxEnd")
+			return
+		}
+	}()
+
+	userModel := &model.User{
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified || in.Channel == model.Email,
+		Phone:         user.Phone,
+		PhoneVerified: user.PhoneVerified || in.Channel == model.Phone,
+		Attributes:    user.Attributes,
+		UpdateTs:      util.NowUTC(),
+		Version:       util.VersionInc(user.Version),
+	}
+
+	err = uc.repo.UpdateProfile(ctx, user, userModel, txId)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.repo.UpdateProfile")
+		return err
+	}
+
+	err = uc.audit(ctx, txId, &model.AuditEvent{
+		Type:           model.AuditUserVerification,
+		ActorId:        user.Id,
+		ActorUsername:  user.Username,
+		TargetId:       user.Id,
+		TargetUsername: user.Username,
+		Details:        map[string]string{"channel": string(in.Channel)},
+	})
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error processing uc.audit")
+		return err
+	}
+
+	err = uc.webAPI.DeleteOneTimeCode(ctx, purpose, user.Id)
+	if err != nil {
+		zLog.Err(err).Msg("UserUseCase - error uc.webAPI.DeleteOneTimeCode")
+		return err
+	}
+
+	return nil
+}
+
+// checkOneTimeCode validates a code stored for the purpose and sent to one of the targets. The attempt
+// is counted before the code is compared, so concurrent guesses can not pass the attempts limit. The
+// code is dropped once the limit is passed or when it was sent to another target.
+func (uc *UserUseCase) checkOneTimeCode(ctx context.Context, purpose string, user *model.User, code string, targets ...string) error {
+
+	stored, err := uc.webAPI.UseOneTimeCode(ctx, purpose, user.Id)
+	if err != nil {
+		return err
+	}
+
+	if stored == nil {
+		return model.ErrNotFound
+	}
+
+	if stored.Target == "" || !slices.Contains(targets, stored.Target) {
+		if err = uc.webAPI.DeleteOneTimeCode(ctx, purpose, user.Id); err != nil {
+			return err
+		}
+		return model.ErrNotFound
+	}
+
+	if stored.Attempts > config.Conf.Verification.MaxAttempts {
+		if err = uc.webAPI.DeleteOneTimeCode(ctx, purpose, user.Id); err != nil {
+			return err
+		}
+		return model.ErrTooManyRequests
+	}
+
+	if !util.VerifyCode(code, stored.CodeHash) {
+		return model.ErrUnauthorized
+	}
+
+	return nil
+}
+
+func (uc *UserUseCase) userByToken(ctx context.Context, token string) (*model.User, error) {
+
+	user, _, err := uc.tokenOwner(ctx, token)
+
+	return user, err
+}
+
+// tokenOwner returns the user the valid access token was issued to, together with its claims.
+func (uc *UserUseCase) tokenOwner(ctx context.Context, token string) (*model.User, *dto.AuthTokenClaim, error) {
+
+	claims, err := dto.ParseAccessToken(token)
+	if err != nil {
+		return nil, nil, model.ErrUnauthorized
+	}
+
+	// a token exchanged for another service is not accepted by this one
+	if claims.Audience != "" && claims.Audience != config.Conf.Jwt.Audience {
+		return nil, nil, model.ErrUnauthorized
+	}
+
+	user, err := uc.repo.GetById(ctx, claims.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user == nil || user.State != model.Enabled {
+		return nil, nil, model.ErrUnauthorized
+	}
+
+	// permission checks on the owner see the roles granted by its groups too
+	access, err := uc.effectiveAccess(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	user.Roles = access.Roles
+
+	return user, claims, nil
+}
+
+func contactOf(user *model.User, channel model.Channel) (target string, verified bool) {
+	switch channel {
+	case model.Email:
+		return user.Email, user.EmailVerified
+	case model.Phone:
+		return user.Phone, user.PhoneVerified
+	}
+	return "", false
+}
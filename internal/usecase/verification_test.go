@@ -0,0 +1,118 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+// startEmailVerification sends a code to the email of the user and returns it.
+func startEmailVerification(t *testing.T, uc *UserUseCase, f *fakes, token string) string {
+	t.Helper()
+
+	_, err := uc.StartVerification(context.Background(), &dto.StartVerification{AccessToken: token, Channel: model.Email})
+	if err != nil {
+		t.Fatalf("StartVerification: %v", err)
+	}
+
+	msg := f.sender.last()
+	if msg == nil {
+		t.Fatal("no code was sent")
+	}
+	fields := strings.Fields(msg.Body)
+	return fields[len(fields)-1]
+}
+
+func TestConfirmVerification(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Email = "alice@example.com"
+	f.users.put(u)
+	token := accessToken(t, u)
+
+	code := startEmailVerification(t, uc, f, token)
+
+	err := uc.ConfirmVerification(context.Background(), &dto.ConfirmVerification{AccessToken: token, Channel: model.Email, Code: "000000" + code})
+	if !errors.Is(err, model.ErrUnauthorized) {
+		t.Fatalf("wrong code: ConfirmVerification = %v, want ErrUnauthorized", err)
+	}
+
+	err = uc.ConfirmVerification(context.Background(), &dto.ConfirmVerification{AccessToken: token, Channel: model.Email, Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmVerification: %v", err)
+	}
+	if !f.users.get(u.Id).EmailVerified {
+		t.Fatal("email is not verified")
+	}
+
+	// the code is single-use
+	err = uc.ConfirmVerification(context.Background(), &dto.ConfirmVerification{AccessToken: token, Channel: model.Email, Code: code})
+	if !errors.Is(err, model.ErrNotFound) {
+		t.Fatalf("reused code: ConfirmVerification = %v, want ErrNotFound", err)
+	}
+}
+
+func TestConfirmVerificationLimitsConcurrentAttempts(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Email = "alice@example.com"
+	f.users.put(u)
+	token := accessToken(t, u)
+
+	code := startEmailVerification(t, uc, f, token)
+
+	const guesses = 20
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		unauthorized int
+	)
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := uc.ConfirmVerification(context.Background(), &dto.ConfirmVerification{AccessToken: token, Channel: model.Email, Code: "x"})
+			if errors.Is(err, model.ErrUnauthorized) {
+				mu.Lock()
+				unauthorized++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max := config.Conf.Verification.MaxAttempts; unauthorized != max {
+		t.Fatalf("%d guesses were compared, want %d", unauthorized, max)
+	}
+
+	// the code was dropped once the limit was passed
+	err := uc.ConfirmVerification(context.Background(), &dto.ConfirmVerification{AccessToken: token, Channel: model.Email, Code: code})
+	if !errors.Is(err, model.ErrNotFound) {
+		t.Fatalf("ConfirmVerification after the limit = %v, want ErrNotFound", err)
+	}
+}
+
+func TestConfirmVerificationRejectsChangedContact(t *testing.T) {
+	uc, f := newTestUserUseCase(t)
+	u := f.addUser("alice")
+	u.Email = "alice@example.com"
+	f.users.put(u)
+	token := accessToken(t, u)
+
+	code := startEmailVerification(t, uc, f, token)
+
+	changed := f.users.get(u.Id)
+	changed.Email = "mallory@example.com"
+	f.users.put(changed)
+
+	err := uc.ConfirmVerification(context.Background(), &dto.ConfirmVerification{AccessToken: token, Channel: model.Email, Code: code})
+	if !errors.Is(err, model.ErrNotFound) {
+		t.Fatalf("ConfirmVerification = %v, want ErrNotFound", err)
+	}
+}
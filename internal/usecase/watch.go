@@ -0,0 +1,180 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+)
+
+// headNotifier wakes up watchers when the newest outbox position moves.
+type headNotifier struct {
+	mu    sync.Mutex
+	pos   int64
+	moved chan struct{}
+}
+
+func newHeadNotifier() *headNotifier {
+	return &headNotifier{
+		moved: make(chan struct{}),
+	}
+}
+
+// wait returns a channel that is closed the next time the head moves.
+func (n *headNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.moved
+}
+
+func (n *headNotifier) advance(pos int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if pos <= n.pos {
+		return
+	}
+	n.pos = pos
+	close(n.moved)
+	n.moved = make(chan struct{})
+}
+
+// WatchHead numbers the committed outbox events, written by any instance, polls the newest position
+// and wakes up the watchers whenever it moves. It returns when the context is done.
+func (uc *OutboxUseCase) WatchHead(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.OutboxUseCase").
+		Str("method", "WatchHead").Logger()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := uc.number(ctx, config.Conf.Watch.BatchSize); err != nil {
+				zLog.Err(err).Msg("OutboxUseCase - error processing uc.number")
+			}
+
+			_, newest, err := uc.repo.PosRange(ctx)
+			if err != nil {
+				zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.PosRange")
+				continue
+			}
+			uc.head.advance(newest)
+		}
+	}
+}
+
+// number gives positions to the committed events that have none, in batches of limit events.
+// Writers never wait on each other for an order, only the instances numbering events do.
+func (uc *OutboxUseCase) number(ctx context.Context, limit int) error {
+	for ctx.Err() == nil {
+		n, err := uc.numberBatch(ctx, limit)
+		if err != nil || n < int64(limit) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (uc *OutboxUseCase) numberBatch(ctx context.Context, limit int) (n int64, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.OutboxUseCase").
+		Str("method", "numberBatch").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing r.txRepo.NewTxId")
+		return 0, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("OutboxUseCase - error processing r.txRepo.TxEnd")
+			n = 0
+		}
+	}()
+
+	n, err = uc.repo.AssignPositions(ctx, limit, txId)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.AssignPositions")
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// WatchUserEvents sends matching outbox events in order until the context is done or send fails.
+// Events are read from the database in batches at the pace of send, so a slow consumer only
+// holds one batch in memory and gRPC flow control pushes back on the loop.
+func (uc *OutboxUseCase) WatchUserEvents(ctx context.Context, in *dto.WatchUserEvents, send func(ev *model.OutboxEvent) error) error {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.OutboxUseCase").
+		Str("method", "WatchUserEvents").
+		Int64("cursor", in.Cursor).Logger()
+
+	cfg := config.Conf.Watch
+	if uc.streams.Add(1) > int64(cfg.MaxStreams) {
+		uc.streams.Add(-1)
+		return model.ErrTooManyRequests
+	}
+	defer uc.streams.Add(-1)
+
+	oldest, newest, err := uc.repo.PosRange(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.PosRange")
+		return err
+	}
+
+	cursor := in.Cursor
+	switch {
+	case cursor > 0:
+		// events between the cursor and the oldest retained one were purged
+		if oldest > 0 && cursor < oldest-1 {
+			return model.ErrCursorExpired
+		}
+	case in.FromOldest:
+		cursor = 0
+	default:
+		cursor = newest
+	}
+
+	for {
+		// taken before reading so an event committed during the read still wakes us up
+		moved := uc.head.wait()
+
+		events, err := uc.repo.ListAfter(ctx, cursor, in.Types, in.UserId, cfg.BatchSize)
+		if err != nil {
+			zLog.Err(err).Msg("OutboxUseCase - error processing uc.repo.ListAfter")
+			return err
+		}
+
+		for i := range events {
+			if err = send(&events[i]); err != nil {
+				return err
+			}
+			cursor = events[i].Pos
+		}
+
+		if len(events) == cfg.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-moved:
+		}
+	}
+}
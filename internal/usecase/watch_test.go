@@ -0,0 +1,222 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+// addEvent stores an event of the type about the user, numbers it and returns its position.
+func addEvent(t *testing.T, repo *fakeOutboxRepo, typ string, userId uuid.UUID) int64 {
+	t.Helper()
+
+	ev := &model.OutboxEvent{Id: uuid.New(), Type: typ, AggregateId: userId, CreateTs: util.NowUTC()}
+	if err := repo.Create(context.Background(), ev, 0); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := repo.AssignPositions(context.Background(), 1, 0); err != nil {
+		t.Fatalf("AssignPositions: %v", err)
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.events[len(repo.events)-1].Pos
+}
+
+// collect watches until n events were sent and returns their positions.
+func collect(t *testing.T, uc *OutboxUseCase, in *dto.WatchUserEvents, n int) []int64 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var seqs []int64
+	err := uc.WatchUserEvents(ctx, in, func(ev *model.OutboxEvent) error {
+		seqs = append(seqs, ev.Pos)
+		if len(seqs) == n {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchUserEvents: %v", err)
+	}
+	if len(seqs) != n {
+		t.Fatalf("WatchUserEvents sent %v, want %d events", seqs, n)
+	}
+	return seqs
+}
+
+func TestWatchUserEventsFromOldest(t *testing.T) {
+	uc, repo, _, _ := newTestOutboxUseCase(t, 0)
+	alice, bob := uuid.New(), uuid.New()
+	addEvent(t, repo, model.EventUserCreated, alice)
+	addEvent(t, repo, model.EventUserCreated, bob)
+	addEvent(t, repo, model.EventUserUpdated, alice)
+	addEvent(t, repo, model.EventUserUpdated, bob)
+	addEvent(t, repo, model.EventUserUpdated, alice)
+
+	tests := []struct {
+		name string
+		in   dto.WatchUserEvents
+		want []int64
+	}{
+		{"every event", dto.WatchUserEvents{FromOldest: true}, []int64{1, 2, 3, 4, 5}},
+		{"after the cursor", dto.WatchUserEvents{Cursor: 3}, []int64{4, 5}},
+		{"of a type", dto.WatchUserEvents{FromOldest: true, Types: []string{model.EventUserCreated}}, []int64{1, 2}},
+		{"of a user", dto.WatchUserEvents{FromOldest: true, UserId: alice}, []int64{1, 3, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collect(t, uc, &tt.in, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("WatchUserEvents sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchUserEventsReadsInBatches(t *testing.T) {
+	uc, _, _, _ := newTestOutboxUseCase(t, 5)
+	config.Conf.Watch.BatchSize = 2
+
+	if got := collect(t, uc, &dto.WatchUserEvents{FromOldest: true}, 5); !reflect.DeepEqual(got, []int64{1, 2, 3, 4, 5}) {
+		t.Fatalf("WatchUserEvents sent %v, want every event across batches", got)
+	}
+}
+
+func TestWatchUserEventsStartsAtNewest(t *testing.T) {
+	uc, _, _, _ := newTestOutboxUseCase(t, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var seqs []int64
+	err := uc.WatchUserEvents(ctx, &dto.WatchUserEvents{}, func(ev *model.OutboxEvent) error {
+		seqs = append(seqs, ev.Pos)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchUserEvents: %v", err)
+	}
+	if len(seqs) != 0 {
+		t.Fatalf("WatchUserEvents sent %v, want only events after the newest one", seqs)
+	}
+}
+
+func TestWatchUserEventsWaitsForNewEvents(t *testing.T) {
+	uc, repo, _, _ := newTestOutboxUseCase(t, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sent := make(chan int64, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- uc.WatchUserEvents(ctx, &dto.WatchUserEvents{Cursor: 3}, func(ev *model.OutboxEvent) error {
+			sent <- ev.Pos
+			cancel()
+			return nil
+		})
+	}()
+
+	seq := addEvent(t, repo, model.EventUserDeleted, uuid.New())
+	// keeps waking the watcher until it read the event, it may not be waiting yet
+	for got := int64(0); got == 0; {
+		uc.head.advance(seq)
+		select {
+		case got = <-sent:
+			if got != seq {
+				t.Fatalf("WatchUserEvents sent %d, want the new event %d", got, seq)
+			}
+		case <-ctx.Done():
+			t.Fatalf("WatchUserEvents did not send the new event")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("WatchUserEvents: %v", err)
+	}
+}
+
+func TestWatchUserEventsRefusesPurgedCursor(t *testing.T) {
+	uc, repo, _, _ := newTestOutboxUseCase(t, 5)
+	repo.purge(3)
+
+	err := uc.WatchUserEvents(context.Background(), &dto.WatchUserEvents{Cursor: 2}, func(*model.OutboxEvent) error { return nil })
+	if !errors.Is(err, model.ErrCursorExpired) {
+		t.Fatalf("WatchUserEvents = %v, want ErrCursorExpired", err)
+	}
+
+	// the cursor right before the oldest event misses nothing
+	if got := collect(t, uc, &dto.WatchUserEvents{Cursor: 3}, 2); !reflect.DeepEqual(got, []int64{4, 5}) {
+		t.Fatalf("WatchUserEvents sent %v, want [4 5]", got)
+	}
+}
+
+func TestWatchUserEventsLimitsStreams(t *testing.T) {
+	uc, _, _, _ := newTestOutboxUseCase(t, 1)
+	config.Conf.Watch.MaxStreams = 1
+	uc.streams.Add(1)
+
+	err := uc.WatchUserEvents(context.Background(), &dto.WatchUserEvents{FromOldest: true}, func(*model.OutboxEvent) error { return nil })
+	if !errors.Is(err, model.ErrTooManyRequests) {
+		t.Fatalf("WatchUserEvents = %v, want ErrTooManyRequests", err)
+	}
+	if n := uc.streams.Load(); n != 1 {
+		t.Fatalf("%d streams counted, want the refused one released", n)
+	}
+}
+
+func TestWatchUserEventsStopsWhenSendFails(t *testing.T) {
+	uc, _, _, _ := newTestOutboxUseCase(t, 3)
+	failed := errors.New("stream closed")
+
+	err := uc.WatchUserEvents(context.Background(), &dto.WatchUserEvents{FromOldest: true}, func(*model.OutboxEvent) error { return failed })
+	if !errors.Is(err, failed) {
+		t.Fatalf("WatchUserEvents = %v, want the send error", err)
+	}
+	if n := uc.streams.Load(); n != 0 {
+		t.Fatalf("%d streams counted after the end, want 0", n)
+	}
+}
+
+func TestWatchHeadNumbersCommittedEvents(t *testing.T) {
+	uc, repo, _, _ := newTestOutboxUseCase(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// written without a position, like an event whose transaction just committed
+	ev := &model.OutboxEvent{Id: uuid.New(), Type: model.EventUserDeleted, CreateTs: util.NowUTC()}
+	if err := repo.Create(ctx, ev, 0); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, _ := repo.ListAfter(ctx, 2, nil, uuid.Nil, 10); len(got) != 0 {
+		t.Fatalf("ListAfter = %v, want the event hidden until it is numbered", got)
+	}
+
+	go uc.WatchHead(ctx, 5*time.Millisecond)
+
+	var got *model.OutboxEvent
+	err := uc.WatchUserEvents(ctx, &dto.WatchUserEvents{Cursor: 2}, func(ev *model.OutboxEvent) error {
+		got = ev
+		cancel()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchUserEvents: %v", err)
+	}
+	if got == nil || got.Id != ev.Id || got.Pos != 3 {
+		t.Fatalf("WatchUserEvents sent %+v, want the new event at position 3", got)
+	}
+}
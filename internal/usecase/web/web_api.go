@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/google/uuid"
 
 	"authenticator/config"
+	"authenticator/internal/model"
 )
 
 type WebAPI struct {
@@ -49,3 +51,190 @@ func (w *WebAPI) GetRefreshTokenByID(ctx context.Context, id uuid.UUID) (refresh
 
 	return
 }
+
+func (w *WebAPI) DeleteRefreshToken(ctx context.Context, id uuid.UUID) (err error) {
+
+	key := fmt.Sprintf("%v:%v", userRefreshToken, id)
+
+	err = w.cache.Del(ctx, key).Err()
+
+	return
+}
+
+// CountRefreshTokens counts the users with a refresh token, that is with an active session.
+func (w *WebAPI) CountRefreshTokens(ctx context.Context) (n int64, err error) {
+
+	iter := w.cache.Scan(ctx, 0, userRefreshToken+":*", 1000).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+
+	err = iter.Err()
+
+	return
+}
+
+const userOneTimeCode = "user:oneTimeCode"
+
+func (w *WebAPI) AddOneTimeCode(ctx context.Context, purpose string, id uuid.UUID, code *model.OneTimeCode, ttl time.Duration) (err error) {
+
+	key := fmt.Sprintf("%v:%v:%v", userOneTimeCode, purpose, id)
+
+	_, err = w.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "hash", code.CodeHash, "target", code.Target, "attempts", code.Attempts)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+
+	return
+}
+
+// useOneTimeCodeScript counts an attempt at an existing code and returns it. HINCRBY on the existing
+// key keeps its expiry, a missing or expired code is not recreated.
+var useOneTimeCodeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+local values = redis.call('HMGET', KEYS[1], 'hash', 'target')
+return {values[1], values[2], attempts}
+`)
+
+// UseOneTimeCode counts an attempt at the code and returns it, its attempts include this one.
+// It returns nil when there is no code.
+func (w *WebAPI) UseOneTimeCode(ctx context.Context, purpose string, id uuid.UUID) (code *model.OneTimeCode, err error) {
+
+	key := fmt.Sprintf("%v:%v:%v", userOneTimeCode, purpose, id)
+
+	values, err := useOneTimeCodeScript.Run(ctx, w.cache, []string{key}).Slice()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected one-time code reply of %d values", len(values))
+	}
+
+	hash, _ := values[0].(string)
+	target, _ := values[1].(string)
+	attempts, _ := values[2].(int64)
+
+	code = &model.OneTimeCode{
+		CodeHash: hash,
+		Target:   target,
+		Attempts: int(attempts),
+	}
+
+	return
+}
+
+func (w *WebAPI) DeleteOneTimeCode(ctx context.Context, purpose string, id uuid.UUID) (err error) {
+
+	key := fmt.Sprintf("%v:%v:%v", userOneTimeCode, purpose, id)
+
+	err = w.cache.Del(ctx, key).Err()
+
+	return
+}
+
+const userLoginFailures = "user:loginFailures"
+
+func (w *WebAPI) GetLoginFailures(ctx context.Context, username string) (failures int, err error) {
+
+	key := fmt.Sprintf("%v:%v", userLoginFailures, username)
+
+	failures, err = w.cache.Get(ctx, key).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	return
+}
+
+// IncrLoginFailures counts a failed login, the counter expires window after the first failure.
+// The counter is created with its expiry in the same transaction, so it can not be left without one.
+func (w *WebAPI) IncrLoginFailures(ctx context.Context, username string, window time.Duration) (failures int, err error) {
+
+	key := fmt.Sprintf("%v:%v", userLoginFailures, username)
+
+	var incr *redis.IntCmd
+	_, err = w.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetNX(ctx, key, 0, window)
+		incr = pipe.Incr(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	failures = int(incr.Val())
+
+	return
+}
+
+func (w *WebAPI) ResetLoginFailures(ctx context.Context, username string) (err error) {
+
+	key := fmt.Sprintf("%v:%v", userLoginFailures, username)
+
+	err = w.cache.Del(ctx, key).Err()
+
+	return
+}
+
+const (
+	userGroupGrant           = "user:groupGrant"
+	userGroupGrantGeneration = "user:groupGrant:generation"
+)
+
+// groupGrantKey scopes cached grants by the generation, bumping the generation
+// drops every cached grant at once.
+func groupGrantKey(generation int64, id uuid.UUID) string {
+	return fmt.Sprintf("%v:%v:%v", userGroupGrant, generation, id)
+}
+
+// GetGroupGrant returns the cached grant of the user and the generation it was looked up in. A grant read from the
+// database after a miss is added with that generation, so a grant read before an invalidation is never found after it.
+func (w *WebAPI) GetGroupGrant(ctx context.Context, id uuid.UUID) (grant *model.GroupGrant, generation int64, err error) {
+
+	generation, err = w.cache.Get(ctx, userGroupGrantGeneration).Int64()
+	if err != nil && err != redis.Nil {
+		return
+	}
+
+	data, err := w.cache.Get(ctx, groupGrantKey(generation, id)).Bytes()
+	if err == redis.Nil {
+		return nil, generation, nil
+	}
+	if err != nil {
+		return
+	}
+
+	grant = &model.GroupGrant{}
+	err = json.Unmarshal(data, grant)
+
+	return
+}
+
+func (w *WebAPI) AddGroupGrant(ctx context.Context, id uuid.UUID, generation int64, grant *model.GroupGrant, ttl time.Duration) (err error) {
+
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return
+	}
+
+	err = w.cache.Set(ctx, groupGrantKey(generation, id), data, ttl).Err()
+
+	return
+}
+
+// InvalidateGroupGrants drops the cached grants of every user.
+func (w *WebAPI) InvalidateGroupGrants(ctx context.Context) (err error) {
+
+	err = w.cache.Incr(ctx, userGroupGrantGeneration).Err()
+
+	return
+}
@@ -0,0 +1,136 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"authenticator/internal/model"
+)
+
+func newTestWebAPI(t *testing.T) (*WebAPI, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewWebAPI(client), mr
+}
+
+func TestUseOneTimeCodeCountsAttempts(t *testing.T) {
+	w, mr := newTestWebAPI(t)
+	ctx := context.Background()
+	id := uuid.New()
+
+	err := w.AddOneTimeCode(ctx, "login", id, &model.OneTimeCode{CodeHash: "h", Target: "a@example.com"}, time.Minute)
+	if err != nil {
+		t.Fatalf("AddOneTimeCode: %v", err)
+	}
+
+	for want := 1; want <= 3; want++ {
+		code, err := w.UseOneTimeCode(ctx, "login", id)
+		if err != nil {
+			t.Fatalf("UseOneTimeCode: %v", err)
+		}
+		if code == nil || code.Attempts != want || code.CodeHash != "h" || code.Target != "a@example.com" {
+			t.Fatalf("UseOneTimeCode = %+v, want attempt %d", code, want)
+		}
+	}
+
+	key := fmt.Sprintf("%v:%v:%v", userOneTimeCode, "login", id)
+	if ttl := mr.TTL(key); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("ttl = %v after the attempts, want the one set with the code", ttl)
+	}
+}
+
+func TestUseOneTimeCodeDoesNotRecreateExpiredCode(t *testing.T) {
+	w, mr := newTestWebAPI(t)
+	ctx := context.Background()
+	id := uuid.New()
+
+	err := w.AddOneTimeCode(ctx, "login", id, &model.OneTimeCode{CodeHash: "h", Target: "a@example.com"}, time.Minute)
+	if err != nil {
+		t.Fatalf("AddOneTimeCode: %v", err)
+	}
+	mr.FastForward(2 * time.Minute)
+
+	code, err := w.UseOneTimeCode(ctx, "login", id)
+	if err != nil {
+		t.Fatalf("UseOneTimeCode: %v", err)
+	}
+	if code != nil {
+		t.Fatalf("UseOneTimeCode = %+v, want nil", code)
+	}
+
+	key := fmt.Sprintf("%v:%v:%v", userOneTimeCode, "login", id)
+	if mr.Exists(key) {
+		t.Fatal("the expired code was recreated")
+	}
+}
+
+func TestIncrLoginFailuresExpiresFromFirstFailure(t *testing.T) {
+	w, mr := newTestWebAPI(t)
+	ctx := context.Background()
+
+	n, err := w.IncrLoginFailures(ctx, "alice", time.Minute)
+	if err != nil || n != 1 {
+		t.Fatalf("IncrLoginFailures = %d, %v, want 1", n, err)
+	}
+
+	mr.FastForward(30 * time.Second)
+
+	n, err = w.IncrLoginFailures(ctx, "alice", time.Minute)
+	if err != nil || n != 2 {
+		t.Fatalf("IncrLoginFailures = %d, %v, want 2", n, err)
+	}
+
+	key := fmt.Sprintf("%v:%v", userLoginFailures, "alice")
+	if ttl := mr.TTL(key); ttl <= 0 || ttl > 30*time.Second {
+		t.Fatalf("ttl = %v, want the window left from the first failure", ttl)
+	}
+
+	mr.FastForward(31 * time.Second)
+
+	n, err = w.GetLoginFailures(ctx, "alice")
+	if err != nil || n != 0 {
+		t.Fatalf("GetLoginFailures = %d, %v after the window, want 0", n, err)
+	}
+}
+
+func TestGroupGrantAddedAfterInvalidationIsNotFound(t *testing.T) {
+	w, _ := newTestWebAPI(t)
+	ctx := context.Background()
+	id := uuid.New()
+
+	grant, generation, err := w.GetGroupGrant(ctx, id)
+	if err != nil || grant != nil {
+		t.Fatalf("GetGroupGrant = %v, %v, want a miss", grant, err)
+	}
+
+	// a group change commits between the lookup and the add of the grant read from the database
+	if err = w.InvalidateGroupGrants(ctx); err != nil {
+		t.Fatalf("InvalidateGroupGrants: %v", err)
+	}
+	if err = w.AddGroupGrant(ctx, id, generation, &model.GroupGrant{Roles: []string{"admin"}}, time.Minute); err != nil {
+		t.Fatalf("AddGroupGrant: %v", err)
+	}
+
+	grant, next, err := w.GetGroupGrant(ctx, id)
+	if err != nil || grant != nil {
+		t.Fatalf("GetGroupGrant = %v, %v, want the stale grant missed", grant, err)
+	}
+
+	if err = w.AddGroupGrant(ctx, id, next, &model.GroupGrant{Roles: []string{"support"}}, time.Minute); err != nil {
+		t.Fatalf("AddGroupGrant: %v", err)
+	}
+	grant, _, err = w.GetGroupGrant(ctx, id)
+	if err != nil || grant == nil || grant.Roles[0] != "support" {
+		t.Fatalf("GetGroupGrant = %v, %v, want the grant added", grant, err)
+	}
+}
@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"authenticator/config"
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+const (
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 500
+	webhookSecretBytes      = 32
+	maxWebhookErrorLen      = 1024
+)
+
+// WebhookUseCase manages webhook subscriptions and delivers the events queued for them.
+type WebhookUseCase struct {
+	repo      WebhookRepo
+	txRepo    TxRepo
+	deliverer WebhookDeliverer
+}
+
+// NewWebhookUseCase -.
+func NewWebhookUseCase(r WebhookRepo, tx TxRepo, d WebhookDeliverer) *WebhookUseCase {
+	return &WebhookUseCase{
+		repo:      r,
+		txRepo:    tx,
+		deliverer: d,
+	}
+}
+
+// CreateWebhook subscribes the url to the event types, a secret is generated when none is given.
+// The returned webhook is the only place the secret is handed out.
+func (uc *WebhookUseCase) CreateWebhook(ctx context.Context, in *dto.CreateWebhook) (*model.Webhook, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.WebhookUseCase").
+		Str("method", "CreateWebhook").Logger()
+
+	u, err := url.Parse(in.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		zLog.Error().Str("url", in.Url).Msg("WebhookUseCase - invalid url")
+		return nil, model.ErrBadRequest
+	}
+
+	// the deliverer checks every connection again, this rejects the obvious cases up front
+	err = uc.deliverer.CheckUrl(ctx, in.Url)
+	if err != nil {
+		zLog.Err(err).Str("url", in.Url).Msg("WebhookUseCase - url not allowed")
+		return nil, model.ErrBadRequest
+	}
+
+	secret := in.Secret
+	if secret == "" {
+		secret, err = util.GenerateToken(webhookSecretBytes)
+		if err != nil {
+			zLog.Err(err).Msg("WebhookUseCase - error util.GenerateToken")
+			return nil, err
+		}
+	}
+
+	now := util.NowUTC()
+	item := &model.Webhook{
+		Url:        in.Url,
+		EventTypes: in.EventTypes,
+		Secret:     secret,
+		CreateTs:   now,
+		UpdateTs:   now,
+	}
+
+	err = uc.repo.Create(ctx, item)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing uc.repo.Create")
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (uc *WebhookUseCase) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	items, err := uc.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		items[i].Secret = ""
+	}
+
+	return items, nil
+}
+
+func (uc *WebhookUseCase) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	return uc.repo.Delete(ctx, id)
+}
+
+func (uc *WebhookUseCase) ListWebhookDeliveries(ctx context.Context, filter *model.WebhookDeliveryFilter) ([]model.WebhookDelivery, error) {
+	switch {
+	case filter.Limit <= 0:
+		filter.Limit = defaultDeliveryPageSize
+	case filter.Limit > maxDeliveryPageSize:
+		filter.Limit = maxDeliveryPageSize
+	}
+
+	return uc.repo.ListDeliveries(ctx, filter)
+}
+
+// ReplayWebhookDeliveries queues dead deliveries again and returns how many were queued.
+func (uc *WebhookUseCase) ReplayWebhookDeliveries(ctx context.Context, in *dto.ReplayWebhookDeliveries) (int64, error) {
+	if in.WebhookId == uuid.Nil && len(in.DeliveryIds) == 0 {
+		return 0, model.ErrBadRequest
+	}
+
+	return uc.repo.Replay(ctx, in.WebhookId, in.DeliveryIds, util.NowUTC())
+}
+
+// DeliverDue attempts up to limit due deliveries and returns how many were attempted.
+// The deliveries are claimed in a short transaction and sent outside of it, each result is recorded
+// in its own transaction. A claim lasts for the timeout of the whole batch, a run that crashed
+// leaves its deliveries to be sent again once it expired.
+// A failed attempt is retried with exponential back-off until the attempts run out,
+// then the delivery is dead and only a replay sends it again.
+func (uc *WebhookUseCase) DeliverDue(ctx context.Context, limit int) (int, error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.WebhookUseCase").
+		Str("method", "DeliverDue").Logger()
+
+	cfg := config.Conf.Webhook
+	deliveries, err := uc.claimDue(ctx, limit, time.Duration(cfg.Timeout*(limit+1))*time.Second)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing uc.claimDue")
+		return 0, err
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	hooks, err := uc.repo.List(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing uc.repo.List")
+		return 0, err
+	}
+	byId := make(map[uuid.UUID]*model.Webhook, len(hooks))
+	for i := range hooks {
+		byId[hooks[i].Id] = &hooks[i]
+	}
+
+	n := 0
+	for i := range deliveries {
+		d := &deliveries[i]
+
+		hook, ok := byId[d.WebhookId]
+		if !ok {
+			// deleted since the batch was claimed, the cascade removes the delivery
+			continue
+		}
+
+		statusCode, sendErr := uc.deliverer.Deliver(ctx, hook, d)
+
+		now := util.NowUTC()
+		d.Attempts++
+		d.LastStatusCode = statusCode
+		d.UpdateTs = now
+
+		switch {
+		case sendErr == nil:
+			d.Status = model.DeliveryDelivered
+			d.LastError = ""
+		case d.Attempts >= cfg.MaxAttempts:
+			d.Status = model.DeliveryDead
+			d.LastError = truncate(sendErr.Error(), maxWebhookErrorLen)
+		default:
+			d.NextAttemptTs = now.Add(retryBackoff(d.Attempts, cfg))
+			d.LastError = truncate(sendErr.Error(), maxWebhookErrorLen)
+		}
+
+		if sendErr != nil {
+			zLog.Warn().Err(sendErr).
+				Str("delivery_id", d.Id.String()).
+				Int("attempts", d.Attempts).
+				Str("status", string(d.Status)).
+				Msg("WebhookUseCase - delivery failed")
+		}
+
+		err = uc.recordDelivery(ctx, d)
+		if err != nil {
+			zLog.Err(err).Msg("WebhookUseCase - error processing uc.recordDelivery")
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// claimDue claims the due deliveries for the lease and commits.
+func (uc *WebhookUseCase) claimDue(ctx context.Context, limit int, lease time.Duration) (deliveries []model.WebhookDelivery, err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.WebhookUseCase").
+		Str("method", "claimDue").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing r.txRepo.NewTxId")
+		return nil, err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("WebhookUseCase - error processing r.txRepo.TxEnd")
+			deliveries = nil
+		}
+	}()
+
+	now := util.NowUTC()
+	deliveries, err = uc.repo.ClaimDue(ctx, now, now.Add(lease), limit, txId)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing uc.repo.ClaimDue")
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// recordDelivery stores the outcome of an attempt.
+func (uc *WebhookUseCase) recordDelivery(ctx context.Context, d *model.WebhookDelivery) (err error) {
+
+	zLog := zerolog.Ctx(ctx).With().
+		Str("unit", "internal.usecase.WebhookUseCase").
+		Str("method", "recordDelivery").Logger()
+
+	var txId int
+	txId, err = uc.txRepo.NewTxId(ctx)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing r.txRepo.NewTxId")
+		return err
+	}
+	defer func() {
+		err = uc.txRepo.TxEnd(ctx, txId, err)
+		if err != nil {
+			zLog.Err(err).Msg("WebhookUseCase - error processing r.txRepo.TxEnd")
+		}
+	}()
+
+	err = uc.repo.UpdateDelivery(ctx, d, txId)
+	if err != nil {
+		zLog.Err(err).Msg("WebhookUseCase - error processing uc.repo.UpdateDelivery")
+		return err
+	}
+
+	return nil
+}
+
+// PurgeDelivered removes deliveries that succeeded before the given time.
+func (uc *WebhookUseCase) PurgeDelivered(ctx context.Context, before time.Time) (int64, error) {
+	return uc.repo.PurgeDelivered(ctx, before)
+}
+
+// retryBackoff doubles the delay with every attempt, starting at RetryBase and capped at RetryMax.
+func retryBackoff(attempts int, cfg config.Webhook) time.Duration {
+	base := time.Duration(cfg.RetryBase) * time.Second
+	max := time.Duration(cfg.RetryMax) * time.Second
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+const (
+	HeaderId        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// ErrAddressNotAllowed is returned for a webhook url that resolves to a loopback, link-local,
+// private or otherwise non-public address.
+var ErrAddressNotAllowed = errors.New("webhook address not allowed")
+
+// cgnat is the shared address space of RFC 6598, private to the carrier network.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// HttpDeliverer posts deliveries as JSON and signs them with the webhook secret.
+// The signature is "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>",
+// receivers should recompute it and reject stale timestamps.
+// Redirects are not followed and, unless allowPrivate is set, every connection to a non-public
+// address is refused when it is dialed, after the name was resolved, so a webhook can not reach
+// the internal network through DNS either.
+type HttpDeliverer struct {
+	client       *http.Client
+	allowPrivate bool
+}
+
+func NewHttpDeliverer(timeout time.Duration, allowPrivate bool) *HttpDeliverer {
+	d := &HttpDeliverer{allowPrivate: allowPrivate}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: d.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be the address dialed, the target would go unchecked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	d.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return d
+}
+
+// control runs before every connection with the resolved address.
+func (d *HttpDeliverer) control(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !d.allowed(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, addrPort.Addr())
+	}
+	return nil
+}
+
+func (d *HttpDeliverer) allowed(addr netip.Addr) bool {
+	if d.allowPrivate {
+		return true
+	}
+
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsUnspecified() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() && !cgnat.Contains(addr)
+}
+
+// CheckUrl resolves the host of the url and refuses it if any of its addresses is not allowed.
+func (d *HttpDeliverer) CheckUrl(ctx context.Context, rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if !d.allowed(addr) {
+			return fmt.Errorf("%w: %s", ErrAddressNotAllowed, addr)
+		}
+	}
+
+	return nil
+}
+
+type body struct {
+	Id       string            `json:"id"`
+	Type     string            `json:"type"`
+	CreateTs int64             `json:"create_ts"`
+	Data     map[string]string `json:"data"`
+	Attempt  int               `json:"attempt"`
+}
+
+// Deliver returns the response status code, an error is returned for anything but a 2xx response.
+func (d *HttpDeliverer) Deliver(ctx context.Context, hook *model.Webhook, in *model.WebhookDelivery) (int, error) {
+	payload, err := json.Marshal(body{
+		Id:       in.EventId.String(),
+		Type:     in.EventType,
+		CreateTs: in.EventTs.Unix(),
+		Data:     in.Payload,
+		Attempt:  in.Attempts + 1,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	ts := strconv.FormatInt(util.NowUTC().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderId, in.EventId.String())
+	req.Header.Set(HeaderEvent, in.EventType)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, "sha256="+util.Sign(hook.Secret, ts+"."+string(payload)))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
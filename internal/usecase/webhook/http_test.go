@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/model"
+	"authenticator/pkg/util"
+)
+
+func testDelivery() *model.WebhookDelivery {
+	return &model.WebhookDelivery{
+		Id:        uuid.New(),
+		EventId:   uuid.New(),
+		EventType: model.EventUserCreated,
+		EventTs:   util.NowUTC(),
+		Payload:   map[string]string{"username": "alice"},
+	}
+}
+
+func TestDeliverSignsTheBody(t *testing.T) {
+	var got struct {
+		body, ts, signature, event string
+	}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got.body = string(b)
+		got.ts = r.Header.Get(HeaderTimestamp)
+		got.signature = r.Header.Get(HeaderSignature)
+		got.event = r.Header.Get(HeaderEvent)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	d := NewHttpDeliverer(time.Second, true)
+	hook := &model.Webhook{Url: receiver.URL, Secret: "secret"}
+
+	status, err := d.Deliver(context.Background(), hook, testDelivery())
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Deliver = %d, %v, want 204", status, err)
+	}
+	if got.event != model.EventUserCreated || !strings.Contains(got.body, `"username":"alice"`) {
+		t.Fatalf("received event %q with body %s", got.event, got.body)
+	}
+	if got.signature != "sha256="+util.Sign("secret", got.ts+"."+got.body) {
+		t.Fatalf("signature %s does not match the body", got.signature)
+	}
+}
+
+func TestDeliverDoesNotFollowRedirects(t *testing.T) {
+	var followed bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	d := NewHttpDeliverer(time.Second, true)
+	status, err := d.Deliver(context.Background(), &model.Webhook{Url: receiver.URL}, testDelivery())
+	if err == nil || status != http.StatusTemporaryRedirect {
+		t.Fatalf("Deliver = %d, %v, want the redirect reported as a failure", status, err)
+	}
+	if followed {
+		t.Fatal("the redirect was followed")
+	}
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	var reached bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer receiver.Close()
+
+	d := NewHttpDeliverer(time.Second, false)
+	_, err := d.Deliver(context.Background(), &model.Webhook{Url: receiver.URL}, testDelivery())
+	if !errors.Is(err, ErrAddressNotAllowed) {
+		t.Fatalf("Deliver = %v, want ErrAddressNotAllowed", err)
+	}
+	if reached {
+		t.Fatal("the loopback receiver was reached")
+	}
+}
+
+func TestCheckUrl(t *testing.T) {
+	d := NewHttpDeliverer(time.Second, false)
+
+	for _, u := range []string{
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+		"http://100.64.0.1/hook",
+	} {
+		if err := d.CheckUrl(context.Background(), u); !errors.Is(err, ErrAddressNotAllowed) {
+			t.Errorf("CheckUrl(%s) = %v, want ErrAddressNotAllowed", u, err)
+		}
+	}
+
+	if err := d.CheckUrl(context.Background(), "https://203.0.113.10/hook"); err != nil {
+		t.Errorf("CheckUrl of a public address = %v", err)
+	}
+}
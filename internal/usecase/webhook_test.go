@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"authenticator/internal/dto"
+	"authenticator/internal/model"
+	"authenticator/internal/usecase/webhook"
+	"authenticator/pkg/util"
+)
+
+func newTestWebhookUseCase(t *testing.T, allowPrivate bool) (*WebhookUseCase, *fakeWebhookRepo, *fakeTxRepo) {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.Webhook.MaxAttempts = 3
+	cfg.Webhook.RetryBase = 30
+	cfg.Webhook.RetryMax = 3600
+	cfg.Webhook.Timeout = 1
+
+	repo := &fakeWebhookRepo{}
+	tx := &fakeTxRepo{}
+	return NewWebhookUseCase(repo, tx, webhook.NewHttpDeliverer(time.Second, allowPrivate)), repo, tx
+}
+
+func (r *fakeWebhookRepo) addDelivery(hook *model.Webhook) *model.WebhookDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := util.NowUTC()
+	r.deliveries = append(r.deliveries, model.WebhookDelivery{
+		Id:            uuid.New(),
+		WebhookId:     hook.Id,
+		EventId:       uuid.New(),
+		EventType:     model.EventUserCreated,
+		EventTs:       now,
+		Status:        model.DeliveryPending,
+		NextAttemptTs: now.Add(-time.Second),
+	})
+	return &r.deliveries[len(r.deliveries)-1]
+}
+
+func TestCreateWebhookRefusesInternalUrl(t *testing.T) {
+	uc, repo, _ := newTestWebhookUseCase(t, false)
+
+	for _, u := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/", "http://10.0.0.1/hook"} {
+		_, err := uc.CreateWebhook(context.Background(), &dto.CreateWebhook{Url: u})
+		if !errors.Is(err, model.ErrBadRequest) {
+			t.Fatalf("CreateWebhook(%s) = %v, want ErrBadRequest", u, err)
+		}
+	}
+	if len(repo.hooks) != 0 {
+		t.Fatal("a refused webhook was stored")
+	}
+}
+
+func TestDeliverDueSendsOutsideTransaction(t *testing.T) {
+	uc, repo, tx := newTestWebhookUseCase(t, true)
+
+	var mu sync.Mutex
+	var received int
+	var inTx bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received++
+		inTx = inTx || tx.inTx()
+	}))
+	defer receiver.Close()
+
+	hook, err := uc.CreateWebhook(context.Background(), &dto.CreateWebhook{Url: receiver.URL})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	repo.addDelivery(hook)
+	repo.addDelivery(hook)
+
+	n, err := uc.DeliverDue(context.Background(), 10)
+	if err != nil || n != 2 {
+		t.Fatalf("DeliverDue = %d, %v, want 2 deliveries", n, err)
+	}
+	if received != 2 || inTx {
+		t.Fatalf("received %d deliveries, inside a transaction: %v", received, inTx)
+	}
+	for _, d := range repo.deliveries {
+		if d.Status != model.DeliveryDelivered || d.Attempts != 1 || d.LastStatusCode != http.StatusOK {
+			t.Fatalf("delivery %+v, want delivered on the first attempt", d)
+		}
+	}
+}
+
+func TestDeliverDueRetriesFailures(t *testing.T) {
+	uc, repo, _ := newTestWebhookUseCase(t, true)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	hook, err := uc.CreateWebhook(context.Background(), &dto.CreateWebhook{Url: receiver.URL})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	repo.addDelivery(hook)
+
+	if _, err = uc.DeliverDue(context.Background(), 10); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	d := repo.deliveries[0]
+	if d.Status != model.DeliveryPending || d.LastStatusCode != http.StatusServiceUnavailable ||
+		time.Until(d.NextAttemptTs) < 29*time.Second {
+		t.Fatalf("delivery %+v, want it pending and retried after the back-off", d)
+	}
+
+	// the retry is not due yet
+	if n, _ := uc.DeliverDue(context.Background(), 10); n != 0 {
+		t.Fatalf("DeliverDue sent %d deliveries before they were due", n)
+	}
+}
+
+func TestDeliverDueSkipsClaimedDeliveries(t *testing.T) {
+	uc, repo, _ := newTestWebhookUseCase(t, true)
+	hook := &model.Webhook{Id: uuid.New(), Url: "http://127.0.0.1:1/hook"}
+	repo.hooks = append(repo.hooks, *hook)
+	d := repo.addDelivery(hook)
+	d.NextAttemptTs = util.NowUTC().Add(time.Minute)
+
+	if n, err := uc.DeliverDue(context.Background(), 10); err != nil || n != 0 {
+		t.Fatalf("DeliverDue = %d, %v, want the claimed delivery skipped", n, err)
+	}
+}
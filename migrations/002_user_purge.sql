@@ -0,0 +1,2 @@
+CREATE INDEX ix_user_deleted_update_ts ON tbl_user (update_ts) WHERE
+    state = 'deleted'::state_t;
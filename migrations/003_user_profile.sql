@@ -0,0 +1,12 @@
+ALTER TABLE tbl_user
+    ADD COLUMN IF NOT EXISTS email          VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS phone          VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS phone_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS attributes     JSONB   NOT NULL DEFAULT '{}'::jsonb;
+
+CREATE UNIQUE INDEX uq_user_email ON tbl_user (lower(email)) WHERE
+    email IS NOT NULL AND state != 'deleted'::state_t;
+
+CREATE UNIQUE INDEX uq_user_phone ON tbl_user (phone) WHERE
+    phone IS NOT NULL AND state != 'deleted'::state_t;
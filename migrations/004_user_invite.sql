@@ -0,0 +1,16 @@
+-- the new value can not be used in the transaction adding it, nothing below refers to it
+ALTER TYPE state_t ADD VALUE IF NOT EXISTS 'invited';
+
+ALTER TABLE tbl_user
+    ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS tbl_user_invite
+(
+    user_id    UUID PRIMARY KEY REFERENCES tbl_user (id) ON DELETE CASCADE,
+    token_hash VARCHAR(64)                 NOT NULL,
+    expire_ts  TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    create_ts  TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX uq_user_invite_token_hash ON tbl_user_invite (token_hash);
+CREATE INDEX ix_user_invite_expire_ts ON tbl_user_invite (expire_ts);
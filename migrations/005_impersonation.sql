@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS tbl_impersonation
+(
+    id        UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    actor_id  UUID                        NOT NULL,
+    target_id UUID                        NOT NULL,
+    reason    VARCHAR(256)                NOT NULL,
+    expire_ts TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    create_ts TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE INDEX ix_impersonation_actor_id ON tbl_impersonation (actor_id, create_ts);
+CREATE INDEX ix_impersonation_target_id ON tbl_impersonation (target_id, create_ts);
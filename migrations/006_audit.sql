@@ -0,0 +1,34 @@
+CREATE TYPE audit_outcome_t AS ENUM ('success', 'failure');
+
+CREATE TABLE IF NOT EXISTS tbl_audit_event
+(
+    id              UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    type            VARCHAR(64)                 NOT NULL,
+    actor_id        UUID,
+    actor_username  VARCHAR(64),
+    target_id       UUID,
+    target_username VARCHAR(64),
+    ip              VARCHAR(64),
+    user_agent      VARCHAR(256),
+    outcome         audit_outcome_t             NOT NULL,
+    details         JSONB                       NOT NULL DEFAULT '{}'::jsonb,
+    create_ts       TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE INDEX ix_audit_event_create_ts ON tbl_audit_event (create_ts DESC, id DESC);
+CREATE INDEX ix_audit_event_actor_id ON tbl_audit_event (actor_id, create_ts DESC);
+CREATE INDEX ix_audit_event_target_id ON tbl_audit_event (target_id, create_ts DESC);
+
+-- audit events are append-only
+CREATE OR REPLACE FUNCTION fn_audit_event_immutable() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'tbl_audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tr_audit_event_immutable
+    BEFORE UPDATE OR DELETE
+    ON tbl_audit_event
+    FOR EACH ROW
+EXECUTE FUNCTION fn_audit_event_immutable();
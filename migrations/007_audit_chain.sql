@@ -0,0 +1,16 @@
+-- events logged before the chain existed keep a NULL hash and are left out of it
+ALTER TABLE tbl_audit_event
+    ADD COLUMN IF NOT EXISTS seq       BIGINT GENERATED ALWAYS AS IDENTITY UNIQUE,
+    ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS hash      VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS tbl_audit_checkpoint
+(
+    id        UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    seq       BIGINT                      NOT NULL,
+    hash      VARCHAR(64)                 NOT NULL,
+    signature VARCHAR(64)                 NOT NULL,
+    create_ts TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE INDEX ix_audit_checkpoint_seq ON tbl_audit_checkpoint (seq);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS tbl_outbox
+(
+    seq          BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    id           UUID                        NOT NULL UNIQUE,
+    type         VARCHAR(64)                 NOT NULL,
+    aggregate_id UUID                        NOT NULL,
+    payload      JSONB                       NOT NULL DEFAULT '{}'::jsonb,
+    create_ts    TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    published_ts TIMESTAMP WITHOUT TIME ZONE,
+    attempts     INT                         NOT NULL DEFAULT 0,
+    last_error   TEXT
+);
+
+CREATE INDEX ix_outbox_pending ON tbl_outbox (seq) WHERE published_ts IS NULL;
+CREATE INDEX ix_outbox_published_ts ON tbl_outbox (published_ts) WHERE published_ts IS NOT NULL;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS tbl_webhook
+(
+    id          UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    url         VARCHAR(2048)               NOT NULL,
+    event_types TEXT[]                      NOT NULL DEFAULT '{}',
+    secret      VARCHAR(128)                NOT NULL,
+    create_ts   TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    update_ts   TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE TYPE delivery_status_t AS ENUM ('pending', 'delivered', 'dead');
+
+CREATE TABLE IF NOT EXISTS tbl_webhook_delivery
+(
+    id               UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    webhook_id       UUID                        NOT NULL REFERENCES tbl_webhook (id) ON DELETE CASCADE,
+    event_id         UUID                        NOT NULL,
+    event_type       VARCHAR(64)                 NOT NULL,
+    payload          JSONB                       NOT NULL DEFAULT '{}'::jsonb,
+    event_ts         TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    status           delivery_status_t           NOT NULL DEFAULT 'pending',
+    attempts         INT                         NOT NULL DEFAULT 0,
+    next_attempt_ts  TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    last_status_code INT,
+    last_error       TEXT,
+    create_ts        TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    update_ts        TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX ix_webhook_delivery_due ON tbl_webhook_delivery (next_attempt_ts) WHERE status = 'pending';
+CREATE INDEX ix_webhook_delivery_webhook_id ON tbl_webhook_delivery (webhook_id, create_ts);
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS tbl_group
+(
+    id          UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    name        VARCHAR(64)                 NOT NULL UNIQUE,
+    description VARCHAR(256)                NOT NULL DEFAULT '',
+    roles       TEXT[]                      NOT NULL DEFAULT '{}',
+    create_ts   TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    update_ts   TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tbl_group_user
+(
+    group_id  UUID                        NOT NULL REFERENCES tbl_group (id) ON DELETE CASCADE,
+    user_id   UUID                        NOT NULL REFERENCES tbl_user (id) ON DELETE CASCADE,
+    create_ts TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX ix_group_user_user_id ON tbl_group_user (user_id);
+
+-- nested groups: the users of member_id are members of group_id too
+CREATE TABLE IF NOT EXISTS tbl_group_member
+(
+    group_id  UUID                        NOT NULL REFERENCES tbl_group (id) ON DELETE CASCADE,
+    member_id UUID                        NOT NULL REFERENCES tbl_group (id) ON DELETE CASCADE,
+    create_ts TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    PRIMARY KEY (group_id, member_id),
+    CHECK (group_id != member_id)
+);
+
+CREATE INDEX ix_group_member_member_id ON tbl_group_member (member_id);
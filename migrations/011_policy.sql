@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS tbl_policy
+(
+    id          UUID PRIMARY KEY                     DEFAULT gen_random_uuid(),
+    realm       VARCHAR(64)                 NOT NULL,
+    name        VARCHAR(64)                 NOT NULL,
+    description VARCHAR(256)                NOT NULL DEFAULT '',
+    effect      VARCHAR(8)                  NOT NULL,
+    actions     TEXT[]                      NOT NULL,
+    condition   TEXT                        NOT NULL DEFAULT '',
+    create_ts   TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    update_ts   TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    UNIQUE (realm, name)
+);
@@ -0,0 +1,25 @@
+-- every WriteTuples call is a revision, consistency tokens name one
+CREATE TABLE IF NOT EXISTS tbl_relation_revision
+(
+    revision  BIGSERIAL PRIMARY KEY,
+    create_ts TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+-- a tuple is visible at the revisions from create_rev up to, not including, delete_rev
+CREATE TABLE IF NOT EXISTS tbl_relation_tuple
+(
+    namespace         VARCHAR(64)  NOT NULL,
+    object_id         VARCHAR(128) NOT NULL,
+    relation          VARCHAR(64)  NOT NULL,
+    subject_namespace VARCHAR(64)  NOT NULL,
+    subject_object_id VARCHAR(128) NOT NULL,
+    subject_relation  VARCHAR(64)  NOT NULL DEFAULT '',
+    create_rev        BIGINT       NOT NULL,
+    delete_rev        BIGINT
+);
+
+CREATE UNIQUE INDEX ux_relation_tuple_live ON tbl_relation_tuple
+    (namespace, object_id, relation, subject_namespace, subject_object_id, subject_relation) WHERE delete_rev IS NULL;
+CREATE INDEX ix_relation_tuple_object ON tbl_relation_tuple (namespace, object_id, relation, create_rev);
+CREATE INDEX ix_relation_tuple_subject ON tbl_relation_tuple
+    (subject_namespace, subject_object_id, subject_relation, create_rev);
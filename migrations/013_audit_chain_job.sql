@@ -0,0 +1,63 @@
+-- events are written pending and chained afterwards by the chain job, so audited transactions do not
+-- wait on each other; chain_seq is the position in the chain, given by the job
+ALTER TABLE tbl_audit_event
+    ADD COLUMN IF NOT EXISTS chain_seq BIGINT UNIQUE,
+    ADD COLUMN IF NOT EXISTS pending   BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- rows already there are either chained or logged before the chain existed, new rows are pending
+ALTER TABLE tbl_audit_event
+    ALTER COLUMN pending SET DEFAULT TRUE;
+
+ALTER TABLE tbl_audit_event
+    DISABLE TRIGGER tr_audit_event_immutable;
+
+UPDATE tbl_audit_event
+SET chain_seq = seq
+WHERE hash IS NOT NULL;
+
+ALTER TABLE tbl_audit_event
+    ENABLE TRIGGER tr_audit_event_immutable;
+
+CREATE INDEX IF NOT EXISTS ix_audit_event_pending ON tbl_audit_event (seq) WHERE pending;
+
+-- the chain job has to write chain_seq, prev_hash and hash after the insert, so the trigger from 006 can no
+-- longer refuse every UPDATE; it lets through exactly one update per event: a pending event is chained once,
+-- its content stays as written and it is linked to the current head of the chain, so a chained event can never
+-- be rehashed and the hashes can only grow the chain, not rewrite it. DELETE stays refused.
+CREATE OR REPLACE FUNCTION fn_audit_event_immutable() RETURNS TRIGGER AS
+$$
+DECLARE
+    head_seq  BIGINT;
+    head_hash VARCHAR(64);
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.pending AND NOT NEW.pending AND NEW.hash IS NOT NULL
+        AND to_jsonb(NEW) - 'chain_seq' - 'prev_hash' - 'hash' - 'pending'
+            = to_jsonb(OLD) - 'chain_seq' - 'prev_hash' - 'hash' - 'pending' THEN
+        SELECT chain_seq, hash
+        INTO head_seq, head_hash
+        FROM tbl_audit_event
+        WHERE chain_seq IS NOT NULL
+        ORDER BY chain_seq DESC
+        LIMIT 1;
+
+        IF NEW.chain_seq = COALESCE(head_seq, 0) + 1 AND NEW.prev_hash = COALESCE(head_hash, '') THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+    RAISE EXCEPTION 'tbl_audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+-- checkpoints are append-only too
+CREATE OR REPLACE FUNCTION fn_audit_checkpoint_immutable() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'tbl_audit_checkpoint is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tr_audit_checkpoint_immutable
+    BEFORE UPDATE OR DELETE
+    ON tbl_audit_checkpoint
+    FOR EACH ROW
+EXECUTE FUNCTION fn_audit_checkpoint_immutable();
@@ -0,0 +1,9 @@
+-- the relay claims events for a while and publishes them outside of the transaction,
+-- an event failing too often is dead-lettered and no longer retried
+ALTER TABLE tbl_outbox
+    ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITHOUT TIME ZONE,
+    ADD COLUMN IF NOT EXISTS dead_ts       TIMESTAMP WITHOUT TIME ZONE;
+
+DROP INDEX IF EXISTS ix_outbox_pending;
+CREATE INDEX ix_outbox_pending ON tbl_outbox (seq) WHERE published_ts IS NULL AND dead_ts IS NULL;
+CREATE INDEX ix_outbox_dead_ts ON tbl_outbox (dead_ts) WHERE dead_ts IS NOT NULL;
@@ -0,0 +1,11 @@
+-- seq is taken when an event is written and transactions commit out of seq order, so WatchUserEvents
+-- follows pos instead: it is given to committed events only, by one transaction at a time
+CREATE SEQUENCE IF NOT EXISTS seq_outbox_pos;
+
+ALTER TABLE tbl_outbox
+    ADD COLUMN IF NOT EXISTS pos BIGINT UNIQUE;
+
+UPDATE tbl_outbox SET pos = seq WHERE pos IS NULL;
+SELECT setval('seq_outbox_pos', COALESCE((SELECT MAX(pos) FROM tbl_outbox), 0) + 1, false);
+
+CREATE INDEX IF NOT EXISTS ix_outbox_unnumbered ON tbl_outbox (seq) WHERE pos IS NULL;
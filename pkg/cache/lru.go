@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// LRU keeps at most size values, each for at most ttl, and evicts the least recently used one
+// when it is full. It is not safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func NewLRU[K comparable, V any](size int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[K]*list.Element, size),
+	}
+}
+
+// Get returns the value of the key, expired values are not returned.
+func (c *LRU[K, V]) Get(key K) (value V, ok bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return value, false
+	}
+
+	entry := e.Value.(*lruEntry[K, V])
+	if time.Now().After(entry.expires) {
+		c.removeElement(e)
+		return value, false
+	}
+
+	c.ll.MoveToFront(e)
+
+	return entry.value, true
+}
+
+// Add stores the value until ttl from now, or until expires when that is earlier.
+// It reports whether the least recently used value was evicted to make room.
+func (c *LRU[K, V]) Add(key K, value V, expires time.Time) (evicted bool) {
+	if max := time.Now().Add(c.ttl); expires.IsZero() || expires.After(max) {
+		expires = max
+	}
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*lruEntry[K, V])
+		entry.value = value
+		entry.expires = expires
+		c.ll.MoveToFront(e)
+		return false
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key: key, value: value, expires: expires})
+
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+		return true
+	}
+
+	return false
+}
+
+func (c *LRU[K, V]) Remove(key K) {
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// Purge removes every value.
+func (c *LRU[K, V]) Purge() {
+	c.ll.Init()
+	c.items = make(map[K]*list.Element, c.size)
+}
+
+func (c *LRU[K, V]) Len() int {
+	return c.ll.Len()
+}
+
+func (c *LRU[K, V]) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*lruEntry[K, V]).key)
+}
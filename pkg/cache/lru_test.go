@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[string, int](2, time.Minute)
+
+	c.Add("a", 1, time.Time{})
+	c.Add("b", 2, time.Time{})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(a) missed")
+	}
+
+	if evicted := c.Add("c", 3, time.Time{}); !evicted {
+		t.Fatalf("Add(c) evicted nothing, want b")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("Get(b) hit, want it evicted as the least recently used")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v, want 1", v, ok)
+	}
+
+	if evicted := c.Add("a", 10, time.Time{}); evicted {
+		t.Fatalf("Add(a) of a cached key evicted")
+	}
+	if v, _ := c.Get("a"); v != 10 || c.Len() != 2 {
+		t.Fatalf("Get(a) = %d with %d values, want 10 with 2", v, c.Len())
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := NewLRU[string, int](10, time.Minute)
+
+	c.Add("past", 1, time.Now().Add(-time.Second))
+	if _, ok := c.Get("past"); ok {
+		t.Fatalf("Get(past) hit, want it expired")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("%d values, want the expired one removed", c.Len())
+	}
+
+	// the ttl bounds a later expiry
+	c = NewLRU[string, int](10, time.Millisecond)
+	c.Add("later", 1, time.Now().Add(time.Hour))
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("later"); ok {
+		t.Fatalf("Get(later) hit, want it expired after the ttl")
+	}
+}
+
+func TestLRURemoveAndPurge(t *testing.T) {
+	c := NewLRU[string, int](10, time.Minute)
+	c.Add("a", 1, time.Time{})
+	c.Add("b", 2, time.Time{})
+
+	c.Remove("a")
+	c.Remove("unknown")
+	if _, ok := c.Get("a"); ok || c.Len() != 1 {
+		t.Fatalf("Get(a) hit with %d values, want it removed", c.Len())
+	}
+
+	c.Purge()
+	if _, ok := c.Get("b"); ok || c.Len() != 0 {
+		t.Fatalf("Get(b) hit with %d values, want none after Purge", c.Len())
+	}
+}
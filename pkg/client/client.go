@@ -0,0 +1,149 @@
+// Package client is the Go SDK of the authenticator. It logs users in, keeps their tokens fresh,
+// verifies access tokens and authenticates the requests of a service with gRPC and HTTP middleware.
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+
+	"authenticator/internal/controller"
+)
+
+// ErrInvalidToken is returned for a token that is malformed, expired or not signed by a trusted key.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Token is a pair of tokens issued by the authenticator.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	// Expiry is when the access token expires, read from its exp claim.
+	Expiry time.Time
+}
+
+// Client calls AuthService.
+type Client struct {
+	api      controller.AuthServiceClient
+	audience string
+}
+
+// New returns a client on the connection, the caller keeps it and closes it.
+func New(conn grpc.ClientConnInterface) *Client {
+	return &Client{
+		api: controller.NewAuthServiceClient(conn),
+	}
+}
+
+// ForAudience returns a client whose Verify accepts the tokens issued for the audience, the name
+// of the service. Without it Verify rejects every audience-bound token.
+func (c *Client) ForAudience(audience string) *Client {
+	return &Client{
+		api:      c.api,
+		audience: audience,
+	}
+}
+
+// Login logs the user in with a password.
+func (c *Client) Login(ctx context.Context, username, password string) (*Token, error) {
+	res, err := c.api.Auth(ctx, &controller.AuthRequest{
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newToken(res.AccessToken, res.RefreshToken)
+}
+
+// Refresh exchanges the tokens for new ones. The refresh token can be used once,
+// and only when the access token expired or is about to.
+func (c *Client) Refresh(ctx context.Context, t *Token) (*Token, error) {
+	res, err := c.api.UpdateToken(ctx, &controller.UpdateTokenRequest{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newToken(res.AccessToken, res.RefreshToken)
+}
+
+// Verify validates the access token with ValidateToken. Unlike a Verifier it sees revoked users
+// and permissions granted through groups, at the cost of a call. The connection must carry the token of a
+// caller with the token:validate permission, see PerRPCCredentials.
+func (c *Client) Verify(ctx context.Context, accessToken string) (*Principal, error) {
+	res, err := c.api.ValidateToken(ctx, &controller.ValidateTokenRequest{
+		AccessToken: accessToken,
+		Audience:    c.audience,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(res.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		UserId:      id,
+		Username:    res.Username,
+		Roles:       res.Roles,
+		Groups:      res.Groups,
+		Permissions: res.Permissions,
+		Scopes:      res.Scopes,
+		Audience:    res.Audience,
+		Actor:       newActor(res.Actor),
+	}, nil
+}
+
+func newActor(a *controller.Actor) *Actor {
+	if a == nil {
+		return nil
+	}
+	return &Actor{
+		UserId:   a.UserId,
+		Username: a.Username,
+		Actor:    newActor(a.Actor),
+	}
+}
+
+func newToken(accessToken, refreshToken string) (*Token, error) {
+	exp, err := expiry(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       exp,
+	}, nil
+}
+
+// expiry reads the exp claim without verifying the token, the client only uses it to schedule refreshes.
+func expiry(accessToken string) (time.Time, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(b, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	return time.Unix(claims.ExpiresAt, 0), nil
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSRefresh is how long a JWKS caches the keys it fetched.
+const DefaultJWKSRefresh = time.Hour
+
+// minJWKSRefetch limits the refetches for unknown key ids, so tokens with made up ids can not
+// make us hammer the JWKS endpoint.
+const minJWKSRefetch = 10 * time.Second
+
+// jwksFetchTimeout bounds a fetch of the key set, whatever the client.
+const jwksFetchTimeout = 10 * time.Second
+
+// maxJWKSBytes is the largest key set read.
+const maxJWKSBytes = 1 << 20
+
+// JWKS is the key set published at a JWK Set URL (RFC 7517). The keys are cached and fetched again
+// after the refresh interval, or early when a token names a key id we do not know, such as after
+// a key rotation. Only RSA and EC public keys are understood, a symmetric key published in a key set
+// would let anyone reading the set sign tokens.
+type JWKS struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]jwk
+	fetchedAt time.Time
+	// fetching is the fetch running, concurrent callers wait for it instead of fetching too
+	fetching *jwksFetch
+}
+
+type jwk struct {
+	alg string
+	key interface{}
+}
+
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKS returns the key set at the url. A nil client is http.DefaultClient and a refresh of 0 is DefaultJWKSRefresh.
+func NewJWKS(url string, client *http.Client, refresh time.Duration) *JWKS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &JWKS{
+		url:     url,
+		client:  client,
+		refresh: refresh,
+	}
+}
+
+func (s *JWKS) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	s.mu.Lock()
+	k, ok := s.find(kid)
+	age := time.Since(s.fetchedAt)
+	fetching := s.fetching != nil
+	s.mu.Unlock()
+
+	// a key we do not know may come with the fetch running
+	if age > s.refresh || (!ok && (age > minJWKSRefetch || fetching)) {
+		// a failed fetch keeps the keys we have
+		if err := s.fetch(ctx); err != nil && !ok {
+			return nil, err
+		}
+		s.mu.Lock()
+		k, ok = s.find(kid)
+		s.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key <%s>", kid)
+	}
+
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("key <%s> is for %s, not %s", kid, k.alg, alg)
+	}
+	switch k.key.(type) {
+	case *rsa.PublicKey:
+		ok = strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		ok = strings.HasPrefix(alg, "ES")
+	default:
+		ok = false
+	}
+	if !ok {
+		return nil, fmt.Errorf("key <%s> can not verify %s", kid, alg)
+	}
+
+	return k.key, nil
+}
+
+// find looks the key up by id. A token without a key id is verified by the only key of the set.
+// The caller holds the lock.
+func (s *JWKS) find(kid string) (jwk, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// fetch reads the key set without holding the lock, a caller arriving while a fetch runs waits for it.
+func (s *JWKS) fetch(ctx context.Context) error {
+	s.mu.Lock()
+	if f := s.fetching; f != nil {
+		s.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	s.fetching = f
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+
+	// the fetch is shared, a caller giving up does not cancel it for the others
+	fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+	keys, err := s.get(fetchCtx)
+	cancel()
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.fetching = nil
+	s.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+
+	return err
+}
+
+func (s *JWKS) get(ctx context.Context) (map[string]jwk, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: %s", res.Status)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err = json.NewDecoder(io.LimitReader(res.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, k, err := parseJWK(raw)
+		if err != nil {
+			// keys of types we do not know are skipped, like other JWK Set readers do
+			continue
+		}
+		keys[kid] = k
+	}
+
+	return keys, nil
+}
+
+func parseJWK(raw json.RawMessage) (string, jwk, error) {
+	var v struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", jwk{}, err
+	}
+	if v.Use != "" && v.Use != "sig" {
+		return "", jwk{}, fmt.Errorf("key use %s", v.Use)
+	}
+
+	k := jwk{alg: v.Alg}
+	switch v.Kty {
+	case "RSA":
+		n, err := decodeInt(v.N)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		e, err := decodeInt(v.E)
+		if err != nil || !e.IsInt64() {
+			return "", jwk{}, fmt.Errorf("bad RSA exponent")
+		}
+		k.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch v.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", jwk{}, fmt.Errorf("curve %s", v.Crv)
+		}
+		x, err := decodeInt(v.X)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		y, err := decodeInt(v.Y)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return "", jwk{}, fmt.Errorf("point not on curve")
+		}
+		k.key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		// oct keys are refused too, a shared secret must not be published
+		return "", jwk{}, fmt.Errorf("key type %s", v.Kty)
+	}
+
+	return v.Kid, k, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("bad key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
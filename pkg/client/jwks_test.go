@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func rsaJWK(t *testing.T, kid string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	return fmt.Sprintf(`{"kty":"RSA","kid":%q,"n":%q,"e":%q}`, kid, n, e)
+}
+
+func TestJWKSRefusesSymmetricKeys(t *testing.T) {
+	oct := base64.RawURLEncoding.EncodeToString([]byte("published secret"))
+	set := fmt.Sprintf(`{"keys":[%s,{"kty":"oct","kid":"hmac","k":%q}]}`, rsaJWK(t, "rsa"), oct)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(set))
+	}))
+	defer srv.Close()
+
+	keys := NewJWKS(srv.URL, nil, 0)
+
+	if _, err := keys.Key(context.Background(), "rsa", "RS256"); err != nil {
+		t.Fatalf("Key(rsa): %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "rsa", "HS256"); err == nil {
+		t.Fatal("an RSA key verified HS256")
+	}
+	if _, err := keys.Key(context.Background(), "hmac", "HS256"); err == nil {
+		t.Fatal("the oct key of the set was used")
+	}
+}
+
+func TestJWKSFetchesOnceForConcurrentCallers(t *testing.T) {
+	set := fmt.Sprintf(`{"keys":[%s]}`, rsaJWK(t, "rsa"))
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		<-release
+		_, _ = w.Write([]byte(set))
+	}))
+	defer srv.Close()
+
+	keys := NewJWKS(srv.URL, nil, 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := keys.Key(context.Background(), "rsa", "RS256")
+			errs <- err
+		}()
+	}
+
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the lock is not held while fetching, a caller with a cancelled context returns at once
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := keys.Key(ctx, "rsa", "RS256"); err == nil {
+		t.Fatal("Key returned before the keys were fetched")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Key: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches, want 1", n)
+	}
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type principalKey struct{}
+
+// WithPrincipal returns the context carrying the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal stored by the middleware, nil when there is none.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// UnaryServerInterceptor authenticates every call with the bearer token of its authorization metadata
+// and stores the principal in the context. Calls without a valid token fail with Unauthenticated.
+func UnaryServerInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor(a Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &principalStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, a Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = bearerToken(v[0])
+	}
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+
+	p, err := a.Verify(ctx, token)
+	if err != nil {
+		if s, ok := status.FromError(err); ok && s.Code() != codes.Unauthenticated {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+
+	return WithPrincipal(ctx, p), nil
+}
+
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
+
+// Middleware authenticates every request with its Authorization bearer token and stores the principal
+// in the request context. Requests without a valid token are answered 401.
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r.Header.Get("Authorization"))
+			if token == "" {
+				unauthorized(w)
+				return
+			}
+
+			p, err := a.Verify(r.Context(), token)
+			if err != nil {
+				if s, ok := status.FromError(err); ok && s.Code() != codes.Unauthenticated {
+					http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				unauthorized(w)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultLeeway is how long before expiry a TokenSource refreshes the access token. It must stay below
+// ACCESS_TOKEN_REFRESH_WINDOW of the server, which refuses earlier refreshes.
+const DefaultLeeway = 30 * time.Second
+
+const refreshTimeout = 10 * time.Second
+
+// TokenSource returns a valid access token.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// refreshingSource refreshes the token when it is about to expire. A refresh rotates the refresh token,
+// so concurrent callers share a single refresh.
+type refreshingSource struct {
+	c      *Client
+	leeway time.Duration
+
+	mu      sync.Mutex
+	token   *Token
+	refresh *refreshCall
+}
+
+type refreshCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewTokenSource returns a TokenSource starting with the token, usually the one of Login, that refreshes
+// it leeway before it expires. A leeway of 0 is DefaultLeeway.
+func NewTokenSource(c *Client, t *Token, leeway time.Duration) TokenSource {
+	if leeway <= 0 {
+		leeway = DefaultLeeway
+	}
+	return &refreshingSource{
+		c:      c,
+		leeway: leeway,
+		token:  t,
+	}
+}
+
+func (s *refreshingSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	if time.Until(s.token.Expiry) > s.leeway {
+		t := s.token
+		s.mu.Unlock()
+		return t, nil
+	}
+
+	call := s.refresh
+	if call == nil {
+		call = &refreshCall{done: make(chan struct{})}
+		s.refresh = call
+		// the refresh outlives the caller that started it, the others still wait for it
+		go s.run(context.WithoutCancel(ctx), call, s.token)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *refreshingSource) run(ctx context.Context, call *refreshCall, t *Token) {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	call.token, call.err = s.c.Refresh(ctx, t)
+
+	s.mu.Lock()
+	if call.err == nil {
+		s.token = call.token
+	}
+	s.refresh = nil
+	s.mu.Unlock()
+
+	close(call.done)
+}
+
+// PerRPCCredentials sends the access token of the source as the bearer token of every call.
+// Set insecure when the connection has no transport security, such as inside a private network.
+func PerRPCCredentials(ts TokenSource, insecure bool) credentials.PerRPCCredentials {
+	return &tokenCredentials{ts: ts, insecure: insecure}
+}
+
+type tokenCredentials struct {
+	ts       TokenSource
+	insecure bool
+}
+
+func (c *tokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	t, err := c.ts.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + t.AccessToken}, nil
+}
+
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return !c.insecure
+}
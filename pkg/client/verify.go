@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+
+	"authenticator/internal/model"
+)
+
+// Principal is the user an access token was issued to.
+type Principal struct {
+	UserId uuid.UUID
+	// Username and Groups are only known to Client.Verify, they are not claims of the token.
+	Username    string
+	Roles       []string
+	Groups      []string
+	Permissions []string
+	// Scopes restrict an exchanged token, none means the token is not restricted.
+	Scopes   []string
+	Audience string
+	// Actor acts on behalf of the user, for impersonation and delegated tokens.
+	Actor *Actor
+	// Profile holds the profile claims of the token, set by ACCESS_TOKEN_CLAIMS.
+	Profile   map[string]interface{}
+	ExpiresAt time.Time
+}
+
+// Actor is who acts on behalf of the user, a nested Actor is the previous one in the delegation chain.
+type Actor struct {
+	UserId   string
+	Username string
+	Actor    *Actor
+}
+
+// HasPermission reports whether the principal has the permission.
+func (p *Principal) HasPermission(permission string) bool {
+	for _, v := range p.Permissions {
+		if v == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator turns an access token into its principal. Both Verifier and Client are authenticators.
+type Authenticator interface {
+	Verify(ctx context.Context, accessToken string) (*Principal, error)
+}
+
+// KeySet finds the key that verifies the signature of a token.
+type KeySet interface {
+	Key(ctx context.Context, kid, alg string) (interface{}, error)
+}
+
+// HMACKey is the key set of a single shared secret, TOKEN_SECRET of the authenticator.
+func HMACKey(secret []byte) KeySet {
+	return hmacKey(secret)
+}
+
+type hmacKey []byte
+
+func (k hmacKey) Key(_ context.Context, _, alg string) (interface{}, error) {
+	if !strings.HasPrefix(alg, "HS") {
+		return nil, fmt.Errorf("unexpected signing method %s", alg)
+	}
+	return []byte(k), nil
+}
+
+// Verifier verifies access tokens locally, without calling the authenticator. Its principals have
+// the permissions of the roles in the token, a revoked user is only noticed when the token expires.
+type Verifier struct {
+	keys     KeySet
+	audience string
+}
+
+// NewVerifier verifies tokens signed with the keys for the audience, the name of the service. Tokens
+// without an audience are accepted by every service, an audience-bound token only by its audience.
+func NewVerifier(keys KeySet, audience string) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		audience: audience,
+	}
+}
+
+type tokenClaims struct {
+	ID      uuid.UUID
+	Profile map[string]interface{} `json:"profile,omitempty"`
+	Act     *actorClaim            `json:"act,omitempty"`
+	Scope   string                 `json:"scope,omitempty"`
+	Roles   []string               `json:"roles,omitempty"`
+	jwt.StandardClaims
+}
+
+type actorClaim struct {
+	Sub      string      `json:"sub"`
+	Username string      `json:"username,omitempty"`
+	Act      *actorClaim `json:"act,omitempty"`
+}
+
+func (v *Verifier) Verify(ctx context.Context, accessToken string) (*Principal, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid, t.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.ID == uuid.Nil || claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: no ID or exp claim", ErrInvalidToken)
+	}
+	if claims.Audience != "" && claims.Audience != v.audience {
+		return nil, fmt.Errorf("%w: audience <%s>", ErrInvalidToken, claims.Audience)
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	p := &Principal{
+		UserId:    claims.ID,
+		Roles:     claims.Roles,
+		Scopes:    scopes,
+		Audience:  claims.Audience,
+		Actor:     actorOf(claims.Act),
+		Profile:   claims.Profile,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	// the scopes of an exchanged token narrow the permissions of its roles
+	for _, perm := range model.PermissionList(claims.Roles) {
+		if len(scopes) == 0 || slices.Contains(scopes, string(perm)) {
+			p.Permissions = append(p.Permissions, string(perm))
+		}
+	}
+
+	return p, nil
+}
+
+func actorOf(a *actorClaim) *Actor {
+	if a == nil {
+		return nil
+	}
+	return &Actor{
+		UserId:   a.Sub,
+		Username: a.Username,
+		Actor:    actorOf(a.Act),
+	}
+}
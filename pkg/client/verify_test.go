@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+
+	"authenticator/internal/model"
+)
+
+var testSecret = []byte("test-secret")
+
+func signTestToken(t *testing.T, claims *tokenClaims) string {
+	t.Helper()
+
+	if claims.ID == uuid.Nil {
+		claims.ID = uuid.New()
+	}
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return token
+}
+
+func TestVerifierAudience(t *testing.T) {
+	unbound := signTestToken(t, &tokenClaims{})
+	bound := signTestToken(t, &tokenClaims{StandardClaims: jwt.StandardClaims{Audience: "billing"}})
+
+	tests := []struct {
+		name     string
+		audience string
+		token    string
+		valid    bool
+	}{
+		{"unbound token, no audience", "", unbound, true},
+		{"unbound token, audience", "billing", unbound, true},
+		{"bound token, no audience", "", bound, false},
+		{"bound token, other audience", "reports", bound, false},
+		{"bound token, its audience", "billing", bound, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerifier(HMACKey(testSecret), tt.audience).Verify(context.Background(), tt.token)
+			if tt.valid && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifierScopesNarrowPermissions(t *testing.T) {
+	token := signTestToken(t, &tokenClaims{
+		Roles: []string{model.RoleAdmin},
+		Scope: string(model.PermissionUserRead),
+	})
+
+	p, err := NewVerifier(HMACKey(testSecret), "").Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !slices.Equal(p.Permissions, []string{string(model.PermissionUserRead)}) {
+		t.Fatalf("permissions = %v, want only %s", p.Permissions, model.PermissionUserRead)
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "authenticator"
+
+// Path is where Handler is served.
+const Path = "/metrics"
+
+var (
+	RPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of the RPCs, streams last until they end.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	RPCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "RPCs handled, by gRPC status code.",
+	}, []string{"method", "code"})
+
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Successful logins, by login method.",
+	}, []string{"method"})
+
+	LoginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Failed logins, by login method and reason.",
+	}, []string{"method", "reason"})
+
+	PasswordHashDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_hash_duration_seconds",
+		Help:      "Duration of hashing passwords (hash) and checking them against their hash (verify).",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"algorithm", "op"})
+
+	ActiveSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Users with an unexpired refresh token.",
+	})
+
+	OutboxBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_backlog",
+		Help:      "Outbox events not published yet.",
+	})
+)
+
+// Register adds the metrics of the service and the collectors to the default registry. The metrics
+// are only registered by the service, so programs importing its packages, such as users of
+// pkg/client, do not serve them.
+func Register(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(RPCDuration, RPCRequests, Logins, LoginFailures, PasswordHashDuration, ActiveSessions, OutboxBacklog)
+	prometheus.MustRegister(collectors...)
+}
+
+// Handler serves the metrics of the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
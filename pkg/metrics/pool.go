@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stat describes one value a collector reads from its source on every scrape.
+type stat[T any] struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(T) float64
+}
+
+func newStat[T any](subsystem, name, help string, valueType prometheus.ValueType, value func(T) float64) stat[T] {
+	return stat[T]{
+		desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, nil),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+// statsCollector reports the stats of a snapshot taken once per scrape.
+type statsCollector[T any] struct {
+	snapshot func() T
+	stats    []stat[T]
+}
+
+func (c *statsCollector[T]) Describe(ch chan<- *prometheus.Desc) {
+	for _, s := range c.stats {
+		ch <- s.desc
+	}
+}
+
+func (c *statsCollector[T]) Collect(ch chan<- prometheus.Metric) {
+	v := c.snapshot()
+	for _, s := range c.stats {
+		ch <- prometheus.MustNewConstMetric(s.desc, s.valueType, s.value(v))
+	}
+}
+
+// NewPgxPoolCollector reports the connections and acquires of the pool.
+func NewPgxPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	const sub = "pgxpool"
+	return &statsCollector[*pgxpool.Stat]{
+		snapshot: pool.Stat,
+		stats: []stat[*pgxpool.Stat]{
+			newStat(sub, "acquired_conns", "Connections in use.", prometheus.GaugeValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }),
+			newStat(sub, "idle_conns", "Idle connections.", prometheus.GaugeValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }),
+			newStat(sub, "constructing_conns", "Connections being opened.", prometheus.GaugeValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.ConstructingConns()) }),
+			newStat(sub, "total_conns", "Open connections.", prometheus.GaugeValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }),
+			newStat(sub, "max_conns", "Most connections the pool opens.", prometheus.GaugeValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }),
+			newStat(sub, "acquires_total", "Connections acquired.", prometheus.CounterValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }),
+			newStat(sub, "acquire_duration_seconds_total", "Time spent acquiring connections.", prometheus.CounterValue,
+				func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }),
+			newStat(sub, "empty_acquires_total", "Acquires that waited for a connection.", prometheus.CounterValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }),
+			newStat(sub, "canceled_acquires_total", "Acquires canceled by their context.", prometheus.CounterValue,
+				func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }),
+		},
+	}
+}
+
+// NewRedisPoolCollector reports the connections of the pool of the client.
+func NewRedisPoolCollector(client *redis.Client) prometheus.Collector {
+	const sub = "redis_pool"
+	return &statsCollector[*redis.PoolStats]{
+		snapshot: client.PoolStats,
+		stats: []stat[*redis.PoolStats]{
+			newStat(sub, "hits_total", "Times an idle connection was found.", prometheus.CounterValue,
+				func(s *redis.PoolStats) float64 { return float64(s.Hits) }),
+			newStat(sub, "misses_total", "Times no idle connection was found.", prometheus.CounterValue,
+				func(s *redis.PoolStats) float64 { return float64(s.Misses) }),
+			newStat(sub, "timeouts_total", "Times waiting for a connection timed out.", prometheus.CounterValue,
+				func(s *redis.PoolStats) float64 { return float64(s.Timeouts) }),
+			newStat(sub, "total_conns", "Open connections.", prometheus.GaugeValue,
+				func(s *redis.PoolStats) float64 { return float64(s.TotalConns) }),
+			newStat(sub, "idle_conns", "Idle connections.", prometheus.GaugeValue,
+				func(s *redis.PoolStats) float64 { return float64(s.IdleConns) }),
+			newStat(sub, "stale_conns_total", "Stale connections removed.", prometheus.CounterValue,
+				func(s *redis.PoolStats) float64 { return float64(s.StaleConns) }),
+		},
+	}
+}
+
+// NewUserCacheCollector reports the stats of the user cache, as returned by CachedUserRepo.Stats.
+func NewUserCacheCollector(stats func() map[string]int64) prometheus.Collector {
+	const sub = "user_cache"
+	return &statsCollector[map[string]int64]{
+		snapshot: stats,
+		stats: []stat[map[string]int64]{
+			newStat(sub, "hits_total", "Users found in process.", prometheus.CounterValue,
+				func(s map[string]int64) float64 { return float64(s["hits"]) }),
+			newStat(sub, "redis_hits_total", "Users found in Redis.", prometheus.CounterValue,
+				func(s map[string]int64) float64 { return float64(s["redis_hits"]) }),
+			newStat(sub, "misses_total", "Users read from the database.", prometheus.CounterValue,
+				func(s map[string]int64) float64 { return float64(s["misses"]) }),
+			newStat(sub, "invalidations_total", "Users dropped after a change.", prometheus.CounterValue,
+				func(s map[string]int64) float64 { return float64(s["invalidations"]) }),
+			newStat(sub, "evictions_total", "Users dropped to make room.", prometheus.CounterValue,
+				func(s map[string]int64) float64 { return float64(s["evictions"]) }),
+			newStat(sub, "size", "Users cached in process.", prometheus.GaugeValue,
+				func(s map[string]int64) float64 { return float64(s["size"]) }),
+		},
+	}
+}
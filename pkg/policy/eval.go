@@ -0,0 +1,319 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUndefined is returned when a condition reads an attribute the request does not have.
+// A rule whose condition is undefined does not match, unless it denies: a deny fails closed on any error.
+var ErrUndefined = errors.New("undefined")
+
+// ErrType is returned when an operator gets operands it can not handle.
+var ErrType = errors.New("type error")
+
+// Eval evaluates the condition against the attributes. Attributes are nested maps
+// (map[string]interface{} or map[string]string) of strings, float64 numbers, bools and lists.
+func (e *Expr) Eval(attrs map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(attrs)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: condition is %s, not a boolean", ErrType, typeName(v))
+	}
+	return b, nil
+}
+
+type node interface {
+	eval(attrs map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type path struct {
+	name string
+}
+
+func (n path) eval(attrs map[string]interface{}) (interface{}, error) {
+	var cur interface{} = attrs
+	for _, part := range strings.Split(n.name, ".") {
+		switch m := cur.(type) {
+		case map[string]interface{}:
+			v, ok := m[part]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUndefined, n.name)
+			}
+			cur = v
+		case map[string]string:
+			v, ok := m[part]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUndefined, n.name)
+			}
+			cur = v
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUndefined, n.name)
+		}
+	}
+
+	if s, ok := cur.([]string); ok {
+		items := make([]interface{}, len(s))
+		for i := range s {
+			items[i] = s[i]
+		}
+		return items, nil
+	}
+	if i, ok := cur.(int); ok {
+		return float64(i), nil
+	}
+
+	return cur, nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n list) eval(attrs map[string]interface{}) (interface{}, error) {
+	items := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(attrs map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%w: ! of %s", ErrType, typeName(v))
+	}
+	return !b, nil
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(attrs map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || short-circuit, so a guard like `has && x == 1` stops before x
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s of %s", ErrType, n.op, typeName(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := n.right.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s of %s", ErrType, n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []interface{}:
+			for _, item := range r {
+				if equal(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case string:
+			l, ok := left.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s in string", ErrType, typeName(left))
+			}
+			return strings.Contains(r, l), nil
+		}
+		return nil, fmt.Errorf("%w: in %s", ErrType, typeName(right))
+	}
+
+	c, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+type call struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n call) eval(attrs map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return n.fn(args)
+}
+
+type function struct {
+	arity int
+	apply func(args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"startsWith": {2, stringsFn(func(s []string) interface{} { return strings.HasPrefix(s[0], s[1]) })},
+	"endsWith":   {2, stringsFn(func(s []string) interface{} { return strings.HasSuffix(s[0], s[1]) })},
+	"lower":      {1, stringsFn(func(s []string) interface{} { return strings.ToLower(s[0]) })},
+}
+
+func stringsFn(f func(s []string) interface{}) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s := make([]string, len(args))
+		for i, arg := range args {
+			v, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: expected a string, got %s", ErrType, typeName(arg))
+			}
+			s[i] = v
+		}
+		return f(s), nil
+	}
+}
+
+// equal compares values of the same type. Numbers also equal strings holding the same number,
+// since request context values arrive as strings.
+func equal(a, b interface{}) bool {
+	if x, y, ok := numbers(a, b); ok {
+		return x == y
+	}
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if _, ok := b.([]interface{}); ok {
+		return false
+	}
+	return a == b
+}
+
+func compare(a, b interface{}) (int, error) {
+	if x, y, ok := numbers(a, b); ok {
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	x, ok1 := a.(string)
+	y, ok2 := b.(string)
+	if ok1 && ok2 {
+		return strings.Compare(x, y), nil
+	}
+	return 0, fmt.Errorf("%w: can not order %s and %s", ErrType, typeName(a), typeName(b))
+}
+
+// numbers returns both values as numbers when at least one is a number and the other is one or parses as one.
+func numbers(a, b interface{}) (float64, float64, bool) {
+	x, okA := a.(float64)
+	y, okB := b.(float64)
+	if !okA && !okB {
+		return 0, 0, false
+	}
+	var err error
+	if !okA {
+		s, ok := a.(string)
+		if !ok {
+			return 0, 0, false
+		}
+		if x, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if !okB {
+		s, ok := b.(string)
+		if !ok {
+			return 0, 0, false
+		}
+		if y, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return x, y, true
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	}
+	return "object"
+}
@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+var testAttrs = map[string]interface{}{
+	"subject": map[string]interface{}{
+		"name":  "alice",
+		"roles": []string{"editor", "support"},
+		"level": 3,
+		"attributes": map[string]string{
+			"department": "sales",
+			"clearance":  "5",
+		},
+	},
+	"resource": map[string]interface{}{
+		"owner": "Alice",
+		"tags":  []interface{}{"public", 7.0},
+		"attributes": map[string]string{
+			"department": "sales",
+		},
+	},
+	"env": map[string]interface{}{
+		"hour":     10.0,
+		"internal": true,
+		"region":   nil,
+	},
+}
+
+func TestEval(t *testing.T) {
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		// equality, numbers also equal the strings holding them
+		{"subject.name == 'alice'", true},
+		{`subject.name == "bob"`, false},
+		{"subject.name != 'bob'", true},
+		{"subject.level == 3", true},
+		{"subject.attributes.clearance == 5", true},
+		{"env.region == null", true},
+		{"env.internal == true", true},
+		{"subject.attributes.department == resource.attributes.department", true},
+		{"resource.tags == ['public', 7]", true},
+		{"resource.tags == ['public']", false},
+		{"subject.name == ['alice']", false},
+
+		// ordering of numbers and strings
+		{"env.hour >= 9 && env.hour < 17", true},
+		{"env.hour > 10", false},
+		{"env.hour <= 10", true},
+		{"subject.attributes.clearance > 4", true},
+		{"'10' > 9", true},
+		{"'10' > '9'", false},
+		{"'a' < 'b'", true},
+
+		// in a list or a string
+		{"'editor' in subject.roles", true},
+		{"'admin' in subject.roles", false},
+		{"7 in resource.tags", true},
+		{"'7' in resource.tags", true},
+		{"subject.name in ['alice', 'bob']", true},
+		{"'lic' in subject.name", true},
+
+		// logic, && and || short-circuit before an undefined attribute
+		{"!env.internal", false},
+		{"env.internal || subject.missing == 1", true},
+		{"!env.internal && subject.missing == 1", false},
+
+		// functions
+		{"startsWith(subject.name, 'al')", true},
+		{"endsWith(subject.name, 'al')", false},
+		{"endsWith(subject.name, 'ce')", true},
+		{"lower(resource.owner) == subject.name", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if got, err := e.Eval(testAttrs); err != nil || got != tt.want {
+				t.Fatalf("Eval = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+
+	tests := []struct {
+		src  string
+		want error
+	}{
+		{"subject.missing == 1", ErrUndefined},
+		{"subject.name.first == 'a'", ErrUndefined},
+		{"unknown.name == 'a'", ErrUndefined},
+		{"subject.attributes.missing == 'a'", ErrUndefined},
+		{"env.internal && subject.missing == 1", ErrUndefined},
+		{"subject.name", ErrType},
+		{"!subject.name", ErrType},
+		{"subject.name && true", ErrType},
+		{"true && subject.level", ErrType},
+		{"subject.name < 3", ErrType},
+		{"env.internal > false", ErrType},
+		{"subject.roles >= 'a'", ErrType},
+		{"'a' in env.hour", ErrType},
+		{"3 in subject.name", ErrType},
+		{"startsWith(subject.level, '3')", ErrType},
+		{"lower(subject.roles) == 'editor'", ErrType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if got, err := e.Eval(testAttrs); !errors.Is(err, tt.want) || got {
+				t.Fatalf("Eval = %v, %v, want false with %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// Package policy implements the condition language of access policies.
+//
+// A condition is a boolean expression over the attributes of the request:
+//
+//	"editor" in subject.roles && resource.attributes.department == subject.attributes.department
+//	    && env.hour >= 9 && env.hour < 17
+//
+// It has string ('a' or "a"), number, boolean, null and list ([a, b]) literals, dotted attribute
+// paths, the operators ! && || == != < <= > >= and in, parentheses and the functions
+// startsWith(s, prefix), endsWith(s, suffix) and lower(s).
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxLength is the longest condition Compile accepts.
+const MaxLength = 4096
+
+var ErrSyntax = errors.New("syntax error")
+
+// Expr is a compiled condition.
+type Expr struct {
+	src  string
+	root node
+}
+
+// String returns the source of the condition.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Compile parses a condition. An empty condition is always true.
+func Compile(src string) (*Expr, error) {
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("%w: condition longer than %d", ErrSyntax, MaxLength)
+	}
+	if strings.TrimSpace(src) == "" {
+		return &Expr{src: src, root: literal{true}}, nil
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.at(tokEOF) {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrSyntax, i)
+			}
+			tokens = append(tokens, token{tokString, b.String(), i})
+			i = j + 1
+
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, src[i:j], i})
+			i = j
+
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_' || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j], i})
+			i = j
+
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, c, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{tokEOF, "end of condition", len(src)}), nil
+}
+
+// binding powers of the binary operators, higher binds tighter
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4, "in": 4,
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) at(kind tokenKind, text ...string) bool {
+	t := p.peek()
+	return t.kind == kind && (len(text) == 0 || t.text == text[0])
+}
+
+func (p *parser) expect(text string) error {
+	if !p.at(tokOp, text) {
+		return p.errorf("expected %q, got %q", text, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, p.peek().pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) binaryOp() (string, bool) {
+	t := p.peek()
+	if t.kind == tokOp || (t.kind == tokIdent && t.text == "in") {
+		_, ok := precedence[t.text]
+		return t.text, ok
+	}
+	return "", false
+}
+
+func (p *parser) expr(minPrec int) (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.binaryOp()
+		if !ok || precedence[op] <= minPrec {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.expr(precedence[op])
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.at(tokOp, "!") {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return literal{t.text}, nil
+
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w at %d: invalid number %q", ErrSyntax, t.pos, t.text)
+		}
+		return literal{n}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if p.at(tokOp, "(") {
+			return p.call(t)
+		}
+		if strings.HasPrefix(t.text, ".") || strings.HasSuffix(t.text, ".") || strings.Contains(t.text, "..") {
+			return nil, fmt.Errorf("%w at %d: invalid path %q", ErrSyntax, t.pos, t.text)
+		}
+		return path{t.text}, nil
+
+	case tokOp:
+		switch t.text {
+		case "(":
+			inner, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			var items []node
+			for !p.at(tokOp, "]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.expr(0)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			p.next()
+			return list{items}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w at %d: unexpected %q", ErrSyntax, t.pos, t.text)
+}
+
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("%w at %d: unknown function %q", ErrSyntax, name.pos, name.text)
+	}
+	p.next()
+
+	var args []node
+	for !p.at(tokOp, ")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%w at %d: %s takes %d arguments", ErrSyntax, name.pos, name.text, fn.arity)
+	}
+
+	return call{name: name.text, fn: fn.apply, args: args}, nil
+}
@@ -0,0 +1,81 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompilePrecedence(t *testing.T) {
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"false && true || true", true},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"!true == false", true},
+		{"1 < 2 == true", true},
+		{"1 == 1 == true", true},
+		{"2 in [1, 2] == true", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if got, err := e.Eval(nil); err != nil || got != tt.want {
+				t.Fatalf("Eval = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSyntaxErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"unterminated string", `subject.name == "alice`},
+		{"unknown character", "subject.age # 1"},
+		{"unknown operator", "1 + 1"},
+		{"missing operand", "subject.age >"},
+		{"missing parenthesis", "(true && false"},
+		{"extra parenthesis", "true)"},
+		{"two operands", "true false"},
+		{"list without comma", "1 in [1 2]"},
+		{"unterminated list", "1 in [1, 2"},
+		{"invalid number", "1.2.3 == 1"},
+		{"invalid path", "subject..name == 'a'"},
+		{"path ending with a dot", "subject. == 'a'"},
+		{"unknown function", "upper(subject.name) == 'A'"},
+		{"wrong arity", "startsWith(subject.name) == true"},
+		{"too long", strings.Repeat("x", MaxLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.src); !errors.Is(err, ErrSyntax) {
+				t.Fatalf("Compile(%q) = %v, want ErrSyntax", tt.src, err)
+			}
+		})
+	}
+}
+
+func TestCompileEmptyIsTrue(t *testing.T) {
+	for _, src := range []string{"", "  \n"} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", src, err)
+		}
+		if got, err := e.Eval(nil); err != nil || !got {
+			t.Fatalf("Eval of %q = %v, %v, want true", src, got, err)
+		}
+	}
+}
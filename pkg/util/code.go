@@ -0,0 +1,57 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+)
+
+// GenerateNumericCode returns a random code of n decimal digits.
+func GenerateNumericCode(n int) (string, error) {
+	code := make([]byte, n)
+	ten := big.NewInt(10)
+	for i := range code {
+		d, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + d.Int64())
+	}
+	return string(code), nil
+}
+
+// GenerateToken returns a random url-safe token built from n random bytes.
+func GenerateToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashCode returns the hex encoded sha256 of a one-time code, so codes are never stored in clear.
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyCode compares a one-time code with its hash in constant time.
+func VerifyCode(code, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashCode(code)), []byte(hash)) == 1
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of data.
+func Sign(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature made by Sign in constant time.
+func VerifySignature(secret, data, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, data)), []byte(signature))
+}
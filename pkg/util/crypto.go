@@ -1,10 +1,16 @@
 package util
 
 import (
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
+
+	"authenticator/pkg/metrics"
 )
 
 func HashPassword(password string) (hash string, err error) {
+	defer observeHash("hash", time.Now())
+
 	var hashByte []byte
 	hashByte, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -16,6 +22,12 @@ func HashPassword(password string) (hash string, err error) {
 }
 
 func VerifyPasswordFromHash(password string, hash string) error {
+	defer observeHash("verify", time.Now())
+
 	bHash := []byte(hash)
 	return bcrypt.CompareHashAndPassword(bHash, []byte(password))
 }
+
+func observeHash(op string, start time.Time) {
+	metrics.PasswordHashDuration.WithLabelValues("bcrypt", op).Observe(time.Since(start).Seconds())
+}